@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/WoWBytePaladin/go-mall/api/request"
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/logic/appservice"
+	"github.com/gin-gonic/gin"
+)
+
+// FlashSaleInfo 秒杀活动详情
+func FlashSaleInfo(c *gin.Context) {
+	flashSaleId, _ := strconv.ParseInt(c.Param("flash_sale_id"), 10, 64)
+	if flashSaleId <= 0 {
+		app.NewResponse(c).Error(errcode.ErrParams)
+		return
+	}
+	flashSaleAppSvc := appservice.NewFlashSaleAppSvc(c)
+	replyFlashSale, err := flashSaleAppSvc.GetFlashSaleInfo(flashSaleId)
+	if err != nil {
+		replyFlashSaleError(c, err)
+		return
+	}
+
+	app.NewResponse(c).Success(replyFlashSale)
+}
+
+// FlashSaleBuy 秒杀抢购, 抢到库存后排队下单, 用返回的订单号轮询下单结果
+func FlashSaleBuy(c *gin.Context) {
+	flashSaleId, _ := strconv.ParseInt(c.Param("flash_sale_id"), 10, 64)
+	if flashSaleId <= 0 {
+		app.NewResponse(c).Error(errcode.ErrParams)
+		return
+	}
+	request := new(request.FlashSaleBuy)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	flashSaleAppSvc := appservice.NewFlashSaleAppSvc(c)
+	replyQueued, err := flashSaleAppSvc.BuyFlashSale(flashSaleId, request, c.GetInt64("userId"))
+	if err != nil {
+		replyFlashSaleError(c, err)
+		return
+	}
+
+	app.NewResponse(c).Success(replyQueued)
+}
+
+// FlashSaleOrderResult 轮询秒杀下单的结果
+func FlashSaleOrderResult(c *gin.Context) {
+	flashSaleAppSvc := appservice.NewFlashSaleAppSvc(c)
+	replyResult, err := flashSaleAppSvc.GetFlashSaleOrderResult(c.Param("order_no"), c.GetInt64("userId"))
+	if err != nil {
+		replyFlashSaleError(c, err)
+		return
+	}
+
+	app.NewResponse(c).Success(replyResult)
+}
+
+// AdminCreateFlashSale 管理后台创建秒杀活动
+func AdminCreateFlashSale(c *gin.Context) {
+	request := new(request.FlashSaleCreate)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	flashSaleAppSvc := appservice.NewFlashSaleAppSvc(c)
+	replyFlashSale, err := flashSaleAppSvc.CreateFlashSale(request)
+	if err != nil {
+		replyFlashSaleError(c, err)
+		return
+	}
+
+	app.NewResponse(c).Success(replyFlashSale)
+}
+
+// AdminFlashSales 管理后台查询秒杀活动
+func AdminFlashSales(c *gin.Context) {
+	pagination := app.NewPagination(c)
+	flashSaleAppSvc := appservice.NewFlashSaleAppSvc(c)
+	replyFlashSales, err := flashSaleAppSvc.GetFlashSales(pagination)
+	if err != nil {
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		return
+	}
+
+	app.NewResponse(c).SetPagination(pagination).Success(replyFlashSales)
+}
+
+// replyFlashSaleError 按秒杀模块的错误响应, 其他错误响应服务器错误
+func replyFlashSaleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errcode.ErrParams):
+		app.NewResponse(c).Error(errcode.ErrParams)
+	case errors.Is(err, errcode.ErrCommodityNotExists):
+		app.NewResponse(c).Error(errcode.ErrCommodityNotExists)
+	case errors.Is(err, errcode.ErrOrderNotExists):
+		app.NewResponse(c).Error(errcode.ErrOrderNotExists)
+	case errors.Is(err, errcode.ErrFlashSaleParams):
+		app.NewResponse(c).Error(errcode.ErrFlashSaleParams.WithCause(err))
+	case errors.Is(err, errcode.ErrFlashSaleNotExists):
+		app.NewResponse(c).Error(errcode.ErrFlashSaleNotExists)
+	case errors.Is(err, errcode.ErrFlashSaleNotInTime):
+		app.NewResponse(c).Error(errcode.ErrFlashSaleNotInTime)
+	case errors.Is(err, errcode.ErrFlashSaleSoldOut):
+		app.NewResponse(c).Error(errcode.ErrFlashSaleSoldOut)
+	case errors.Is(err, errcode.ErrFlashSaleOverLimit):
+		app.NewResponse(c).Error(errcode.ErrFlashSaleOverLimit)
+	default:
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+	}
+}
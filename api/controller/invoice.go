@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/WoWBytePaladin/go-mall/api/request"
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/logic/appservice"
+	"github.com/gin-gonic/gin"
+)
+
+// AddInvoiceTitle 新增发票抬头
+func AddInvoiceTitle(c *gin.Context) {
+	request := new(request.InvoiceTitle)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	replyTitle, err := invoiceAppSvc.AddInvoiceTitle(request, c.GetInt64("userId"))
+	if err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+
+	app.NewResponse(c).Success(replyTitle)
+}
+
+// GetInvoiceTitles 获取用户的发票抬头列表
+func GetInvoiceTitles(c *gin.Context) {
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	replyTitles, err := invoiceAppSvc.GetUserInvoiceTitles(c.GetInt64("userId"))
+	if err != nil {
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		return
+	}
+	app.NewResponse(c).Success(replyTitles)
+}
+
+// UpdateInvoiceTitle 修改发票抬头
+func UpdateInvoiceTitle(c *gin.Context) {
+	titleId, _ := strconv.ParseInt(c.Param("title_id"), 10, 64)
+	if titleId <= 0 {
+		app.NewResponse(c).Error(errcode.ErrParams)
+		return
+	}
+	request := new(request.InvoiceTitle)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	err := invoiceAppSvc.ModifyInvoiceTitle(request, c.GetInt64("userId"), titleId)
+	if err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+	app.NewResponse(c).SuccessOk()
+}
+
+// DeleteInvoiceTitle 删除发票抬头
+func DeleteInvoiceTitle(c *gin.Context) {
+	titleId, _ := strconv.ParseInt(c.Param("title_id"), 10, 64)
+	if titleId <= 0 {
+		app.NewResponse(c).Error(errcode.ErrParams)
+		return
+	}
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	err := invoiceAppSvc.DeleteInvoiceTitle(c.GetInt64("userId"), titleId)
+	if err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+	app.NewResponse(c).SuccessOk()
+}
+
+// OrderInvoiceApply 用户申请开票
+func OrderInvoiceApply(c *gin.Context) {
+	request := new(request.OrderInvoiceApply)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	replyInvoice, err := invoiceAppSvc.ApplyOrderInvoice(request, c.GetInt64("userId"))
+	if err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+
+	app.NewResponse(c).Success(replyInvoice)
+}
+
+// OrderInvoices 订单的开票记录
+func OrderInvoices(c *gin.Context) {
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	replyInvoices, err := invoiceAppSvc.GetOrderInvoices(c.Param("order_no"), c.GetInt64("userId"))
+	if err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+
+	app.NewResponse(c).Success(replyInvoices)
+}
+
+// OrderInvoicePdf 下载发票PDF文件
+func OrderInvoicePdf(c *gin.Context) {
+	invoiceNo := c.Param("invoice_no")
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	pdfPath, err := invoiceAppSvc.GetInvoicePdf(invoiceNo, c.GetInt64("userId"))
+	if err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+
+	c.FileAttachment(pdfPath, fmt.Sprintf("invoice_%s.pdf", invoiceNo))
+}
+
+// AdminInvoices 管理后台查询开票记录
+func AdminInvoices(c *gin.Context) {
+	request := new(request.AdminInvoiceQuery)
+	if err := c.ShouldBindQuery(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	pagination := app.NewPagination(c)
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	replyInvoices, err := invoiceAppSvc.GetInvoices(request, pagination)
+	if err != nil {
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		return
+	}
+
+	app.NewResponse(c).SetPagination(pagination).Success(replyInvoices)
+}
+
+// AdminIssueInvoice 管理后台开具发票
+func AdminIssueInvoice(c *gin.Context) {
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	if err := invoiceAppSvc.IssueInvoice(c.Param("invoice_no")); err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+
+	app.NewResponse(c).SuccessOk()
+}
+
+// AdminRedFlushInvoice 管理后台红冲发票
+func AdminRedFlushInvoice(c *gin.Context) {
+	invoiceAppSvc := appservice.NewInvoiceAppSvc(c)
+	if err := invoiceAppSvc.RedFlushInvoice(c.Param("invoice_no")); err != nil {
+		replyInvoiceError(c, err)
+		return
+	}
+
+	app.NewResponse(c).SuccessOk()
+}
+
+// replyInvoiceError 按发票模块的错误响应, 其他错误响应服务器错误
+func replyInvoiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, errcode.ErrParams):
+		app.NewResponse(c).Error(errcode.ErrParams)
+	case errors.Is(err, errcode.ErrOrderParams):
+		app.NewResponse(c).Error(errcode.ErrOrderParams)
+	case errors.Is(err, errcode.ErrInvoiceParams):
+		app.NewResponse(c).Error(errcode.ErrInvoiceParams.WithCause(err))
+	case errors.Is(err, errcode.ErrInvoiceNotExists):
+		app.NewResponse(c).Error(errcode.ErrInvoiceNotExists)
+	case errors.Is(err, errcode.ErrInvoiceNotAllowed):
+		app.NewResponse(c).Error(errcode.ErrInvoiceNotAllowed.WithCause(err))
+	case errors.Is(err, errcode.ErrInvoiceStateInvalid):
+		app.NewResponse(c).Error(errcode.ErrInvoiceStateInvalid.WithCause(err))
+	default:
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+	}
+}
@@ -2,9 +2,16 @@ package controller
 
 import (
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/api/reply"
 	"github.com/WoWBytePaladin/go-mall/api/request"
 	"github.com/WoWBytePaladin/go-mall/common/app"
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
 	"github.com/WoWBytePaladin/go-mall/logic/appservice"
 	"github.com/gin-gonic/gin"
 )
@@ -35,18 +42,81 @@ func OrderCreate(c *gin.Context) {
 	app.NewResponse(c).Success(reply)
 }
 
+// OrderBuyNow 立即购买, 不经过购物车直接下单
+func OrderBuyNow(c *gin.Context) {
+	request := new(request.OrderBuyNow)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	reply, err := orderAppSvc.CreateDirectOrder(request, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrParams) {
+			app.NewResponse(c).Error(errcode.ErrParams)
+		} else if errors.Is(err, errcode.ErrCommodityNotExists) {
+			app.NewResponse(c).Error(errcode.ErrCommodityNotExists)
+		} else if errors.Is(err, errcode.ErrCartItemParam) {
+			app.NewResponse(c).Error(errcode.ErrCartItemParam)
+		} else if errors.Is(err, errcode.ErrCommodityStockOut) {
+			app.NewResponse(c).Error(errcode.ErrCommodityStockOut.WithCause(err))
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).Success(reply)
+}
+
 // UserOrders 用户订单列表
 func UserOrders(c *gin.Context) {
+	request := new(request.UserOrderQuery)
+	if err := c.ShouldBindQuery(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	pagination := app.NewPagination(c)
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyOrders, err := orderAppSvc.GetUserOrders(c.GetInt64("userId"), request, pagination)
+	if err != nil {
+		if errors.Is(err, errcode.ErrParams) {
+			app.NewResponse(c).Error(errcode.ErrParams)
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).SetPagination(pagination).Success(replyOrders)
+}
+
+// UserDeletedOrders 用户删除了的订单列表
+func UserDeletedOrders(c *gin.Context) {
 	pagination := app.NewPagination(c)
 	orderAppSvc := appservice.NewOrderAppSvc(c)
-	replyOrders, err := orderAppSvc.GetUserOrders(c.GetInt64("userId"), pagination)
+	replyOrders, err := orderAppSvc.GetUserDeletedOrders(c.GetInt64("userId"), pagination)
 	if err != nil {
 		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		return
 	}
 
 	app.NewResponse(c).SetPagination(pagination).Success(replyOrders)
 }
 
+// UserOrderTabCounts 用户订单列表每个标签页的订单数, 用于标签页上的角标
+func UserOrderTabCounts(c *gin.Context) {
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyCounts, err := orderAppSvc.GetUserOrderTabCounts(c.GetInt64("userId"))
+	if err != nil {
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		return
+	}
+
+	app.NewResponse(c).Success(replyCounts)
+}
+
 // OrderInfo 订单详情
 func OrderInfo(c *gin.Context) {
 	orderNo := c.Param("order_no")
@@ -83,6 +153,80 @@ func OrderCancel(c *gin.Context) {
 	app.NewResponse(c).SuccessOk()
 }
 
+// OrderDelete 用户删除订单, 只能删除已经结束的订单
+func OrderDelete(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.DeleteOrder(orderNo, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else if errors.Is(err, errcode.ErrOrderCanNotBeChanged) {
+			app.NewResponse(c).Error(errcode.ErrOrderCanNotBeChanged.WithCause(err))
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).SuccessOk()
+}
+
+// OrderRestore 用户恢复删除了的订单
+func OrderRestore(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.RestoreOrder(orderNo, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else if errors.Is(err, errcode.ErrOrderCanNotBeChanged) {
+			app.NewResponse(c).Error(errcode.ErrOrderCanNotBeChanged.WithCause(err))
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).SuccessOk()
+}
+
+// OrderConfirmReceipt 用户确认收货
+func OrderConfirmReceipt(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.ConfirmOrderReceipt(orderNo, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else if errors.Is(err, errcode.ErrOrderCanNotBeChanged) {
+			app.NewResponse(c).Error(errcode.ErrOrderCanNotBeChanged)
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).SuccessOk()
+}
+
+// OrderBuyAgain 再次购买, 把订单中的商品重新加入购物车
+func OrderBuyAgain(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyResult, err := orderAppSvc.BuyAgain(orderNo, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).Success(replyResult)
+}
+
 // CreateOrderPay 订单发起支付
 func CreateOrderPay(c *gin.Context) {
 	request := new(request.OrderPayCreate)
@@ -90,11 +234,18 @@ func CreateOrderPay(c *gin.Context) {
 		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
 		return
 	}
+	request.ClientIp = c.ClientIP()
 	orderAppSvc := appservice.NewOrderAppSvc(c)
 	reply, err := orderAppSvc.OrderCreatePay(request, c.GetInt64("userId"))
 	if err != nil {
 		if errors.Is(err, errcode.ErrOrderParams) {
 			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else if errors.Is(err, errcode.ErrOrderUnsupportedPayScene) {
+			app.NewResponse(c).Error(errcode.ErrOrderUnsupportedPayScene)
+		} else if errors.Is(err, errcode.ErrTooManyRequests) {
+			app.NewResponse(c).Error(errcode.ErrTooManyRequests)
+		} else if errors.Is(err, errcode.ErrOrderSandboxPayDisabled) {
+			app.NewResponse(c).Error(errcode.ErrOrderSandboxPayDisabled)
 		} else {
 			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
 		}
@@ -103,3 +254,312 @@ func CreateOrderPay(c *gin.Context) {
 
 	app.NewResponse(c).Success(reply)
 }
+
+// SandboxPaySimulate 模拟沙箱支付的支付结果, 只在开发和测试环境可用
+func SandboxPaySimulate(c *gin.Context) {
+	request := new(request.SandboxPaySimulate)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.SimulateSandboxPay(request, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderSandboxPayDisabled) {
+			app.NewResponse(c).Error(errcode.ErrOrderSandboxPayDisabled)
+		} else if errors.Is(err, errcode.ErrOrderParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else if errors.Is(err, errcode.ErrOrderPayMoneyMismatch) {
+			app.NewResponse(c).Error(errcode.ErrOrderPayMoneyMismatch)
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).SuccessOk()
+}
+
+// OrderRefundApply 用户申请退款
+func OrderRefundApply(c *gin.Context) {
+	request := new(request.OrderRefundApply)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	reply, err := orderAppSvc.ApplyOrderRefund(request, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else if errors.Is(err, errcode.ErrOrderRefundParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderRefundParams)
+		} else if errors.Is(err, errcode.ErrOrderRefundNotAllowed) {
+			app.NewResponse(c).Error(errcode.ErrOrderRefundNotAllowed.WithCause(err))
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).Success(reply)
+}
+
+// OrderRefunds 订单的退款申请列表
+func OrderRefunds(c *gin.Context) {
+	orderNo := c.Param("order_no")
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyRefunds, err := orderAppSvc.GetOrderRefunds(orderNo, c.GetInt64("userId"))
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderParams)
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).Success(replyRefunds)
+}
+
+// AdminAuditOrderRefund 管理后台审核退款申请
+func AdminAuditOrderRefund(c *gin.Context) {
+	request := new(request.OrderRefundAudit)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.AuditOrderRefund(c.Param("refund_no"), request)
+	if err != nil {
+		if errors.Is(err, errcode.ErrOrderRefundParams) {
+			app.NewResponse(c).Error(errcode.ErrOrderRefundParams)
+		} else if errors.Is(err, errcode.ErrOrderRefundNotAllowed) {
+			app.NewResponse(c).Error(errcode.ErrOrderRefundNotAllowed.WithCause(err))
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).SuccessOk()
+}
+
+// AdminPickOrders 管理后台批量把订单标记为检货完成
+func AdminPickOrders(c *gin.Context) {
+	request := new(request.OrderBatch)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	app.NewResponse(c).Success(orderAppSvc.PickOrders(request))
+}
+
+// AdminShipOrders 管理后台批量发货
+func AdminShipOrders(c *gin.Context) {
+	request := new(request.OrderShipBatch)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyResult, err := orderAppSvc.ShipOrders(request)
+	if err != nil {
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		return
+	}
+
+	app.NewResponse(c).Success(replyResult)
+}
+
+// AdminStartOrdersDelivery 管理后台批量把订单标记为派送中
+func AdminStartOrdersDelivery(c *gin.Context) {
+	request := new(request.OrderBatch)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	app.NewResponse(c).Success(orderAppSvc.StartOrdersDelivery(request))
+}
+
+// AdminDeliverOrders 管理后台批量把订单标记为已送达
+func AdminDeliverOrders(c *gin.Context) {
+	request := new(request.OrderBatch)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	app.NewResponse(c).Success(orderAppSvc.DeliverOrders(request))
+}
+
+// AdminSearchOrders 管理后台按条件查询订单
+func AdminSearchOrders(c *gin.Context) {
+	request := new(request.AdminOrderQuery)
+	if err := c.ShouldBindQuery(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	pagination := app.NewPagination(c)
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyOrders, err := orderAppSvc.SearchOrders(request, pagination)
+	if err != nil {
+		if errors.Is(err, errcode.ErrParams) {
+			app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).SetPagination(pagination).Success(replyOrders)
+}
+
+// AdminExportOrders 管理后台按条件导出订单的CSV文件, 查询条件与 AdminSearchOrders 相同
+// 订单边查询边写入响应, 导出大量订单时不会占用太多内存
+func AdminExportOrders(c *gin.Context) {
+	request := new(request.AdminOrderQuery)
+	if err := c.ShouldBindQuery(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="orders_%s.csv"`, time.Now().Format("20060102150405")))
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.ExportOrdersCsv(request, c.Writer)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		// 已经开始输出文件, 只能中断导出
+		logger.New(c).Error("AdminExportOrdersError", "err", err)
+		return
+	}
+	// 还没有输出文件时按接口的错误格式响应
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+	if errors.Is(err, errcode.ErrParams) {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+	} else {
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+	}
+}
+
+// AdminRunWxPayReconcile 管理后台手动执行某天的微信支付对账
+func AdminRunWxPayReconcile(c *gin.Context) {
+	request := new(request.PayReconcileRun)
+	if err := c.ShouldBindJSON(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyDiffs, err := orderAppSvc.ReconcileWxTradeBill(request.BillDate)
+	if err != nil {
+		if errors.Is(err, errcode.ErrParams) {
+			app.NewResponse(c).Error(errcode.ErrParams)
+		} else {
+			app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		}
+		return
+	}
+
+	app.NewResponse(c).Success(replyDiffs)
+}
+
+// AdminReconcileDiffs 管理后台查询支付对账差异
+func AdminReconcileDiffs(c *gin.Context) {
+	request := new(request.PayReconcileDiffQuery)
+	if err := c.ShouldBindQuery(request); err != nil {
+		app.NewResponse(c).Error(errcode.ErrParams.WithCause(err))
+		return
+	}
+	pagination := app.NewPagination(c)
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	replyDiffs, err := orderAppSvc.GetReconcileDiffs(request, pagination)
+	if err != nil {
+		app.NewResponse(c).Error(errcode.ErrServer.WithCause(err))
+		return
+	}
+
+	app.NewResponse(c).SetPagination(pagination).Success(replyDiffs)
+}
+
+// WxPayNotify 微信支付结果通知
+// 通知的应答不使用项目统一的响应格式, 接收成功时返回 204 无应答体, 失败时按微信要求返回 code 和 message
+func WxPayNotify(c *gin.Context) {
+	notifyRequest, rawBody, ok := bindWxPayNotify(c)
+	if !ok {
+		return
+	}
+
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.WxPayNotify(notifyRequest, rawBody)
+	replyWxPayNotify(c, err)
+}
+
+// WxRefundNotify 微信退款结果通知, 应答格式与支付结果通知相同
+func WxRefundNotify(c *gin.Context) {
+	notifyRequest, rawBody, ok := bindWxPayNotify(c)
+	if !ok {
+		return
+	}
+
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.WxRefundNotify(notifyRequest, rawBody)
+	replyWxPayNotify(c, err)
+}
+
+// bindWxPayNotify 读取微信支付通知的头信息和原始请求体, 读取失败时直接应答微信
+func bindWxPayNotify(c *gin.Context) (*request.WxPayNotifyRequest, string, bool) {
+	notifyRequest := new(request.WxPayNotifyRequest)
+	if err := c.ShouldBindHeader(&notifyRequest.Header); err != nil {
+		logger.New(c).Error("WxPayNotifyHeaderError", "err", err)
+		c.JSON(http.StatusBadRequest, &reply.WxPayNotifyReply{Code: "FAIL", Message: "通知头信息不完整"})
+		return nil, "", false
+	}
+	rawBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		logger.New(c).Error("WxPayNotifyBodyError", "err", err)
+		c.JSON(http.StatusBadRequest, &reply.WxPayNotifyReply{Code: "FAIL", Message: "读取通知内容失败"})
+		return nil, "", false
+	}
+	return notifyRequest, string(rawBody), true
+}
+
+// replyWxPayNotify 按处理结果应答微信支付通知
+func replyWxPayNotify(c *gin.Context, err error) {
+	if err != nil {
+		logger.New(c).Error("WxPayNotifyError", "err", err)
+		if errors.Is(err, errcode.ErrOrderPayNotifyInvalid) {
+			c.JSON(http.StatusUnauthorized, &reply.WxPayNotifyReply{Code: "FAIL", Message: "签名验证失败"})
+		} else {
+			// 返回非 2xx 的状态码, 微信会按照策略重新发送通知
+			c.JSON(http.StatusInternalServerError, &reply.WxPayNotifyReply{Code: "FAIL", Message: "失败"})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// AliPayNotify 支付宝异步通知
+// 处理成功时按支付宝要求返回纯文本 success, 返回其他内容支付宝会按策略重新发送通知
+func AliPayNotify(c *gin.Context) {
+	if err := c.Request.ParseForm(); err != nil {
+		logger.New(c).Error("AliPayNotifyFormError", "err", err)
+		c.String(http.StatusOK, "failure")
+		return
+	}
+
+	orderAppSvc := appservice.NewOrderAppSvc(c)
+	err := orderAppSvc.AliPayNotify(c.Request.PostForm)
+	if err != nil {
+		logger.New(c).Error("AliPayNotifyError", "err", err)
+		c.String(http.StatusOK, "failure")
+		return
+	}
+
+	c.String(http.StatusOK, "success")
+}
@@ -0,0 +1,32 @@
+package reply
+
+type FlashSale struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	CommodityId  int64  `json:"commodity_id"`
+	FlashPrice   int    `json:"flash_price"`
+	TotalStock   int    `json:"total_stock"`
+	SoldNum      int    `json:"sold_num"`
+	PerUserLimit int    `json:"per_user_limit"`
+	StartTime    string `json:"start_time"`
+	EndTime      string `json:"end_time"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// FlashSaleInfo 秒杀活动详情, 带上活动剩余的库存
+type FlashSaleInfo struct {
+	FlashSale
+	Stock int `json:"stock"`
+}
+
+// FlashSaleQueued 秒杀抢到库存后的响应, 用订单号轮询下单结果
+type FlashSaleQueued struct {
+	OrderNo string `json:"order_no"`
+}
+
+// FlashSaleOrderResult 秒杀下单的结果
+type FlashSaleOrderResult struct {
+	OrderNo    string `json:"order_no"`
+	State      int    `json:"state"` // 1-排队中 2-下单成功 3-下单失败
+	FailReason string `json:"fail_reason"`
+}
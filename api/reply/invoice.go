@@ -0,0 +1,25 @@
+package reply
+
+type InvoiceTitle struct {
+	ID        int64  `json:"id"`
+	TitleType int    `json:"title_type"`
+	Title     string `json:"title"`
+	TaxNo     string `json:"tax_no"`
+	CreatedAt string `json:"created_at"`
+}
+
+// OrderInvoice 订单的开票记录
+type OrderInvoice struct {
+	InvoiceNo        string `json:"invoice_no"`
+	OrderNo          string `json:"order_no"`
+	TitleType        int    `json:"title_type"`
+	Title            string `json:"title"`
+	TaxNo            string `json:"tax_no"`
+	Amount           int    `json:"amount"`
+	InvoiceState     int    `json:"invoice_state"` // 1-已申请 2-已开票 3-已红冲 4-开票中
+	InvoiceNumber    string `json:"invoice_number"`
+	RedInvoiceNumber string `json:"red_invoice_number"`
+	IssuedAt         string `json:"issued_at"`
+	RedFlushedAt     string `json:"red_flushed_at"`
+	CreatedAt        string `json:"created_at"`
+}
@@ -5,14 +5,72 @@ type OrderCreateReply struct {
 }
 
 type Order struct {
+	OrderNo    string `json:"order_no"`
+	PayTransId string `json:"pay_trans_id"`
+	PayType    int    `json:"pay_type"`
+	BillMoney  int    `json:"bill_money"`
+	PayMoney   int    `json:"pay_money"`
+	// 价格明细: bill_money - coupon_money - discount_money - vip_discount_money = pay_money
+	CouponMoney      int    `json:"coupon_money"`
+	DiscountMoney    int    `json:"discount_money"`
+	VipDiscountMoney int    `json:"vip_discount_money"`
+	PayState         int    `json:"pay_state"`
+	OrderStatus      int    `json:"-"`
+	FrontStatus      string `json:"status"`
+	Address          struct {
+		UserName      string `json:"user_name"`
+		UserPhone     string `json:"user_phone"`
+		ProvinceName  string `json:"province_name"`
+		CityName      string `json:"city_name"`
+		RegionName    string `json:"region_name"`
+		DetailAddress string `json:"detail_address"`
+	} `json:"address,omitempty"`
+	Items []struct {
+		CommodityId           int64  `json:"commodity_id"`
+		CommodityName         string `json:"commodity_name"`
+		CommodityImg          string `json:"commodity_img"`
+		CommoditySellingPrice int    `json:"commodity_selling_price"`
+		CommodityNum          int    `json:"commodity_num"`
+		DiscountMoney         int    `json:"discount_money"` // 分摊到商品的优惠金额
+		PaidMoney             int    `json:"paid_money"`     // 商品的实付金额
+	} `json:"items,omitempty"`
+	PayDeadline string `json:"pay_deadline"` // 支付截止时间, 前端用来展示支付倒计时
+	ShipCarrier string `json:"ship_carrier"` // 物流公司编码
+	TrackingNo  string `json:"tracking_no"`  // 物流单号
+	CreatedAt   string `json:"created_at"`
+	OrderType   int    `json:"order_type"` // 订单类型 0-未拆单 1-父订单 2-子订单
+	IsDel       int    `json:"is_del"`     // 用户是否删除了订单 0-未删除 1-已删除, 已删除的订单可以恢复
+	// ParentOrderNo 子订单的父订单号, SubOrders 父订单拆分出的子订单, 只在订单详情中返回
+	ParentOrderNo string   `json:"parent_order_no,omitempty"`
+	SubOrders     []*Order `json:"sub_orders,omitempty"`
+	// Timeline 订单状态变更的时间线, 只在订单详情中返回
+	Timeline []*OrderTimelineNode `json:"timeline,omitempty"`
+}
+
+// OrderTimelineNode 订单时间线上的一次状态变更
+type OrderTimelineNode struct {
+	Status    string `json:"status"` // 变更后订单的前台状态
+	Event     string `json:"event"`
+	Actor     int    `json:"actor"`
+	Remark    string `json:"remark"`
+	CreatedAt string `json:"created_at"`
+}
+
+// AdminOrder 管理后台查询的订单
+type AdminOrder struct {
 	OrderNo     string `json:"order_no"`
+	OrderType   int    `json:"order_type"` // 订单类型 0-未拆单 1-父订单 2-子订单
+	IsDel       int    `json:"is_del"`     // 用户是否删除了订单 0-未删除 1-已删除
+	UserId      int64  `json:"user_id"`
 	PayTransId  string `json:"pay_trans_id"`
 	PayType     int    `json:"pay_type"`
 	BillMoney   int    `json:"bill_money"`
 	PayMoney    int    `json:"pay_money"`
 	PayState    int    `json:"pay_state"`
-	OrderStatus int    `json:"-"`
+	OrderStatus int    `json:"order_status"`
 	FrontStatus string `json:"status"`
+	ShipCarrier string `json:"ship_carrier"`
+	TrackingNo  string `json:"tracking_no"`
 	Address     struct {
 		UserName      string `json:"user_name"`
 		UserPhone     string `json:"user_phone"`
@@ -20,13 +78,91 @@ type Order struct {
 		CityName      string `json:"city_name"`
 		RegionName    string `json:"region_name"`
 		DetailAddress string `json:"detail_address"`
-	} `json:"address,omitempty"`
+	} `json:"address"`
 	Items []struct {
 		CommodityId           int64  `json:"commodity_id"`
 		CommodityName         string `json:"commodity_name"`
-		CommodityImg          string `json:"commodity_img"`
 		CommoditySellingPrice int    `json:"commodity_selling_price"`
 		CommodityNum          int    `json:"commodity_num"`
-	} `json:"items,omitempty"`
+	} `json:"items"`
+	PaidAt    string `json:"paid_at"`
 	CreatedAt string `json:"created_at"`
 }
+
+// OrderBuyAgain 再次购买的结果
+type OrderBuyAgain struct {
+	AddedItems []struct {
+		CommodityId   int64  `json:"commodity_id"`
+		CommodityName string `json:"commodity_name"`
+		CommodityNum  int    `json:"commodity_num"`
+	} `json:"added_items"`
+	SkippedItems []struct {
+		CommodityId   int64  `json:"commodity_id"`
+		CommodityName string `json:"commodity_name"`
+		CommodityNum  int    `json:"commodity_num"`
+		Reason        string `json:"reason"` // DELETED-已删除 OFF_SHELF-已下架 OUT_OF_STOCK-库存不足
+	} `json:"skipped_items"`
+	PriceChangedItems []struct {
+		CommodityId   int64  `json:"commodity_id"`
+		CommodityName string `json:"commodity_name"`
+		OrderedPrice  int    `json:"ordered_price"` // 原订单中的价格
+		CurrentPrice  int    `json:"current_price"` // 现在的售价
+	} `json:"price_changed_items"`
+}
+
+// OrderTabCount 订单列表标签页上的订单数
+type OrderTabCount struct {
+	Status string `json:"status"` // 前台状态
+	Count  int64  `json:"count"`
+}
+
+type OrderRefund struct {
+	RefundNo     string `json:"refund_no"`
+	OrderNo      string `json:"order_no"`
+	RefundMoney  int    `json:"refund_money"`
+	IsFullRefund bool   `json:"is_full_refund"`
+	Reason       string `json:"reason"`
+	AuditRemark  string `json:"audit_remark"`
+	RefundState  int    `json:"refund_state"`
+	Items        []struct {
+		CommodityId  int64 `json:"commodity_id"`
+		CommodityNum int   `json:"commodity_num"`
+		RefundMoney  int   `json:"refund_money"`
+	} `json:"items"`
+	RefundedAt string `json:"refunded_at"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// PayReconcileDiff 支付对账差异
+type PayReconcileDiff struct {
+	BillDate        string `json:"bill_date"`
+	PayType         int    `json:"pay_type"`
+	DiffType        int    `json:"diff_type"` // 1-本地缺失 2-平台缺失 3-金额不一致 4-交易ID不一致
+	OrderNo         string `json:"order_no"`
+	LocalTransId    string `json:"local_trans_id"`
+	RemoteTransId   string `json:"remote_trans_id"`
+	LocalPayMoney   int    `json:"local_pay_money"`
+	RemotePayMoney  int    `json:"remote_pay_money"`
+	RemoteTradeTime string `json:"remote_trade_time"`
+	CreatedAt       string `json:"created_at"`
+}
+
+// WxPayNotifyReply 处理微信支付通知失败时按微信要求的格式返回的应答
+// https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/payment-notice.html
+type WxPayNotifyReply struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// OrderBatchResult 批量操作订单的结果, 部分订单失败时不影响其他订单
+type OrderBatchResult struct {
+	Succeeded []string             `json:"succeeded"`
+	Failed    []*OrderBatchFailure `json:"failed"`
+}
+
+// OrderBatchFailure 批量操作中失败的订单
+type OrderBatchFailure struct {
+	OrderNo string `json:"order_no"`
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+}
@@ -0,0 +1,18 @@
+package request
+
+// FlashSaleCreate 管理后台创建秒杀活动请求
+type FlashSaleCreate struct {
+	Name         string `json:"name" binding:"required,max=100"`
+	CommodityId  int64  `json:"commodity_id" binding:"required"`
+	FlashPrice   int    `json:"flash_price" binding:"required,min=1"` // 秒杀价, 单位: 分
+	TotalStock   int    `json:"total_stock" binding:"required,min=1"`
+	PerUserLimit int    `json:"per_user_limit" binding:"required,min=1,max=99"`
+	StartTime    string `json:"start_time" binding:"required,datetime=2006-01-02 15:04:05"`
+	EndTime      string `json:"end_time" binding:"required,datetime=2006-01-02 15:04:05"`
+}
+
+// FlashSaleBuy 秒杀抢购请求
+type FlashSaleBuy struct {
+	CommodityNum  int   `json:"commodity_num" binding:"required,min=1,max=99"`
+	UserAddressId int64 `json:"user_address_id" binding:"required"`
+}
@@ -0,0 +1,19 @@
+package request
+
+// InvoiceTitle 新增和修改发票抬头请求
+type InvoiceTitle struct {
+	TitleType int    `json:"title_type" binding:"required,oneof=1 2"` // 1-个人 2-单位
+	Title     string `json:"title" binding:"required,max=100"`
+	TaxNo     string `json:"tax_no" binding:"omitempty,alphanum,min=15,max=20"` // 单位抬头的纳税人识别号
+}
+
+// OrderInvoiceApply 用户申请开票请求
+type OrderInvoiceApply struct {
+	OrderNo string `json:"order_no" binding:"required"`
+	TitleId int64  `json:"title_id" binding:"required"`
+}
+
+// AdminInvoiceQuery 管理后台查询开票记录请求
+type AdminInvoiceQuery struct {
+	InvoiceState int `form:"invoice_state" binding:"omitempty,oneof=1 2 3 4"` // 不传时查询所有状态的开票记录
+}
@@ -7,19 +7,98 @@ type OrderCreate struct {
 	UserAddressId  int64   `json:"user_address_id" binding:"required"`
 }
 
+// UserOrderQuery 用户订单列表查询请求
+type UserOrderQuery struct {
+	FrontStatus string `form:"front_status"` // 订单的前台状态, 比如 待付款 待发货, 不传时查询所有订单
+}
+
+// OrderBuyNow 立即购买请求, 不经过购物车直接下单
+type OrderBuyNow struct {
+	CommodityId   int64 `json:"commodity_id" binding:"required"`
+	CommodityNum  int   `json:"commodity_num" binding:"required,min=1,max=99"`
+	UserAddressId int64 `json:"user_address_id" binding:"required"`
+}
+
 // OrderPayCreate 订单发起支付请求
 type OrderPayCreate struct {
+	OrderNo  string `json:"order_no" binding:"required"`
+	PayType  int    `json:"pay_type" binding:"required,oneof= 1 2 3"`
+	PayScene string `json:"pay_scene" binding:"required"` // 支付场景 微信支付: jsapi app h5 native ; 支付宝: web wap app ; 沙箱支付: 任意
+	ClientIp string `json:"-"`                            // 用户的客户端IP, 由控制器设置, H5支付需要
+}
+
+// OrderRefundApply 用户申请退款请求
+type OrderRefundApply struct {
 	OrderNo string `json:"order_no" binding:"required"`
-	PayType int    `json:"pay_type" binding:"required,oneof= 1 2"`
+	Reason  string `json:"reason" binding:"required,max=80"`
+	// 要退的商品, 不传时整单退款
+	Items []struct {
+		CommodityId  int64 `json:"commodity_id" binding:"required"`
+		CommodityNum int   `json:"commodity_num" binding:"required,min=1"`
+	} `json:"items" binding:"omitempty,dive"`
+}
+
+// OrderRefundAudit 管理后台审核退款申请请求
+type OrderRefundAudit struct {
+	Approved    bool   `json:"approved"`
+	AuditRemark string `json:"audit_remark" binding:"max=200"`
+}
+
+// OrderBatch 管理后台批量操作订单请求
+type OrderBatch struct {
+	OrderNos []string `json:"order_nos" binding:"required,min=1,max=100,dive,required"`
+}
+
+// OrderShipBatch 管理后台批量发货请求
+type OrderShipBatch struct {
+	Shipments []*OrderShipment `json:"shipments" binding:"required,min=1,max=100,dive"`
+}
+
+// OrderShipment 订单的发货信息
+type OrderShipment struct {
+	OrderNo     string `json:"order_no" binding:"required"`
+	CarrierCode string `json:"carrier_code" binding:"required,max=10"` // 物流公司编码, 比如 SF ZTO
+	TrackingNo  string `json:"tracking_no" binding:"required,max=40"`  // 物流单号
+}
+
+// SandboxPaySimulate 模拟沙箱支付结果请求
+type SandboxPaySimulate struct {
+	OrderNo      string `json:"order_no" binding:"required"`
+	Result       string `json:"result" binding:"required,oneof=success fail delay"` // 模拟的支付结果 success-支付成功 fail-支付失败 delay-延迟通知支付成功
+	DelaySeconds int    `json:"delay_seconds" binding:"omitempty,min=1,max=300"`    // 延迟通知的秒数, 不传时延迟10秒
+}
+
+// PayReconcileRun 管理后台手动执行支付对账请求
+type PayReconcileRun struct {
+	BillDate string `json:"bill_date" binding:"required,datetime=2006-01-02"`
+}
+
+// PayReconcileDiffQuery 管理后台查询对账差异请求
+type PayReconcileDiffQuery struct {
+	BillDate string `form:"bill_date" binding:"required,datetime=2006-01-02"`
+	DiffType int    `form:"diff_type" binding:"omitempty,oneof=1 2 3 4"` // 不传时查询所有类型的差异
+}
+
+// AdminOrderQuery 管理后台查询订单请求, 不传的条件不参与查询
+type AdminOrderQuery struct {
+	OrderNo     string `form:"order_no"`
+	UserId      int64  `form:"user_id"`
+	UserPhone   string `form:"user_phone"`                                         // 收货人手机号
+	StartDate   string `form:"start_date" binding:"omitempty,datetime=2006-01-02"` // 下单日期的开始, 包含
+	EndDate     string `form:"end_date" binding:"omitempty,datetime=2006-01-02"`   // 下单日期的结束, 包含
+	OrderStatus *int   `form:"order_status" binding:"omitempty,min=0"`
+	PayState    *int   `form:"pay_state" binding:"omitempty,oneof=0 1 2 3"` // 0-未发起支付 1-待支付 2-已支付 3-支付失败
+	PayType     *int   `form:"pay_type" binding:"omitempty,oneof=0 1 2 3"`
 }
 
 // WxPayNotifyRequest 微信支付回调通知请求
 // https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/payment-notice.html
 type WxPayNotifyRequest struct {
 	Header struct {
-		Timestamp string `json:"Wechatpay-Timestamp"`
-		Nonce     string `json:"Wechatpay-Nonce"`
-		Signature string `json:"Wechatpay-Signature""`
+		Timestamp string `header:"Wechatpay-Timestamp" binding:"required"`
+		Nonce     string `header:"Wechatpay-Nonce" binding:"required"`
+		Signature string `header:"Wechatpay-Signature" binding:"required"`
+		Serial    string `header:"Wechatpay-Serial" binding:"required"`
 	}
 	Body struct {
 		ID           string    `json:"id"`
@@ -0,0 +1,33 @@
+package router
+
+import (
+	"github.com/WoWBytePaladin/go-mall/api/controller"
+	"github.com/WoWBytePaladin/go-mall/common/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func registerAdminRoutes(rg *gin.RouterGroup) {
+	// 这个路由组中的路由都以 /admin/ 开头, 是给管理后台用的接口
+	g := rg.Group("/admin/")
+	g.Use(middleware.AuthAdmin())
+	// 审核退款申请
+	g.POST("order/refund/:refund_no/audit", controller.AdminAuditOrderRefund)
+	// 订单查询和导出
+	g.GET("orders", controller.AdminSearchOrders)
+	g.GET("orders/export", controller.AdminExportOrders)
+	// 商家履约: 检货、发货、派送、送达, 都支持批量操作
+	g.POST("order/pick", controller.AdminPickOrders)
+	g.POST("order/ship", controller.AdminShipOrders)
+	g.POST("order/start-delivery", controller.AdminStartOrdersDelivery)
+	g.POST("order/deliver", controller.AdminDeliverOrders)
+	// 支付对账
+	g.POST("reconcile/wxpay", controller.AdminRunWxPayReconcile)
+	g.GET("reconcile/diffs", controller.AdminReconcileDiffs)
+	// 开票: 查询开票记录、开具发票、红冲发票
+	g.GET("invoices", controller.AdminInvoices)
+	g.POST("invoice/:invoice_no/issue", controller.AdminIssueInvoice)
+	g.POST("invoice/:invoice_no/red-flush", controller.AdminRedFlushInvoice)
+	// 秒杀活动
+	g.GET("flash-sales", controller.AdminFlashSales)
+	g.POST("flash-sale", controller.AdminCreateFlashSale)
+}
@@ -0,0 +1,21 @@
+package router
+
+import (
+	"github.com/WoWBytePaladin/go-mall/api/controller"
+	"github.com/WoWBytePaladin/go-mall/common/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+func registerFlashSaleRoutes(rg *gin.RouterGroup) {
+	// 这个路由组中的路由都以 /flash-sale/ 开头
+	g := rg.Group("/flash-sale/")
+	// 秒杀活动详情, 带活动剩余的库存
+	g.GET(":flash_sale_id/info", controller.FlashSaleInfo)
+
+	authGroup := rg.Group("/flash-sale/")
+	authGroup.Use(middleware.AuthUser())
+	// 秒杀抢购, 抢到库存后排队下单
+	authGroup.POST(":flash_sale_id/buy", controller.FlashSaleBuy)
+	// 轮询秒杀下单的结果
+	authGroup.GET("order-result/:order_no", controller.FlashSaleOrderResult)
+}
@@ -7,17 +7,52 @@ import (
 )
 
 func registerOrderRoutes(rg *gin.RouterGroup) {
+	// 支付平台的支付结果通知, 由支付平台回调, 不需要用户身份验证
+	notifyGroup := rg.Group("/order/")
+	// 微信支付结果通知
+	notifyGroup.POST("wxpay-notify", controller.WxPayNotify)
+	// 微信退款结果通知
+	notifyGroup.POST("wxpay-refund-notify", controller.WxRefundNotify)
+	// 支付宝异步通知
+	notifyGroup.POST("alipay-notify", controller.AliPayNotify)
+
 	// 这个路由组中的路由都以 /order/ 开头, 并且都需要身份验证
 	g := rg.Group("/order/")
 	g.Use(middleware.AuthUser())
 	// 创建订单
 	g.POST("create", controller.OrderCreate)
+	// 立即购买, 不经过购物车直接下单
+	g.POST("buy-now", controller.OrderBuyNow)
 	// 用户订单列表
 	g.GET("user-order/", controller.UserOrders)
+	// 用户订单列表每个标签页的订单数
+	g.GET("user-order/summary", controller.UserOrderTabCounts)
+	// 用户删除了的订单列表
+	g.GET("user-order/deleted", controller.UserDeletedOrders)
 	// 订单详情
 	g.GET(":order_no/info", controller.OrderInfo)
 	// 取消订单
 	g.PATCH(":order_no/cancel", controller.OrderCancel)
+	// 确认收货
+	g.PATCH(":order_no/confirm-receipt", controller.OrderConfirmReceipt)
+	// 删除订单, 只能删除已完成、已取消或者已退款的订单
+	g.DELETE(":order_no", controller.OrderDelete)
+	// 恢复删除了的订单
+	g.PATCH(":order_no/restore", controller.OrderRestore)
+	// 再次购买
+	g.POST(":order_no/buy-again", controller.OrderBuyAgain)
 	// 发起订单支付
 	g.POST("create-pay", controller.CreateOrderPay)
+	// 模拟沙箱支付的支付结果, 只在开发和测试环境可用
+	g.POST("sandbox-pay/simulate", controller.SandboxPaySimulate)
+	// 申请退款
+	g.POST("refund", controller.OrderRefundApply)
+	// 订单的退款申请
+	g.GET(":order_no/refunds", controller.OrderRefunds)
+	// 申请开票
+	g.POST("invoice", controller.OrderInvoiceApply)
+	// 订单的开票记录
+	g.GET(":order_no/invoices", controller.OrderInvoices)
+	// 下载发票PDF, 红冲后下载的是红字发票
+	g.GET("invoice/:invoice_no/pdf", controller.OrderInvoicePdf)
 }
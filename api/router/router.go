@@ -14,4 +14,6 @@ func RegisterRoutes(engine *gin.Engine) {
 	registerCommodityRoutes(routeGroup)
 	registerCartRoutes(routeGroup)
 	registerOrderRoutes(routeGroup)
+	registerFlashSaleRoutes(routeGroup)
+	registerAdminRoutes(routeGroup)
 }
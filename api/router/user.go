@@ -37,4 +37,12 @@ func registerUserRoutes(rg *gin.RouterGroup) {
 	g.PATCH("address/:address_id", middleware.AuthUser(), controller.UpdateUserAddress)
 	// 删除用户的单条地址信息
 	g.DELETE("address/:address_id", middleware.AuthUser(), controller.DeleteUserAddress)
+	// 新增发票抬头
+	g.POST("invoice-title", middleware.AuthUser(), controller.AddInvoiceTitle)
+	// 查询用户所有的发票抬头
+	g.GET("invoice-title/", middleware.AuthUser(), controller.GetInvoiceTitles)
+	// 修改发票抬头
+	g.PATCH("invoice-title/:title_id", middleware.AuthUser(), controller.UpdateInvoiceTitle)
+	// 删除发票抬头
+	g.DELETE("invoice-title/:title_id", middleware.AuthUser(), controller.DeleteInvoiceTitle)
 }
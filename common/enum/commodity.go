@@ -0,0 +1,14 @@
+package enum
+
+// 商品的上架状态
+const (
+	CommoditySellStatusOn  = iota + 1 // 上架
+	CommoditySellStatusOff            // 下架
+)
+
+// 商品库存预占的状态
+const (
+	StockReservationStateReserved  = iota + 1 // 已预占, 订单创建后未支付
+	StockReservationStateCommitted            // 已售出, 订单支付成功
+	StockReservationStateReleased             // 已释放, 订单取消或者超时关闭
+)
@@ -0,0 +1,8 @@
+package enum
+
+// 秒杀下单的排队结果
+const (
+	FlashSaleOrderStateQueued  = iota + 1 // 排队中
+	FlashSaleOrderStateCreated            // 下单成功
+	FlashSaleOrderStateFailed             // 下单失败
+)
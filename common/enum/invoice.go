@@ -0,0 +1,20 @@
+package enum
+
+// 发票抬头类型
+const (
+	InvoiceTitleTypePersonal = iota + 1 // 个人
+	InvoiceTitleTypeCompany             // 单位, 需要填写纳税人识别号
+)
+
+// 发票的状态
+const (
+	InvoiceStateRequested  = iota + 1 // 已申请, 等待开票
+	InvoiceStateIssued                // 已开票
+	InvoiceStateRedFlushed            // 已红冲 -- 开出了对应的红字发票, 原发票作废
+	InvoiceStateIssuing               // 开票中 -- 已被开票请求占用, 正在调用开票服务
+)
+
+// 开票服务的类型
+const (
+	InvoiceIssuerLocal = "local" // 本地开票, 只生成PDF, 用于开发测试和还没有对接开票平台的时候
+)
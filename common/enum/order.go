@@ -11,35 +11,126 @@ const (
 	PayTypeNotConfirmed = iota // 未确认 -- 创建订单时的初始状态
 	PayTypeWxPay               // 微信支付
 	PayTypeAliPay              // 支付宝
+	PayTypeSandbox             // 沙箱支付 -- 只在开发和测试环境可用, 模拟支付平台走通支付流程
 )
 
 const (
-	OrderStatusCreated        = iota // 已创建
-	OrderStatusUnPaid                // 待支付
-	OrderStatusPaid                  // 已支付
-	OrderStatusChecked               // 检货完成
-	OrderStatusShipped               // 已发货
-	OrderStatusOnDelivery            // 配送中 -- 快递员上门送货中
-	OrderStatusDelivered             // 已送达
-	OrderStatusConfirmReceipt        // 已确认收货
-	OrderStatusCompleted             // 订单完成
-	OrderStatusUserQuit              // 用户取消
-	OrderStatusUnpaidClose           // 超时未支付
-	OrderStatusMerchantClose         // 商家关闭订单
+	OrderStatusCreated         = iota // 已创建
+	OrderStatusUnPaid                 // 待支付
+	OrderStatusPaid                   // 已支付
+	OrderStatusChecked                // 检货完成
+	OrderStatusShipped                // 已发货
+	OrderStatusOnDelivery             // 配送中 -- 快递员上门送货中
+	OrderStatusDelivered              // 已送达
+	OrderStatusConfirmReceipt         // 已确认收货
+	OrderStatusCompleted              // 订单完成
+	OrderStatusUserQuit               // 用户取消
+	OrderStatusUnpaidClose            // 超时未支付
+	OrderStatusMerchantClose          // 商家关闭订单
+	OrderStatusRefunded               // 已全额退款
+	OrderStatusPartialRefunded        // 部分退款
+)
+
+// 触发订单状态变更的角色
+const (
+	OrderActorUser     = iota + 1 // 用户
+	OrderActorMerchant            // 商家
+	OrderActorSystem              // 系统 -- 后台任务等自动触发
+	OrderActorPayment             // 支付平台 -- 支付结果、退款结果
+)
+
+// 订单状态变更的事件
+const (
+	OrderEventStartPay       = "START_PAY"       // 发起支付
+	OrderEventPaySuccess     = "PAY_SUCCESS"     // 支付成功
+	OrderEventUserCancel     = "USER_CANCEL"     // 用户取消
+	OrderEventPayTimeout     = "PAY_TIMEOUT"     // 超时未支付关闭
+	OrderEventMerchantClose  = "MERCHANT_CLOSE"  // 商家关闭
+	OrderEventCheck          = "CHECK"           // 检货完成
+	OrderEventShip           = "SHIP"            // 发货
+	OrderEventStartDelivery  = "START_DELIVERY"  // 开始派送
+	OrderEventDeliver        = "DELIVER"         // 送达
+	OrderEventConfirmReceipt = "CONFIRM_RECEIPT" // 确认收货
+	OrderEventComplete       = "COMPLETE"        // 订单完成
+	OrderEventRefund         = "REFUND"          // 全额退款
+	OrderEventPartialRefund  = "PARTIAL_REFUND"  // 部分退款
+)
+
+// 退款申请的状态
+const (
+	RefundStatePending    = iota // 待审核
+	RefundStateProcessing        // 退款中 -- 审核通过, 已向支付平台发起退款
+	RefundStateSuccess           // 退款成功
+	RefundStateRejected          // 审核未通过
+	RefundStateFailed            // 退款失败
 )
 
 // OrderFrontStatus 用户在前台看到的订单状态
 var OrderFrontStatus = map[int]string{
-	OrderStatusCreated:        "待付款",
-	OrderStatusUnPaid:         "待付款",
-	OrderStatusPaid:           "待发货",
-	OrderStatusChecked:        "待发货",
-	OrderStatusShipped:        "待收货",
-	OrderStatusOnDelivery:     "待收货",
-	OrderStatusDelivered:      "待收货",
-	OrderStatusConfirmReceipt: "待评价",
-	OrderStatusCompleted:      "已完成",
-	OrderStatusUserQuit:       "已取消",
-	OrderStatusUnpaidClose:    "已取消",
-	OrderStatusMerchantClose:  "已取消",
+	OrderStatusCreated:         "待付款",
+	OrderStatusUnPaid:          "待付款",
+	OrderStatusPaid:            "待发货",
+	OrderStatusChecked:         "待发货",
+	OrderStatusShipped:         "待收货",
+	OrderStatusOnDelivery:      "待收货",
+	OrderStatusDelivered:       "待收货",
+	OrderStatusConfirmReceipt:  "待评价",
+	OrderStatusCompleted:       "已完成",
+	OrderStatusUserQuit:        "已取消",
+	OrderStatusUnpaidClose:     "已取消",
+	OrderStatusMerchantClose:   "已取消",
+	OrderStatusRefunded:        "已退款",
+	OrderStatusPartialRefunded: "部分退款",
+}
+
+// OrderFrontStatusTabs 订单列表的标签页, 标签页上显示对应前台状态的订单数
+var OrderFrontStatusTabs = []string{"待付款", "待发货", "待收货", "待评价"}
+
+// 支付对账差异的类型
+const (
+	ReconcileDiffMissingLocal    = iota + 1 // 支付平台有交易, 本地订单没有支付成功
+	ReconcileDiffMissingRemote              // 本地订单支付成功, 支付平台账单中没有交易
+	ReconcileDiffMoneyMismatch              // 支付金额不一致
+	ReconcileDiffTransIdMismatch            // 支付平台交易ID不一致
+)
+
+// ShipCarriers 支持的物流公司, 物流公司编码 -> 名称
+var ShipCarriers = map[string]string{
+	"SF":    "顺丰速运",
+	"EMS":   "中国邮政EMS",
+	"JD":    "京东物流",
+	"ZTO":   "中通快递",
+	"YTO":   "圆通速递",
+	"STO":   "申通快递",
+	"YUNDA": "韵达快递",
+	"JTSD":  "极兔速递",
+}
+
+// 再次购买时跳过订单商品的原因
+const (
+	BuyAgainSkipDeleted    = "DELETED"      // 商品已删除
+	BuyAgainSkipOffShelf   = "OFF_SHELF"    // 商品已下架
+	BuyAgainSkipOutOfStock = "OUT_OF_STOCK" // 库存不足
+)
+
+// 业务单号的前缀, 单号由ID生成器生成, 同一种单号长度固定
+const (
+	BizNoPrefixOrder   = ""  // 订单号
+	BizNoPrefixRefund  = "R" // 退款单号
+	BizNoPrefixInvoice = "I" // 开票申请单号
+)
+
+// 订单类型, 一次下单的商品来自不同的商家或者仓库时拆分成父订单和子订单
+// 用户支付父订单, 支付后由子订单分别发货、退款
+const (
+	OrderTypeNormal = iota // 没有拆单的订单
+	OrderTypeParent        // 拆单后的父订单
+	OrderTypeSub           // 拆单后的子订单
+)
+
+// OrderTypeNames 订单类型的名称
+var OrderTypeNames = map[int]string{
+	OrderTypeNormal: "普通订单",
+	OrderTypeParent: "父订单",
+	OrderTypeSub:    "子订单",
 }
@@ -15,3 +15,34 @@ const (
 	REDISKEY_TOKEN_REFRESH_LOCK  = "GOMALL:USER:TOKEN_REFRESH_LOCk_%s"
 	REDISKEY_PASSWORDRESET_TOKEN = "GOMALL:USER:PASSWORD_RESET_TOKEN_%s"
 )
+
+const (
+	REDISKEY_JOB_LOCK = "GOMALL:JOB:LOCK_%s"
+)
+
+const (
+	REDISKEY_ORDER_PAY_DEADLINE_QUEUE = "GOMALL:ORDER:PAY_DEADLINE_QUEUE"
+	REDISKEY_ORDER_PAY_LOCK           = "GOMALL:ORDER:PAY_LOCK_%s"
+	REDISKEY_ORDER_PAY_REPLY          = "GOMALL:ORDER:PAY_REPLY_%s_%d_%s"
+	REDISKEY_PAY_RECONCILE_DONE       = "GOMALL:ORDER:PAY_RECONCILE_DONE_%d_%s"
+	REDISKEY_ORDER_PAY_QUERY_TIMES    = "GOMALL:ORDER:PAY_QUERY_TIMES_%s"
+	REDISKEY_ORDER_PAY_QUERY_WAIT     = "GOMALL:ORDER:PAY_QUERY_WAIT_%s"
+	REDISKEY_ORDER_SANDBOX_PAY        = "GOMALL:ORDER:SANDBOX_PAY_%s"
+)
+
+const (
+	REDISKEY_IDGEN_WORKER        = "GOMALL:IDGEN:WORKER_%d"
+	REDISKEY_IDGEN_WORKER_CURSOR = "GOMALL:IDGEN:WORKER_CURSOR"
+)
+
+// 秒杀活动的键名带上 {活动ID} 哈希标签, 让同一个活动的键在 Redis 集群中落在同一个槽, Lua 脚本才能同时操作它们
+const (
+	REDISKEY_FLASHSALE_INFO             = "GOMALL:FLASHSALE:INFO_{%d}"
+	REDISKEY_FLASHSALE_STOCK            = "GOMALL:FLASHSALE:STOCK_{%d}"
+	REDISKEY_FLASHSALE_USER_BOUGHT      = "GOMALL:FLASHSALE:USER_BOUGHT_{%d}"
+	REDISKEY_FLASHSALE_RETURNED         = "GOMALL:FLASHSALE:RETURNED_{%d}"
+	REDISKEY_FLASHSALE_ORDER_QUEUE      = "GOMALL:FLASHSALE:ORDER_QUEUE"
+	REDISKEY_FLASHSALE_ORDER_PROCESSING = "GOMALL:FLASHSALE:ORDER_PROCESSING"
+	REDISKEY_FLASHSALE_ORDER_CLAIMED    = "GOMALL:FLASHSALE:ORDER_CLAIMED"
+	REDISKEY_FLASHSALE_ORDER_RESULT     = "GOMALL:FLASHSALE:ORDER_RESULT_%s"
+)
@@ -47,6 +47,31 @@ var (
 	ErrOrderParams              = newError(10000500, "订单参数异常")
 	ErrOrderCanNotBeChanged     = newError(10000501, "订单不可修改")
 	ErrOrderUnsupportedPayScene = newError(10000502, "支付场景暂不支持")
+	ErrOrderPayNotifyInvalid    = newError(10000503, "支付通知校验失败")
+	ErrOrderPayMoneyMismatch    = newError(10000504, "支付金额与订单金额不一致")
+	ErrOrderRefundParams        = newError(10000505, "退款申请参数异常")
+	ErrOrderRefundNotAllowed    = newError(10000506, "订单当前不可申请退款")
+	ErrOrderSandboxPayDisabled  = newError(10000507, "沙箱支付仅在开发和测试环境可用")
+	ErrOrderNotExists           = newError(10000508, "订单不存在")
+	ErrOrderShipCarrierInvalid  = newError(10000509, "不支持的物流公司")
+	ErrOrderPaidAfterClosed     = newError(10000510, "订单关闭后收到付款")
+)
+
+// 发票模块相关错误码 10000600 ~ 10000699
+var (
+	ErrInvoiceParams       = newError(10000600, "开票参数异常")
+	ErrInvoiceNotAllowed   = newError(10000601, "订单当前不可申请开票")
+	ErrInvoiceNotExists    = newError(10000602, "发票不存在")
+	ErrInvoiceStateInvalid = newError(10000603, "发票当前状态不支持该操作")
+)
+
+// 秒杀模块相关错误码 10000700 ~ 10000799
+var (
+	ErrFlashSaleParams    = newError(10000700, "秒杀活动参数异常")
+	ErrFlashSaleNotExists = newError(10000701, "秒杀活动不存在")
+	ErrFlashSaleNotInTime = newError(10000702, "不在秒杀活动时间内")
+	ErrFlashSaleSoldOut   = newError(10000703, "秒杀商品已抢完")
+	ErrFlashSaleOverLimit = newError(10000704, "超过秒杀活动的限购数量")
 )
 
 func (e *AppError) HttpStatusCode() int {
@@ -56,7 +81,10 @@ func (e *AppError) HttpStatusCode() int {
 	case ErrServer.Code(), ErrPanic.Code():
 		return http.StatusInternalServerError
 	case ErrParams.Code(), ErrUserInvalid.Code(), ErrUserNameOccupied.Code(), ErrUserNotRight.Code(),
-		ErrCommodityNotExists.Code(), ErrCommodityStockOut.Code(), ErrCartItemParam.Code(), ErrOrderParams.Code():
+		ErrCommodityNotExists.Code(), ErrCommodityStockOut.Code(), ErrCartItemParam.Code(), ErrOrderParams.Code(),
+		ErrOrderUnsupportedPayScene.Code(), ErrOrderPayNotifyInvalid.Code(), ErrOrderPayMoneyMismatch.Code(),
+		ErrOrderRefundParams.Code(), ErrOrderNotExists.Code(), ErrOrderShipCarrierInvalid.Code(),
+		ErrInvoiceParams.Code(), ErrInvoiceNotExists.Code(), ErrFlashSaleParams.Code(), ErrFlashSaleNotExists.Code():
 		return http.StatusBadRequest
 	case ErrNotFound.Code():
 		return http.StatusNotFound
@@ -64,7 +92,10 @@ func (e *AppError) HttpStatusCode() int {
 		return http.StatusTooManyRequests
 	case ErrToken.Code():
 		return http.StatusUnauthorized
-	case ErrForbidden.Code(), ErrCartWrongUser.Code(), ErrOrderCanNotBeChanged.Code():
+	case ErrForbidden.Code(), ErrCartWrongUser.Code(), ErrOrderCanNotBeChanged.Code(),
+		ErrOrderRefundNotAllowed.Code(), ErrOrderSandboxPayDisabled.Code(),
+		ErrInvoiceNotAllowed.Code(), ErrInvoiceStateInvalid.Code(),
+		ErrFlashSaleNotInTime.Code(), ErrFlashSaleSoldOut.Code(), ErrFlashSaleOverLimit.Code():
 		return http.StatusForbidden
 	default:
 		return http.StatusInternalServerError
@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"crypto/subtle"
+
 	"github.com/WoWBytePaladin/go-mall/common/app"
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/config"
 	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
 	"github.com/gin-gonic/gin"
 )
@@ -33,3 +36,17 @@ func AuthUser() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AuthAdmin 管理后台接口的认证, 请求头中的 go-mall-admin-token 需要跟配置的管理令牌一致
+func AuthAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.Request.Header.Get("go-mall-admin-token")
+		adminToken := config.App.AdminToken
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			app.NewResponse(c).Error(errcode.ErrForbidden)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
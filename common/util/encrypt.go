@@ -61,6 +61,19 @@ func PKCS5UnPadding(origData []byte) []byte {
 
 // RsaSignPKCS1v15 对消息的散列值进行数字签名
 func RsaSignPKCS1v15(msg, privateKey []byte, hashType crypto.Hash) ([]byte, error) {
+	key, err := ParseRsaPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := rsa.SignPKCS1v15(cryptoRand.Reader, key, hashType, msg)
+	if err != nil {
+		return nil, errors.New("sign error")
+	}
+	return sign, nil
+}
+
+// ParseRsaPrivateKey 解析 PKCS8 格式的 PEM 私钥
+func ParseRsaPrivateKey(privateKey []byte) (*rsa.PrivateKey, error) {
 	block, _ := pem.Decode(privateKey)
 	if block == nil {
 		return nil, errors.New("private key decode error")
@@ -73,11 +86,43 @@ func RsaSignPKCS1v15(msg, privateKey []byte, hashType crypto.Hash) ([]byte, erro
 	if ok == false {
 		return nil, errors.New("private key format error")
 	}
-	sign, err := rsa.SignPKCS1v15(cryptoRand.Reader, key, hashType, msg)
+	return key, nil
+}
+
+// RsaVerifyPKCS1v15 用PEM格式的公钥验证消息散列值的数字签名
+// publicKey 支持 PUBLIC KEY(PKIX) 和 CERTIFICATE(X509证书) 两种PEM格式
+func RsaVerifyPKCS1v15(msg, sign, publicKey []byte, hashType crypto.Hash) error {
+	key, err := ParseRsaPublicKey(publicKey)
 	if err != nil {
-		return nil, errors.New("sign error")
+		return err
 	}
-	return sign, nil
+	return rsa.VerifyPKCS1v15(key, hashType, msg, sign)
+}
+
+// ParseRsaPublicKey 解析 PUBLIC KEY(PKIX) 或 CERTIFICATE(X509证书) 格式的 PEM 公钥
+func ParseRsaPublicKey(publicKey []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(publicKey)
+	if block == nil {
+		return nil, errors.New("public key decode error")
+	}
+	var pub interface{}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.New("parse certificate error")
+		}
+		pub = cert.PublicKey
+	} else {
+		var err error
+		if pub, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return nil, errors.New("parse public key error")
+		}
+	}
+	key, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key format error")
+	}
+	return key, nil
 }
 
 // SHA256HashString 对字符串消息进行 sha256 哈希
@@ -103,13 +103,14 @@ func Request(method string, url string, options ...Option) (httpStatusCode int,
 	}()
 
 	httpStatusCode = resp.StatusCode
-	if httpStatusCode != http.StatusOK {
-		// 返回非 200 时Go的 http 库不回返回error, 这里处理成error 调用方好判断
-		err = errcode.Wrap("request api error", errors.New(fmt.Sprintf("non 200 response, response code: %d", httpStatusCode)))
+	// 非 2xx 的应答体里一般有接口返回的错误信息, 先读出来一起返回给调用方
+	respBody, _ = ioutil.ReadAll(resp.Body)
+	if httpStatusCode < http.StatusOK || httpStatusCode >= http.StatusMultipleChoices {
+		// 返回非 2xx 时Go的 http 库不回返回error, 这里处理成error 调用方好判断
+		err = errcode.Wrap("request api error", errors.New(fmt.Sprintf("non 2xx response, response code: %d", httpStatusCode)))
 		return
 	}
 
-	respBody, _ = ioutil.ReadAll(resp.Body)
 	return
 }
 
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 项目里金额统一以分为单位用整数保存, 对接使用元为单位的支付平台时用下面的函数转换
+
+// FenToYuan 把以分为单位的金额转换成以元为单位的字符串 549700 ---> "5497.00"
+func FenToYuan(fen int) string {
+	sign := ""
+	if fen < 0 {
+		sign = "-"
+		fen = -fen
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, fen/100, fen%100)
+}
+
+// YuanToFen 把以元为单位的金额字符串转换成以分为单位的整数 "5497.00" ---> 549700
+// 不使用浮点数转换, 避免精度问题
+func YuanToFen(yuan string) (int, error) {
+	yuan = strings.TrimSpace(yuan)
+	negative := strings.HasPrefix(yuan, "-")
+	yuan = strings.TrimPrefix(yuan, "-")
+	parts := strings.Split(yuan, ".")
+	if len(parts) > 2 || parts[0] == "" {
+		return 0, errors.New("invalid money format: " + yuan)
+	}
+	// strconv.Atoi 允许正负号, 整数和小数部分都只能是数字, 否则 "1.-5" 会被转换成 95
+	if !isAsciiDigits(parts[0]) || (len(parts) == 2 && !isAsciiDigits(parts[1])) {
+		return 0, errors.New("invalid money format: " + yuan)
+	}
+	integer, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	fraction := 0
+	if len(parts) == 2 {
+		decimal := parts[1]
+		if len(decimal) == 0 || len(decimal) > 2 {
+			return 0, errors.New("invalid money format: " + yuan)
+		}
+		if len(decimal) == 1 {
+			decimal += "0"
+		}
+		if fraction, err = strconv.Atoi(decimal); err != nil {
+			return 0, err
+		}
+	}
+	fen := integer*100 + fraction
+	if negative {
+		fen = -fen
+	}
+	return fen, nil
+}
+
+// isAsciiDigits 字符串是否只包含ASCII数字, 空字符串返回 true, 由调用方检查长度
+func isAsciiDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
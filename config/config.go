@@ -18,17 +18,42 @@ type appConfig struct {
 		FileMaxSize      int    `mapstructure:"max_size"`
 		BackUpFileMaxAge int    `mapstructure:"max_age"`
 	}
+	AdminToken string `mapstructure:"admin_token"` // 管理后台接口的访问令牌
 	Pagination struct {
 		DefaultSize int `mapstructure:"default_size"`
 		MaxSize     int `mapstructure:"max_size"`
 	}
+	Order struct {
+		PayTimeout       time.Duration `mapstructure:"pay_timeout"`        // 订单的支付时限
+		AutoConfirmDays  int           `mapstructure:"auto_confirm_days"`  // 订单送达后自动确认收货的天数
+		ReviewWindowDays int           `mapstructure:"review_window_days"` // 确认收货后可以评价的天数, 过后订单自动完成
+	} `mapstructure:"order"`
 	WechatPay struct {
 		AppId           string `mapstructure:"appid"`
+		AppAppId        string `mapstructure:"app_appid"` // 移动应用的AppID, 用于APP支付
 		MchId           string `mapstructure:"mchid"`
 		PrivateSerialNo string `mapstructure:"private_serial_no"`
-		AesKey          string `mapstructur:"aes_key""`
-		NotifyUrl       string `mapstructur:"notify_url"`
-	}
+		AesKey          string `mapstructure:"aes_key"`
+		NotifyUrl       string `mapstructure:"notify_url"`
+		RefundNotifyUrl string `mapstructure:"refund_notify_url"`
+		KeyProvider     string `mapstructure:"key_provider"` // 商户私钥的提供者 file | env | dir
+		KeySource       string `mapstructure:"key_source"`   // 商户私钥的来源, 含义由 KeyProvider 决定
+	} `mapstructure:"wechat_pay"`
+	AliPay struct {
+		AppId         string `mapstructure:"app_id"`
+		GatewayUrl    string `mapstructure:"gateway_url"`
+		NotifyUrl     string `mapstructure:"notify_url"`
+		ReturnUrl     string `mapstructure:"return_url"`
+		KeyProvider   string `mapstructure:"key_provider"`    // 应用私钥的提供者 file | env | dir, 与微信支付的一致
+		KeySource     string `mapstructure:"key_source"`      // 应用私钥的来源, 含义由 KeyProvider 决定
+		PublicKeyFile string `mapstructure:"public_key_file"` // 支付宝公钥(或支付宝公钥证书)的 PEM 文件路径
+	} `mapstructure:"alipay"`
+	Invoice struct {
+		Issuer      string `mapstructure:"issuer"`        // 开票服务 local-本地开票, 只生成PDF
+		PdfDir      string `mapstructure:"pdf_dir"`       // 发票PDF文件的存放目录
+		SellerName  string `mapstructure:"seller_name"`   // 销售方名称
+		SellerTaxNo string `mapstructure:"seller_tax_no"` // 销售方纳税人识别号
+	} `mapstructure:"invoice"`
 }
 
 // 数据库配置
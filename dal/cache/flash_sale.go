@@ -0,0 +1,270 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/redis/go-redis/v9"
+)
+
+// 秒杀活动
+// 活动开始前把活动信息和库存预热到 Redis, 抢购时用 Lua 脚本原子地检查限购数量并扣减库存, 抢到库存的请求进入下单队列异步创建订单。
+
+var (
+	ErrFlashSaleNotLoaded = errors.New("秒杀活动的库存还没有预热")
+	ErrFlashSaleSoldOut   = errors.New("秒杀活动的库存不足")
+	ErrFlashSaleOverLimit = errors.New("超过秒杀活动的限购数量")
+)
+
+// deductFlashSaleStockScript 检查用户的限购数量并扣减秒杀库存, 用户的抢购数量和库存一起过期
+// 返回 1-扣减成功 0-库存不足 -1-库存没有预热 -2-超过限购数量
+var deductFlashSaleStockScript = redis.NewScript(`
+local stock = redis.call("GET", KEYS[1])
+if not stock then
+	return -1
+end
+local num = tonumber(ARGV[2])
+local bought = tonumber(redis.call("HGET", KEYS[2], ARGV[1]) or "0")
+if bought + num > tonumber(ARGV[3]) then
+	return -2
+end
+if tonumber(stock) < num then
+	return 0
+end
+redis.call("DECRBY", KEYS[1], num)
+redis.call("HINCRBY", KEYS[2], ARGV[1], num)
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return 1
+`)
+
+// returnFlashSaleStockScript 把订单抢到的库存还给秒杀活动, 同一个订单只还一次
+// 返回 1-归还成功 0-已经归还过或者库存已经过期
+var returnFlashSaleStockScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+if redis.call("SADD", KEYS[3], ARGV[3]) == 0 then
+	return 0
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[3], ttl)
+end
+redis.call("INCRBY", KEYS[1], ARGV[2])
+redis.call("HINCRBY", KEYS[2], ARGV[1], -tonumber(ARGV[2]))
+return 1
+`)
+
+// LoadFlashSale 预热秒杀活动的信息和库存, 缓存在活动结束一天后过期
+// 库存已经预热过的不会被覆盖, 避免把抢购中的库存重置
+func LoadFlashSale(ctx context.Context, flashSale *do.FlashSale, stock int) error {
+	flashSaleBytes, err := json.Marshal(flashSale)
+	if err != nil {
+		return err
+	}
+	expireAt := flashSale.EndTime.Add(24 * time.Hour)
+	infoKey := fmt.Sprintf(enum.REDISKEY_FLASHSALE_INFO, flashSale.ID)
+	stockKey := fmt.Sprintf(enum.REDISKEY_FLASHSALE_STOCK, flashSale.ID)
+	if err = Redis().Set(ctx, infoKey, flashSaleBytes, time.Until(expireAt)).Err(); err != nil {
+		return err
+	}
+	return Redis().SetNX(ctx, stockKey, stock, time.Until(expireAt)).Err()
+}
+
+// GetFlashSale 获取预热的秒杀活动信息, 没有预热时返回 nil
+func GetFlashSale(ctx context.Context, flashSaleId int64) (*do.FlashSale, error) {
+	infoKey := fmt.Sprintf(enum.REDISKEY_FLASHSALE_INFO, flashSaleId)
+	flashSaleBytes, err := Redis().Get(ctx, infoKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	flashSale := new(do.FlashSale)
+	if err = json.Unmarshal(flashSaleBytes, flashSale); err != nil {
+		return nil, err
+	}
+	return flashSale, nil
+}
+
+// GetFlashSaleStock 获取秒杀活动剩余的库存
+// @return loaded 库存是否已经预热
+func GetFlashSaleStock(ctx context.Context, flashSaleId int64) (stock int, loaded bool, err error) {
+	stockKey := fmt.Sprintf(enum.REDISKEY_FLASHSALE_STOCK, flashSaleId)
+	stock, err = Redis().Get(ctx, stockKey).Int()
+	if err == redis.Nil {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return stock, true, nil
+}
+
+// DeductFlashSaleStock 扣减秒杀库存, 用户抢购的总数不能超过 perUserLimit
+// 库存不足、超过限购数量、库存还没有预热时分别返回 ErrFlashSaleSoldOut、ErrFlashSaleOverLimit、ErrFlashSaleNotLoaded
+func DeductFlashSaleStock(ctx context.Context, flashSaleId, userId int64, num, perUserLimit int) error {
+	keys := []string{
+		fmt.Sprintf(enum.REDISKEY_FLASHSALE_STOCK, flashSaleId),
+		fmt.Sprintf(enum.REDISKEY_FLASHSALE_USER_BOUGHT, flashSaleId),
+	}
+	result, err := deductFlashSaleStockScript.Run(ctx, Redis(), keys, userId, num, perUserLimit).Int()
+	if err != nil {
+		return err
+	}
+	switch result {
+	case 1:
+		return nil
+	case 0:
+		return ErrFlashSaleSoldOut
+	case -2:
+		return ErrFlashSaleOverLimit
+	default:
+		return ErrFlashSaleNotLoaded
+	}
+}
+
+// ReturnFlashSaleStock 把订单抢到的库存还给秒杀活动, 同时减少用户的抢购数量
+// 同一个订单重复归还时返回 false, 不会重复增加库存
+func ReturnFlashSaleStock(ctx context.Context, flashSaleOrder *do.FlashSaleOrder) (bool, error) {
+	keys := []string{
+		fmt.Sprintf(enum.REDISKEY_FLASHSALE_STOCK, flashSaleOrder.FlashSaleId),
+		fmt.Sprintf(enum.REDISKEY_FLASHSALE_USER_BOUGHT, flashSaleOrder.FlashSaleId),
+		fmt.Sprintf(enum.REDISKEY_FLASHSALE_RETURNED, flashSaleOrder.FlashSaleId),
+	}
+	returned, err := returnFlashSaleStockScript.Run(ctx, Redis(), keys,
+		flashSaleOrder.UserId, flashSaleOrder.CommodityNum, flashSaleOrder.OrderNo).Int()
+	if err != nil {
+		return false, err
+	}
+	return returned == 1, nil
+}
+
+// PushFlashSaleOrder 把抢到库存的下单请求加入下单队列
+func PushFlashSaleOrder(ctx context.Context, flashSaleOrder *do.FlashSaleOrder) error {
+	flashSaleOrderBytes, err := json.Marshal(flashSaleOrder)
+	if err != nil {
+		return err
+	}
+	return Redis().LPush(ctx, enum.REDISKEY_FLASHSALE_ORDER_QUEUE, flashSaleOrderBytes).Err()
+}
+
+// claimFlashSaleOrderScript 从下单队列取出一个请求放进处理中列表, 同时记下取出的时间
+// 返回取出的请求, 队列为空时返回 false
+var claimFlashSaleOrderScript = redis.NewScript(`
+local flashSaleOrder = redis.call("LMOVE", KEYS[1], KEYS[2], "RIGHT", "LEFT")
+if not flashSaleOrder then
+	return false
+end
+redis.call("HSET", KEYS[3], flashSaleOrder, ARGV[1])
+return flashSaleOrder
+`)
+
+// ackFlashSaleOrderScript 处理完的请求从处理中列表里删除
+var ackFlashSaleOrderScript = redis.NewScript(`
+redis.call("LREM", KEYS[1], 1, ARGV[1])
+redis.call("HDEL", KEYS[2], ARGV[1])
+return 1
+`)
+
+// requeueFlashSaleOrderScript 把处理中列表里的请求放回下单队列, 下一个就处理它
+// 返回 1-放回成功 0-请求已经处理完了
+var requeueFlashSaleOrderScript = redis.NewScript(`
+if redis.call("LREM", KEYS[1], 1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("RPUSH", KEYS[3], ARGV[1])
+return 1
+`)
+
+// ClaimFlashSaleOrder 按排队的顺序取出一个下单请求, 队列为空时返回 nil
+// 取出的请求先放进处理中列表, 处理完后调用 AckFlashSaleOrder 删除; 服务在处理中途崩溃时,
+// 请求留在处理中列表里, 由 RequeueStuckFlashSaleOrders 放回下单队列
+// 多个服务实例同时取时, 每个请求只会被一个实例取到
+// @return claimed 取出的原始请求, 确认处理完时使用
+func ClaimFlashSaleOrder(ctx context.Context) (flashSaleOrder *do.FlashSaleOrder, claimed string, err error) {
+	keys := []string{enum.REDISKEY_FLASHSALE_ORDER_QUEUE, enum.REDISKEY_FLASHSALE_ORDER_PROCESSING, enum.REDISKEY_FLASHSALE_ORDER_CLAIMED}
+	claimed, err = claimFlashSaleOrderScript.Run(ctx, Redis(), keys, time.Now().Unix()).Text()
+	if err == redis.Nil {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	flashSaleOrder = new(do.FlashSaleOrder)
+	if err = json.Unmarshal([]byte(claimed), flashSaleOrder); err != nil {
+		return nil, claimed, err
+	}
+	return flashSaleOrder, claimed, nil
+}
+
+// AckFlashSaleOrder 确认下单请求已经处理完, 把它从处理中列表里删除
+func AckFlashSaleOrder(ctx context.Context, claimed string) error {
+	keys := []string{enum.REDISKEY_FLASHSALE_ORDER_PROCESSING, enum.REDISKEY_FLASHSALE_ORDER_CLAIMED}
+	return ackFlashSaleOrderScript.Run(ctx, Redis(), keys, claimed).Err()
+}
+
+// RequeueStuckFlashSaleOrders 把取出超过 timeout 仍没有确认处理完的下单请求放回下单队列
+// 请求按订单号保证幂等, 被重复处理也不会重复创建订单
+// @return requeued 放回下单队列的请求数
+func RequeueStuckFlashSaleOrders(ctx context.Context, timeout time.Duration) (requeued int, err error) {
+	claimedList, err := Redis().LRange(ctx, enum.REDISKEY_FLASHSALE_ORDER_PROCESSING, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	claimedBefore := time.Now().Add(-timeout).Unix()
+	keys := []string{enum.REDISKEY_FLASHSALE_ORDER_PROCESSING, enum.REDISKEY_FLASHSALE_ORDER_CLAIMED, enum.REDISKEY_FLASHSALE_ORDER_QUEUE}
+	for _, claimed := range claimedList {
+		claimedAt, err := Redis().HGet(ctx, enum.REDISKEY_FLASHSALE_ORDER_CLAIMED, claimed).Int64()
+		if err != nil && err != redis.Nil {
+			return requeued, err
+		}
+		// 没有取出时间的请求是取出时间记录丢失了, 也放回队列
+		if err == nil && claimedAt > claimedBefore {
+			continue
+		}
+		result, err := requeueFlashSaleOrderScript.Run(ctx, Redis(), keys, claimed).Int()
+		if err != nil {
+			return requeued, err
+		}
+		requeued += result
+	}
+	return requeued, nil
+}
+
+// SetFlashSaleOrderResult 保存秒杀下单的排队结果, 用户轮询下单结果时查询
+func SetFlashSaleOrderResult(ctx context.Context, result *do.FlashSaleOrderResult, ttl time.Duration) error {
+	redisKey := fmt.Sprintf(enum.REDISKEY_FLASHSALE_ORDER_RESULT, result.OrderNo)
+	resultBytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return Redis().Set(ctx, redisKey, resultBytes, ttl).Err()
+}
+
+// GetFlashSaleOrderResult 获取秒杀下单的排队结果, 没有结果时返回 nil
+func GetFlashSaleOrderResult(ctx context.Context, orderNo string) (*do.FlashSaleOrderResult, error) {
+	redisKey := fmt.Sprintf(enum.REDISKEY_FLASHSALE_ORDER_RESULT, orderNo)
+	resultBytes, err := Redis().Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	result := new(do.FlashSaleOrderResult)
+	if err = json.Unmarshal(resultBytes, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
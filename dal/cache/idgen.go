@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/redis/go-redis/v9"
+)
+
+// ID生成器 worker id 的租约
+// 每个服务实例从 Redis 中租用一个 worker id, 租约到期前要续约, 实例停止后租约到期, worker id 可以被其他实例租用
+
+var ErrNoIdWorkerAvailable = errors.New("没有可以租用的ID生成器 worker id")
+
+// renewIdWorkerScript 只有租约的持有者才能续约
+var renewIdWorkerScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseIdWorkerScript 只有租约的持有者才能释放租约
+var releaseIdWorkerScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// LeaseIdWorker 租用一个空闲的 worker id
+// 从一个递增的游标开始查找空闲的 worker id, 让多个实例同时启动时尽量不去争抢同一个 worker id
+// @param owner 租约的持有者, 每个服务实例唯一
+// @param maxWorkerId worker id 的最大值
+func LeaseIdWorker(ctx context.Context, owner string, maxWorkerId int64, ttl time.Duration) (int64, error) {
+	cursor, err := Redis().Incr(ctx, enum.REDISKEY_IDGEN_WORKER_CURSOR).Result()
+	if err != nil {
+		return 0, err
+	}
+	for i := int64(0); i <= maxWorkerId; i++ {
+		workerId := (cursor + i) % (maxWorkerId + 1)
+		redisKey := fmt.Sprintf(enum.REDISKEY_IDGEN_WORKER, workerId)
+		leased, err := Redis().SetNX(ctx, redisKey, owner, ttl).Result()
+		if err != nil {
+			return 0, err
+		}
+		if leased {
+			return workerId, nil
+		}
+	}
+	return 0, ErrNoIdWorkerAvailable
+}
+
+// RenewIdWorker 续约 worker id, 租约已经到期或者被其他实例租用时返回 false
+func RenewIdWorker(ctx context.Context, workerId int64, owner string, ttl time.Duration) (bool, error) {
+	redisKey := fmt.Sprintf(enum.REDISKEY_IDGEN_WORKER, workerId)
+	renewed, err := renewIdWorkerScript.Run(ctx, Redis(), []string{redisKey}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// ReleaseIdWorker 释放 worker id 的租约
+func ReleaseIdWorker(ctx context.Context, workerId int64, owner string) error {
+	redisKey := fmt.Sprintf(enum.REDISKEY_IDGEN_WORKER, workerId)
+	return releaseIdWorkerScript.Run(ctx, Redis(), []string{redisKey}, owner).Err()
+}
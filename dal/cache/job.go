@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/redis/go-redis/v9"
+)
+
+// renewJobLockScript 只有锁的持有者才能延长锁的有效期
+var renewJobLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// LockJob 获取后台任务的执行锁, 多个服务实例中同一时间只有一个能执行任务
+// 锁不主动释放, 到期后自动失效, 避免其他实例在同一个周期内重复执行任务
+// @param owner 锁的持有者, 每次执行唯一
+func LockJob(ctx context.Context, jobName, owner string, ttl time.Duration) (bool, error) {
+	redisLockKey := fmt.Sprintf(enum.REDISKEY_JOB_LOCK, jobName)
+	return Redis().SetNX(ctx, redisLockKey, owner, ttl).Result()
+}
+
+// RenewJobLock 任务还在执行时延长执行锁的有效期, 返回 false 表示锁已经不归 owner 持有了
+func RenewJobLock(ctx context.Context, jobName, owner string, ttl time.Duration) (bool, error) {
+	redisLockKey := fmt.Sprintf(enum.REDISKEY_JOB_LOCK, jobName)
+	renewed, err := renewJobLockScript.Run(ctx, Redis(), []string{redisLockKey}, owner, ttl.Milliseconds()).Int()
+	return renewed == 1, err
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/redis/go-redis/v9"
+)
+
+// AddOrderPayDeadline 把订单加入支付超时队列, 队列用有序集合实现, 分值为订单的支付截止时间
+func AddOrderPayDeadline(ctx context.Context, orderNo string, payDeadline time.Time) error {
+	return Redis().ZAdd(ctx, enum.REDISKEY_ORDER_PAY_DEADLINE_QUEUE, redis.Z{
+		Score:  float64(payDeadline.Unix()),
+		Member: orderNo,
+	}).Err()
+}
+
+// PopExpiredOrderPayDeadlines 从支付超时队列中取出已经超过支付截止时间的订单号
+// 多个服务实例同时取时, 只有成功把订单号从队列中删除的实例能拿到这个订单号, 保证一个订单只被一个实例处理
+func PopExpiredOrderPayDeadlines(ctx context.Context, now time.Time, limit int64) ([]string, error) {
+	orderNos, err := Redis().ZRangeByScore(ctx, enum.REDISKEY_ORDER_PAY_DEADLINE_QUEUE, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.Unix(), 10),
+		Count: limit,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	poppedOrderNos := make([]string, 0, len(orderNos))
+	for _, orderNo := range orderNos {
+		removed, err := Redis().ZRem(ctx, enum.REDISKEY_ORDER_PAY_DEADLINE_QUEUE, orderNo).Result()
+		if err != nil {
+			return poppedOrderNos, err
+		}
+		if removed > 0 {
+			poppedOrderNos = append(poppedOrderNos, orderNo)
+		}
+	}
+	return poppedOrderNos, nil
+}
+
+// LockOrderPay 获取订单发起支付的锁, 同一个订单同一时间只能有一个发起支付的请求在处理
+func LockOrderPay(ctx context.Context, orderNo string) (bool, error) {
+	redisLockKey := fmt.Sprintf(enum.REDISKEY_ORDER_PAY_LOCK, orderNo)
+	return Redis().SetNX(ctx, redisLockKey, "locked", 30*time.Second).Result()
+}
+
+func UnlockOrderPay(ctx context.Context, orderNo string) error {
+	redisLockKey := fmt.Sprintf(enum.REDISKEY_ORDER_PAY_LOCK, orderNo)
+	return Redis().Del(ctx, redisLockKey).Err()
+}
+
+// SetOrderPayReply 缓存订单发起支付返回给前端的支付信息
+// @param payReply 序列化后的支付信息
+// @param ttl 缓存有效期, 不应超过支付平台预支付信息的有效期
+func SetOrderPayReply(ctx context.Context, orderNo string, payType int, payScene string, payReply []byte, ttl time.Duration) error {
+	redisKey := fmt.Sprintf(enum.REDISKEY_ORDER_PAY_REPLY, orderNo, payType, payScene)
+	return Redis().Set(ctx, redisKey, payReply, ttl).Err()
+}
+
+// GetOrderPayReply 获取缓存的订单支付信息, 没有缓存时返回 nil
+func GetOrderPayReply(ctx context.Context, orderNo string, payType int, payScene string) ([]byte, error) {
+	redisKey := fmt.Sprintf(enum.REDISKEY_ORDER_PAY_REPLY, orderNo, payType, payScene)
+	payReply, err := Redis().Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	return payReply, err
+}
+
+// SetPayReconcileDone 标记某个支付方式某天的账单已经完成对账
+func SetPayReconcileDone(ctx context.Context, payType int, billDate string) error {
+	redisKey := fmt.Sprintf(enum.REDISKEY_PAY_RECONCILE_DONE, payType, billDate)
+	// 只有每天的定时对账会检查这个标记, 保留两天足够了
+	return Redis().Set(ctx, redisKey, "done", 48*time.Hour).Err()
+}
+
+// IsPayReconcileDone 某个支付方式某天的账单是否已经完成对账
+func IsPayReconcileDone(ctx context.Context, payType int, billDate string) (bool, error) {
+	redisKey := fmt.Sprintf(enum.REDISKEY_PAY_RECONCILE_DONE, payType, billDate)
+	exists, err := Redis().Exists(ctx, redisKey).Result()
+	return exists > 0, err
+}
+
+// DelayOrderPayQuery 记录一次对订单支付结果的主动查询, 并按查询次数退避下一次查询的时间
+// 等待时间从1分钟开始每查询一次翻一倍, 最长等待 maxWait
+func DelayOrderPayQuery(ctx context.Context, orderNo string, maxWait time.Duration) error {
+	timesKey := fmt.Sprintf(enum.REDISKEY_ORDER_PAY_QUERY_TIMES, orderNo)
+	times, err := Redis().Incr(ctx, timesKey).Result()
+	if err != nil {
+		return err
+	}
+	// 待支付的订单最多一天就会被关闭, 查询次数保留一天足够了
+	if err = Redis().Expire(ctx, timesKey, 24*time.Hour).Err(); err != nil {
+		return err
+	}
+	wait := maxWait
+	if times <= 16 && time.Minute<<(times-1) < maxWait {
+		wait = time.Minute << (times - 1)
+	}
+	waitKey := fmt.Sprintf(enum.REDISKEY_ORDER_PAY_QUERY_WAIT, orderNo)
+	return Redis().Set(ctx, waitKey, times, wait).Err()
+}
+
+// IsOrderPayQueryDelayed 订单的支付结果查询是否还在退避等待中
+func IsOrderPayQueryDelayed(ctx context.Context, orderNo string) (bool, error) {
+	waitKey := fmt.Sprintf(enum.REDISKEY_ORDER_PAY_QUERY_WAIT, orderNo)
+	exists, err := Redis().Exists(ctx, waitKey).Result()
+	return exists > 0, err
+}
+
+// SetSandboxPayTransaction 保存沙箱支付创建的模拟交易
+func SetSandboxPayTransaction(ctx context.Context, transaction *do.SandboxPayTransaction, ttl time.Duration) error {
+	redisKey := fmt.Sprintf(enum.REDISKEY_ORDER_SANDBOX_PAY, transaction.OrderNo)
+	transactionBytes, err := json.Marshal(transaction)
+	if err != nil {
+		return err
+	}
+	return Redis().Set(ctx, redisKey, transactionBytes, ttl).Err()
+}
+
+// GetSandboxPayTransaction 获取订单的沙箱支付模拟交易, 没有交易时返回 nil
+func GetSandboxPayTransaction(ctx context.Context, orderNo string) (*do.SandboxPayTransaction, error) {
+	redisKey := fmt.Sprintf(enum.REDISKEY_ORDER_SANDBOX_PAY, orderNo)
+	transactionBytes, err := Redis().Get(ctx, redisKey).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	transaction := new(do.SandboxPayTransaction)
+	if err = json.Unmarshal(transactionBytes, transaction); err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
@@ -14,7 +14,9 @@ func Redis() *redis.Client {
 	return redisClient
 }
 
-func init() {
+// InitRedis 创建redis客户端并检查连接, 在应用启动时调用
+// 单测不调用这里, 通过 SetRedisClient 换成单测自己的客户端
+func InitRedis() {
 	redisClient = redis.NewClient(&redis.Options{
 		Addr:         config.Redis.Addr,
 		Password:     config.Redis.Password,
@@ -31,3 +33,8 @@ func init() {
 		panic(err)
 	}
 }
+
+// SetRedisClient 设置redis客户端 -- 只用在单测中把redis客户端换成单测用的客户端
+func SetRedisClient(client *redis.Client) {
+	redisClient = client
+}
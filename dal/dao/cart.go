@@ -7,6 +7,7 @@ import (
 	"github.com/WoWBytePaladin/go-mall/dal/model"
 	"github.com/WoWBytePaladin/go-mall/logic/do"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CartDao struct {
@@ -50,6 +51,38 @@ func (cd *CartDao) AddCartItem(cartItem *do.ShoppingCartItem) error {
 	return err
 }
 
+// EnsureCartItems 在一个事务里把多个商品加入用户的购物车, 保证购物车中每个商品的数量不少于 CommodityNum
+// 购物车中已有的商品数量不够时更新为 CommodityNum, 够时保持不变, 重复调用不会让数量累加;
+// 任何一个商品加入失败时整体回滚, 不会只加入一部分商品
+func (cd *CartDao) EnsureCartItems(userId int64, cartItems []*do.ShoppingCartItem) error {
+	return DBMaster().WithContext(cd.ctx).Transaction(func(tx *gorm.DB) error {
+		for _, cartItem := range cartItems {
+			cartItemModel := new(model.ShoppingCartItem)
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where(model.ShoppingCartItem{UserId: userId, CommodityId: cartItem.CommodityId}, "UserId", "CommodityId").
+				Find(cartItemModel).Error
+			if err != nil {
+				return err
+			}
+			if cartItemModel.CartItemId == 0 {
+				cartItemModel = &model.ShoppingCartItem{UserId: userId, CommodityId: cartItem.CommodityId, CommodityNum: cartItem.CommodityNum}
+				if err = tx.Create(cartItemModel).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if cartItemModel.CommodityNum >= cartItem.CommodityNum {
+				continue
+			}
+			err = tx.Model(cartItemModel).Update("commodity_num", cartItem.CommodityNum).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (cd *CartDao) UpdateCartItem(cartItem *model.ShoppingCartItem) error {
 	return DBMaster().WithContext(cd.ctx).Model(cartItem).Updates(cartItem).Error
 }
@@ -2,15 +2,9 @@ package dao
 
 import (
 	"context"
-	"errors"
-	"fmt"
-	"github.com/WoWBytePaladin/go-mall/common/errcode"
 	"github.com/WoWBytePaladin/go-mall/common/util"
 	"github.com/WoWBytePaladin/go-mall/dal/model"
 	"github.com/WoWBytePaladin/go-mall/logic/do"
-	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
-	"strconv"
 )
 
 type CommodityDao struct {
@@ -138,47 +132,3 @@ func (cd *CommodityDao) FindCommodities(commodityIdList []int64) ([]*model.Commo
 	err := DB().WithContext(cd.ctx).Find(&commodities, commodityIdList).Error
 	return commodities, err
 }
-
-// ReduceStuckInOrderCreate 创建订单后商品减库存
-func (cd *CommodityDao) ReduceStuckInOrderCreate(tx *gorm.DB, orderItems []*do.OrderItem) error {
-	for _, orderItem := range orderItems {
-		commodity := new(model.Commodity)
-		// SELECT FOR UPDATE 当前读
-		tx.Clauses(clause.Locking{Strength: "UPDATE"}).WithContext(cd.ctx).
-			Find(commodity, orderItem.CommodityId)
-		newStock := commodity.StockNum - orderItem.CommodityNum
-		if newStock < 0 {
-			return errcode.ErrCommodityStockOut.WithCause(errors.New("商品缺少库存, 商品ID:" + strconv.FormatInt(commodity.ID, 10)))
-		}
-		commodity.StockNum = newStock
-		// https://gorm.io/docs/update.html#Update-single-column
-		err := tx.WithContext(cd.ctx).Model(commodity).Update("stock_num", newStock).Error
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-// RecoverOrderCommodityStuck  用户取消订单后商品减库存
-func (cd *CommodityDao) RecoverOrderCommodityStuck(orderItems []*do.OrderItem) error {
-	err := DBMaster().Transaction(func(tx *gorm.DB) error {
-		for _, orderItem := range orderItems {
-			commodity := new(model.Commodity)
-			tx.Clauses(clause.Locking{Strength: "UPDATE"}).WithContext(cd.ctx).Find(commodity, orderItem.CommodityId)
-			if commodity.ID == 0 {
-				return errcode.ErrNotFound.WithCause(errors.New(fmt.Sprintf("商品未找到, ID: %d", orderItem.CommodityId)))
-			}
-			newStock := commodity.StockNum + orderItem.CommodityNum
-			err := tx.WithContext(cd.ctx).Model(commodity).Update("stock_num", newStock).Error
-			if err != nil {
-				return err
-			}
-		}
-
-		return nil
-	})
-
-	return err
-}
@@ -0,0 +1,227 @@
+package dao
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 商品库存
+// 商品的库存分成可售(stock_num)、预占(reserved_num)和已售(sold_num)三部分:
+// 创建订单时从可售库存里预占, 订单支付成功后预占的库存记为已售, 订单取消或者超时关闭后预占的库存释放回可售库存。
+// 每个订单预占的库存记录在 stock_reservations 表里, 预占、售出和释放都按预占记录执行, 重复执行不会重复变更库存。
+// 秒杀活动创建时把活动库存从可售库存整体占用到预占库存, 秒杀订单从活动占用的库存里预占, 活动结束后没有卖出的库存释放回可售库存。
+
+// ReserveOrderStock 创建订单时预占订单商品的库存, 在创建订单的事务里执行
+// 订单已经预占过的商品不再重复预占, 商品的可售库存不足时返回 ErrCommodityStockOut
+func (cd *CommodityDao) ReserveOrderStock(tx *gorm.DB, orderId int64, orderNo string, orderItems []*do.OrderItem) error {
+	reservedCommodityIds := make([]int64, 0)
+	err := tx.WithContext(cd.ctx).Model(model.StockReservation{}).
+		Where("order_id = ?", orderId).Pluck("commodity_id", &reservedCommodityIds).Error
+	if err != nil {
+		return err
+	}
+	for _, stockItem := range mergeStockItems(orderItems) {
+		if lo.Contains(reservedCommodityIds, stockItem.CommodityId) {
+			continue
+		}
+		// 可售库存足够时才预占, 条件更新会锁住商品的行记录直到事务结束
+		result := tx.WithContext(cd.ctx).Model(model.Commodity{}).
+			Where("id = ? AND stock_num >= ?", stockItem.CommodityId, stockItem.CommodityNum).
+			Updates(map[string]interface{}{
+				"stock_num":    gorm.Expr("stock_num - ?", stockItem.CommodityNum),
+				"reserved_num": gorm.Expr("reserved_num + ?", stockItem.CommodityNum),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errcode.ErrCommodityStockOut.WithCause(fmt.Errorf("商品缺少库存, 商品ID:%d", stockItem.CommodityId))
+		}
+		reservation := &model.StockReservation{
+			OrderId:      orderId,
+			OrderNo:      orderNo,
+			CommodityId:  stockItem.CommodityId,
+			CommodityNum: stockItem.CommodityNum,
+			State:        enum.StockReservationStateReserved,
+		}
+		if err = tx.WithContext(cd.ctx).Create(reservation).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReserveFlashSaleOrderStock 创建秒杀订单时预占订单商品的库存, 在创建订单的事务里执行
+// 秒杀活动的库存在创建活动时已经从可售库存占用到了预占库存里, 这里只记录订单的预占记录, 不再变更商品库存
+func (cd *CommodityDao) ReserveFlashSaleOrderStock(tx *gorm.DB, flashSaleId, orderId int64, orderNo string, orderItems []*do.OrderItem) error {
+	reservedCommodityIds := make([]int64, 0)
+	err := tx.WithContext(cd.ctx).Model(model.StockReservation{}).
+		Where("order_id = ?", orderId).Pluck("commodity_id", &reservedCommodityIds).Error
+	if err != nil {
+		return err
+	}
+	for _, stockItem := range mergeStockItems(orderItems) {
+		if lo.Contains(reservedCommodityIds, stockItem.CommodityId) {
+			continue
+		}
+		reservation := &model.StockReservation{
+			OrderId:      orderId,
+			OrderNo:      orderNo,
+			CommodityId:  stockItem.CommodityId,
+			CommodityNum: stockItem.CommodityNum,
+			FlashSaleId:  flashSaleId,
+			State:        enum.StockReservationStateReserved,
+		}
+		if err = tx.WithContext(cd.ctx).Create(reservation).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommitOrderStock 订单支付成功后把订单预占的库存记为已售, 在变更订单状态的事务里执行
+func (cd *CommodityDao) CommitOrderStock(tx *gorm.DB, orderId int64) error {
+	return cd.settleOrderStock(tx, orderId, enum.StockReservationStateCommitted, func(reservation *model.StockReservation) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"reserved_num": gorm.Expr("reserved_num - ?", reservation.CommodityNum),
+			"sold_num":     gorm.Expr("sold_num + ?", reservation.CommodityNum),
+		}, nil
+	})
+}
+
+// ReleaseOrderStock 订单取消或者超时关闭后把订单预占的库存释放回可售库存, 在变更订单状态的事务里执行
+// 秒杀订单释放的库存还给秒杀活动, 继续占在预占库存里, 活动结束后没有卖出的库存由 FlashSaleDao.ReleaseFlashSaleStock 统一释放;
+// 活动的库存已经统一释放过时, 秒杀订单释放的库存直接回到可售库存
+// 库存预占上线前创建的订单没有预占记录, 按订单的购物项把下单时扣减的可售库存加回去
+func (cd *CommodityDao) ReleaseOrderStock(tx *gorm.DB, orderId int64) error {
+	var reservationCount int64
+	err := tx.WithContext(cd.ctx).Model(model.StockReservation{}).Where("order_id = ?", orderId).
+		Count(&reservationCount).Error
+	if err != nil {
+		return err
+	}
+	if reservationCount == 0 {
+		return cd.restoreLegacyOrderStock(tx, orderId)
+	}
+	return cd.settleOrderStock(tx, orderId, enum.StockReservationStateReleased, func(reservation *model.StockReservation) (map[string]interface{}, error) {
+		if reservation.FlashSaleId > 0 {
+			// 锁住秒杀活动的行记录, 跟活动统一释放库存互斥, 保证每份库存只释放一次
+			flashSale := new(model.FlashSale)
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "stock_released").
+				Where("id = ?", reservation.FlashSaleId).Find(flashSale).Error
+			if err != nil {
+				return nil, err
+			}
+			if flashSale.ID != 0 && !flashSale.StockReleased {
+				return nil, nil
+			}
+		}
+		return map[string]interface{}{
+			"reserved_num": gorm.Expr("reserved_num - ?", reservation.CommodityNum),
+			"stock_num":    gorm.Expr("stock_num + ?", reservation.CommodityNum),
+		}, nil
+	})
+}
+
+// restoreLegacyOrderStock 把库存预占上线前创建的订单扣减的可售库存加回去
+// 这些订单下单时直接扣减可售库存, 没有计入预占数量; 订单关闭只会发生一次, 库存只恢复一次
+func (cd *CommodityDao) restoreLegacyOrderStock(tx *gorm.DB, orderId int64) error {
+	orderItems := make([]*model.OrderItem, 0)
+	if err := tx.WithContext(cd.ctx).Where("order_id = ?", orderId).Find(&orderItems).Error; err != nil {
+		return err
+	}
+	stockItems := lo.Map(orderItems, func(orderItem *model.OrderItem, _ int) *do.OrderItem {
+		return &do.OrderItem{CommodityId: orderItem.CommodityId, CommodityNum: orderItem.CommodityNum}
+	})
+	for _, stockItem := range mergeStockItems(stockItems) {
+		// 下单后被删除的商品也要恢复库存
+		err := tx.WithContext(cd.ctx).Unscoped().Model(model.Commodity{}).Where("id = ?", stockItem.CommodityId).
+			Update("stock_num", gorm.Expr("stock_num + ?", stockItem.CommodityNum)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settleOrderStock 把订单仍是已预占状态的预占记录变更为 toState, 并按 stockUpdates 更新商品的库存, stockUpdates 返回 nil 时不更新商品库存
+// 预占记录的状态和商品库存在调用方的事务里更新, 只有从已预占变更成功的记录才更新库存, 重复或者并发执行时库存只变更一次
+func (cd *CommodityDao) settleOrderStock(tx *gorm.DB, orderId int64, toState int,
+	stockUpdates func(reservation *model.StockReservation) (map[string]interface{}, error)) error {
+	tx = tx.WithContext(cd.ctx)
+	reservations := make([]*model.StockReservation, 0)
+	err := tx.Where("order_id = ? AND state = ?", orderId, enum.StockReservationStateReserved).
+		Order("commodity_id").Find(&reservations).Error
+	if err != nil {
+		return err
+	}
+	for _, reservation := range reservations {
+		result := tx.Model(reservation).Where("state = ?", enum.StockReservationStateReserved).
+			Update("state", toState)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		updates, err := stockUpdates(reservation)
+		if err != nil {
+			return err
+		}
+		if updates == nil {
+			continue
+		}
+		// 下单后被删除的商品也要变更库存
+		err = tx.Unscoped().Model(model.Commodity{}).Where("id = ?", reservation.CommodityId).
+			Updates(updates).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReturnSoldStock 订单退款成功后把退款商品的已售库存退回可售库存, 在结算退款的事务里执行
+// 退款结算是幂等的, 同一笔退款只会调用一次
+func (cd *CommodityDao) ReturnSoldStock(tx *gorm.DB, orderItems []*do.OrderItem) error {
+	for _, stockItem := range mergeStockItems(orderItems) {
+		// 库存预占上线前支付的订单没有计入已售数量, 已售数量最少减到0
+		err := tx.WithContext(cd.ctx).Unscoped().Model(model.Commodity{}).Where("id = ?", stockItem.CommodityId).
+			Updates(map[string]interface{}{
+				"sold_num":  gorm.Expr("GREATEST(sold_num, ?) - ?", stockItem.CommodityNum, stockItem.CommodityNum),
+				"stock_num": gorm.Expr("stock_num + ?", stockItem.CommodityNum),
+			}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeStockItems 合并同一商品的购物项并按商品ID排序, 多个订单同时变更库存时按相同的顺序锁商品的行记录, 避免死锁
+func mergeStockItems(orderItems []*do.OrderItem) []*do.OrderItem {
+	stockItemMap := make(map[int64]*do.OrderItem, len(orderItems))
+	stockItems := make([]*do.OrderItem, 0, len(orderItems))
+	for _, orderItem := range orderItems {
+		if stockItem, exists := stockItemMap[orderItem.CommodityId]; exists {
+			stockItem.CommodityNum += orderItem.CommodityNum
+			continue
+		}
+		stockItem := &do.OrderItem{CommodityId: orderItem.CommodityId, CommodityNum: orderItem.CommodityNum}
+		stockItemMap[orderItem.CommodityId] = stockItem
+		stockItems = append(stockItems, stockItem)
+	}
+	sort.Slice(stockItems, func(i, j int) bool {
+		return stockItems[i].CommodityId < stockItems[j].CommodityId
+	})
+	return stockItems
+}
@@ -0,0 +1,140 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"gorm.io/gorm"
+)
+
+type FlashSaleDao struct {
+	ctx context.Context
+}
+
+func NewFlashSaleDao(ctx context.Context) *FlashSaleDao {
+	return &FlashSaleDao{ctx: ctx}
+}
+
+// CreateFlashSale 创建秒杀活动, 同时把活动的库存从商品的可售库存占用到预占库存, 活动期间普通订单不会买走活动的库存
+// 商品的可售库存不足时返回 ErrCommodityStockOut
+func (fsd *FlashSaleDao) CreateFlashSale(flashSale *do.FlashSale) (*model.FlashSale, error) {
+	flashSaleModel := new(model.FlashSale)
+	if err := util.CopyProperties(flashSaleModel, flashSale); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	err := DBMaster().WithContext(fsd.ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(model.Commodity{}).
+			Where("id = ? AND stock_num >= ?", flashSaleModel.CommodityId, flashSaleModel.TotalStock).
+			Updates(map[string]interface{}{
+				"stock_num":    gorm.Expr("stock_num - ?", flashSaleModel.TotalStock),
+				"reserved_num": gorm.Expr("reserved_num + ?", flashSaleModel.TotalStock),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errcode.ErrCommodityStockOut.WithCause(fmt.Errorf("商品缺少库存, 商品ID:%d", flashSaleModel.CommodityId))
+		}
+		return tx.Create(flashSaleModel).Error
+	})
+	return flashSaleModel, err
+}
+
+func (fsd *FlashSaleDao) GetFlashSaleById(flashSaleId int64) (*model.FlashSale, error) {
+	flashSale := new(model.FlashSale)
+	err := DB().WithContext(fsd.ctx).Where("id = ?", flashSaleId).Find(flashSale).Error
+	return flashSale, err
+}
+
+// GetFlashSales 分页查询秒杀活动, 开始时间晚的在前
+func (fsd *FlashSaleDao) GetFlashSales(offset, returnSize int) (flashSales []*model.FlashSale, totalRow int64, err error) {
+	err = DB().WithContext(fsd.ctx).Order("start_time DESC, id DESC").
+		Offset(offset).Limit(returnSize).Find(&flashSales).Error
+	if err != nil {
+		return
+	}
+	err = DB().WithContext(fsd.ctx).Model(model.FlashSale{}).Count(&totalRow).Error
+	return
+}
+
+// GetFlashSalesStartBefore 查询在 startBefore 之前开始并且在 now 时还没结束的秒杀活动
+func (fsd *FlashSaleDao) GetFlashSalesStartBefore(startBefore, now time.Time) ([]*model.FlashSale, error) {
+	flashSales := make([]*model.FlashSale, 0)
+	err := DB().WithContext(fsd.ctx).Where("start_time <= ? AND end_time > ?", startBefore, now).
+		Find(&flashSales).Error
+	return flashSales, err
+}
+
+// SetFlashSaleSoldNum 更新秒杀活动已经抢购的数量
+func (fsd *FlashSaleDao) SetFlashSaleSoldNum(flashSaleId int64, soldNum int) error {
+	return DBMaster().WithContext(fsd.ctx).Model(model.FlashSale{}).Where("id = ?", flashSaleId).
+		Update("sold_num", soldNum).Error
+}
+
+// GetFlashSaleOrdersInStatus 查询秒杀活动中处于 orderStatuses 状态的订单, 秒杀订单只有一个购物项
+func (fsd *FlashSaleDao) GetFlashSaleOrdersInStatus(flashSaleId int64, orderStatuses []int) ([]*do.FlashSaleOrder, error) {
+	flashSaleOrders := make([]*do.FlashSaleOrder, 0)
+	err := DB().WithContext(fsd.ctx).Unscoped().Table("orders").
+		Select("orders.flash_sale_id, orders.user_id, orders.order_no, order_items.commodity_num").
+		Joins("JOIN order_items ON order_items.order_id = orders.id").
+		Where("orders.flash_sale_id = ? AND orders.order_status IN (?)", flashSaleId, orderStatuses).
+		Scan(&flashSaleOrders).Error
+	return flashSaleOrders, err
+}
+
+// GetFlashSalesToReleaseStock 查询在 endBefore 之前结束, 还没有释放库存的秒杀活动
+func (fsd *FlashSaleDao) GetFlashSalesToReleaseStock(endBefore time.Time) ([]*model.FlashSale, error) {
+	flashSales := make([]*model.FlashSale, 0)
+	err := DB().WithContext(fsd.ctx).Where("end_time <= ? AND stock_released = ?", endBefore, false).
+		Find(&flashSales).Error
+	return flashSales, err
+}
+
+// ReleaseFlashSaleStock 把秒杀活动没有卖出的库存从商品的预占库存释放回可售库存, 每个活动只释放一次
+// 没有卖出的库存是活动库存减去已售出和仍在预占的秒杀订单的库存, 仍在预占的订单之后释放时库存直接回到可售库存
+// @return released 此次调用是否真正释放了库存
+func (fsd *FlashSaleDao) ReleaseFlashSaleStock(flashSaleId int64) (released bool, err error) {
+	err = DBMaster().WithContext(fsd.ctx).Transaction(func(tx *gorm.DB) error {
+		// 先把活动标记为已释放, 条件更新会锁住活动的行记录, 跟秒杀订单释放库存互斥
+		result := tx.Model(model.FlashSale{}).Where("id = ? AND stock_released = ?", flashSaleId, false).
+			Update("stock_released", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		flashSale := new(model.FlashSale)
+		if err := tx.Where("id = ?", flashSaleId).Find(flashSale).Error; err != nil {
+			return err
+		}
+		var usedNum int
+		err := tx.Model(model.StockReservation{}).Select("COALESCE(SUM(commodity_num), 0)").
+			Where("flash_sale_id = ? AND state IN (?)", flashSaleId,
+				[]int{enum.StockReservationStateReserved, enum.StockReservationStateCommitted}).
+			Scan(&usedNum).Error
+		if err != nil {
+			return err
+		}
+		if unsoldNum := flashSale.TotalStock - usedNum; unsoldNum > 0 {
+			// 活动期间被删除的商品也要释放库存
+			err = tx.Unscoped().Model(model.Commodity{}).Where("id = ?", flashSale.CommodityId).
+				Updates(map[string]interface{}{
+					"reserved_num": gorm.Expr("reserved_num - ?", unsoldNum),
+					"stock_num":    gorm.Expr("stock_num + ?", unsoldNum),
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+		released = true
+		return nil
+	})
+	return released, err
+}
@@ -1,6 +1,8 @@
 package dao
 
 import (
+	"errors"
+
 	"github.com/WoWBytePaladin/go-mall/config"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -19,7 +21,9 @@ func DBMaster() *gorm.DB {
 	return _DbMaster
 }
 
-func init() {
+// InitDB 连接主库和只读实例, 在应用启动时调用, 连接不上数据库时让项目停止启动
+// 单测不调用这里, 通过 SetDBMasterConn/SetDBSlaveConn 换成 sqlmock 的连接
+func InitDB() {
 	//logger.New(context.TODO()).Info("database info", "db", config.Database)
 	_DbMaster = initDB(config.Database.Master)
 	_DbSlave = initDB(config.Database.Slave)
@@ -40,21 +44,28 @@ func initDB(option config.DbConnectOption) *gorm.DB {
 		getDialector(option.Type, option.DSN),
 		&gorm.Config{
 			Logger: NewGormLogger(),
+			// 把违反唯一索引等数据库错误转换成 gorm 的错误, 调用方不用依赖具体数据库的错误码
+			TranslateError: true,
 		},
 	)
-	if err != nil {
-		panic(err)
+	if err == nil {
+		sqlDb, _ := db.DB()
+		sqlDb.SetMaxOpenConns(option.MaxOpenConn)
+		sqlDb.SetMaxIdleConns(option.MaxIdleConn)
+		sqlDb.SetConnMaxLifetime(option.MaxLifeTime)
+		err = sqlDb.Ping()
 	}
-	sqlDb, _ := db.DB()
-	sqlDb.SetMaxOpenConns(option.MaxOpenConn)
-	sqlDb.SetMaxIdleConns(option.MaxIdleConn)
-	sqlDb.SetConnMaxLifetime(option.MaxLifeTime)
-	if err = sqlDb.Ping(); err != nil {
+	if err != nil {
 		panic(err)
 	}
 	return db
 }
 
+// IsDuplicateKeyError 写库时是否违反了唯一索引
+func IsDuplicateKeyError(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
 // SetDBMasterConn 设置连接对象 -- 只用在单测中把DB连接改成sqlMock的DB连接
 func SetDBMasterConn(conn *gorm.DB) {
 	_DbMaster = conn
@@ -0,0 +1,165 @@
+package dao
+
+import (
+	"context"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type InvoiceDao struct {
+	ctx context.Context
+}
+
+func NewInvoiceDao(ctx context.Context) *InvoiceDao {
+	return &InvoiceDao{ctx: ctx}
+}
+
+func (ivd *InvoiceDao) CreateInvoiceTitle(title *do.InvoiceTitle) (*model.InvoiceTitle, error) {
+	titleModel := new(model.InvoiceTitle)
+	if err := util.CopyProperties(titleModel, title); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	err := DBMaster().WithContext(ivd.ctx).Create(titleModel).Error
+	return titleModel, err
+}
+
+// UpdateInvoiceTitle 更新发票抬头, 个人抬头的纳税人识别号为空, 需要用 Select 指定字段才能把它更新成零值
+func (ivd *InvoiceDao) UpdateInvoiceTitle(title *do.InvoiceTitle) error {
+	return DBMaster().WithContext(ivd.ctx).Model(&model.InvoiceTitle{ID: title.ID}).
+		Select("title_type", "title", "tax_no").
+		Updates(&model.InvoiceTitle{TitleType: title.TitleType, Title: title.Title, TaxNo: title.TaxNo}).Error
+}
+
+func (ivd *InvoiceDao) GetInvoiceTitle(titleId int64) (*model.InvoiceTitle, error) {
+	title := new(model.InvoiceTitle)
+	err := DB().WithContext(ivd.ctx).Where("id = ?", titleId).
+		Find(title).Error
+	return title, err
+}
+
+func (ivd *InvoiceDao) FindUserInvoiceTitles(userId int64) ([]*model.InvoiceTitle, error) {
+	titles := make([]*model.InvoiceTitle, 0)
+	err := DB().WithContext(ivd.ctx).Where("user_id = ?", userId).
+		Order("id DESC").Find(&titles).Error
+	return titles, err
+}
+
+func (ivd *InvoiceDao) DeleteInvoiceTitle(title *model.InvoiceTitle) error {
+	return DBMaster().WithContext(ivd.ctx).Delete(title).Error
+}
+
+// CreateOrderInvoice 创建订单的开票记录, 订单已经有没被红冲的发票时不创建, 返回 created=false
+// 在事务里锁住订单的行记录后再检查和创建, 同一个订单的并发开票申请只有一个能创建成功
+func (ivd *InvoiceDao) CreateOrderInvoice(invoice *do.OrderInvoice) (created bool, err error) {
+	invoiceModel := new(model.OrderInvoice)
+	if err = util.CopyProperties(invoiceModel, invoice); err != nil {
+		return false, errcode.ErrCoverData.WithCause(err)
+	}
+	err = DBMaster().WithContext(ivd.ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").
+			Where("id = ?", invoice.OrderId).Find(new(model.Order)).Error
+		if err != nil {
+			return err
+		}
+		var activeCount int64
+		err = tx.Model(model.OrderInvoice{}).
+			Where("order_id = ? AND invoice_state <> ?", invoice.OrderId, enum.InvoiceStateRedFlushed).
+			Count(&activeCount).Error
+		if err != nil || activeCount > 0 {
+			return err
+		}
+		if err = tx.Create(invoiceModel).Error; err != nil {
+			return err
+		}
+		created = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	invoice.ID = invoiceModel.ID
+	return created, nil
+}
+
+func (ivd *InvoiceDao) GetOrderInvoiceByNo(invoiceNo string) (*model.OrderInvoice, error) {
+	invoice := new(model.OrderInvoice)
+	err := DB().WithContext(ivd.ctx).Where("invoice_no = ?", invoiceNo).
+		Find(invoice).Error
+	return invoice, err
+}
+
+// GetOrderInvoices 查询订单的所有开票记录
+func (ivd *InvoiceDao) GetOrderInvoices(orderId int64) ([]*model.OrderInvoice, error) {
+	invoices := make([]*model.OrderInvoice, 0)
+	err := DB().WithContext(ivd.ctx).Where("order_id = ?", orderId).
+		Order("id DESC").Find(&invoices).Error
+	return invoices, err
+}
+
+// GetInvoices 分页查询开票记录, 先申请的排在前面
+// @param invoiceState 发票状态, 为 0 时查询所有状态
+func (ivd *InvoiceDao) GetInvoices(invoiceState int, offset, returnSize int) (invoices []*model.OrderInvoice, totalRows int64, err error) {
+	query := DB().WithContext(ivd.ctx).Model(model.OrderInvoice{})
+	if invoiceState > 0 {
+		query = query.Where("invoice_state = ?", invoiceState)
+	}
+	if err = query.Count(&totalRows).Error; err != nil {
+		return
+	}
+	err = query.Order("id ASC").Offset(offset).Limit(returnSize).Find(&invoices).Error
+	return
+}
+
+// ClaimInvoiceIssuing 把已申请的开票记录更新为开票中, 占用这张发票后才能调用开票服务
+// 返回的 bool 表示此次调用是否占用成功, 并发的开票请求只有一个能占用成功, 避免重复开票
+func (ivd *InvoiceDao) ClaimInvoiceIssuing(invoiceId int64) (bool, error) {
+	dbResult := DBMaster().WithContext(ivd.ctx).Model(model.OrderInvoice{}).
+		Where("id = ? AND invoice_state = ?", invoiceId, enum.InvoiceStateRequested).
+		Update("invoice_state", enum.InvoiceStateIssuing)
+
+	return dbResult.RowsAffected > 0, dbResult.Error
+}
+
+// ReleaseInvoiceIssuing 开票失败时把开票中的记录恢复为已申请, 之后可以重新开票
+func (ivd *InvoiceDao) ReleaseInvoiceIssuing(invoiceId int64) error {
+	return DBMaster().WithContext(ivd.ctx).Model(model.OrderInvoice{}).
+		Where("id = ? AND invoice_state = ?", invoiceId, enum.InvoiceStateIssuing).
+		Update("invoice_state", enum.InvoiceStateRequested).Error
+}
+
+// SetInvoiceIssued 把开票中的记录更新为已开票, 开票金额更新为开票时计算的金额
+// 返回的 bool 表示此次调用是否真正更新了记录
+func (ivd *InvoiceDao) SetInvoiceIssued(invoiceId int64, amount int, result *do.InvoiceIssueResult) (bool, error) {
+	dbResult := DBMaster().WithContext(ivd.ctx).Model(model.OrderInvoice{}).
+		Where("id = ? AND invoice_state = ?", invoiceId, enum.InvoiceStateIssuing).
+		Updates(map[string]interface{}{
+			"invoice_state":  enum.InvoiceStateIssued,
+			"amount":         amount,
+			"invoice_number": result.InvoiceNumber,
+			"pdf_path":       result.PdfPath,
+			"issued_at":      result.IssuedAt,
+		})
+
+	return dbResult.RowsAffected > 0, dbResult.Error
+}
+
+// SetInvoiceRedFlushed 把已开票的记录更新为已红冲
+// 返回的 bool 表示此次调用是否真正更新了记录, 避免重复红冲
+func (ivd *InvoiceDao) SetInvoiceRedFlushed(invoiceId int64, result *do.InvoiceIssueResult) (bool, error) {
+	dbResult := DBMaster().WithContext(ivd.ctx).Model(model.OrderInvoice{}).
+		Where("id = ? AND invoice_state = ?", invoiceId, enum.InvoiceStateIssued).
+		Updates(map[string]interface{}{
+			"invoice_state":      enum.InvoiceStateRedFlushed,
+			"red_invoice_number": result.InvoiceNumber,
+			"red_pdf_path":       result.PdfPath,
+			"red_flushed_at":     result.IssuedAt,
+		})
+
+	return dbResult.RowsAffected > 0, dbResult.Error
+}
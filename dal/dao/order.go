@@ -2,7 +2,9 @@ package dao
 
 import (
 	"context"
+	"time"
 
+	"github.com/WoWBytePaladin/go-mall/common/enum"
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
 	"github.com/WoWBytePaladin/go-mall/common/util"
 	"github.com/WoWBytePaladin/go-mall/dal/model"
@@ -66,9 +68,22 @@ func (od *OrderDao) createOrderAddress(tx *gorm.DB, orderAddress *do.OrderAddres
 	return tx.WithContext(od.ctx).Create(orderAddressModel).Error
 }
 
-// GetUserOrders 查询用户订单
-func (od *OrderDao) GetUserOrders(userId int64, offset, returnSize int) (orders []*model.Order, totalRows int64, err error) {
-	err = DB().WithContext(od.ctx).Where("user_id = ?", userId).
+// GetUserOrders 分页查询用户的订单, 最新的订单在前
+// @param orderStatuses 要查询的订单状态, 为空时查询所有状态的订单; 还在履约的部分退款订单按履约进度也会被查询出来
+func (od *OrderDao) GetUserOrders(userId int64, orderStatuses []int, offset, returnSize int) (orders []*model.Order, totalRows int64, err error) {
+	query := DB().WithContext(od.ctx).Model(model.Order{}).Where("user_id = ?", userId).Scopes(userVisibleOrderScope)
+	if len(orderStatuses) > 0 {
+		statusCond := DB().Where("order_status IN (?)", orderStatuses)
+		for _, orderStatus := range orderStatuses {
+			if fulfilmentCond := partialRefundedFulfilmentCond(orderStatus); fulfilmentCond != nil {
+				statusCond = statusCond.Or(fulfilmentCond)
+			}
+		}
+		query = query.Where(statusCond)
+	}
+	// 列表和记录数共用查询条件
+	query = query.Session(&gorm.Session{})
+	err = query.Order("id DESC").
 		Offset(offset).Limit(returnSize).
 		Find(&orders).Error
 	if err != nil {
@@ -76,10 +91,159 @@ func (od *OrderDao) GetUserOrders(userId int64, offset, returnSize int) (orders
 	}
 
 	// 查询满足条件的记录数
-	DB().WithContext(od.ctx).Model(model.Order{}).Where("user_id = ?", userId).Count(&totalRows)
+	err = query.Count(&totalRows).Error
+	return
+}
+
+// orderTimeHappenedSince 订单上没有发生的时间默认是1970-01-01, 不早于这个时间说明已经发生
+var orderTimeHappenedSince = time.Date(1971, 1, 1, 0, 0, 0, 0, time.Local)
+
+// partialRefundedFulfilmentCond 部分退款的订单履约进度处于 fulfilmentStatus 的查询条件, 不是履约状态时返回 nil
+// 履约进度由发货、送达、确认收货的时间推算, 跟领域服务里推算部分退款订单履约状态的规则一致
+func partialRefundedFulfilmentCond(fulfilmentStatus int) *gorm.DB {
+	cond := DB().Where("order_status = ?", enum.OrderStatusPartialRefunded)
+	switch fulfilmentStatus {
+	case enum.OrderStatusPaid:
+		return cond.Where("confirmed_at < ? AND delivered_at < ? AND shipped_at < ?",
+			orderTimeHappenedSince, orderTimeHappenedSince, orderTimeHappenedSince)
+	case enum.OrderStatusShipped:
+		return cond.Where("confirmed_at < ? AND delivered_at < ? AND shipped_at >= ?",
+			orderTimeHappenedSince, orderTimeHappenedSince, orderTimeHappenedSince)
+	case enum.OrderStatusDelivered:
+		return cond.Where("confirmed_at < ? AND delivered_at >= ?", orderTimeHappenedSince, orderTimeHappenedSince)
+	case enum.OrderStatusConfirmReceipt:
+		return cond.Where("confirmed_at >= ?", orderTimeHappenedSince)
+	}
+	return nil
+}
+
+// GetUserPartialRefundedOrders 查询用户部分退款的订单, 只查询推算履约进度需要的字段
+func (od *OrderDao) GetUserPartialRefundedOrders(userId int64) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0)
+	err := DB().WithContext(od.ctx).Select("id", "order_status", "shipped_at", "delivered_at", "confirmed_at").
+		Where("user_id = ? AND order_status = ?", userId, enum.OrderStatusPartialRefunded).
+		Scopes(userVisibleOrderScope).
+		Find(&orders).Error
+	return orders, err
+}
+
+// CountUserOrdersByStatus 按订单状态分组统计用户的订单数, 返回订单状态到订单数的映射
+func (od *OrderDao) CountUserOrdersByStatus(userId int64) (map[int]int64, error) {
+	statusCounts := make([]struct {
+		OrderStatus int
+		Total       int64
+	}, 0)
+	err := DB().WithContext(od.ctx).Model(model.Order{}).
+		Select("order_status, COUNT(*) AS total").
+		Where("user_id = ?", userId).
+		Scopes(userVisibleOrderScope).
+		Group("order_status").
+		Scan(&statusCounts).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[int]int64, len(statusCounts))
+	for _, statusCount := range statusCounts {
+		counts[statusCount.OrderStatus] = statusCount.Total
+	}
+	return counts, nil
+}
+
+// GetUserDeletedOrders 分页查询用户删除了的订单, 最近删除的订单在前
+func (od *OrderDao) GetUserDeletedOrders(userId int64, offset, returnSize int) (orders []*model.Order, totalRows int64, err error) {
+	query := DB().WithContext(od.ctx).Unscoped().Model(model.Order{}).
+		Where("user_id = ? AND is_del = ?", userId, 1).
+		Scopes(userVisibleOrderScope).
+		Session(&gorm.Session{})
+	err = query.Order("updated_at DESC, id DESC").
+		Offset(offset).Limit(returnSize).
+		Find(&orders).Error
+	if err != nil {
+		return
+	}
+
+	err = query.Count(&totalRows).Error
 	return
 }
 
+// SetUserOrderDeleted 用户删除或者恢复订单, 父订单拆分出的子订单一起删除或者恢复
+func (od *OrderDao) SetUserOrderDeleted(orderId int64, deleted bool) error {
+	isDel := 0
+	if deleted {
+		isDel = 1
+	}
+	return DBMaster().WithContext(od.ctx).Unscoped().Model(model.Order{}).
+		Where("id = ? OR parent_id = ?", orderId, orderId).
+		Update("is_del", isDel).Error
+}
+
+// userVisibleOrderScope 用户订单列表中显示的订单
+// 拆单的订单, 支付前用户看到的是要支付的父订单, 支付后看到的是分别发货的子订单
+func userVisibleOrderScope(db *gorm.DB) *gorm.DB {
+	return db.Where("(order_type <> ? OR pay_state <> ?) AND (order_type <> ? OR pay_state = ?)",
+		enum.OrderTypeParent, enum.PayStatePaid, enum.OrderTypeSub, enum.PayStatePaid)
+}
+
+// SearchOrders 管理后台按条件分页查询订单, 最新的订单在前, 用户删除了的订单也要查询出来
+func (od *OrderDao) SearchOrders(query *do.OrderQuery, offset, returnSize int) (orders []*model.Order, totalRows int64, err error) {
+	db := DB().WithContext(od.ctx).Unscoped().Model(model.Order{}).Scopes(orderQueryScope(query)).Session(&gorm.Session{})
+	err = db.Order("id DESC").
+		Offset(offset).Limit(returnSize).
+		Find(&orders).Error
+	if err != nil {
+		return
+	}
+
+	err = db.Count(&totalRows).Error
+	return
+}
+
+// ScanOrders 按条件分批查询订单, 用于导出订单, 按ID从小到大排序, 包含用户删除了的订单
+// @param lastId 上一批订单的最大ID, 用于分批查询
+// @param limit 每批查询的数量
+func (od *OrderDao) ScanOrders(query *do.OrderQuery, lastId int64, limit int) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0, limit)
+	err := DB().WithContext(od.ctx).Unscoped().Scopes(orderQueryScope(query)).
+		Where("id > ?", lastId).
+		Order("id ASC").Limit(limit).
+		Find(&orders).Error
+
+	return orders, err
+}
+
+// orderQueryScope 把订单查询条件转换成查询语句
+func orderQueryScope(query *do.OrderQuery) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if query.OrderNo != "" {
+			db = db.Where("order_no = ?", query.OrderNo)
+		}
+		if query.UserId > 0 {
+			db = db.Where("user_id = ?", query.UserId)
+		}
+		if query.UserPhone != "" {
+			// 手机号保存在订单的收货地址上
+			db = db.Where("id IN (?)", DB().WithContext(db.Statement.Context).Model(model.OrderAddress{}).
+				Select("order_id").Where("user_phone = ?", query.UserPhone))
+		}
+		if !query.CreatedStart.IsZero() {
+			db = db.Where("created_at >= ?", query.CreatedStart)
+		}
+		if !query.CreatedEnd.IsZero() {
+			db = db.Where("created_at < ?", query.CreatedEnd)
+		}
+		if query.OrderStatus != nil {
+			db = db.Where("order_status = ?", *query.OrderStatus)
+		}
+		if query.PayState != nil {
+			db = db.Where("pay_state = ?", *query.PayState)
+		}
+		if query.PayType != nil {
+			db = db.Where("pay_type = ?", *query.PayType)
+		}
+		return db
+	}
+}
+
 // GetMultiOrdersAddress 获取多个订单的地址, 返回以 orderId 为Key, 对应的订单地址为值的 Map
 func (od *OrderDao) GetMultiOrdersAddress(orderIds []int64) (map[int64]*model.OrderAddress, error) {
 	orderAddressList := make([]*model.OrderAddress, 0, len(orderIds))
@@ -115,9 +279,10 @@ func (od *OrderDao) GetMultiOrdersItems(orderIds []int64) (map[int64][]*model.Or
 	return orderItemsMap, nil
 }
 
+// GetOrderByNo 用订单号查询订单, 用户删除了的订单也能查询到
 func (od *OrderDao) GetOrderByNo(orderNo string) (*model.Order, error) {
 	order := new(model.Order)
-	err := DB().WithContext(od.ctx).Where("order_no = ?", orderNo).
+	err := DB().WithContext(od.ctx).Unscoped().Where("order_no = ?", orderNo).
 		Find(order).Error
 
 	return order, err
@@ -139,13 +304,113 @@ func (od *OrderDao) GetOrderItems(orderId int64) ([]*model.OrderItem, error) {
 	return orderItems, err
 }
 
-// UpdateOrderStatus 更新订单状态
-func (od *OrderDao) UpdateOrderStatus(orderId int64, status int) error {
+// SetOrderPayFailed 把待支付订单的支付状态更新为支付失败, 父订单的子订单一起更新
+func (od *OrderDao) SetOrderPayFailed(orderId int64) error {
 	return DBMaster().WithContext(od.ctx).Model(model.Order{}).
-		Where("id = ?", orderId).
-		Update("order_status", status).Error
+		Where("(id = ? OR parent_id = ?) AND pay_state = ?", orderId, orderId, enum.PayStateUnPaid).
+		Update("pay_state", enum.PayStatePayFailed).Error
+}
+
+// GetStuckUnPaidOrders 查询发起支付后迟迟没有收到支付结果的订单, 子订单不单独支付, 不查询子订单
+// @param startedBefore 在这个时间之前发起支付的订单
+// @param lastId 上一批订单的最大ID, 用于分批查询
+// @param limit 每批查询的数量
+func (od *OrderDao) GetStuckUnPaidOrders(startedBefore time.Time, lastId int64, limit int) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0, limit)
+	err := DB().WithContext(od.ctx).
+		Where("pay_state = ? AND order_status = ? AND updated_at < ? AND id > ? AND order_type <> ?",
+			enum.PayStateUnPaid, enum.OrderStatusUnPaid, startedBefore, lastId, enum.OrderTypeSub).
+		Order("id ASC").Limit(limit).
+		Find(&orders).Error
+
+	return orders, err
 }
 
-func (od *OrderDao) UpdateOrder(orderModel *model.Order) error {
-	return DBMaster().WithContext(od.ctx).Model(orderModel).Updates(orderModel).Error
+// GetExpiredUnpaidOrders 查询超过支付截止时间仍未支付、也没有关闭的订单, 子订单跟随父订单关闭, 不查询子订单
+// @param deadlineBefore 支付截止时间在这个时间之前的订单
+// @param lastId 上一批订单的最大ID, 用于分批查询
+// @param limit 每批查询的数量
+func (od *OrderDao) GetExpiredUnpaidOrders(deadlineBefore time.Time, lastId int64, limit int) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0, limit)
+	err := DB().WithContext(od.ctx).Unscoped().
+		Where("pay_state <> ? AND order_status IN (?) AND pay_deadline < ? AND id > ? AND order_type <> ?",
+			enum.PayStatePaid, []int{enum.OrderStatusCreated, enum.OrderStatusUnPaid}, deadlineBefore, lastId, enum.OrderTypeSub).
+		Order("id ASC").Limit(limit).
+		Find(&orders).Error
+
+	return orders, err
+}
+
+// GetDeliveredOrdersBefore 查询在指定时间之前送达、用户还没有确认收货的订单
+// 送达后部分退款的订单也要查出来, 它们的履约进度由送达、确认收货的时间记录, 没有发生的时间是默认的1970-01-01
+// @param deliveredBefore 在这个时间之前送达的订单
+// @param lastId 上一批订单的最大ID, 用于分批查询
+// @param limit 每批查询的数量
+func (od *OrderDao) GetDeliveredOrdersBefore(deliveredBefore time.Time, lastId int64, limit int) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0, limit)
+	err := DB().WithContext(od.ctx).
+		Where("delivered_at < ? AND id > ?", deliveredBefore, lastId).
+		Where("order_status = ? OR (order_status = ? AND YEAR(delivered_at) > 1970 AND YEAR(confirmed_at) = 1970)",
+			enum.OrderStatusDelivered, enum.OrderStatusPartialRefunded).
+		Order("id ASC").Limit(limit).
+		Find(&orders).Error
+
+	return orders, err
+}
+
+// GetConfirmedOrdersBefore 查询在指定时间之前确认收货、还没有完成的订单, 包括确认收货后部分退款的订单
+// @param confirmedBefore 在这个时间之前确认收货的订单
+// @param lastId 上一批订单的最大ID, 用于分批查询
+// @param limit 每批查询的数量
+func (od *OrderDao) GetConfirmedOrdersBefore(confirmedBefore time.Time, lastId int64, limit int) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0, limit)
+	err := DB().WithContext(od.ctx).
+		Where("confirmed_at < ? AND id > ?", confirmedBefore, lastId).
+		Where("order_status = ? OR (order_status = ? AND YEAR(confirmed_at) > 1970)",
+			enum.OrderStatusConfirmReceipt, enum.OrderStatusPartialRefunded).
+		Order("id ASC").Limit(limit).
+		Find(&orders).Error
+
+	return orders, err
+}
+
+// GetPaidOrdersBetween 查询一段时间内支付成功的订单, 子订单不单独支付, 不查询子订单
+// @param payType 支付方式
+// @param start 支付时间的开始, 包含
+// @param end 支付时间的结束, 不包含
+func (od *OrderDao) GetPaidOrdersBetween(payType int, start, end time.Time) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0)
+	err := DB().WithContext(od.ctx).Unscoped().
+		Where("pay_type = ? AND pay_state = ? AND paid_at >= ? AND paid_at < ? AND order_type <> ?",
+			payType, enum.PayStatePaid, start, end, enum.OrderTypeSub).
+		Find(&orders).Error
+
+	return orders, err
+}
+
+// GetOrderById 用订单ID查询订单
+func (od *OrderDao) GetOrderById(orderId int64) (*model.Order, error) {
+	order := new(model.Order)
+	err := DB().WithContext(od.ctx).Unscoped().Where("id = ?", orderId).Find(order).Error
+
+	return order, err
+}
+
+// GetSubOrders 查询父订单拆分出的子订单
+func (od *OrderDao) GetSubOrders(parentId int64) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0)
+	err := DB().WithContext(od.ctx).Unscoped().Where("parent_id = ?", parentId).
+		Order("id ASC").
+		Find(&orders).Error
+
+	return orders, err
+}
+
+// GetOrdersByNos 用订单号批量查询订单
+func (od *OrderDao) GetOrdersByNos(orderNos []string) ([]*model.Order, error) {
+	orders := make([]*model.Order, 0, len(orderNos))
+	err := DB().WithContext(od.ctx).Unscoped().Where("order_no in (?)", orderNos).
+		Find(&orders).Error
+
+	return orders, err
 }
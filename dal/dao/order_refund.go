@@ -0,0 +1,146 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+type OrderRefundDao struct {
+	ctx context.Context
+}
+
+func NewOrderRefundDao(ctx context.Context) *OrderRefundDao {
+	return &OrderRefundDao{ctx: ctx}
+}
+
+// CreateOrderRefund 创建退款申请和申请中要退的商品
+func (ord *OrderRefundDao) CreateOrderRefund(refund *do.OrderRefund) error {
+	refundModel := new(model.OrderRefund)
+	if err := util.CopyProperties(refundModel, refund); err != nil {
+		return errcode.ErrCoverData.WithCause(err)
+	}
+
+	return DBMaster().WithContext(ord.ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(refundModel).Error; err != nil {
+			return err
+		}
+		refund.ID = refundModel.ID
+		refundItemModels := make([]*model.OrderRefundItem, 0, len(refund.Items))
+		for _, item := range refund.Items {
+			item.RefundId = refundModel.ID
+			refundItemModels = append(refundItemModels, &model.OrderRefundItem{
+				RefundId:     item.RefundId,
+				OrderId:      item.OrderId,
+				CommodityId:  item.CommodityId,
+				CommodityNum: item.CommodityNum,
+				RefundMoney:  item.RefundMoney,
+			})
+		}
+		if len(refundItemModels) == 0 {
+			return nil
+		}
+		return tx.Create(refundItemModels).Error
+	})
+}
+
+func (ord *OrderRefundDao) GetOrderRefundByNo(refundNo string) (*model.OrderRefund, error) {
+	refund := new(model.OrderRefund)
+	err := DB().WithContext(ord.ctx).Where("refund_no = ?", refundNo).
+		Find(refund).Error
+
+	return refund, err
+}
+
+// GetOrderRefunds 查询订单的所有退款申请
+func (ord *OrderRefundDao) GetOrderRefunds(orderId int64) ([]*model.OrderRefund, error) {
+	refunds := make([]*model.OrderRefund, 0)
+	err := DB().WithContext(ord.ctx).Where("order_id = ?", orderId).
+		Order("id DESC").Find(&refunds).Error
+
+	return refunds, err
+}
+
+// GetMultiRefundsItems 获取多个退款申请要退的商品, 返回以 refundId 为Key, 退款商品列表为值的 Map
+func (ord *OrderRefundDao) GetMultiRefundsItems(refundIds []int64) (map[int64][]*model.OrderRefundItem, error) {
+	refundItems := make([]*model.OrderRefundItem, 0)
+	err := DB().WithContext(ord.ctx).Where("refund_id in (?)", refundIds).
+		Find(&refundItems).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return lo.GroupBy(refundItems, func(item *model.OrderRefundItem) int64 {
+		return item.RefundId
+	}), nil
+}
+
+// AuditOrderRefund 审核退款申请, 只有待审核的申请会被更新
+// 返回的 bool 表示此次调用是否真正更新了申请, 避免重复审核
+func (ord *OrderRefundDao) AuditOrderRefund(refundId int64, approved bool, auditRemark string) (bool, error) {
+	refundState := enum.RefundStateRejected
+	if approved {
+		refundState = enum.RefundStateProcessing
+	}
+	result := DBMaster().WithContext(ord.ctx).Model(model.OrderRefund{}).
+		Where("id = ? AND refund_state = ?", refundId, enum.RefundStatePending).
+		Updates(map[string]interface{}{
+			"refund_state": refundState,
+			"audit_remark": auditRemark,
+		})
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// SetRefundTransId 回填支付平台的退款单号
+func (ord *OrderRefundDao) SetRefundTransId(refundId int64, refundTransId string) error {
+	return DBMaster().WithContext(ord.ctx).Model(model.OrderRefund{}).
+		Where("id = ?", refundId).
+		Update("refund_trans_id", refundTransId).Error
+}
+
+// SetOrderRefundResult 把退款中的申请更新为退款成功或者退款失败
+// 返回的 bool 表示此次调用是否真正更新了申请, 重复的退款通知不会重复更新
+func (ord *OrderRefundDao) SetOrderRefundResult(refundId int64, refundState int, refundTransId string, refundedAt time.Time) (bool, error) {
+	return ord.SetOrderRefundResultInTx(DBMaster(), refundId, refundState, refundTransId, refundedAt)
+}
+
+// SetOrderRefundResultInTx 在事务 tx 里把退款中的申请更新为退款成功或者退款失败
+func (ord *OrderRefundDao) SetOrderRefundResultInTx(tx *gorm.DB, refundId int64, refundState int, refundTransId string, refundedAt time.Time) (bool, error) {
+	updates := map[string]interface{}{
+		"refund_state": refundState,
+	}
+	if refundTransId != "" {
+		updates["refund_trans_id"] = refundTransId
+	}
+	if refundState == enum.RefundStateSuccess {
+		updates["refunded_at"] = refundedAt
+	}
+	result := tx.WithContext(ord.ctx).Model(model.OrderRefund{}).
+		Where("id = ? AND refund_state = ?", refundId, enum.RefundStateProcessing).
+		Updates(updates)
+
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetStuckProcessingRefunds 查询审核通过后迟迟没有拿到退款结果的微信支付退款申请
+// @param updatedBefore 在这个时间之后没有更新过的退款申请
+// @param lastId 上一批退款申请的最大ID, 用于分批查询
+// @param limit 每批查询的数量
+func (ord *OrderRefundDao) GetStuckProcessingRefunds(updatedBefore time.Time, lastId int64, limit int) ([]*model.OrderRefund, error) {
+	refunds := make([]*model.OrderRefund, 0, limit)
+	err := DB().WithContext(ord.ctx).
+		Where("refund_state = ? AND pay_type = ? AND updated_at < ? AND id > ?",
+			enum.RefundStateProcessing, enum.PayTypeWxPay, updatedBefore, lastId).
+		Order("id ASC").Limit(limit).
+		Find(&refunds).Error
+
+	return refunds, err
+}
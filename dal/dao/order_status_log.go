@@ -0,0 +1,125 @@
+package dao
+
+import (
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"gorm.io/gorm"
+)
+
+// TransitOrderStatus 变更订单状态并记录状态变更
+// 只有订单当前状态仍是 statusLog.FromStatus 时才会更新, 订单状态被并发修改时不做任何更新
+// 用户删除订单只是不在订单列表里显示, 删除了的订单(比如已完成的订单退款)照常变更状态
+// @param updates 随订单状态一起更新的其他字段
+// @param afterTransit 订单状态变更成功后在同一个事务里执行的操作, 执行失败时状态变更一起回滚, 没有时传 nil
+// @return bool 此次调用是否真正变更了订单状态
+func (od *OrderDao) TransitOrderStatus(orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog,
+	afterTransit func(tx *gorm.DB) error) (bool, error) {
+	transited := false
+	err := DBMaster().WithContext(od.ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(model.Order{}).
+			Where("id = ? AND order_status = ?", orderId, statusLog.FromStatus).
+			Updates(orderStatusColumns(updates, statusLog.ToStatus))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		statusLog.OrderId = orderId
+		if err := tx.Create(statusLog).Error; err != nil {
+			return err
+		}
+		if afterTransit != nil {
+			if err := afterTransit(tx); err != nil {
+				return err
+			}
+		}
+		transited = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return transited, nil
+}
+
+// TransitParentOrderStatus 变更父订单和它的子订单的状态, 并为每个订单记录状态变更
+// 只有父订单当前状态仍是 statusLog.FromStatus 时才会更新, 子订单只更新状态和父订单相同的
+// @param afterTransit 订单状态变更成功后在同一个事务里执行的操作, 执行失败时状态变更一起回滚, 没有时传 nil
+// @return bool 此次调用是否真正变更了父订单的状态
+func (od *OrderDao) TransitParentOrderStatus(parentId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog,
+	afterTransit func(tx *gorm.DB) error) (bool, error) {
+	transited := false
+	err := DBMaster().WithContext(od.ctx).Transaction(func(tx *gorm.DB) error {
+		columns := orderStatusColumns(updates, statusLog.ToStatus)
+		result := tx.Unscoped().Model(model.Order{}).
+			Where("id = ? AND order_status = ?", parentId, statusLog.FromStatus).
+			Updates(columns)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		subOrders := make([]*model.Order, 0)
+		err := tx.Unscoped().Select("id", "order_no").
+			Where("parent_id = ? AND order_status = ?", parentId, statusLog.FromStatus).
+			Find(&subOrders).Error
+		if err != nil {
+			return err
+		}
+		statusLog.OrderId = parentId
+		statusLogs := []*model.OrderStatusLog{statusLog}
+		if len(subOrders) > 0 {
+			subOrderIds := make([]int64, 0, len(subOrders))
+			for _, subOrder := range subOrders {
+				subOrderIds = append(subOrderIds, subOrder.ID)
+				subOrderLog := *statusLog
+				subOrderLog.OrderId = subOrder.ID
+				subOrderLog.OrderNo = subOrder.OrderNo
+				statusLogs = append(statusLogs, &subOrderLog)
+			}
+			err = tx.Unscoped().Model(model.Order{}).
+				Where("id IN (?) AND order_status = ?", subOrderIds, statusLog.FromStatus).
+				Updates(columns).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err = tx.Create(statusLogs).Error; err != nil {
+			return err
+		}
+		if afterTransit != nil {
+			if err = afterTransit(tx); err != nil {
+				return err
+			}
+		}
+		transited = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return transited, nil
+}
+
+// orderStatusColumns 变更订单状态时要更新的字段
+func orderStatusColumns(updates map[string]interface{}, toStatus int) map[string]interface{} {
+	columns := make(map[string]interface{}, len(updates)+1)
+	for column, value := range updates {
+		columns[column] = value
+	}
+	columns["order_status"] = toStatus
+	return columns
+}
+
+// GetOrderStatusLogs 查询订单的状态变更记录, 按变更的先后排序
+func (od *OrderDao) GetOrderStatusLogs(orderId int64) ([]*model.OrderStatusLog, error) {
+	statusLogs := make([]*model.OrderStatusLog, 0)
+	err := DB().WithContext(od.ctx).Where("order_id = ?", orderId).
+		Order("id ASC").
+		Find(&statusLogs).Error
+
+	return statusLogs, err
+}
@@ -0,0 +1,54 @@
+package dao
+
+import (
+	"context"
+
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"gorm.io/gorm"
+)
+
+type PayReconcileDao struct {
+	ctx context.Context
+}
+
+func NewPayReconcileDao(ctx context.Context) *PayReconcileDao {
+	return &PayReconcileDao{ctx: ctx}
+}
+
+// ReplaceReconcileDiffs 保存某天某个支付方式的对账差异
+// 同一天重复对账时先删掉上次的结果, 只保留最新一次对账的差异
+func (prd *PayReconcileDao) ReplaceReconcileDiffs(billDate string, payType int, diffs []*do.PayReconcileDiff) error {
+	diffModels := make([]*model.PayReconcileDiff, 0, len(diffs))
+	if err := util.CopyProperties(&diffModels, &diffs); err != nil {
+		return errcode.ErrCoverData.WithCause(err)
+	}
+
+	return DBMaster().WithContext(prd.ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("bill_date = ? AND pay_type = ?", billDate, payType).
+			Delete(&model.PayReconcileDiff{}).Error
+		if err != nil {
+			return err
+		}
+		if len(diffModels) == 0 {
+			return nil
+		}
+		return tx.Create(diffModels).Error
+	})
+}
+
+// GetReconcileDiffs 分页查询某天的对账差异
+// @param diffType 差异类型, 为 0 时查询所有类型
+func (prd *PayReconcileDao) GetReconcileDiffs(billDate string, diffType int, offset, returnSize int) (diffs []*model.PayReconcileDiff, totalRows int64, err error) {
+	query := DB().WithContext(prd.ctx).Model(model.PayReconcileDiff{}).Where("bill_date = ?", billDate)
+	if diffType > 0 {
+		query = query.Where("diff_type = ?", diffType)
+	}
+	if err = query.Count(&totalRows).Error; err != nil {
+		return
+	}
+	err = query.Order("id ASC").Offset(offset).Limit(returnSize).Find(&diffs).Error
+	return
+}
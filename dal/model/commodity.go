@@ -16,9 +16,13 @@ type Commodity struct {
 	DetailContent string                `gorm:"column:detail_content;NOT NULL"`                       // 商品详情
 	OriginalPrice int                   `gorm:"column:original_price;default:1;NOT NULL"`             // 商品原价
 	SellingPrice  int                   `gorm:"column:selling_price;default:1;NOT NULL"`              // 商品售价
-	StockNum      int                   `gorm:"column:stock_num;default:0;NOT NULL"`                  // 商品库存数量
+	StockNum      int                   `gorm:"column:stock_num;default:0;NOT NULL"`                  // 商品的可售库存数量
+	ReservedNum   int                   `gorm:"column:reserved_num;default:0;NOT NULL"`               // 已下单未支付的订单预占的库存数量
+	SoldNum       int                   `gorm:"column:sold_num;default:0;NOT NULL"`                   // 已支付的订单售出的库存数量
 	Tag           string                `gorm:"column:tag;NOT NULL"`                                  // 商品标签
 	SellStatus    int                   `gorm:"column:sell_status;default:1;NOT NULL"`                // 商品上架状态 1-上架  2-下架
+	MerchantId    int64                 `gorm:"column:merchant_id;default:0;NOT NULL"`                // 商品所属的商家ID, 0-自营
+	WarehouseId   int64                 `gorm:"column:warehouse_id;default:0;NOT NULL"`               // 商品的发货仓库ID, 0-默认仓库
 	IsDel         soft_delete.DeletedAt `gorm:"softDelete:flag"`                                      // 删除标识字段(0-未删除 1-已删除)
 	CreatedAt     time.Time             `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 创建时间
 	UpdatedAt     time.Time             `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 更新时间
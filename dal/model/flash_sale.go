@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// FlashSale 秒杀活动
+type FlashSale struct {
+	ID            int64     `gorm:"column:id;primary_key;AUTO_INCREMENT"`                 // 主键ID
+	Name          string    `gorm:"column:name;NOT NULL"`                                 // 活动名称
+	CommodityId   int64     `gorm:"column:commodity_id;NOT NULL"`                         // 秒杀的商品ID
+	FlashPrice    int       `gorm:"column:flash_price;default:0;NOT NULL"`                // 秒杀价（分）
+	TotalStock    int       `gorm:"column:total_stock;default:0;NOT NULL"`                // 活动的库存数量
+	SoldNum       int       `gorm:"column:sold_num;default:0;NOT NULL"`                   // 已经抢购的数量, 由对账任务从 Redis 同步
+	PerUserLimit  int       `gorm:"column:per_user_limit;default:1;NOT NULL"`             // 每个用户的限购数量
+	StockReleased bool      `gorm:"column:stock_released;default:0;NOT NULL"`             // 活动结束后没有卖出的库存是否已经还给商品的可售库存
+	StartTime     time.Time `gorm:"column:start_time;NOT NULL"`                           // 活动开始时间
+	EndTime       time.Time `gorm:"column:end_time;NOT NULL"`                             // 活动结束时间
+	CreatedAt     time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 创建时间
+	UpdatedAt     time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 更新时间
+}
+
+func (FlashSale) TableName() string {
+	return "flash_sales"
+}
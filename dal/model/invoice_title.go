@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/plugin/soft_delete"
+)
+
+// InvoiceTitle 用户的发票抬头
+type InvoiceTitle struct {
+	ID        int64                 `gorm:"column:id;primary_key;AUTO_INCREMENT"`                 // 发票抬头ID
+	UserId    int64                 `gorm:"column:user_id;NOT NULL"`                              // 用户ID
+	TitleType int                   `gorm:"column:title_type;default:1;NOT NULL"`                 // 抬头类型 1-个人 2-单位
+	Title     string                `gorm:"column:title;NOT NULL"`                                // 发票抬头, 个人姓名或者单位名称
+	TaxNo     string                `gorm:"column:tax_no;NOT NULL"`                               // 单位的纳税人识别号, 个人抬头为空
+	IsDel     soft_delete.DeletedAt `gorm:"softDelete:flag"`                                      // 删除状态 0-未删除 1-已删除
+	CreatedAt time.Time             `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 添加时间
+	UpdatedAt time.Time             `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 修改时间
+}
+
+func (InvoiceTitle) TableName() string {
+	return "invoice_titles"
+}
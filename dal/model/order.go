@@ -7,19 +7,35 @@ import (
 )
 
 type Order struct {
-	ID          int64                 `gorm:"column:id;primary_key;AUTO_INCREMENT"`                 // 订单ID
-	OrderNo     string                `gorm:"column:order_no;NOT NULL"`                             // 业务支付订单号
-	PayTransId  string                `gorm:"column:pay_trans_id;NOT NULL"`                         // 支付成功后，回填的支付平台交易ID
-	PayType     int                   `gorm:"column:pay_type;default:0;NOT NULL"`                   // 支付类型 0-未确定 1-微信支付 2-支付宝
-	UserId      int64                 `gorm:"column:user_id;NOT NULL"`                              // 用户ID
-	BillMoney   int                   `gorm:"column:bill_money;default:0;NOT NULL"`                 // 订单金额（分）
-	PayMoney    int                   `gorm:"column:pay_money;default:0;NOT NULL"`                  // 支付金额（分）
-	PayState    int                   `gorm:"column:pay_state;default:1;NOT NULL"`                  // 1-待支付，2-支付成功，3-支付失败
-	OrderStatus int                   `gorm:"column:order_status;default:0;NOT NULL"`               // 订单状态:0.待支付 1.已支付 2.配货完成 3:已出库 4.已发货 5.配送完成待客户确认 6. 已确认收货 7. 交易成功 11.用户手动关闭 12.超时未支付关闭 13.商家确认后关闭
-	PaidAt      time.Time             `gorm:"column:paid_at;default:1970-01-01 00:00:00;NOT NULL"`  // 未支付时, 默认时间为1970-01-01
-	IsDel       soft_delete.DeletedAt `gorm:"softDelete:flag"`                                      // 0-未删除 1-已删除
-	CreatedAt   time.Time             `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 创建时间
-	UpdatedAt   time.Time             `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 更新时间
+	ID               int64                 `gorm:"column:id;primary_key;AUTO_INCREMENT"`                     // 订单ID
+	OrderNo          string                `gorm:"column:order_no;NOT NULL;uniqueIndex:uk_order_no"`         // 业务支付订单号
+	PayTransId       string                `gorm:"column:pay_trans_id;NOT NULL"`                             // 支付成功后，回填的支付平台交易ID
+	PayType          int                   `gorm:"column:pay_type;default:0;NOT NULL"`                       // 支付类型 0-未确定 1-微信支付 2-支付宝
+	UserId           int64                 `gorm:"column:user_id;NOT NULL"`                                  // 用户ID
+	BillMoney        int                   `gorm:"column:bill_money;default:0;NOT NULL"`                     // 订单金额（分）
+	PayMoney         int                   `gorm:"column:pay_money;default:0;NOT NULL"`                      // 支付金额（分）
+	CouponId         int64                 `gorm:"column:coupon_id;default:0;NOT NULL"`                      // 使用的优惠券ID, 未使用时为0
+	CouponMoney      int                   `gorm:"column:coupon_money;default:0;NOT NULL"`                   // 优惠券减免金额（分）
+	DiscountId       int64                 `gorm:"column:discount_id;default:0;NOT NULL"`                    // 使用的满减活动ID, 未使用时为0
+	DiscountMoney    int                   `gorm:"column:discount_money;default:0;NOT NULL"`                 // 满减金额（分）
+	VipDiscountMoney int                   `gorm:"column:vip_discount_money;default:0;NOT NULL"`             // VIP减免金额（分）
+	PayState         int                   `gorm:"column:pay_state;default:1;NOT NULL"`                      // 1-待支付，2-支付成功，3-支付失败
+	OrderStatus      int                   `gorm:"column:order_status;default:0;NOT NULL"`                   // 订单状态:0.待支付 1.已支付 2.配货完成 3:已出库 4.已发货 5.配送完成待客户确认 6. 已确认收货 7. 交易成功 11.用户手动关闭 12.超时未支付关闭 13.商家确认后关闭
+	PayDeadline      time.Time             `gorm:"column:pay_deadline;default:1970-01-01 00:00:00;NOT NULL"` // 支付截止时间, 超时未支付的订单会被自动关闭
+	PaidAt           time.Time             `gorm:"column:paid_at;default:1970-01-01 00:00:00;NOT NULL"`      // 未支付时, 默认时间为1970-01-01
+	ShipCarrier      string                `gorm:"column:ship_carrier;NOT NULL"`                             // 物流公司编码
+	TrackingNo       string                `gorm:"column:tracking_no;NOT NULL"`                              // 物流单号
+	ShippedAt        time.Time             `gorm:"column:shipped_at;default:1970-01-01 00:00:00;NOT NULL"`   // 发货时间, 未发货时默认为1970-01-01
+	DeliveredAt      time.Time             `gorm:"column:delivered_at;default:1970-01-01 00:00:00;NOT NULL"` // 送达时间, 未送达时默认为1970-01-01
+	ConfirmedAt      time.Time             `gorm:"column:confirmed_at;default:1970-01-01 00:00:00;NOT NULL"` // 确认收货时间, 未确认时默认为1970-01-01
+	OrderType        int                   `gorm:"column:order_type;default:0;NOT NULL"`                     // 订单类型 0-未拆单 1-父订单 2-子订单
+	ParentId         int64                 `gorm:"column:parent_id;default:0;NOT NULL"`                      // 子订单的父订单ID, 其他订单为0
+	MerchantId       int64                 `gorm:"column:merchant_id;default:0;NOT NULL"`                    // 发货的商家ID, 0-自营; 父订单为0
+	WarehouseId      int64                 `gorm:"column:warehouse_id;default:0;NOT NULL"`                   // 发货的仓库ID, 0-默认仓库; 父订单为0
+	FlashSaleId      int64                 `gorm:"column:flash_sale_id;default:0;NOT NULL"`                  // 秒杀活动ID, 不是秒杀订单时为0
+	IsDel            soft_delete.DeletedAt `gorm:"softDelete:flag"`                                          // 0-未删除 1-已删除
+	CreatedAt        time.Time             `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"`     // 创建时间
+	UpdatedAt        time.Time             `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"`     // 更新时间
 }
 
 func (Order) TableName() string {
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+)
+
+// OrderInvoice 订单的开票记录
+// 申请开票时把发票抬头复制到记录里, 之后用户修改或者删除抬头不影响已经申请的发票
+type OrderInvoice struct {
+	ID               int64     `gorm:"column:id;primary_key;AUTO_INCREMENT"`                       // 开票记录ID
+	InvoiceNo        string    `gorm:"column:invoice_no;NOT NULL;uniqueIndex:uk_invoice_no"`       // 业务开票申请单号
+	OrderId          int64     `gorm:"column:order_id;NOT NULL"`                                   // 订单ID
+	OrderNo          string    `gorm:"column:order_no;NOT NULL"`                                   // 业务订单号
+	UserId           int64     `gorm:"column:user_id;NOT NULL"`                                    // 用户ID
+	TitleType        int       `gorm:"column:title_type;default:1;NOT NULL"`                       // 抬头类型 1-个人 2-单位
+	Title            string    `gorm:"column:title;NOT NULL"`                                      // 发票抬头
+	TaxNo            string    `gorm:"column:tax_no;NOT NULL"`                                     // 购买方纳税人识别号
+	Amount           int       `gorm:"column:amount;default:0;NOT NULL"`                           // 开票金额（分）, 订单实付金额减去已退款的金额
+	InvoiceState     int       `gorm:"column:invoice_state;default:1;NOT NULL"`                    // 1-已申请 2-已开票 3-已红冲 4-开票中
+	InvoiceNumber    string    `gorm:"column:invoice_number;NOT NULL"`                             // 开票平台返回的发票号码
+	PdfPath          string    `gorm:"column:pdf_path;NOT NULL"`                                   // 发票PDF文件
+	RedInvoiceNumber string    `gorm:"column:red_invoice_number;NOT NULL"`                         // 红字发票的号码
+	RedPdfPath       string    `gorm:"column:red_pdf_path;NOT NULL"`                               // 红字发票PDF文件
+	IssuedAt         time.Time `gorm:"column:issued_at;default:1970-01-01 00:00:00;NOT NULL"`      // 开票时间, 未开票时默认为1970-01-01
+	RedFlushedAt     time.Time `gorm:"column:red_flushed_at;default:1970-01-01 00:00:00;NOT NULL"` // 红冲时间, 未红冲时默认为1970-01-01
+	CreatedAt        time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"`       // 创建时间
+	UpdatedAt        time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"`       // 更新时间
+}
+
+func (OrderInvoice) TableName() string {
+	return "order_invoices"
+}
@@ -14,6 +14,8 @@ type OrderItem struct {
 	CommodityImg          string    `gorm:"column:commodity_img;NOT NULL"`                        // 下单时商品的主图(订单快照)
 	CommoditySellingPrice int       `gorm:"column:commodity_selling_price;default:0;NOT NULL"`    // 下单时商品的价格(订单快照)
 	CommodityNum          int       `gorm:"column:commodity_num;default:1;NOT NULL"`              // 数量(订单快照)
+	DiscountMoney         int       `gorm:"column:discount_money;default:0;NOT NULL"`             // 分摊到这个购物项的优惠金额(分)
+	PaidMoney             int       `gorm:"column:paid_money;default:0;NOT NULL"`                 // 这个购物项实付的金额(分), 商品总价减去分摊的优惠
 	CreatedAt             time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 创建时间
 	UpdatedAt             time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 更新时间
 }
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+)
+
+// OrderRefund 订单退款申请
+type OrderRefund struct {
+	ID            int64     `gorm:"column:id;primary_key;AUTO_INCREMENT"`                    // 退款申请ID
+	RefundNo      string    `gorm:"column:refund_no;NOT NULL;uniqueIndex:uk_refund_no"`      // 业务退款单号
+	OrderId       int64     `gorm:"column:order_id;NOT NULL"`                                // 订单ID
+	OrderNo       string    `gorm:"column:order_no;NOT NULL"`                                // 业务订单号
+	UserId        int64     `gorm:"column:user_id;NOT NULL"`                                 // 用户ID
+	PayType       int       `gorm:"column:pay_type;default:0;NOT NULL"`                      // 原订单的支付类型 1-微信支付 2-支付宝
+	RefundMoney   int       `gorm:"column:refund_money;default:0;NOT NULL"`                  // 退款金额（分）
+	IsFullRefund  bool      `gorm:"column:is_full_refund;default:0;NOT NULL"`                // 是否整单退款
+	Reason        string    `gorm:"column:reason;NOT NULL"`                                  // 用户填写的退款原因
+	AuditRemark   string    `gorm:"column:audit_remark;NOT NULL"`                            // 审核备注
+	RefundState   int       `gorm:"column:refund_state;default:0;NOT NULL"`                  // 0-待审核 1-退款中 2-退款成功 3-审核未通过 4-退款失败
+	RefundTransId string    `gorm:"column:refund_trans_id;NOT NULL"`                         // 支付平台的退款单号
+	RefundedAt    time.Time `gorm:"column:refunded_at;default:1970-01-01 00:00:00;NOT NULL"` // 退款成功时间, 未退款时默认为1970-01-01
+	CreatedAt     time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"`    // 创建时间
+	UpdatedAt     time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"`    // 更新时间
+}
+
+func (OrderRefund) TableName() string {
+	return "order_refunds"
+}
+
+// OrderRefundItem 退款申请中退的订单商品
+type OrderRefundItem struct {
+	ID           int64     `gorm:"column:id;primary_key;AUTO_INCREMENT"`                 // 主键id
+	RefundId     int64     `gorm:"column:refund_id;NOT NULL"`                            // 退款申请ID
+	OrderId      int64     `gorm:"column:order_id;NOT NULL"`                             // 订单ID
+	CommodityId  int64     `gorm:"column:commodity_id;NOT NULL"`                         // 商品ID
+	CommodityNum int       `gorm:"column:commodity_num;default:1;NOT NULL"`              // 退的商品数量
+	RefundMoney  int       `gorm:"column:refund_money;default:0;NOT NULL"`               // 这个商品的退款金额（分）
+	CreatedAt    time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 创建时间
+	UpdatedAt    time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 更新时间
+}
+
+func (OrderRefundItem) TableName() string {
+	return "order_refund_items"
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// OrderStatusLog 订单状态变更记录
+type OrderStatusLog struct {
+	ID         int64     `gorm:"column:id;primary_key;AUTO_INCREMENT"`                 // 主键ID
+	OrderId    int64     `gorm:"column:order_id;NOT NULL"`                             // 订单ID
+	OrderNo    string    `gorm:"column:order_no;NOT NULL"`                             // 业务订单号
+	Event      string    `gorm:"column:event;NOT NULL"`                                // 触发状态变更的事件
+	FromStatus int       `gorm:"column:from_status;default:0;NOT NULL"`                // 变更前的订单状态
+	ToStatus   int       `gorm:"column:to_status;default:0;NOT NULL"`                  // 变更后的订单状态
+	Actor      int       `gorm:"column:actor;default:0;NOT NULL"`                      // 触发变更的角色 1-用户 2-商家 3-系统 4-支付平台
+	ActorId    int64     `gorm:"column:actor_id;default:0;NOT NULL"`                   // 触发变更的用户ID等, 没有时为0
+	Remark     string    `gorm:"column:remark;NOT NULL"`                               // 备注
+	CreatedAt  time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"` // 创建时间
+}
+
+func (OrderStatusLog) TableName() string {
+	return "order_status_logs"
+}
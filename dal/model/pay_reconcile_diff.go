@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// PayReconcileDiff 支付对账发现的差异记录
+type PayReconcileDiff struct {
+	ID              int64     `gorm:"column:id;primary_key;AUTO_INCREMENT"`                          // 主键ID
+	BillDate        string    `gorm:"column:bill_date;NOT NULL"`                                     // 账单日期 YYYY-MM-DD
+	PayType         int       `gorm:"column:pay_type;default:0;NOT NULL"`                            // 支付类型 1-微信支付 2-支付宝
+	DiffType        int       `gorm:"column:diff_type;default:0;NOT NULL"`                           // 差异类型 1-本地缺失 2-平台缺失 3-金额不一致 4-交易ID不一致
+	OrderNo         string    `gorm:"column:order_no;NOT NULL"`                                      // 业务订单号
+	LocalTransId    string    `gorm:"column:local_trans_id;NOT NULL"`                                // 本地订单记录的支付平台交易ID
+	RemoteTransId   string    `gorm:"column:remote_trans_id;NOT NULL"`                               // 账单中的支付平台交易ID
+	LocalPayMoney   int       `gorm:"column:local_pay_money;default:0;NOT NULL"`                     // 本地订单的支付金额（分）
+	RemotePayMoney  int       `gorm:"column:remote_pay_money;default:0;NOT NULL"`                    // 账单中的支付金额（分）
+	RemoteTradeTime time.Time `gorm:"column:remote_trade_time;default:1970-01-01 00:00:00;NOT NULL"` // 账单中的交易时间
+	CreatedAt       time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"`          // 创建时间
+	UpdatedAt       time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"`          // 更新时间
+}
+
+func (PayReconcileDiff) TableName() string {
+	return "pay_reconcile_diffs"
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// StockReservation 订单预占的商品库存, 每个订单的每个商品一条记录
+// 拆单的订单按父订单预占库存; 秒杀订单的库存在创建秒杀活动时已经从可售库存里占用了, 预占时只记录预占记录
+type StockReservation struct {
+	ID           int64     `gorm:"column:id;primary_key;AUTO_INCREMENT"`                        // 主键ID
+	OrderId      int64     `gorm:"column:order_id;NOT NULL;uniqueIndex:uk_order_commodity"`     // 订单ID, 和商品ID一起建唯一索引
+	OrderNo      string    `gorm:"column:order_no;NOT NULL"`                                    // 业务订单号
+	CommodityId  int64     `gorm:"column:commodity_id;NOT NULL;uniqueIndex:uk_order_commodity"` // 商品ID
+	CommodityNum int       `gorm:"column:commodity_num;default:0;NOT NULL"`                     // 预占的库存数量
+	FlashSaleId  int64     `gorm:"column:flash_sale_id;default:0;NOT NULL"`                     // 秒杀订单从这个秒杀活动占用的库存里预占, 不是秒杀订单时为0
+	State        int       `gorm:"column:state;default:1;NOT NULL"`                             // 预占状态 1-已预占 2-已售出 3-已释放
+	CreatedAt    time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP;NOT NULL"`        // 创建时间
+	UpdatedAt    time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP;NOT NULL"`        // 更新时间
+}
+
+func (StockReservation) TableName() string {
+	return "stock_reservations"
+}
@@ -0,0 +1,51 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/logic/appservice"
+)
+
+// 秒杀相关的后台任务
+
+func init() {
+	register(&Job{Name: "FlashSalePreload", Interval: 10 * time.Second, Run: preloadFlashSales})
+	register(&Job{Name: "FlashSaleOrderCreate", Interval: time.Second, Run: createQueuedFlashSaleOrders})
+	register(&Job{Name: "FlashSaleOrderRequeue", Interval: 30 * time.Second, Run: requeueStuckFlashSaleOrders})
+	register(&Job{Name: "FlashSaleReconcile", Interval: 30 * time.Second, Run: reconcileFlashSales})
+}
+
+// preloadFlashSales 把即将开始的秒杀活动的信息和库存预热到 Redis
+func preloadFlashSales(ctx context.Context) error {
+	_, err := appservice.NewFlashSaleAppSvc(ctx).PreloadFlashSales()
+	return err
+}
+
+// createQueuedFlashSaleOrders 按排队顺序为秒杀下单队列中的请求创建订单
+func createQueuedFlashSaleOrders(ctx context.Context) error {
+	created, err := appservice.NewFlashSaleAppSvc(ctx).CreateQueuedFlashSaleOrders()
+	if created > 0 {
+		logger.New(ctx).Info("QueuedFlashSaleOrdersCreated", "created", created)
+	}
+	return err
+}
+
+// requeueStuckFlashSaleOrders 把处理中途服务崩溃等原因没有处理完的秒杀下单请求放回下单队列
+func requeueStuckFlashSaleOrders(ctx context.Context) error {
+	requeued, err := appservice.NewFlashSaleAppSvc(ctx).RequeueStuckFlashSaleOrders()
+	if requeued > 0 {
+		logger.New(ctx).Warn("StuckFlashSaleOrdersRequeued", "requeued", requeued)
+	}
+	return err
+}
+
+// reconcileFlashSales 把取消和关闭的秒杀订单抢到的库存还给活动, 并把 Redis 中的抢购数量同步回 MySQL
+func reconcileFlashSales(ctx context.Context) error {
+	reconciled, err := appservice.NewFlashSaleAppSvc(ctx).ReconcileFlashSales()
+	if reconciled > 0 {
+		logger.New(ctx).Info("FlashSalesReconciled", "reconciled", reconciled)
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+)
+
+// Job 后台定时任务
+type Job struct {
+	Name     string                          // 任务名, 也用作任务执行锁的键名
+	Interval time.Duration                   // 执行间隔
+	Run      func(ctx context.Context) error // 任务每次执行的逻辑
+}
+
+// jobs 服务启动时要运行的后台任务
+var jobs []*Job
+
+// register 注册后台任务, 各个任务在自己文件的 init 中注册
+func register(job *Job) {
+	jobs = append(jobs, job)
+}
+
+// Start 启动所有后台任务, ctx 被取消后任务停止
+func Start(ctx context.Context) {
+	for _, job := range jobs {
+		go job.loop(ctx)
+	}
+}
+
+func (job *Job) loop(ctx context.Context) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			job.runOnce(ctx)
+		}
+	}
+}
+
+// runOnce 执行一次任务
+// 服务部署多个实例时, 通过 Redis 锁保证每个周期只有一个实例执行任务
+func (job *Job) runOnce(ctx context.Context) {
+	// 每次执行生成新的追踪ID, 方便在日志中查看同一次执行的记录
+	traceId := util.GenerateSpanID("127.0.0.1")
+	ctx = context.WithValue(ctx, "traceid", traceId)
+	ctx = context.WithValue(ctx, "spanid", traceId)
+	log := logger.New(ctx)
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("JobPanic", "job", job.Name, "err", err)
+		}
+	}()
+
+	// 锁的有效期比执行间隔稍短, 保证下个周期能重新获取到锁
+	lockTTL := job.Interval * 4 / 5
+	locked, err := cache.LockJob(ctx, job.Name, traceId, lockTTL)
+	if err != nil {
+		log.Error("JobLockError", "job", job.Name, "err", err)
+		return
+	}
+	if !locked { // 其他实例正在执行这个周期的任务
+		return
+	}
+	// 执行时间超过锁的有效期时, 锁过期后其他实例会同时执行任务, 执行期间定期给锁续期
+	stopRenew := make(chan struct{})
+	defer close(stopRenew)
+	go job.renewLock(ctx, traceId, lockTTL, stopRenew)
+	start := time.Now()
+	if err = job.Run(ctx); err != nil {
+		log.Error("JobRunError", "job", job.Name, "err", err)
+		return
+	}
+	log.Info("JobRunFinished", "job", job.Name, "dur/ms", time.Since(start).Milliseconds())
+}
+
+// renewLock 任务执行期间每隔锁有效期的三分之一给执行锁续期一次, stop 被关闭后停止续期
+// 执行结束后不释放锁, 锁在有效期到了后自动失效
+func (job *Job) renewLock(ctx context.Context, owner string, ttl time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := cache.RenewJobLock(ctx, job.Name, owner, ttl)
+			if err != nil {
+				logger.New(ctx).Error("JobLockRenewError", "job", job.Name, "err", err)
+				continue
+			}
+			if !renewed {
+				logger.New(ctx).Warn("JobLockLost", "job", job.Name)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/logic/appservice"
+)
+
+// 订单相关的后台任务
+
+func init() {
+	register(&Job{Name: "OrderPayReconcile", Interval: time.Minute, Run: reconcileOrderPay})
+	register(&Job{Name: "OrderRefundReconcile", Interval: 10 * time.Minute, Run: reconcileOrderRefund})
+	register(&Job{Name: "OrderPayTimeoutClose", Interval: 10 * time.Second, Run: closeExpiredUnpaidOrders})
+	register(&Job{Name: "OrderPayTimeoutSweep", Interval: 5 * time.Minute, Run: sweepExpiredUnpaidOrders})
+	register(&Job{Name: "WxPayDailyReconcile", Interval: 10 * time.Minute, Run: reconcileDailyWxTradeBill})
+	register(&Job{Name: "OrderAutoConfirmReceipt", Interval: 10 * time.Minute, Run: autoConfirmDeliveredOrders})
+	register(&Job{Name: "OrderAutoComplete", Interval: 10 * time.Minute, Run: completeConfirmedOrders})
+}
+
+// reconcileOrderPay 对发起支付超过5分钟仍未收到支付结果的订单, 主动查询支付结果
+func reconcileOrderPay(ctx context.Context) error {
+	reconciled, err := appservice.NewOrderAppSvc(ctx).ReconcileStuckOrderPays(5 * time.Minute)
+	logger.New(ctx).Info("OrderPayReconciled", "reconciled", reconciled)
+	return err
+}
+
+// reconcileOrderRefund 对审核通过超过10分钟仍未拿到退款结果的退款申请, 主动查询退款结果
+func reconcileOrderRefund(ctx context.Context) error {
+	reconciled, err := appservice.NewOrderAppSvc(ctx).ReconcileStuckOrderRefunds(10 * time.Minute)
+	if reconciled > 0 {
+		logger.New(ctx).Info("OrderRefundReconciled", "reconciled", reconciled)
+	}
+	return err
+}
+
+// closeExpiredUnpaidOrders 关闭超过支付截止时间仍未支付的订单
+func closeExpiredUnpaidOrders(ctx context.Context) error {
+	closed, err := appservice.NewOrderAppSvc(ctx).CloseExpiredUnpaidOrders()
+	if closed > 0 {
+		logger.New(ctx).Info("ExpiredUnpaidOrdersClosed", "closed", closed)
+	}
+	return err
+}
+
+// sweepExpiredUnpaidOrders 兜底关闭支付截止时间过去5分钟仍未关闭的订单, 比如从支付超时队列里丢失的订单
+func sweepExpiredUnpaidOrders(ctx context.Context) error {
+	closed, err := appservice.NewOrderAppSvc(ctx).SweepExpiredUnpaidOrders(5 * time.Minute)
+	if closed > 0 {
+		logger.New(ctx).Info("ExpiredUnpaidOrdersSwept", "closed", closed)
+	}
+	return err
+}
+
+// reconcileDailyWxTradeBill 每天用微信支付的交易账单核对前一天的订单支付, 当天完成后不再重复执行
+func reconcileDailyWxTradeBill(ctx context.Context) error {
+	done, err := appservice.NewOrderAppSvc(ctx).ReconcileDailyWxTradeBill()
+	if done {
+		logger.New(ctx).Info("WxPayDailyReconciled")
+	}
+	return err
+}
+
+// autoConfirmDeliveredOrders 订单送达后超过配置的天数用户仍未确认收货时, 自动确认收货
+func autoConfirmDeliveredOrders(ctx context.Context) error {
+	confirmed, err := appservice.NewOrderAppSvc(ctx).AutoConfirmDeliveredOrders()
+	if confirmed > 0 {
+		logger.New(ctx).Info("DeliveredOrdersAutoConfirmed", "confirmed", confirmed)
+	}
+	return err
+}
+
+// completeConfirmedOrders 确认收货后过了评价期的订单自动完成
+func completeConfirmedOrders(ctx context.Context) error {
+	completed, err := appservice.NewOrderAppSvc(ctx).CompleteConfirmedOrders()
+	if completed > 0 {
+		logger.New(ctx).Info("ConfirmedOrdersCompleted", "completed", completed)
+	}
+	return err
+}
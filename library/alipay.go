@@ -0,0 +1,345 @@
+package library
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/common/util/httptool"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+)
+
+// 对接支付宝开放平台的Lib
+// 支付宝文档: https://opendocs.alipay.com/open/common/105901
+
+type AliPayLib struct {
+	ctx       context.Context
+	payConfig AliPayConfig
+}
+
+type AliPayConfig struct {
+	AppId           string
+	KeyProvider     KeyProvider // 应用私钥的提供者, 按 AppId 提供私钥
+	AliPayPublicKey []byte      // 支付宝公钥, 用于验证支付宝的应答和通知
+	GatewayUrl      string
+	NotifyUrl       string
+	ReturnUrl       string
+}
+
+func NewAliPayLib(ctx context.Context, config AliPayConfig) *AliPayLib {
+	return &AliPayLib{
+		ctx:       ctx,
+		payConfig: config,
+	}
+}
+
+// 支付宝接口名称
+const (
+	aliPayMethodPagePay    = "alipay.trade.page.pay"
+	aliPayMethodWapPay     = "alipay.trade.wap.pay"
+	aliPayMethodAppPay     = "alipay.trade.app.pay"
+	aliPayMethodTradeQuery = "alipay.trade.query"
+	aliPayMethodTradeClose = "alipay.trade.close"
+)
+
+// 支付宝的交易状态
+const (
+	AliTradeStatusWaitBuyerPay = "WAIT_BUYER_PAY" // 交易创建, 等待买家付款
+	AliTradeStatusClosed       = "TRADE_CLOSED"   // 未付款交易超时关闭, 或支付完成后全额退款
+	AliTradeStatusSuccess      = "TRADE_SUCCESS"  // 交易支付成功
+	AliTradeStatusFinished     = "TRADE_FINISHED" // 交易结束, 不可退款
+)
+
+const aliPaySuccessCode = "10000"
+
+// AliPaySubCodeTradeNotExist 交易不存在, 用户没有扫码或者登录付款时支付宝还没有创建交易
+const AliPaySubCodeTradeNotExist = "ACQ.TRADE_NOT_EXIST"
+
+// AliPayInvokeInfo 前端调起支付宝支付的参数信息
+// 网页和手机网站支付跳转到 PayUrl, APP支付把 OrderString 交给支付宝SDK
+type AliPayInvokeInfo struct {
+	PayUrl      string `json:"pay_url,omitempty"`
+	OrderString string `json:"order_string,omitempty"`
+}
+
+type aliPayBizContent struct {
+	OutTradeNo  string `json:"out_trade_no"`
+	TotalAmount string `json:"total_amount"` // 订单总金额, 单位为元, 精确到小数点后两位
+	Subject     string `json:"subject"`
+	ProductCode string `json:"product_code"`
+	QuitUrl     string `json:"quit_url,omitempty"`    // 手机网站支付用户付款中途退出返回商户网站的地址
+	TimeExpire  string `json:"time_expire,omitempty"` // 绝对超时时间, 格式为 yyyy-MM-dd HH:mm:ss, 超时后支付宝关闭交易
+}
+
+// AliTradeQueryResult 支付宝交易查询接口的应答
+type AliTradeQueryResult struct {
+	Code           string `json:"code"`
+	Msg            string `json:"msg"`
+	SubCode        string `json:"sub_code"`
+	SubMsg         string `json:"sub_msg"`
+	TradeNo        string `json:"trade_no"`
+	OutTradeNo     string `json:"out_trade_no"`
+	TradeStatus    string `json:"trade_status"`
+	TotalAmount    string `json:"total_amount"`
+	BuyerPayAmount string `json:"buyer_pay_amount"`
+	SendPayDate    string `json:"send_pay_date"`
+}
+
+// AliTradeCloseResult 支付宝交易关闭接口的应答
+type AliTradeCloseResult struct {
+	Code       string `json:"code"`
+	Msg        string `json:"msg"`
+	SubCode    string `json:"sub_code"`
+	SubMsg     string `json:"sub_msg"`
+	TradeNo    string `json:"trade_no"`
+	OutTradeNo string `json:"out_trade_no"`
+}
+
+// AliPayNotifyData 支付宝异步通知中业务需要的参数
+// 支付宝文档: https://opendocs.alipay.com/open/270/105902
+type AliPayNotifyData struct {
+	AppId       string
+	TradeNo     string
+	OutTradeNo  string
+	TradeStatus string
+	TotalAmount string
+	GmtPayment  string
+}
+
+// CreatePagePay 电脑网站支付, 返回跳转到支付宝收银台的地址
+func (apl *AliPayLib) CreatePagePay(order *do.Order) (*AliPayInvokeInfo, error) {
+	bizContent := apl.newBizContent(order, "FAST_INSTANT_TRADE_PAY")
+	payParams, err := apl.genRequestParams(aliPayMethodPagePay, bizContent)
+	if err != nil {
+		return nil, errcode.Wrap("AliPayLibCreatePagePayError", err)
+	}
+	return &AliPayInvokeInfo{PayUrl: apl.payConfig.GatewayUrl + "?" + payParams.Encode()}, nil
+}
+
+// CreateWapPay 手机网站支付, 返回跳转到支付宝收银台的地址
+func (apl *AliPayLib) CreateWapPay(order *do.Order) (*AliPayInvokeInfo, error) {
+	bizContent := apl.newBizContent(order, "QUICK_WAP_WAY")
+	bizContent.QuitUrl = apl.payConfig.ReturnUrl
+	payParams, err := apl.genRequestParams(aliPayMethodWapPay, bizContent)
+	if err != nil {
+		return nil, errcode.Wrap("AliPayLibCreateWapPayError", err)
+	}
+	return &AliPayInvokeInfo{PayUrl: apl.payConfig.GatewayUrl + "?" + payParams.Encode()}, nil
+}
+
+// CreateAppPay APP支付, 返回给支付宝SDK使用的签名后的订单信息
+func (apl *AliPayLib) CreateAppPay(order *do.Order) (*AliPayInvokeInfo, error) {
+	bizContent := apl.newBizContent(order, "QUICK_MSECURITY_PAY")
+	payParams, err := apl.genRequestParams(aliPayMethodAppPay, bizContent)
+	if err != nil {
+		return nil, errcode.Wrap("AliPayLibCreateAppPayError", err)
+	}
+	return &AliPayInvokeInfo{OrderString: payParams.Encode()}, nil
+}
+
+// QueryTrade 用业务订单号查询支付宝交易
+// 支付宝文档: https://opendocs.alipay.com/open/bff76748_alipay.trade.query
+func (apl *AliPayLib) QueryTrade(outTradeNo string) (*AliTradeQueryResult, error) {
+	bizContent := map[string]string{"out_trade_no": outTradeNo}
+	responseContent, err := apl.callApi(aliPayMethodTradeQuery, bizContent)
+	if err != nil {
+		return nil, errcode.Wrap("AliPayLibQueryTradeError", err)
+	}
+	queryResult := new(AliTradeQueryResult)
+	if err = json.Unmarshal(responseContent, queryResult); err != nil {
+		return nil, errcode.Wrap("AliPayLibQueryTradeError", err)
+	}
+	if queryResult.Code != aliPaySuccessCode {
+		return queryResult, errcode.Wrap("AliPayLibQueryTradeError",
+			fmt.Errorf("code: %s, sub_code: %s, sub_msg: %s", queryResult.Code, queryResult.SubCode, queryResult.SubMsg))
+	}
+
+	return queryResult, nil
+}
+
+// CloseTrade 关闭等待买家付款的交易, 关闭后用户不能再用这笔交易付款
+// 用户还没有扫码或者登录付款时支付宝那边没有交易, 不需要关闭, 按关闭成功处理
+func (apl *AliPayLib) CloseTrade(outTradeNo string) error {
+	bizContent := map[string]string{"out_trade_no": outTradeNo}
+	responseContent, err := apl.callApi(aliPayMethodTradeClose, bizContent)
+	if err != nil {
+		return errcode.Wrap("AliPayLibCloseTradeError", err)
+	}
+	closeResult := new(AliTradeCloseResult)
+	if err = json.Unmarshal(responseContent, closeResult); err != nil {
+		return errcode.Wrap("AliPayLibCloseTradeError", err)
+	}
+	if closeResult.Code != aliPaySuccessCode && closeResult.SubCode != AliPaySubCodeTradeNotExist {
+		return errcode.Wrap("AliPayLibCloseTradeError",
+			fmt.Errorf("code: %s, sub_code: %s, sub_msg: %s", closeResult.Code, closeResult.SubCode, closeResult.SubMsg))
+	}
+
+	return nil
+}
+
+// VerifyNotify 验证支付宝异步通知的签名, 验证通过后返回通知中业务需要的参数
+// 支付宝文档: https://opendocs.alipay.com/common/02mse7
+// @param form 通知请求的表单参数
+func (apl *AliPayLib) VerifyNotify(form url.Values) (notifyData *AliPayNotifyData, err error) {
+	sign := form.Get("sign")
+	if sign == "" {
+		return nil, errcode.Wrap("AliPayLibVerifyNotifyError", errors.New("notify sign is empty"))
+	}
+	// 异步通知验签时 sign 和 sign_type 都不参与签名
+	signContent := aliPaySignContent(form, "sign", "sign_type")
+	if err = apl.verifySign(signContent, sign); err != nil {
+		return nil, errcode.Wrap("AliPayLibVerifyNotifyError", err)
+	}
+	if form.Get("app_id") != apl.payConfig.AppId {
+		return nil, errcode.Wrap("AliPayLibVerifyNotifyError", errors.New("notify app_id mismatch"))
+	}
+	notifyData = &AliPayNotifyData{
+		AppId:       form.Get("app_id"),
+		TradeNo:     form.Get("trade_no"),
+		OutTradeNo:  form.Get("out_trade_no"),
+		TradeStatus: form.Get("trade_status"),
+		TotalAmount: form.Get("total_amount"),
+		GmtPayment:  form.Get("gmt_payment"),
+	}
+	return notifyData, nil
+}
+
+func (apl *AliPayLib) newBizContent(order *do.Order, productCode string) *aliPayBizContent {
+	bizContent := &aliPayBizContent{
+		OutTradeNo:  order.OrderNo,
+		TotalAmount: util.FenToYuan(order.PayMoney),
+		Subject:     fmt.Sprintf("GOMALL 商场购买%s 等商品", order.Items[0].CommodityName),
+		ProductCode: productCode,
+	}
+	if !order.PayDeadline.IsZero() {
+		// 交易和订单同时到期, 订单超时关闭后用户不能再付款
+		bizContent.TimeExpire = order.PayDeadline.Format(enum.TimeFormatHyphenedYMDHIS)
+	}
+	return bizContent
+}
+
+// genRequestParams 生成调用支付宝接口的公共请求参数并签名
+func (apl *AliPayLib) genRequestParams(method string, bizContent interface{}) (url.Values, error) {
+	bizContentBytes, err := json.Marshal(bizContent)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	params.Set("app_id", apl.payConfig.AppId)
+	params.Set("method", method)
+	params.Set("format", "JSON")
+	params.Set("charset", "utf-8")
+	params.Set("sign_type", "RSA2")
+	params.Set("timestamp", time.Now().Format(enum.TimeFormatHyphenedYMDHIS))
+	params.Set("version", "1.0")
+	params.Set("biz_content", string(bizContentBytes))
+	if apl.payConfig.NotifyUrl != "" {
+		params.Set("notify_url", apl.payConfig.NotifyUrl)
+	}
+	if apl.payConfig.ReturnUrl != "" && method != aliPayMethodAppPay {
+		params.Set("return_url", apl.payConfig.ReturnUrl)
+	}
+	sign, err := apl.sign(aliPaySignContent(params, "sign"))
+	if err != nil {
+		return nil, err
+	}
+	params.Set("sign", sign)
+
+	return params, nil
+}
+
+// sign 使用应用私钥生成 RSA2(SHA256WithRSA) 签名
+// 支付宝文档: https://opendocs.alipay.com/common/02kf5q
+func (apl *AliPayLib) sign(signContent string) (string, error) {
+	if apl.payConfig.KeyProvider == nil {
+		return "", errors.New("没有配置应用私钥的提供者")
+	}
+	privateKey, err := apl.payConfig.KeyProvider.PrivateKey(apl.payConfig.AppId)
+	if err != nil {
+		return "", err
+	}
+	signBytes, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, util.SHA256HashBytes(signContent))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signBytes), nil
+}
+
+// verifySign 使用支付宝公钥验证 RSA2 签名
+func (apl *AliPayLib) verifySign(signContent, sign string) error {
+	signBytes, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return err
+	}
+	return util.RsaVerifyPKCS1v15(util.SHA256HashBytes(signContent), signBytes, apl.payConfig.AliPayPublicKey, crypto.SHA256)
+}
+
+// callApi 调用支付宝的接口, 返回验签后的应答业务内容
+func (apl *AliPayLib) callApi(method string, bizContent interface{}) ([]byte, error) {
+	reqParams, err := apl.genRequestParams(method, bizContent)
+	if err != nil {
+		return nil, err
+	}
+	_, replyBody, err := httptool.Post(apl.ctx, apl.payConfig.GatewayUrl, []byte(reqParams.Encode()),
+		httptool.WithHeaders(map[string]string{
+			"Content-Type": "application/x-www-form-urlencoded;charset=utf-8",
+		}))
+	if err != nil {
+		return nil, err
+	}
+	return apl.verifyResponse(replyBody, method)
+}
+
+// verifyResponse 验证支付宝接口的同步应答, 返回应答中接口对应的业务内容
+// 应答格式为 {"alipay_trade_query_response": {...}, "sign": "..."}, 签名内容是业务内容部分的原始JSON串
+func (apl *AliPayLib) verifyResponse(replyBody []byte, method string) ([]byte, error) {
+	reply := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(replyBody, &reply); err != nil {
+		return nil, err
+	}
+	responseKey := strings.ReplaceAll(method, ".", "_") + "_response"
+	content, ok := reply[responseKey]
+	if !ok {
+		return nil, errors.New("alipay response content not found")
+	}
+	var sign string
+	if err := json.Unmarshal(reply["sign"], &sign); err != nil || sign == "" {
+		// 接口调用出错时(比如应用配置错误)支付宝不返回签名
+		return nil, fmt.Errorf("alipay response without sign: %s", string(content))
+	}
+	if err := apl.verifySign(string(content), sign); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// aliPaySignContent 生成待签名的字符串
+// 参数按参数名ASCII码升序排列, 空值和 excludeKeys 中的参数不参与签名, 用 & 连接成 key=value 的形式
+func aliPaySignContent(params url.Values, excludeKeys ...string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if params.Get(key) == "" || lo.Contains(excludeKeys, key) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+params.Get(key))
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,135 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Snowflake 风格的ID生成器
+// ID 是一个 63 位的正整数: 41位毫秒时间戳 + 10位 worker id + 12位序列号,
+// 同一个 worker id 在同一毫秒内最多生成 4096 个ID, 不同的服务实例使用不同的 worker id, 生成的ID不会重复,
+// 并且随时间递增, 按ID排序就是按生成时间排序。
+
+const (
+	idWorkerIdBits = 10
+	idSequenceBits = 12
+
+	// IdMaxWorkerId worker id 的最大值, worker id 的取值范围是 [0, IdMaxWorkerId]
+	IdMaxWorkerId    = -1 ^ (-1 << idWorkerIdBits)
+	idMaxSequence    = -1 ^ (-1 << idSequenceBits)
+	idWorkerIdShift  = idSequenceBits
+	idTimestampShift = idSequenceBits + idWorkerIdBits
+)
+
+// idEpoch ID时间戳的起始时间, 41位毫秒时间戳从这个时间开始可以用大约69年
+var idEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// idMaxClockBackward 允许的最大时钟回拨, 回拨不超过这个时长时等待时钟追上, 超过时报错
+const idMaxClockBackward = 10 * time.Millisecond
+
+// idNoLength 业务单号中数字部分的长度, 8位日期 + 19位补零的ID
+const idNoLength = 8 + 19
+
+var ErrIdClockBackward = errors.New("系统时钟回拨, 暂时无法生成ID")
+
+// IdGenerator Snowflake ID 生成器, 可以在多个 goroutine 中并发使用
+type IdGenerator struct {
+	mu            sync.Mutex
+	workerId      int64
+	lastTimestamp int64 // 上次生成ID时的毫秒时间戳(相对 idEpoch)
+	sequence      int64
+}
+
+// NewIdGenerator 创建 worker id 为 workerId 的ID生成器
+// 同一时间, 每个 worker id 只能被一个服务实例使用
+func NewIdGenerator(workerId int64) (*IdGenerator, error) {
+	if workerId < 0 || workerId > IdMaxWorkerId {
+		return nil, fmt.Errorf("worker id 必须在 0 到 %d 之间", IdMaxWorkerId)
+	}
+	return &IdGenerator{workerId: workerId}, nil
+}
+
+// WorkerId 生成器使用的 worker id
+func (g *IdGenerator) WorkerId() int64 {
+	return g.workerId
+}
+
+// NextId 生成下一个ID
+func (g *IdGenerator) NextId() (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	timestamp := g.timestamp()
+	if timestamp < g.lastTimestamp {
+		backward := time.Duration(g.lastTimestamp-timestamp) * time.Millisecond
+		if backward > idMaxClockBackward {
+			return 0, ErrIdClockBackward
+		}
+		time.Sleep(backward)
+		timestamp = g.waitNextMillis(g.lastTimestamp - 1)
+	}
+	if timestamp == g.lastTimestamp {
+		g.sequence = (g.sequence + 1) & idMaxSequence
+		if g.sequence == 0 { // 这一毫秒的序列号用完了, 等到下一毫秒
+			timestamp = g.waitNextMillis(g.lastTimestamp)
+		}
+	} else {
+		g.sequence = 0
+	}
+	g.lastTimestamp = timestamp
+
+	return timestamp<<idTimestampShift | g.workerId<<idWorkerIdShift | g.sequence, nil
+}
+
+// NextNo 生成业务单号, 格式为: 前缀 + 8位日期 + 19位补零的ID
+// 同一个前缀的单号长度固定, 按字符串排序就是按生成时间排序
+func (g *IdGenerator) NextNo(prefix string) (string, error) {
+	id, err := g.NextId()
+	if err != nil {
+		return "", err
+	}
+	return FormatIdNo(prefix, id), nil
+}
+
+// FormatIdNo 把ID格式化成业务单号, 日期取自ID中的时间戳, 保证日期和ID一致
+func FormatIdNo(prefix string, id int64) string {
+	createdAt, _, _ := ParseId(id)
+	return fmt.Sprintf("%s%s%019d", prefix, createdAt.Local().Format("20060102"), id)
+}
+
+// ParseIdNo 从业务单号中解析出ID
+func ParseIdNo(prefix, no string) (int64, error) {
+	if len(no) != len(prefix)+idNoLength || no[:len(prefix)] != prefix {
+		return 0, fmt.Errorf("单号格式错误: %s", no)
+	}
+	id, err := strconv.ParseInt(no[len(prefix)+8:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("单号格式错误: %s", no)
+	}
+	return id, nil
+}
+
+// ParseId 解析出ID的生成时间、worker id 和序列号
+func ParseId(id int64) (createdAt time.Time, workerId, sequence int64) {
+	createdAt = idEpoch.Add(time.Duration(id>>idTimestampShift) * time.Millisecond)
+	workerId = id >> idWorkerIdShift & IdMaxWorkerId
+	sequence = id & idMaxSequence
+	return
+}
+
+func (g *IdGenerator) timestamp() int64 {
+	return time.Since(idEpoch).Milliseconds()
+}
+
+// waitNextMillis 等待到时间戳大于 lastTimestamp
+func (g *IdGenerator) waitNextMillis(lastTimestamp int64) int64 {
+	timestamp := g.timestamp()
+	for timestamp <= lastTimestamp {
+		time.Sleep(100 * time.Microsecond)
+		timestamp = g.timestamp()
+	}
+	return timestamp
+}
@@ -0,0 +1,124 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// 开票服务
+// 电子发票由开票平台(税务数字账户或者第三方开票服务商)开具, 不同平台的接口不同, 项目里只依赖 InvoiceIssuer 接口,
+// 对接新的开票平台时实现这个接口并在 NewInvoiceIssuer 里按配置创建即可。
+
+// InvoiceIssuer 开具发票和红字发票
+type InvoiceIssuer interface {
+	// Issue 按开票记录开具发票
+	Issue(invoice *do.OrderInvoice) (*do.InvoiceIssueResult, error)
+	// RedFlush 为已经开具的发票开具对应的红字发票, 冲销原发票
+	RedFlush(invoice *do.OrderInvoice) (*do.InvoiceIssueResult, error)
+}
+
+type InvoiceIssuerConfig struct {
+	PdfDir      string // 发票PDF文件的存放目录
+	SellerName  string // 销售方名称
+	SellerTaxNo string // 销售方纳税人识别号
+}
+
+// NewInvoiceIssuer 按开票服务的类型创建开票服务
+// @param issuerType 开票服务的类型, 不配置时使用本地开票
+func NewInvoiceIssuer(issuerType string, issuerConfig InvoiceIssuerConfig) InvoiceIssuer {
+	switch issuerType {
+	// 对接开票平台后在这里按类型创建对应的开票服务
+	default:
+		return NewLocalInvoiceIssuer(issuerConfig)
+	}
+}
+
+// LocalInvoiceIssuer 本地开票
+// 不对接开票平台, 在本地生成发票号码和发票PDF, 开出的发票没有税务效力, 用于开发测试
+type LocalInvoiceIssuer struct {
+	issuerConfig InvoiceIssuerConfig
+}
+
+func NewLocalInvoiceIssuer(issuerConfig InvoiceIssuerConfig) *LocalInvoiceIssuer {
+	return &LocalInvoiceIssuer{issuerConfig: issuerConfig}
+}
+
+func (issuer *LocalInvoiceIssuer) Issue(invoice *do.OrderInvoice) (*do.InvoiceIssueResult, error) {
+	if invoice.InvoiceState != enum.InvoiceStateRequested {
+		return nil, errors.New("只有已申请的发票可以开票")
+	}
+	return issuer.issue(invoice, false)
+}
+
+func (issuer *LocalInvoiceIssuer) RedFlush(invoice *do.OrderInvoice) (*do.InvoiceIssueResult, error) {
+	if invoice.InvoiceState != enum.InvoiceStateIssued || invoice.InvoiceNumber == "" {
+		return nil, errors.New("只有已开票的发票可以红冲")
+	}
+	return issuer.issue(invoice, true)
+}
+
+func (issuer *LocalInvoiceIssuer) issue(invoice *do.OrderInvoice, red bool) (*do.InvoiceIssueResult, error) {
+	issuedAt := time.Now()
+	// 全电发票的号码是20位数字, 本地开票用开票时间加随机数生成
+	invoiceNumber := issuedAt.Format("060102150405") + util.RandNumStr(8)
+	if err := os.MkdirAll(issuer.issuerConfig.PdfDir, 0o755); err != nil {
+		return nil, err
+	}
+	pdfPath := filepath.Join(issuer.issuerConfig.PdfDir, invoiceNumber+".pdf")
+	pdfFile, err := os.Create(pdfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer pdfFile.Close()
+	lines := issuer.invoiceLines(invoice, invoiceNumber, issuedAt, red)
+	if _, err = pdfFile.Write(RenderTextPdf(lines)); err != nil {
+		return nil, err
+	}
+
+	return &do.InvoiceIssueResult{InvoiceNumber: invoiceNumber, PdfPath: pdfPath, IssuedAt: issuedAt}, nil
+}
+
+// invoiceLines 发票PDF上每一行的内容, 红字发票的金额为负数
+func (issuer *LocalInvoiceIssuer) invoiceLines(invoice *do.OrderInvoice, invoiceNumber string, issuedAt time.Time, red bool) []string {
+	sign := 1
+	heading := "电子发票（普通发票）"
+	if red {
+		sign = -1
+		heading = "电子发票（红字发票）"
+	}
+	lines := []string{
+		heading,
+		"",
+		"发票号码: " + invoiceNumber,
+		"开票日期: " + issuedAt.Format("2006-01-02"),
+	}
+	if red {
+		lines = append(lines, "对应蓝字发票号码: "+invoice.InvoiceNumber)
+	}
+	lines = append(lines, "", "购买方名称: "+invoice.Title)
+	if invoice.TitleType == enum.InvoiceTitleTypeCompany {
+		lines = append(lines, "购买方纳税人识别号: "+invoice.TaxNo)
+	}
+	lines = append(lines,
+		"销售方名称: "+issuer.issuerConfig.SellerName,
+		"销售方纳税人识别号: "+issuer.issuerConfig.SellerTaxNo,
+		"",
+		"订单号: "+invoice.OrderNo,
+		"项目名称 / 数量 / 金额(元)",
+	)
+	for _, item := range invoice.Items {
+		lines = append(lines, fmt.Sprintf("%s / %d / %s", item.CommodityName, item.CommodityNum, util.FenToYuan(sign*item.Amount)))
+	}
+	lines = append(lines, "", "价税合计(元): "+util.FenToYuan(sign*invoice.Amount))
+	if red {
+		lines = append(lines, "备注: 本红字发票冲销发票号码为 "+invoice.InvoiceNumber+" 的发票")
+	}
+	return lines
+}
@@ -0,0 +1,153 @@
+package library
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/WoWBytePaladin/go-mall/common/util"
+)
+
+// 商户私钥的提供者
+// 商户API私钥不打包进程序, 由部署环境通过文件、环境变量或者挂载的密钥目录提供, 按商户号区分不同商户的私钥。
+// 解析后的私钥会缓存起来, 私钥来源的内容变化时(比如替换了密钥文件)重新解析, 轮换私钥不需要重新构建和发布程序。
+
+// 私钥提供者的类型
+const (
+	KeyProviderFile = "file" // 密钥文件
+	KeyProviderEnv  = "env"  // 环境变量
+	KeyProviderDir  = "dir"  // 挂载的密钥目录, 比如 Kubernetes 的 Secret 卷
+)
+
+// KeyProvider 按商户号提供商户API私钥
+type KeyProvider interface {
+	PrivateKey(mchId string) (*rsa.PrivateKey, error)
+}
+
+// NewKeyProvider 按提供者类型创建私钥提供者
+// @param providerType 提供者类型 file | env | dir, 不配置时使用 file
+// @param source 私钥的来源, file: 密钥文件路径; env: 环境变量名前缀; dir: 密钥目录
+func NewKeyProvider(providerType, source string) KeyProvider {
+	switch providerType {
+	case KeyProviderEnv:
+		return NewEnvKeyProvider(source)
+	case KeyProviderDir:
+		return NewDirKeyProvider(source)
+	default:
+		return NewFileKeyProvider(source)
+	}
+}
+
+// FileKeyProvider 从密钥文件读取私钥
+// 路径中的 {mchid} 会被替换成商户号, 不包含 {mchid} 时所有商户共用一个密钥文件
+type FileKeyProvider struct {
+	path  string
+	cache *privateKeyCache
+}
+
+func NewFileKeyProvider(path string) *FileKeyProvider {
+	return &FileKeyProvider{path: path, cache: newPrivateKeyCache()}
+}
+
+func (provider *FileKeyProvider) PrivateKey(mchId string) (*rsa.PrivateKey, error) {
+	return provider.cache.loadFile(mchId, strings.ReplaceAll(provider.path, "{mchid}", mchId))
+}
+
+// EnvKeyProvider 从环境变量读取私钥
+// 环境变量名为 前缀_商户号, 值为 PEM 格式的私钥, 也可以是 base64 编码后的 PEM, 方便在不支持多行值的地方配置
+type EnvKeyProvider struct {
+	prefix string
+	cache  *privateKeyCache
+}
+
+func NewEnvKeyProvider(prefix string) *EnvKeyProvider {
+	return &EnvKeyProvider{prefix: prefix, cache: newPrivateKeyCache()}
+}
+
+func (provider *EnvKeyProvider) PrivateKey(mchId string) (*rsa.PrivateKey, error) {
+	envName := provider.prefix + "_" + mchId
+	keyValue := os.Getenv(envName)
+	if keyValue == "" {
+		return nil, fmt.Errorf("环境变量 %s 中没有商户私钥", envName)
+	}
+	// 环境变量的值就是私钥内容, 用它作为版本, 值变化时重新解析
+	return provider.cache.load(mchId, keyValue, func() ([]byte, error) {
+		if strings.HasPrefix(strings.TrimSpace(keyValue), "-----BEGIN") {
+			return []byte(keyValue), nil
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(keyValue))
+	})
+}
+
+// DirKeyProvider 从挂载的密钥目录读取私钥, 每个商户的私钥文件为 目录/商户号.pem
+// 密钥卷更新后文件内容随之变化, 不需要重启服务
+type DirKeyProvider struct {
+	dir   string
+	cache *privateKeyCache
+}
+
+func NewDirKeyProvider(dir string) *DirKeyProvider {
+	return &DirKeyProvider{dir: dir, cache: newPrivateKeyCache()}
+}
+
+func (provider *DirKeyProvider) PrivateKey(mchId string) (*rsa.PrivateKey, error) {
+	// 防止拼出目录外的路径
+	if mchId == "" || strings.ContainsAny(mchId, `/\.`) {
+		return nil, fmt.Errorf("商户号不合法: %q", mchId)
+	}
+	return provider.cache.loadFile(mchId, filepath.Join(provider.dir, mchId+".pem"))
+}
+
+// privateKeyCache 按商户号缓存解析后的私钥
+type privateKeyCache struct {
+	mu   sync.RWMutex
+	keys map[string]*cachedPrivateKey
+}
+
+type cachedPrivateKey struct {
+	version string // 私钥来源的版本, 版本变化时重新解析
+	key     *rsa.PrivateKey
+}
+
+func newPrivateKeyCache() *privateKeyCache {
+	return &privateKeyCache{keys: make(map[string]*cachedPrivateKey)}
+}
+
+// loadFile 读取密钥文件中的私钥, 用文件的修改时间和大小判断文件是否被替换
+func (cache *privateKeyCache) loadFile(mchId, path string) (*rsa.PrivateKey, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	version := fmt.Sprintf("%s|%d|%d", path, fileInfo.ModTime().UnixNano(), fileInfo.Size())
+	return cache.load(mchId, version, func() ([]byte, error) {
+		return os.ReadFile(path)
+	})
+}
+
+// load 缓存的私钥版本与 version 一致时直接返回, 否则用 read 读取私钥重新解析
+func (cache *privateKeyCache) load(mchId, version string, read func() ([]byte, error)) (*rsa.PrivateKey, error) {
+	cache.mu.RLock()
+	cached, exists := cache.keys[mchId]
+	cache.mu.RUnlock()
+	if exists && cached.version == version {
+		return cached.key, nil
+	}
+
+	keyPem, err := read()
+	if err != nil {
+		return nil, err
+	}
+	key, err := util.ParseRsaPrivateKey(keyPem)
+	if err != nil {
+		return nil, fmt.Errorf("商户 %s 的私钥解析失败: %w", mchId, err)
+	}
+	cache.mu.Lock()
+	cache.keys[mchId] = &cachedPrivateKey{version: version, key: key}
+	cache.mu.Unlock()
+	return key, nil
+}
@@ -0,0 +1,59 @@
+package library
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf16"
+)
+
+// RenderTextPdf 把多行文本渲染成单页的A4 PDF文件
+// 使用PDF阅读器内置的 STSong-Light 中文字体, 不需要把字体嵌入到文件里, 只适合生成发票、回单这类内容简单的文档
+func RenderTextPdf(lines []string) []byte {
+	content := new(bytes.Buffer)
+	content.WriteString("BT\n/F1 12 Tf\n18 TL\n50 790 Td\n")
+	for _, line := range lines {
+		fmt.Fprintf(content, "<%s> Tj T*\n", pdfTextHex(line))
+	}
+	content.WriteString("ET\n")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [6 0 R] >>",
+		"<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light " +
+			"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 2 >> /FontDescriptor 7 0 R >>",
+		"<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] " +
+			"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>",
+	}
+
+	pdf := new(bytes.Buffer)
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, 0, len(objects))
+	for i, object := range objects {
+		offsets = append(offsets, pdf.Len())
+		fmt.Fprintf(pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xrefOffset := pdf.Len()
+	fmt.Fprintf(pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+
+	return pdf.Bytes()
+}
+
+// pdfTextHex 把文本编码成 UniGB-UCS2-H 需要的 UCS-2 大端十六进制串, 基本平面以外的字符用 ? 代替
+func pdfTextHex(text string) string {
+	buf := make([]byte, 0, len(text)*2)
+	for _, r := range text {
+		if r > 0xFFFF || utf16.IsSurrogate(r) {
+			r = '?'
+		}
+		buf = append(buf, byte(r>>8), byte(r))
+	}
+	return hex.EncodeToString(buf)
+}
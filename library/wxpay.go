@@ -1,26 +1,30 @@
 package library
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
+	"crypto/sha1"
 	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
+	"github.com/WoWBytePaladin/go-mall/common/enum"
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
 	"github.com/WoWBytePaladin/go-mall/common/util"
 	"github.com/WoWBytePaladin/go-mall/common/util/httptool"
 	"github.com/WoWBytePaladin/go-mall/logic/do"
-	"github.com/WoWBytePaladin/go-mall/resources"
 )
 
 type WxPayLib struct {
@@ -30,10 +34,13 @@ type WxPayLib struct {
 
 type WxtPayConfig struct {
 	AppId           string
+	AppAppId        string // 移动应用的AppID, APP支付时使用
 	MchId           string
 	PrivateSerialNo string
 	AesKey          string
 	NotifyUrl       string
+	RefundNotifyUrl string      // 退款结果通知地址
+	KeyProvider     KeyProvider // 商户API私钥的提供者
 }
 
 func NewWxPayLib(ctx context.Context, config WxtPayConfig) *WxPayLib {
@@ -43,11 +50,17 @@ func NewWxPayLib(ctx context.Context, config WxtPayConfig) *WxPayLib {
 	}
 }
 
-const prePayApiUrl = "https://api.mch.weixin.qq.com/v3/pay/transactions/jsapi"
+// 微信支付各个支付场景的下单接口
+const (
+	prePayApiUrl       = "https://api.mch.weixin.qq.com/v3/pay/transactions/jsapi"
+	appPrePayApiUrl    = "https://api.mch.weixin.qq.com/v3/pay/transactions/app"
+	h5PrePayApiUrl     = "https://api.mch.weixin.qq.com/v3/pay/transactions/h5"
+	nativePrePayApiUrl = "https://api.mch.weixin.qq.com/v3/pay/transactions/native"
+)
 
 type PrePayParam struct {
-	AppId       string `json:"app_id"`
-	MchId       string `json:"mch_id"`       // 商户号ID
+	AppId       string `json:"appid"`
+	MchId       string `json:"mchid"`        // 商户号ID
 	Description string `json:"description"`  // 商品描述
 	OutTradeNo  string `json:"out_trade_no"` // 业务的订单号
 	NotifyUrl   string `json:"notify_url"`   // 结果回调通知url
@@ -56,10 +69,31 @@ type PrePayParam struct {
 		Currency string `json:"currency"`
 	} `json:"amount"`
 	Payer struct {
-		OpenId string `json:"open_id"`
+		OpenId string `json:"openid"`
 	} `json:"payer"`
 }
 
+// TransactionParam APP、H5、Native 下单接口的请求参数, 跟JSAPI下单相比不需要传 payer
+type TransactionParam struct {
+	AppId       string `json:"appid"`
+	MchId       string `json:"mchid"`
+	Description string `json:"description"`
+	OutTradeNo  string `json:"out_trade_no"`
+	NotifyUrl   string `json:"notify_url"`
+	Amount      struct {
+		Total    int    `json:"total"`
+		Currency string `json:"currency"`
+	} `json:"amount"`
+	SceneInfo *TransactionSceneInfo `json:"scene_info,omitempty"` // H5下单必须传场景信息
+}
+
+type TransactionSceneInfo struct {
+	PayerClientIp string `json:"payer_client_ip"` // 用户终端IP
+	H5Info        *struct {
+		Type string `json:"type"` // 场景类型 iOS, Android, Wap
+	} `json:"h5_info,omitempty"`
+}
+
 // WxPayInvokeInfo 前端用JSAPI调起支付的参数信息
 // 微信支付文档: https://pay.weixin.qq.com/wiki/doc/apiv3/apis/chapter3_1_4.shtml
 type WxPayInvokeInfo struct {
@@ -71,9 +105,37 @@ type WxPayInvokeInfo struct {
 	PaySign   string `json:"paySign"`
 }
 
+// WxAppPayInvokeInfo APP调起支付的参数信息
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/in-app-payment/app-transfer-payment.html
+type WxAppPayInvokeInfo struct {
+	AppId     string `json:"appid"`
+	PartnerId string `json:"partnerid"` // 商户号
+	PrepayId  string `json:"prepayid"`
+	Package   string `json:"package"` // 固定值 Sign=WXPay
+	NonceStr  string `json:"noncestr"`
+	TimeStamp string `json:"timestamp"`
+	Sign      string `json:"sign"`
+}
+
+// WxH5PayInvokeInfo H5支付的跳转信息, 前端跳转到 MWebUrl 拉起微信支付
+type WxH5PayInvokeInfo struct {
+	MWebUrl string `json:"mweb_url"`
+}
+
+// WxNativePayInvokeInfo Native支付的二维码信息, 前端把 CodeUrl 生成二维码让用户扫码支付
+type WxNativePayInvokeInfo struct {
+	CodeUrl string `json:"code_url"`
+}
+
+// WxPayNotifyResponse 微信支付结果通知的请求体
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/payment-notice.html
 type WxPayNotifyResponse struct {
-	CreateTime string              `json:"create_time"`
-	Resource   WxPayNotifyResource `json:"resource"`
+	ID           string              `json:"id"`
+	CreateTime   string              `json:"create_time"`
+	EventType    string              `json:"event_type"` // 支付成功通知的类型为 TRANSACTION.SUCCESS
+	ResourceType string              `json:"resource_type"`
+	Summary      string              `json:"summary"`
+	Resource     WxPayNotifyResource `json:"resource"`
 }
 type WxPayNotifyResource struct {
 	Ciphertext     string `json:"ciphertext"`
@@ -81,6 +143,18 @@ type WxPayNotifyResource struct {
 	Nonce          string `json:"nonce"`
 }
 
+// 微信支付的交易状态
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/query-by-out-trade-no.html
+const (
+	WxTradeStateSuccess    = "SUCCESS"    // 支付成功
+	WxTradeStateRefund     = "REFUND"     // 转入退款
+	WxTradeStateNotPay     = "NOTPAY"     // 未支付
+	WxTradeStateClosed     = "CLOSED"     // 已关闭
+	WxTradeStateRevoked    = "REVOKED"    // 已撤销(仅付款码支付会返回)
+	WxTradeStateUserPaying = "USERPAYING" // 用户支付中(仅付款码支付会返回)
+	WxTradeStatePayError   = "PAYERROR"   // 支付失败(仅付款码支付会返回)
+)
+
 // WxPayNotifyResourceData 微信支付结果通知中解密后的resource数据
 type WxPayNotifyResourceData struct {
 	TransactionID string `json:"transaction_id"`
@@ -104,6 +178,69 @@ type WxPayNotifyResourceData struct {
 	Attach         string `json:"attach"`
 }
 
+const queryOrderApiUrl = "https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/%s?mchid=%s"
+
+const closeOrderApiUrl = "https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/%s/close"
+
+const tradeBillApiUrl = "https://api.mch.weixin.qq.com/v3/bill/tradebill?bill_date=%s&bill_type=ALL"
+
+const refundApiUrl = "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds"
+
+const queryRefundApiUrl = "https://api.mch.weixin.qq.com/v3/refund/domestic/refunds/%s"
+
+// 微信支付的退款状态
+const (
+	WxRefundStatusSuccess    = "SUCCESS"    // 退款成功
+	WxRefundStatusClosed     = "CLOSED"     // 退款关闭
+	WxRefundStatusProcessing = "PROCESSING" // 退款处理中
+	WxRefundStatusAbnormal   = "ABNORMAL"   // 退款异常
+)
+
+type RefundParam struct {
+	OutTradeNo  string `json:"out_trade_no"`         // 业务订单号
+	OutRefundNo string `json:"out_refund_no"`        // 业务退款单号
+	Reason      string `json:"reason,omitempty"`     // 退款原因, 会展示在用户收到的退款消息中
+	NotifyUrl   string `json:"notify_url,omitempty"` // 退款结果回调通知url
+	Amount      struct {
+		Refund   int    `json:"refund"` // 退款金额
+		Total    int    `json:"total"`  // 原订单金额
+		Currency string `json:"currency"`
+	} `json:"amount"`
+}
+
+// WxRefundResult 申请退款接口的应答
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/refund/refunds/create.html
+type WxRefundResult struct {
+	RefundId      string    `json:"refund_id"` // 微信支付退款单号
+	OutRefundNo   string    `json:"out_refund_no"`
+	TransactionId string    `json:"transaction_id"`
+	OutTradeNo    string    `json:"out_trade_no"`
+	Status        string    `json:"status"`
+	SuccessTime   time.Time `json:"success_time"`
+	Amount        struct {
+		Total  int `json:"total"`
+		Refund int `json:"refund"`
+	} `json:"amount"`
+}
+
+// WxRefundNotifyResourceData 退款结果通知中解密后的退款信息
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/refund-result-notice.html
+type WxRefundNotifyResourceData struct {
+	MchId         string    `json:"mchid"`
+	OutTradeNo    string    `json:"out_trade_no"`
+	TransactionId string    `json:"transaction_id"`
+	OutRefundNo   string    `json:"out_refund_no"`
+	RefundId      string    `json:"refund_id"`
+	RefundStatus  string    `json:"refund_status"`
+	SuccessTime   time.Time `json:"success_time"`
+	Amount        struct {
+		Total       int `json:"total"`
+		Refund      int `json:"refund"`
+		PayerTotal  int `json:"payer_total"`
+		PayerRefund int `json:"payer_refund"`
+	} `json:"amount"`
+}
+
 // CreateOrderPay 创建支付信息
 // @param order *do.Order 业务的订单信息
 // @param userOpenId string 用户的Openid
@@ -123,23 +260,10 @@ func (wpl *WxPayLib) CreateOrderPay(order *do.Order, userOpenId string) (payInvo
 	prePayPram.Amount.Total = order.PayMoney
 	prePayPram.Amount.Currency = "CNY"
 	prePayPram.Payer.OpenId = userOpenId
-	reqBody, _ := json.Marshal(prePayPram)
-	token, err := wpl.getToken(http.MethodPost, string(reqBody), prePayApiUrl)
-	if err != nil {
-		err = errcode.Wrap("WxPayLibCreatePrePayError", err)
-		return
-	}
-	_, replyBody, err := httptool.Post(wpl.ctx, prePayApiUrl, reqBody, httptool.WithHeaders(map[string]string{
-		"Authorization": "WECHATPAY2-SHA256-RSA2048 " + token,
-	}))
-	if err != nil {
-		err = errcode.Wrap("WxPayLibCreatePrePayError", err)
-		return
-	}
 	prepayReply := struct {
 		PrePayId string `json:"prepay_id"`
 	}{}
-	if err = json.Unmarshal(replyBody, &prepayReply); err != nil {
+	if err = wpl.requestApi(http.MethodPost, prePayApiUrl, prePayPram, &prepayReply); err != nil {
 		err = errcode.Wrap("WxPayLibCreatePrePayError", err)
 		return
 	}
@@ -147,34 +271,349 @@ func (wpl *WxPayLib) CreateOrderPay(order *do.Order, userOpenId string) (payInvo
 	payInvokeInfo, err = wpl.genPayInvokeInfo(prepayReply.PrePayId)
 	if err != nil {
 		err = errcode.Wrap("WxPayLibCreatePrePayError", err)
+		return nil, err
 	}
 	return payInvokeInfo, nil
 }
 
+// CreateAppOrderPay 创建APP支付的支付信息
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/in-app-payment/direct-jsons/app-prepay.html
+func (wpl *WxPayLib) CreateAppOrderPay(order *do.Order) (payInvokeInfo *WxAppPayInvokeInfo, err error) {
+	appId := wpl.payConfig.AppAppId
+	if appId == "" {
+		appId = wpl.payConfig.AppId
+	}
+	transactionParam := wpl.newTransactionParam(order)
+	transactionParam.AppId = appId
+	prepayReply := struct {
+		PrePayId string `json:"prepay_id"`
+	}{}
+	if err = wpl.requestApi(http.MethodPost, appPrePayApiUrl, transactionParam, &prepayReply); err != nil {
+		return nil, errcode.Wrap("WxPayLibCreateAppPayError", err)
+	}
+	payInvokeInfo = &WxAppPayInvokeInfo{
+		AppId:     appId,
+		PartnerId: wpl.payConfig.MchId,
+		PrepayId:  prepayReply.PrePayId,
+		Package:   "Sign=WXPay",
+		NonceStr:  util.RandomString(32),
+		TimeStamp: fmt.Sprintf("%v", time.Now().Unix()),
+	}
+	// APP调起支付的签名串 appid\ntimestamp\nnoncestr\nprepayid\n
+	message := fmt.Sprintf("%s\n%s\n%s\n%s\n", payInvokeInfo.AppId, payInvokeInfo.TimeStamp, payInvokeInfo.NonceStr, payInvokeInfo.PrepayId)
+	if payInvokeInfo.Sign, err = wpl.signMessage(message); err != nil {
+		return nil, errcode.Wrap("WxPayLibCreateAppPayError", err)
+	}
+	return payInvokeInfo, nil
+}
+
+// CreateH5OrderPay 创建H5支付的支付信息
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/h5-payment/direct-jsons/h5-prepay.html
+// @param clientIp 用户的客户端IP, 微信会校验发起支付和下单的IP是否一致
+func (wpl *WxPayLib) CreateH5OrderPay(order *do.Order, clientIp string) (payInvokeInfo *WxH5PayInvokeInfo, err error) {
+	transactionParam := wpl.newTransactionParam(order)
+	transactionParam.SceneInfo = &TransactionSceneInfo{PayerClientIp: clientIp}
+	transactionParam.SceneInfo.H5Info = &struct {
+		Type string `json:"type"`
+	}{Type: "Wap"}
+	h5Reply := struct {
+		H5Url string `json:"h5_url"`
+	}{}
+	if err = wpl.requestApi(http.MethodPost, h5PrePayApiUrl, transactionParam, &h5Reply); err != nil {
+		return nil, errcode.Wrap("WxPayLibCreateH5PayError", err)
+	}
+	return &WxH5PayInvokeInfo{MWebUrl: h5Reply.H5Url}, nil
+}
+
+// CreateNativeOrderPay 创建Native(扫码)支付的支付信息
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/native-payment/direct-jsons/native-prepay.html
+func (wpl *WxPayLib) CreateNativeOrderPay(order *do.Order) (payInvokeInfo *WxNativePayInvokeInfo, err error) {
+	nativeReply := struct {
+		CodeUrl string `json:"code_url"`
+	}{}
+	if err = wpl.requestApi(http.MethodPost, nativePrePayApiUrl, wpl.newTransactionParam(order), &nativeReply); err != nil {
+		return nil, errcode.Wrap("WxPayLibCreateNativePayError", err)
+	}
+	return &WxNativePayInvokeInfo{CodeUrl: nativeReply.CodeUrl}, nil
+}
+
+func (wpl *WxPayLib) newTransactionParam(order *do.Order) *TransactionParam {
+	transactionParam := &TransactionParam{
+		AppId:       wpl.payConfig.AppId,
+		MchId:       wpl.payConfig.MchId,
+		Description: fmt.Sprintf("GOMALL 商场购买%s 等商品", order.Items[0].CommodityName),
+		OutTradeNo:  order.OrderNo,
+		NotifyUrl:   wpl.payConfig.NotifyUrl,
+	}
+	transactionParam.Amount.Total = order.PayMoney
+	transactionParam.Amount.Currency = "CNY"
+	return transactionParam
+}
+
+// WxPayApiError 微信支付接口返回的错误应答
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/development/interface-rules/error-code.html
+type WxPayApiError struct {
+	HttpStatus int    `json:"-"`
+	Code       string `json:"code"`    // 错误码, 比如 PARAM_ERROR、SYSTEM_ERROR
+	Message    string `json:"message"` // 错误描述
+}
+
+func (e *WxPayApiError) Error() string {
+	return fmt.Sprintf("微信支付接口返回错误, HTTP状态码: %d, 错误码: %s, 错误描述: %s", e.HttpStatus, e.Code, e.Message)
+}
+
+// Rejected 微信支付是否明确拒绝了请求, 4xx 的错误表示请求没有被受理;
+// 5xx 的系统错误和请求频率受限时请求可能已经被受理, 结果不确定
+func (e *WxPayApiError) Rejected() bool {
+	return e.HttpStatus >= http.StatusBadRequest && e.HttpStatus < http.StatusInternalServerError &&
+		e.HttpStatus != http.StatusTooManyRequests
+}
+
+// NotExists 请求的订单、退款单等资源在微信支付不存在
+func (e *WxPayApiError) NotExists() bool {
+	return e.HttpStatus == http.StatusNotFound
+}
+
+// AsWxPayApiError 从 errcode.Wrap 包装过的错误链里取出微信支付接口返回的错误
+// 网络错误、请求超时等没有拿到微信支付应答的错误返回 false
+func AsWxPayApiError(err error) (*WxPayApiError, bool) {
+	for err != nil {
+		if apiErr, ok := err.(*WxPayApiError); ok {
+			return apiErr, true
+		}
+		if appErr, ok := err.(*errcode.AppError); ok {
+			err = appErr.UnWrap()
+			continue
+		}
+		err = errors.Unwrap(err)
+	}
+	return nil, false
+}
+
+// requestApi 调用微信支付的接口, 下单、退款、查单这些接口只是请求方式、接口地址和参数不同
+// @param httpMethod 请求方式 POST | GET
+// @param apiUrl 接口地址
+// @param param 请求参数, GET请求时为 nil
+// @param reply 用于解析接口应答的对象指针, 接口没有应答体时为 nil
+func (wpl *WxPayLib) requestApi(httpMethod, apiUrl string, param interface{}, reply interface{}) error {
+	var reqBody []byte
+	if param != nil {
+		var err error
+		if reqBody, err = json.Marshal(param); err != nil {
+			return err
+		}
+	}
+	token, err := wpl.getToken(httpMethod, string(reqBody), apiUrl)
+	if err != nil {
+		return err
+	}
+	httpStatus, replyBody, err := httptool.Request(httpMethod, apiUrl,
+		httptool.WithContext(wpl.ctx),
+		httptool.WithData(reqBody),
+		httptool.WithHeaders(map[string]string{
+			"Authorization": "WECHATPAY2-SHA256-RSA2048 " + token,
+			"Content-Type":  "application/json",
+			"Accept":        "application/json",
+		}))
+	if err != nil {
+		if httpStatus != 0 { // 微信支付返回了错误应答, 应答体里有错误码和错误描述
+			apiErr := &WxPayApiError{HttpStatus: httpStatus}
+			_ = json.Unmarshal(replyBody, apiErr)
+			return apiErr
+		}
+		return err
+	}
+	if reply == nil {
+		return nil
+	}
+	return json.Unmarshal(replyBody, reply)
+}
+
+// CreateRefund 申请退款
+// @param outTradeNo 业务订单号
+// @param outRefundNo 业务退款单号, 同一个退款单号多次请求只会退款一次
+// @param reason 退款原因
+// @param refundMoney 退款金额(分)
+// @param totalMoney 原订单的支付金额(分)
+func (wpl *WxPayLib) CreateRefund(outTradeNo, outRefundNo, reason string, refundMoney, totalMoney int) (*WxRefundResult, error) {
+	refundParam := &RefundParam{
+		OutTradeNo:  outTradeNo,
+		OutRefundNo: outRefundNo,
+		Reason:      reason,
+		NotifyUrl:   wpl.payConfig.RefundNotifyUrl,
+	}
+	refundParam.Amount.Refund = refundMoney
+	refundParam.Amount.Total = totalMoney
+	refundParam.Amount.Currency = "CNY"
+	refundResult := new(WxRefundResult)
+	if err := wpl.requestApi(http.MethodPost, refundApiUrl, refundParam, refundResult); err != nil {
+		return nil, errcode.Wrap("WxPayLibCreateRefundError", err)
+	}
+	return refundResult, nil
+}
+
+// QueryRefund 用业务退款单号查询退款在微信支付的处理结果
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/refund/refunds/query-by-out-refund-no.html
+// 查询返回的退款信息跟申请退款接口的应答结构一致
+func (wpl *WxPayLib) QueryRefund(outRefundNo string) (*WxRefundResult, error) {
+	queryUrl := fmt.Sprintf(queryRefundApiUrl, url.PathEscape(outRefundNo))
+	refundResult := new(WxRefundResult)
+	if err := wpl.requestApi(http.MethodGet, queryUrl, nil, refundResult); err != nil {
+		return nil, errcode.Wrap("WxPayLibQueryRefundError", err)
+	}
+	return refundResult, nil
+}
+
+// QueryOrderByOutTradeNo 用业务订单号查询订单在微信支付的交易信息
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/query-by-out-trade-no.html
+// 查询返回的交易信息跟支付结果通知中解密后的信息结构一致
+func (wpl *WxPayLib) QueryOrderByOutTradeNo(outTradeNo string) (*WxPayNotifyResourceData, error) {
+	queryUrl := fmt.Sprintf(queryOrderApiUrl, url.PathEscape(outTradeNo), url.QueryEscape(wpl.payConfig.MchId))
+	tradeData := new(WxPayNotifyResourceData)
+	if err := wpl.requestApi(http.MethodGet, queryUrl, nil, tradeData); err != nil {
+		return nil, errcode.Wrap("WxPayLibQueryOrderError", err)
+	}
+	return tradeData, nil
+}
+
+// CloseOrder 关闭订单在微信支付的交易, 关闭后用户不能再支付这个订单
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/jsapi-payment/close-order.html
+func (wpl *WxPayLib) CloseOrder(outTradeNo string) error {
+	closeUrl := fmt.Sprintf(closeOrderApiUrl, url.PathEscape(outTradeNo))
+	closeParam := map[string]string{"mchid": wpl.payConfig.MchId}
+	if err := wpl.requestApi(http.MethodPost, closeUrl, closeParam, nil); err != nil {
+		return errcode.Wrap("WxPayLibCloseOrderError", err)
+	}
+	return nil
+}
+
+// WxTradeBillRecord 交易账单中的一条交易记录
+type WxTradeBillRecord struct {
+	TradeTime     time.Time // 交易时间
+	TransactionId string    // 微信支付订单号
+	OutTradeNo    string    // 商户订单号
+	TradeType     string    // 交易类型 JSAPI、APP、MWEB、NATIVE
+	TradeState    string    // 交易状态 SUCCESS、REFUND
+	TotalAmount   int       // 订单金额(分)
+	RefundId      string    // 微信退款单号
+	OutRefundNo   string    // 商户退款单号
+	RefundAmount  int       // 退款金额(分)
+}
+
+// DownloadTradeBill 下载指定日期的交易账单
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/bill-download/trade-bill.html
+// 次日10点后才能下载前一天的账单
+func (wpl *WxPayLib) DownloadTradeBill(billDate time.Time) ([]*WxTradeBillRecord, error) {
+	billReply := struct {
+		HashType    string `json:"hash_type"`
+		HashValue   string `json:"hash_value"`
+		DownloadUrl string `json:"download_url"`
+	}{}
+	billUrl := fmt.Sprintf(tradeBillApiUrl, billDate.Format(enum.TimeFormatHyphenedYMD))
+	if err := wpl.requestApi(http.MethodGet, billUrl, nil, &billReply); err != nil {
+		return nil, errcode.Wrap("WxPayLibDownloadTradeBillError", err)
+	}
+	// 下载账单文件, 下载地址同样需要请求签名
+	token, err := wpl.getToken(http.MethodGet, "", billReply.DownloadUrl)
+	if err != nil {
+		return nil, errcode.Wrap("WxPayLibDownloadTradeBillError", err)
+	}
+	_, billData, err := httptool.Get(wpl.ctx, billReply.DownloadUrl, httptool.WithHeaders(map[string]string{
+		"Authorization": "WECHATPAY2-SHA256-RSA2048 " + token,
+	}))
+	if err != nil {
+		return nil, errcode.Wrap("WxPayLibDownloadTradeBillError", err)
+	}
+	// 校验账单文件的摘要, 保证下载的文件完整
+	billHash := sha1.Sum(billData)
+	if !strings.EqualFold(hex.EncodeToString(billHash[:]), billReply.HashValue) {
+		return nil, errcode.Wrap("WxPayLibDownloadTradeBillError", errors.New("账单文件摘要校验失败"))
+	}
+
+	return ParseTradeBill(billData)
+}
+
+// ParseTradeBill 解析交易账单文件
+// 账单第一行是表头, 之后每行一条交易记录, 每个字段值都以 ` 开头, 最后两行是汇总信息
+func ParseTradeBill(billData []byte) ([]*WxTradeBillRecord, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(billData, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, errcode.Wrap("WxPayLibParseTradeBillError", err)
+	}
+	if len(rows) == 0 {
+		return nil, errcode.Wrap("WxPayLibParseTradeBillError", errors.New("账单为空"))
+	}
+	// 按表头的字段名定位各个字段, 不依赖字段的顺序
+	columns := make(map[string]int)
+	for index, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = index
+	}
+	for _, name := range []string{"交易时间", "微信订单号", "商户订单号", "交易类型", "交易状态", "订单金额", "微信退款单号", "商户退款单号", "退款金额"} {
+		if _, exists := columns[name]; !exists {
+			return nil, errcode.Wrap("WxPayLibParseTradeBillError", fmt.Errorf("账单缺少字段: %s", name))
+		}
+	}
+	field := func(row []string, name string) string {
+		if columns[name] >= len(row) {
+			return ""
+		}
+		return strings.TrimPrefix(strings.TrimSpace(row[columns[name]]), "`")
+	}
+
+	records := make([]*WxTradeBillRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if len(row) > 0 && strings.TrimSpace(row[0]) == "总交易单数" { // 后面是汇总信息
+			break
+		}
+		record := &WxTradeBillRecord{
+			TransactionId: field(row, "微信订单号"),
+			OutTradeNo:    field(row, "商户订单号"),
+			TradeType:     field(row, "交易类型"),
+			TradeState:    field(row, "交易状态"),
+			RefundId:      field(row, "微信退款单号"),
+			OutRefundNo:   field(row, "商户退款单号"),
+		}
+		if record.TradeTime, err = time.ParseInLocation(enum.TimeFormatHyphenedYMDHIS, field(row, "交易时间"), time.Local); err != nil {
+			return nil, errcode.Wrap("WxPayLibParseTradeBillError", err)
+		}
+		if record.TotalAmount, err = util.YuanToFen(field(row, "订单金额")); err != nil {
+			return nil, errcode.Wrap("WxPayLibParseTradeBillError", err)
+		}
+		if refundAmount := field(row, "退款金额"); refundAmount != "" {
+			if record.RefundAmount, err = util.YuanToFen(refundAmount); err != nil {
+				return nil, errcode.Wrap("WxPayLibParseTradeBillError", err)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
 // ValidateNotifySignature 验证微信支付结果通知的签名
 // 微信API文档: https://pay.weixin.qq.com/docs/merchant/development/interface-rules/signature-verification.html
+// @param serialNo 签名所用平台证书的序列号 从 HTTP 头 Wechatpay-Serial 获取
 // @param timeStamp 签名生成时间 从 HTTP 头 Wechatpay-Timestamp 获取
 // @param nonce HTTP 头 Wechatpay-Nonce 中的应答随机串
 // @param signature 微信支付的应答签名, 通过HTTP头Wechatpay-Signature 传递
 // @param rawPost 原始请求体
 
-func (wpl *WxPayLib) ValidateNotifySignature(timeStamp, nonce, signature, rawPost string) (verifyRes bool, err error) {
-	signatureBytes, _ := base64.StdEncoding.DecodeString(signature)
-	message := fmt.Sprintf("%s\n%s\n%s\n", timeStamp, nonce, rawPost)
-	pemFileReader, err := resources.LoadResourceFile("wxp_pub.pem")
+func (wpl *WxPayLib) ValidateNotifySignature(serialNo, timeStamp, nonce, signature, rawPost string) (verifyRes bool, err error) {
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature)
 	if err != nil {
-		err = errcode.Wrap("WxPayLibValidateCallBackSignatureError", err)
-		return
+		return false, errcode.Wrap("WxPayLibValidateCallBackSignatureError", err)
 	}
-	publicKeyStr, _ := ioutil.ReadAll(pemFileReader)
-	//pem解码
-	block, _ := pem.Decode(publicKeyStr)
-	//x509解码
-	publicKeyInterface, err := x509.ParseCertificate(block.Bytes)
+	message := fmt.Sprintf("%s\n%s\n%s\n", timeStamp, nonce, rawPost)
+	certificate, err := wpl.getPlatformCert(serialNo)
 	if err != nil {
-		panic(err)
+		return false, errcode.Wrap("WxPayLibValidateCallBackSignatureError", err)
+	}
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return false, errcode.Wrap("WxPayLibValidateCallBackSignatureError", errors.New("平台证书的公钥不是RSA公钥"))
 	}
-	publicKey := publicKeyInterface.PublicKey.(*rsa.PublicKey)
 	//验证数字签名
 	err = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, util.SHA256HashBytes(message), signatureBytes) //crypto.SHA1
 	verifyRes = nil == err
@@ -183,27 +622,52 @@ func (wpl *WxPayLib) ValidateNotifySignature(timeStamp, nonce, signature, rawPos
 }
 
 // DecryptNotifyResourceData 解密微信支付通知中的resource数据
-func (wpl *WxPayLib) DecryptNotifyResourceData(rawPost string) (notifyResourceData *WxPayNotifyResource, err error) {
-	var notifyResponse WxPayNotifyResponse
-	if err = json.Unmarshal([]byte(rawPost), &notifyResponse); err != nil {
+func (wpl *WxPayLib) DecryptNotifyResourceData(rawPost string) (notifyResourceData *WxPayNotifyResourceData, err error) {
+	plaintext, err := wpl.decryptNotifyResource(rawPost)
+	if err != nil {
 		return notifyResourceData, errcode.Wrap("WxPayLibDecryptNotifyDataError", err)
 	}
+	err = json.Unmarshal(plaintext, &notifyResourceData)
+	return
+}
 
+// DecryptRefundNotifyResourceData 解密微信退款结果通知中的resource数据
+func (wpl *WxPayLib) DecryptRefundNotifyResourceData(rawPost string) (refundResourceData *WxRefundNotifyResourceData, err error) {
+	plaintext, err := wpl.decryptNotifyResource(rawPost)
+	if err != nil {
+		return refundResourceData, errcode.Wrap("WxPayLibDecryptRefundNotifyDataError", err)
+	}
+	err = json.Unmarshal(plaintext, &refundResourceData)
+	return
+}
+
+// decryptNotifyResource 解密通知中的resource, 支付通知和退款通知的加密方式相同
+func (wpl *WxPayLib) decryptNotifyResource(rawPost string) ([]byte, error) {
+	var notifyResponse WxPayNotifyResponse
+	if err := json.Unmarshal([]byte(rawPost), &notifyResponse); err != nil {
+		return nil, err
+	}
+	return wpl.decryptAesGcm(notifyResponse.Resource)
+}
+
+// decryptAesGcm 用 APIv3 密钥解密 AEAD_AES_256_GCM 加密的数据, 通知的resource和下载的平台证书都用这种方式加密
+func (wpl *WxPayLib) decryptAesGcm(resource WxPayNotifyResource) ([]byte, error) {
 	aseKey := []byte(wpl.payConfig.AesKey)
-	nonce := []byte(notifyResponse.Resource.Nonce)
-	associatedData := []byte(notifyResponse.Resource.AssociatedData)
-	ciphertext, err := base64.StdEncoding.DecodeString(notifyResponse.Resource.Ciphertext)
+	nonce := []byte(resource.Nonce)
+	associatedData := []byte(resource.AssociatedData)
+	ciphertext, err := base64.StdEncoding.DecodeString(resource.Ciphertext)
 	if err != nil {
-		return notifyResourceData, errcode.Wrap("WxPayLibDecryptNotifyDataError", err)
+		return nil, err
 	}
-	block, _ := aes.NewCipher(aseKey)
-	aesGCM, _ := cipher.NewGCM(block)
-	plaintext, err := aesGCM.Open(nil, nonce, ciphertext, associatedData)
+	block, err := aes.NewCipher(aseKey)
 	if err != nil {
-		return notifyResourceData, err
+		return nil, err
 	}
-	err = json.Unmarshal(plaintext, &notifyResourceData)
-	return
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM.Open(nil, nonce, ciphertext, associatedData)
 }
 
 // genToken 生成微信支付的请求签名
@@ -218,22 +682,10 @@ func (wpl *WxPayLib) getToken(httMethod string, requestBody string, wxApiUrl str
 	timestamp := time.Now().Unix()
 	nonce := util.RandomString(32)
 	message := fmt.Sprintf("%s\n%s\n%d\n%s\n%s\n", httMethod, canonicalUrl, timestamp, nonce, requestBody)
-	// 商户私有证书放在了 resources 目录下
-	pemFileReader, err := resources.LoadResourceFile("wxpay.private.pem")
-	if err != nil {
-		return token, err
-	}
-	privateKey, err := ioutil.ReadAll(pemFileReader)
-	if err != nil {
-		return token, err
-	}
-
-	sha256MsgBytes := util.SHA256HashBytes(message)
-	signBytes, err := util.RsaSignPKCS1v15(sha256MsgBytes, privateKey, crypto.SHA256)
+	sign, err := wpl.signMessage(message)
 	if err != nil {
 		return token, err
 	}
-	sign := base64.StdEncoding.EncodeToString(signBytes)
 
 	token = fmt.Sprintf("mchid=\"%s\",nonce_str=\"%s\",timestamp=\"%d\",serial_no=\"%s\",signature=\"%s\"",
 		wpl.payConfig.MchId, nonce, timestamp, wpl.payConfig.PrivateSerialNo, sign)
@@ -252,20 +704,26 @@ func (wpl *WxPayLib) genPayInvokeInfo(prepayId string) (payInvokeInfo *WxPayInvo
 	}
 	// 签名
 	message := fmt.Sprintf("%s\n%s\n%s\n%s\n", payInvokeInfo.AppId, payInvokeInfo.TimeStamp, payInvokeInfo.NonceStr, payInvokeInfo.Package)
-	pemFileReader, err := resources.LoadResourceFile("wxpay.private.pem")
+	payInvokeInfo.PaySign, err = wpl.signMessage(message)
 	if err != nil {
-		return
+		return nil, err
+	}
+
+	return payInvokeInfo, nil
+}
+
+// signMessage 用商户私钥对签名串签名, 请求签名和调起支付的签名都用它
+func (wpl *WxPayLib) signMessage(message string) (string, error) {
+	if wpl.payConfig.KeyProvider == nil {
+		return "", errors.New("没有配置商户私钥的提供者")
 	}
-	privateKey, err := ioutil.ReadAll(pemFileReader)
+	privateKey, err := wpl.payConfig.KeyProvider.PrivateKey(wpl.payConfig.MchId)
 	if err != nil {
-		return
+		return "", err
 	}
-	sha245MsgBytes := util.SHA256HashBytes(message)
-	signBytes, err := util.RsaSignPKCS1v15(sha245MsgBytes, privateKey, crypto.SHA256)
+	signBytes, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, util.SHA256HashBytes(message))
 	if err != nil {
-		return
+		return "", err
 	}
-	payInvokeInfo.PaySign = base64.StdEncoding.EncodeToString(signBytes)
-
-	return payInvokeInfo, nil
+	return base64.StdEncoding.EncodeToString(signBytes), nil
 }
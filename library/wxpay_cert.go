@@ -0,0 +1,143 @@
+package library
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+)
+
+// 微信支付平台证书
+// 微信支付用平台证书的私钥给应答和通知签名, 平台证书会定期轮换, 新旧证书交替期间两个证书都可能被使用,
+// 通知的 HTTP 头 Wechatpay-Serial 指明了签名所用证书的序列号。
+// 平台证书通过下载接口获取, 按商户号缓存在进程内, 定期重新下载。
+
+const (
+	certificatesApiUrl = "https://api.mch.weixin.qq.com/v3/certificates"
+	// wxPayCertRefreshInterval 定期重新下载平台证书的间隔
+	wxPayCertRefreshInterval = 12 * time.Hour
+	// wxPayCertRetryInterval 两次下载证书的最小间隔, 下载失败后过这么久再重试, 也防止伪造的序列号导致频繁下载
+	wxPayCertRetryInterval = time.Minute
+)
+
+// WxPayPlatformCert 微信支付平台证书
+type WxPayPlatformCert struct {
+	SerialNo      string
+	EffectiveTime time.Time
+	ExpireTime    time.Time
+	Certificate   *x509.Certificate
+}
+
+// wxPayCertStore 一个商户的平台证书缓存
+type wxPayCertStore struct {
+	mu          sync.RWMutex
+	certs       map[string]*WxPayPlatformCert // 按证书序列号缓存的平台证书
+	refreshedAt time.Time                     // 最近一次成功下载证书的时间
+	attemptedAt time.Time                     // 最近一次尝试下载证书的时间, 用来控制下载频率
+}
+
+// wxPayCertStores 商户号到平台证书缓存的映射
+var wxPayCertStores sync.Map
+
+func getWxPayCertStore(mchId string) *wxPayCertStore {
+	store, _ := wxPayCertStores.LoadOrStore(mchId, &wxPayCertStore{certs: make(map[string]*WxPayPlatformCert)})
+	return store.(*wxPayCertStore)
+}
+
+// DownloadPlatformCerts 下载微信支付平台证书
+// 微信支付文档: https://pay.weixin.qq.com/docs/merchant/apis/platform-certificate/api-v3-get-certificates/get.html
+// 证书内容用 APIv3 密钥加密, 解密方式与支付通知相同
+func (wpl *WxPayLib) DownloadPlatformCerts() ([]*WxPayPlatformCert, error) {
+	certsReply := struct {
+		Data []struct {
+			SerialNo           string              `json:"serial_no"`
+			EffectiveTime      time.Time           `json:"effective_time"`
+			ExpireTime         time.Time           `json:"expire_time"`
+			EncryptCertificate WxPayNotifyResource `json:"encrypt_certificate"`
+		} `json:"data"`
+	}{}
+	if err := wpl.requestApi(http.MethodGet, certificatesApiUrl, nil, &certsReply); err != nil {
+		return nil, errcode.Wrap("WxPayLibDownloadPlatformCertsError", err)
+	}
+
+	certs := make([]*WxPayPlatformCert, 0, len(certsReply.Data))
+	for _, certData := range certsReply.Data {
+		certPem, err := wpl.decryptAesGcm(certData.EncryptCertificate)
+		if err != nil {
+			return nil, errcode.Wrap("WxPayLibDownloadPlatformCertsError", err)
+		}
+		certificate, err := parseCertificate(certPem)
+		if err != nil {
+			return nil, errcode.Wrap("WxPayLibDownloadPlatformCertsError", err)
+		}
+		certs = append(certs, &WxPayPlatformCert{
+			SerialNo:      certData.SerialNo,
+			EffectiveTime: certData.EffectiveTime,
+			ExpireTime:    certData.ExpireTime,
+			Certificate:   certificate,
+		})
+	}
+	return certs, nil
+}
+
+// getPlatformCert 获取指定序列号的平台证书
+// 缓存超过刷新间隔或者缓存中没有这个序列号时重新下载证书, 下载失败时继续使用缓存中还有效的证书
+func (wpl *WxPayLib) getPlatformCert(serialNo string) (*x509.Certificate, error) {
+	store := getWxPayCertStore(wpl.payConfig.MchId)
+	store.mu.RLock()
+	cert, exists := store.certs[serialNo]
+	refreshedAt := store.refreshedAt
+	store.mu.RUnlock()
+
+	if !exists || time.Since(refreshedAt) > wxPayCertRefreshInterval {
+		if err := wpl.refreshPlatformCerts(store); err != nil {
+			logger.New(wpl.ctx).Error("WxPayLibRefreshPlatformCertsError", "err", err, "serialNo", serialNo)
+		}
+		store.mu.RLock()
+		cert, exists = store.certs[serialNo]
+		store.mu.RUnlock()
+	}
+	if !exists {
+		return nil, fmt.Errorf("未知的微信支付平台证书序列号: %s", serialNo)
+	}
+	if time.Now().After(cert.ExpireTime) {
+		return nil, fmt.Errorf("微信支付平台证书已过期, 序列号: %s", serialNo)
+	}
+	return cert.Certificate, nil
+}
+
+// refreshPlatformCerts 重新下载平台证书替换缓存, 多个请求同时需要刷新时只下载一次
+func (wpl *WxPayLib) refreshPlatformCerts(store *wxPayCertStore) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if time.Since(store.attemptedAt) <= wxPayCertRetryInterval { // 其他请求刚刚下载过
+		return nil
+	}
+	store.attemptedAt = time.Now()
+	certs, err := wpl.DownloadPlatformCerts()
+	if err != nil {
+		return err
+	}
+	store.refreshedAt = store.attemptedAt
+	// 下载结果会包含所有可用的证书, 直接替换缓存, 已经下线的旧证书随之失效
+	store.certs = make(map[string]*WxPayPlatformCert, len(certs))
+	for _, cert := range certs {
+		store.certs[cert.SerialNo] = cert
+	}
+	return nil
+}
+
+// parseCertificate 解析 PEM 格式的证书
+func parseCertificate(certPem []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		return nil, errors.New("证书不是有效的PEM格式")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
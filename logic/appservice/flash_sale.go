@@ -0,0 +1,137 @@
+package appservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/api/reply"
+	"github.com/WoWBytePaladin/go-mall/api/request"
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+)
+
+type FlashSaleAppSvc struct {
+	ctx                context.Context
+	flashSaleDomainSvc *domainservice.FlashSaleDomainSvc
+}
+
+func NewFlashSaleAppSvc(ctx context.Context) *FlashSaleAppSvc {
+	return &FlashSaleAppSvc{
+		ctx:                ctx,
+		flashSaleDomainSvc: domainservice.NewFlashSaleDomainSvc(ctx),
+	}
+}
+
+// CreateFlashSale 管理后台创建秒杀活动
+func (fsas *FlashSaleAppSvc) CreateFlashSale(createRequest *request.FlashSaleCreate) (*reply.FlashSale, error) {
+	startTime, err := time.ParseInLocation(enum.TimeFormatHyphenedYMDHIS, createRequest.StartTime, time.Local)
+	if err != nil {
+		return nil, errcode.ErrParams.WithCause(err)
+	}
+	endTime, err := time.ParseInLocation(enum.TimeFormatHyphenedYMDHIS, createRequest.EndTime, time.Local)
+	if err != nil {
+		return nil, errcode.ErrParams.WithCause(err)
+	}
+	flashSale := &do.FlashSale{
+		Name:         createRequest.Name,
+		CommodityId:  createRequest.CommodityId,
+		FlashPrice:   createRequest.FlashPrice,
+		TotalStock:   createRequest.TotalStock,
+		PerUserLimit: createRequest.PerUserLimit,
+		StartTime:    startTime,
+		EndTime:      endTime,
+	}
+	flashSale, err = fsas.flashSaleDomainSvc.CreateFlashSale(flashSale)
+	if err != nil {
+		return nil, err
+	}
+	return newFlashSaleReply(flashSale), nil
+}
+
+// GetFlashSales 管理后台分页查询秒杀活动
+func (fsas *FlashSaleAppSvc) GetFlashSales(pagination *app.Pagination) ([]*reply.FlashSale, error) {
+	flashSales, err := fsas.flashSaleDomainSvc.GetFlashSales(pagination)
+	if err != nil {
+		return nil, err
+	}
+	replyFlashSales := make([]*reply.FlashSale, 0, len(flashSales))
+	for _, flashSale := range flashSales {
+		replyFlashSales = append(replyFlashSales, newFlashSaleReply(flashSale))
+	}
+	return replyFlashSales, nil
+}
+
+// GetFlashSaleInfo 秒杀活动详情
+func (fsas *FlashSaleAppSvc) GetFlashSaleInfo(flashSaleId int64) (*reply.FlashSaleInfo, error) {
+	flashSale, stock, err := fsas.flashSaleDomainSvc.GetFlashSale(flashSaleId)
+	if err != nil {
+		return nil, err
+	}
+	return &reply.FlashSaleInfo{FlashSale: *newFlashSaleReply(flashSale), Stock: stock}, nil
+}
+
+// BuyFlashSale 秒杀抢购, 抢到库存后返回用来轮询下单结果的订单号
+func (fsas *FlashSaleAppSvc) BuyFlashSale(flashSaleId int64, buyRequest *request.FlashSaleBuy, userId int64) (*reply.FlashSaleQueued, error) {
+	// 先检查收货地址, 避免抢到库存后才因为地址不对下单失败
+	userDomainSvc := domainservice.NewUserDomainSvc(fsas.ctx)
+	if _, err := userDomainSvc.GetUserSingleAddress(userId, buyRequest.UserAddressId); err != nil {
+		return nil, err
+	}
+	orderNo, err := fsas.flashSaleDomainSvc.QueueFlashSaleOrder(&do.FlashSaleOrder{
+		FlashSaleId:   flashSaleId,
+		UserId:        userId,
+		CommodityNum:  buyRequest.CommodityNum,
+		UserAddressId: buyRequest.UserAddressId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &reply.FlashSaleQueued{OrderNo: orderNo}, nil
+}
+
+// GetFlashSaleOrderResult 轮询秒杀下单的结果
+func (fsas *FlashSaleAppSvc) GetFlashSaleOrderResult(orderNo string, userId int64) (*reply.FlashSaleOrderResult, error) {
+	result, err := fsas.flashSaleDomainSvc.GetFlashSaleOrderResult(orderNo, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &reply.FlashSaleOrderResult{OrderNo: result.OrderNo, State: result.State, FailReason: result.FailReason}, nil
+}
+
+// CreateQueuedFlashSaleOrders 为秒杀下单队列中排队的请求创建订单
+func (fsas *FlashSaleAppSvc) CreateQueuedFlashSaleOrders() (int, error) {
+	return fsas.flashSaleDomainSvc.CreateQueuedOrders(200)
+}
+
+// RequeueStuckFlashSaleOrders 把取出后超过1分钟仍没有处理完的秒杀下单请求放回下单队列
+func (fsas *FlashSaleAppSvc) RequeueStuckFlashSaleOrders() (int, error) {
+	return fsas.flashSaleDomainSvc.RequeueStuckOrders(time.Minute)
+}
+
+// PreloadFlashSales 把即将开始的秒杀活动预热到 Redis
+func (fsas *FlashSaleAppSvc) PreloadFlashSales() (int, error) {
+	return fsas.flashSaleDomainSvc.PreloadFlashSales()
+}
+
+// ReconcileFlashSales 秒杀活动对账, 把 Redis 中的抢购数量同步回 MySQL
+func (fsas *FlashSaleAppSvc) ReconcileFlashSales() (int, error) {
+	return fsas.flashSaleDomainSvc.ReconcileFlashSales()
+}
+
+func newFlashSaleReply(flashSale *do.FlashSale) *reply.FlashSale {
+	return &reply.FlashSale{
+		ID:           flashSale.ID,
+		Name:         flashSale.Name,
+		CommodityId:  flashSale.CommodityId,
+		FlashPrice:   flashSale.FlashPrice,
+		TotalStock:   flashSale.TotalStock,
+		SoldNum:      flashSale.SoldNum,
+		PerUserLimit: flashSale.PerUserLimit,
+		StartTime:    flashSale.StartTime.Format(enum.TimeFormatHyphenedYMDHIS),
+		EndTime:      flashSale.EndTime.Format(enum.TimeFormatHyphenedYMDHIS),
+		CreatedAt:    flashSale.CreatedAt.Format(enum.TimeFormatHyphenedYMDHIS),
+	}
+}
@@ -0,0 +1,126 @@
+package appservice
+
+import (
+	"context"
+
+	"github.com/WoWBytePaladin/go-mall/api/reply"
+	"github.com/WoWBytePaladin/go-mall/api/request"
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+)
+
+type InvoiceAppSvc struct {
+	ctx              context.Context
+	invoiceDomainSvc *domainservice.InvoiceDomainSvc
+}
+
+func NewInvoiceAppSvc(ctx context.Context) *InvoiceAppSvc {
+	return &InvoiceAppSvc{
+		ctx:              ctx,
+		invoiceDomainSvc: domainservice.NewInvoiceDomainSvc(ctx),
+	}
+}
+
+// AddInvoiceTitle 新增发票抬头
+func (ias *InvoiceAppSvc) AddInvoiceTitle(titleRequest *request.InvoiceTitle, userId int64) (*reply.InvoiceTitle, error) {
+	title := new(do.InvoiceTitle)
+	if err := util.CopyProperties(title, titleRequest); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	title.UserId = userId
+	title, err := ias.invoiceDomainSvc.AddInvoiceTitle(title)
+	if err != nil {
+		return nil, err
+	}
+	replyTitle := new(reply.InvoiceTitle)
+	if err = util.CopyProperties(replyTitle, title); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyTitle, nil
+}
+
+// GetUserInvoiceTitles 查询用户的发票抬头
+func (ias *InvoiceAppSvc) GetUserInvoiceTitles(userId int64) ([]*reply.InvoiceTitle, error) {
+	titles, err := ias.invoiceDomainSvc.GetUserInvoiceTitles(userId)
+	if err != nil {
+		return nil, err
+	}
+	replyTitles := make([]*reply.InvoiceTitle, 0, len(titles))
+	if err = util.CopyProperties(&replyTitles, &titles); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyTitles, nil
+}
+
+// ModifyInvoiceTitle 修改用户的发票抬头
+func (ias *InvoiceAppSvc) ModifyInvoiceTitle(titleRequest *request.InvoiceTitle, userId, titleId int64) error {
+	title := new(do.InvoiceTitle)
+	if err := util.CopyProperties(title, titleRequest); err != nil {
+		return errcode.ErrCoverData.WithCause(err)
+	}
+	title.UserId = userId
+	title.ID = titleId
+	return ias.invoiceDomainSvc.ModifyInvoiceTitle(title)
+}
+
+// DeleteInvoiceTitle 删除用户的发票抬头
+func (ias *InvoiceAppSvc) DeleteInvoiceTitle(userId, titleId int64) error {
+	return ias.invoiceDomainSvc.DeleteInvoiceTitle(userId, titleId)
+}
+
+// ApplyOrderInvoice 用户申请开票
+func (ias *InvoiceAppSvc) ApplyOrderInvoice(applyRequest *request.OrderInvoiceApply, userId int64) (*reply.OrderInvoice, error) {
+	invoice, err := ias.invoiceDomainSvc.RequestOrderInvoice(applyRequest.OrderNo, userId, applyRequest.TitleId)
+	if err != nil {
+		return nil, err
+	}
+	replyInvoice := new(reply.OrderInvoice)
+	if err = util.CopyProperties(replyInvoice, invoice); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyInvoice, nil
+}
+
+// GetOrderInvoices 查询订单的开票记录
+func (ias *InvoiceAppSvc) GetOrderInvoices(orderNo string, userId int64) ([]*reply.OrderInvoice, error) {
+	invoices, err := ias.invoiceDomainSvc.GetUserOrderInvoices(orderNo, userId)
+	if err != nil {
+		return nil, err
+	}
+	replyInvoices := make([]*reply.OrderInvoice, 0, len(invoices))
+	if err = util.CopyProperties(&replyInvoices, &invoices); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyInvoices, nil
+}
+
+// GetInvoicePdf 获取用户发票的PDF文件路径
+func (ias *InvoiceAppSvc) GetInvoicePdf(invoiceNo string, userId int64) (string, error) {
+	return ias.invoiceDomainSvc.GetUserInvoicePdf(invoiceNo, userId)
+}
+
+// GetInvoices 管理后台分页查询开票记录
+func (ias *InvoiceAppSvc) GetInvoices(query *request.AdminInvoiceQuery, pagination *app.Pagination) ([]*reply.OrderInvoice, error) {
+	invoices, err := ias.invoiceDomainSvc.GetInvoices(query.InvoiceState, pagination)
+	if err != nil {
+		return nil, err
+	}
+	replyInvoices := make([]*reply.OrderInvoice, 0, len(invoices))
+	if err = util.CopyProperties(&replyInvoices, &invoices); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyInvoices, nil
+}
+
+// IssueInvoice 管理后台开具发票
+func (ias *InvoiceAppSvc) IssueInvoice(invoiceNo string) error {
+	return ias.invoiceDomainSvc.IssueInvoice(invoiceNo)
+}
+
+// RedFlushInvoice 管理后台红冲发票
+func (ias *InvoiceAppSvc) RedFlushInvoice(invoiceNo string) error {
+	return ias.invoiceDomainSvc.RedFlushInvoice(invoiceNo)
+}
@@ -2,14 +2,25 @@ package appservice
 
 import (
 	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/WoWBytePaladin/go-mall/api/reply"
 	"github.com/WoWBytePaladin/go-mall/api/request"
 	"github.com/WoWBytePaladin/go-mall/common/app"
 	"github.com/WoWBytePaladin/go-mall/common/enum"
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
 	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
 	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/samber/lo"
 )
 
 type OrderAppSvc struct {
@@ -46,15 +57,46 @@ func (oas *OrderAppSvc) CreateOrder(orderRequest *request.OrderCreate, userId in
 	return orderReply, nil
 }
 
+// CreateDirectOrder 立即购买, 不经过购物车直接创建订单
+func (oas *OrderAppSvc) CreateDirectOrder(buyRequest *request.OrderBuyNow, userId int64) (*reply.OrderCreateReply, error) {
+	userDomainSvc := domainservice.NewUserDomainSvc(oas.ctx)
+	address, err := userDomainSvc.GetUserSingleAddress(userId, buyRequest.UserAddressId)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := oas.orderDomainSvc.CreateDirectOrder(buyRequest.CommodityId, buyRequest.CommodityNum, address)
+	if err != nil {
+		return nil, err
+	}
+	orderReply := new(reply.OrderCreateReply)
+	orderReply.OrderNo = order.OrderNo
+	return orderReply, nil
+}
+
 // GetUserOrders 查询用户订单
-func (oas *OrderAppSvc) GetUserOrders(userId int64, pagination *app.Pagination) ([]*reply.Order, error) {
-	orders, err := oas.orderDomainSvc.GetUserOrders(userId, pagination)
+func (oas *OrderAppSvc) GetUserOrders(userId int64, query *request.UserOrderQuery, pagination *app.Pagination) ([]*reply.Order, error) {
+	orders, err := oas.orderDomainSvc.GetUserOrders(userId, query.FrontStatus, pagination)
+	if err != nil {
+		return nil, err
+	}
+	return newUserOrdersReply(orders)
+}
+
+// GetUserDeletedOrders 查询用户删除了的订单
+func (oas *OrderAppSvc) GetUserDeletedOrders(userId int64, pagination *app.Pagination) ([]*reply.Order, error) {
+	orders, err := oas.orderDomainSvc.GetUserDeletedOrders(userId, pagination)
 	if err != nil {
 		return nil, err
 	}
+	return newUserOrdersReply(orders)
+}
+
+// newUserOrdersReply 把订单转换成用户订单列表的响应, 收货人信息脱敏
+func newUserOrdersReply(orders []*do.Order) ([]*reply.Order, error) {
 	replyOrders := make([]*reply.Order, 0, len(orders))
 
-	if err = util.CopyProperties(&replyOrders, &orders); err != nil {
+	if err := util.CopyProperties(&replyOrders, &orders); err != nil {
 		return nil, errcode.ErrCoverData.WithCause(err)
 	}
 
@@ -67,6 +109,17 @@ func (oas *OrderAppSvc) GetUserOrders(userId int64, pagination *app.Pagination)
 	return replyOrders, nil
 }
 
+// GetUserOrderTabCounts 用户订单列表每个标签页的订单数
+func (oas *OrderAppSvc) GetUserOrderTabCounts(userId int64) ([]*reply.OrderTabCount, error) {
+	frontCounts, err := oas.orderDomainSvc.CountUserOrdersByFrontStatus(userId)
+	if err != nil {
+		return nil, err
+	}
+	return lo.Map(enum.OrderFrontStatusTabs, func(frontStatus string, _ int) *reply.OrderTabCount {
+		return &reply.OrderTabCount{Status: frontStatus, Count: frontCounts[frontStatus]}
+	}), nil
+}
+
 // GetOrderInfo 订单详情
 func (oas *OrderAppSvc) GetOrderInfo(orderNo string, userId int64) (*reply.Order, error) {
 	order, err := oas.orderDomainSvc.GetSpecifiedUserOrder(orderNo, userId)
@@ -74,6 +127,11 @@ func (oas *OrderAppSvc) GetOrderInfo(orderNo string, userId int64) (*reply.Order
 		return nil, err
 	}
 
+	// 拆单的父订单和子订单
+	if err = oas.orderDomainSvc.FillOrderFamily(order); err != nil {
+		return nil, err
+	}
+
 	replyOrder := new(reply.Order)
 	if err = util.CopyProperties(replyOrder, order); err != nil {
 		return nil, errcode.ErrCoverData.WithCause(err)
@@ -83,6 +141,23 @@ func (oas *OrderAppSvc) GetOrderInfo(orderNo string, userId int64) (*reply.Order
 	// 敏感信息脱敏
 	replyOrder.Address.UserName = util.MaskRealName(replyOrder.Address.UserName)
 	replyOrder.Address.UserPhone = util.MaskPhone(replyOrder.Address.UserPhone)
+	for _, subOrder := range replyOrder.SubOrders {
+		subOrder.FrontStatus = enum.OrderFrontStatus[subOrder.OrderStatus]
+		subOrder.Address.UserName = replyOrder.Address.UserName
+		subOrder.Address.UserPhone = replyOrder.Address.UserPhone
+	}
+	// 订单状态变更的时间线
+	statusLogs, err := domainservice.NewOrderStateMachine(oas.ctx).GetOrderStatusLogs(order.ID)
+	if err != nil {
+		return nil, err
+	}
+	replyOrder.Timeline = make([]*reply.OrderTimelineNode, 0, len(statusLogs))
+	if err = util.CopyProperties(&replyOrder.Timeline, &statusLogs); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	for i, statusLog := range statusLogs {
+		replyOrder.Timeline[i].Status = enum.OrderFrontStatus[statusLog.ToStatus]
+	}
 
 	return replyOrder, nil
 }
@@ -92,15 +167,379 @@ func (oas *OrderAppSvc) CancelOrder(orderNo string, userId int64) error {
 	return oas.orderDomainSvc.CancelUserOrder(orderNo, userId)
 }
 
+// ConfirmOrderReceipt 用户确认收货
+func (oas *OrderAppSvc) ConfirmOrderReceipt(orderNo string, userId int64) error {
+	return oas.orderDomainSvc.ConfirmOrderReceipt(orderNo, userId)
+}
+
+// DeleteOrder 用户删除订单
+func (oas *OrderAppSvc) DeleteOrder(orderNo string, userId int64) error {
+	return oas.orderDomainSvc.DeleteUserOrder(orderNo, userId)
+}
+
+// RestoreOrder 用户恢复删除了的订单
+func (oas *OrderAppSvc) RestoreOrder(orderNo string, userId int64) error {
+	return oas.orderDomainSvc.RestoreUserOrder(orderNo, userId)
+}
+
+// BuyAgain 再次购买, 把订单中还能购买的商品重新加入购物车
+func (oas *OrderAppSvc) BuyAgain(orderNo string, userId int64) (*reply.OrderBuyAgain, error) {
+	result, err := oas.orderDomainSvc.BuyAgain(orderNo, userId)
+	if err != nil {
+		return nil, err
+	}
+	replyResult := new(reply.OrderBuyAgain)
+	if err = util.CopyProperties(replyResult, result); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyResult, nil
+}
+
 // OrderCreatePay 订单发起支付
 func (oas *OrderAppSvc) OrderCreatePay(payRequest *request.OrderPayCreate, userId int64) (replyData interface{}, err error) {
 	switch payRequest.PayType {
-	case enum.PayTypeWxPay: // 使用微信支付
-		payInfo, err := oas.orderDomainSvc.CreteOrderWxPay(payRequest.OrderNo, userId)
-		return payInfo, err
+	case enum.PayTypeWxPay, enum.PayTypeAliPay, enum.PayTypeSandbox: // 所有支付方式都通过支付模版发起支付
+		payTemplate, err := domainservice.NewOrderPayTemplate(oas.ctx, userId, payRequest.OrderNo,
+			payRequest.PayScene, payRequest.ClientIp, payRequest.PayType)
+		if err != nil {
+			return nil, err
+		}
+		return payTemplate.CreateOrderPay()
 	default:
 		err = errcode.ErrParams
 	}
 
 	return
 }
+
+// WxPayNotify 处理微信支付结果通知
+func (oas *OrderAppSvc) WxPayNotify(notifyRequest *request.WxPayNotifyRequest, rawBody string) error {
+	return oas.orderDomainSvc.HandleWxPayNotify(notifyRequest.Header.Serial, notifyRequest.Header.Timestamp, notifyRequest.Header.Nonce,
+		notifyRequest.Header.Signature, rawBody)
+}
+
+// AliPayNotify 处理支付宝异步通知
+func (oas *OrderAppSvc) AliPayNotify(form url.Values) error {
+	return oas.orderDomainSvc.HandleAliPayNotify(form)
+}
+
+// SimulateSandboxPay 模拟沙箱支付的支付结果
+func (oas *OrderAppSvc) SimulateSandboxPay(simulateRequest *request.SandboxPaySimulate, userId int64) error {
+	return oas.orderDomainSvc.SimulateSandboxPay(simulateRequest.OrderNo, userId, simulateRequest.Result,
+		time.Duration(simulateRequest.DelaySeconds)*time.Second)
+}
+
+// ApplyOrderRefund 用户申请退款
+func (oas *OrderAppSvc) ApplyOrderRefund(refundRequest *request.OrderRefundApply, userId int64) (*reply.OrderRefund, error) {
+	refundItems := make([]*do.OrderRefundItem, 0, len(refundRequest.Items))
+	if err := util.CopyProperties(&refundItems, &refundRequest.Items); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	refund, err := oas.orderDomainSvc.ApplyOrderRefund(refundRequest.OrderNo, userId, refundRequest.Reason, refundItems)
+	if err != nil {
+		return nil, err
+	}
+	replyRefund := new(reply.OrderRefund)
+	if err = util.CopyProperties(replyRefund, refund); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyRefund, nil
+}
+
+// GetOrderRefunds 查询订单的退款申请
+func (oas *OrderAppSvc) GetOrderRefunds(orderNo string, userId int64) ([]*reply.OrderRefund, error) {
+	refunds, err := oas.orderDomainSvc.GetUserOrderRefunds(orderNo, userId)
+	if err != nil {
+		return nil, err
+	}
+	replyRefunds := make([]*reply.OrderRefund, 0, len(refunds))
+	if err = util.CopyProperties(&replyRefunds, &refunds); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyRefunds, nil
+}
+
+// AuditOrderRefund 审核退款申请, 审核通过后向支付平台发起退款
+func (oas *OrderAppSvc) AuditOrderRefund(refundNo string, auditRequest *request.OrderRefundAudit) error {
+	if auditRequest.Approved {
+		return oas.orderDomainSvc.ApproveOrderRefund(refundNo, auditRequest.AuditRemark)
+	}
+	return oas.orderDomainSvc.RejectOrderRefund(refundNo, auditRequest.AuditRemark)
+}
+
+// WxRefundNotify 处理微信退款结果通知
+func (oas *OrderAppSvc) WxRefundNotify(notifyRequest *request.WxPayNotifyRequest, rawBody string) error {
+	return oas.orderDomainSvc.HandleWxRefundNotify(notifyRequest.Header.Serial, notifyRequest.Header.Timestamp, notifyRequest.Header.Nonce,
+		notifyRequest.Header.Signature, rawBody)
+}
+
+// ReconcileStuckOrderPays 对账迟迟没有收到支付结果的订单
+func (oas *OrderAppSvc) ReconcileStuckOrderPays(threshold time.Duration) (int, error) {
+	return oas.orderDomainSvc.ReconcileStuckOrderPays(threshold, 100)
+}
+
+// ReconcileStuckOrderRefunds 对账迟迟没有拿到退款结果的退款申请
+func (oas *OrderAppSvc) ReconcileStuckOrderRefunds(threshold time.Duration) (int, error) {
+	return oas.orderDomainSvc.ReconcileStuckOrderRefunds(threshold, 100)
+}
+
+// CloseExpiredUnpaidOrders 关闭超时未支付的订单
+func (oas *OrderAppSvc) CloseExpiredUnpaidOrders() (int, error) {
+	return oas.orderDomainSvc.CloseExpiredUnpaidOrders(100)
+}
+
+// SweepExpiredUnpaidOrders 兜底关闭支付截止时间过去超过 grace 仍未关闭的订单
+func (oas *OrderAppSvc) SweepExpiredUnpaidOrders(grace time.Duration) (int, error) {
+	return oas.orderDomainSvc.SweepExpiredUnpaidOrders(grace, 100)
+}
+
+// AutoConfirmDeliveredOrders 为送达后超过期限仍未确认收货的订单自动确认收货
+func (oas *OrderAppSvc) AutoConfirmDeliveredOrders() (int, error) {
+	return oas.orderDomainSvc.AutoConfirmDeliveredOrders(100)
+}
+
+// CompleteConfirmedOrders 完成已过评价期的订单
+func (oas *OrderAppSvc) CompleteConfirmedOrders() (int, error) {
+	return oas.orderDomainSvc.CompleteConfirmedOrders(100)
+}
+
+// ReconcileWxTradeBill 核对指定日期的微信支付交易账单
+// @param billDate 账单日期 YYYY-MM-DD
+func (oas *OrderAppSvc) ReconcileWxTradeBill(billDate string) ([]*reply.PayReconcileDiff, error) {
+	date, err := time.ParseInLocation(enum.TimeFormatHyphenedYMD, billDate, time.Local)
+	if err != nil {
+		return nil, errcode.ErrParams.WithCause(err)
+	}
+	diffs, err := oas.orderDomainSvc.ReconcileWxTradeBill(date)
+	if err != nil {
+		return nil, err
+	}
+	replyDiffs := make([]*reply.PayReconcileDiff, 0, len(diffs))
+	if err = util.CopyProperties(&replyDiffs, &diffs); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyDiffs, nil
+}
+
+// ReconcileDailyWxTradeBill 每天定时核对前一天的微信支付交易账单
+func (oas *OrderAppSvc) ReconcileDailyWxTradeBill() (bool, error) {
+	return oas.orderDomainSvc.ReconcileDailyWxTradeBill(time.Now())
+}
+
+// GetReconcileDiffs 分页查询对账差异
+func (oas *OrderAppSvc) GetReconcileDiffs(query *request.PayReconcileDiffQuery, pagination *app.Pagination) ([]*reply.PayReconcileDiff, error) {
+	diffs, err := oas.orderDomainSvc.GetReconcileDiffs(query.BillDate, query.DiffType, pagination)
+	if err != nil {
+		return nil, err
+	}
+	replyDiffs := make([]*reply.PayReconcileDiff, 0, len(diffs))
+	if err = util.CopyProperties(&replyDiffs, &diffs); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return replyDiffs, nil
+}
+
+// PickOrders 批量把订单标记为检货完成
+func (oas *OrderAppSvc) PickOrders(batchRequest *request.OrderBatch) *reply.OrderBatchResult {
+	return oas.batchOrders(batchRequest.OrderNos, oas.orderDomainSvc.PickOrder)
+}
+
+// ShipOrders 批量发货
+func (oas *OrderAppSvc) ShipOrders(shipRequest *request.OrderShipBatch) (*reply.OrderBatchResult, error) {
+	shipments := make([]*do.OrderShipment, 0, len(shipRequest.Shipments))
+	if err := util.CopyProperties(&shipments, &shipRequest.Shipments); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	shipmentMap := lo.KeyBy(shipments, func(shipment *do.OrderShipment) string {
+		return shipment.OrderNo
+	})
+	orderNos := lo.Map(shipments, func(shipment *do.OrderShipment, _ int) string {
+		return shipment.OrderNo
+	})
+	return oas.batchOrders(orderNos, func(orderNo string) error {
+		return oas.orderDomainSvc.ShipOrder(shipmentMap[orderNo])
+	}), nil
+}
+
+// StartOrdersDelivery 批量把订单标记为派送中
+func (oas *OrderAppSvc) StartOrdersDelivery(batchRequest *request.OrderBatch) *reply.OrderBatchResult {
+	return oas.batchOrders(batchRequest.OrderNos, oas.orderDomainSvc.StartOrderDelivery)
+}
+
+// DeliverOrders 批量把订单标记为已送达
+func (oas *OrderAppSvc) DeliverOrders(batchRequest *request.OrderBatch) *reply.OrderBatchResult {
+	return oas.batchOrders(batchRequest.OrderNos, oas.orderDomainSvc.DeliverOrder)
+}
+
+// batchOrders 逐个处理批量操作中的订单, 重复的订单号只处理一次
+// 预定义的业务错误把错误码和信息返回给管理后台, 其他错误记录日志后按服务器错误返回
+func (oas *OrderAppSvc) batchOrders(orderNos []string, handle func(orderNo string) error) *reply.OrderBatchResult {
+	result := &reply.OrderBatchResult{
+		Succeeded: make([]string, 0, len(orderNos)),
+		Failed:    make([]*reply.OrderBatchFailure, 0),
+	}
+	for _, orderNo := range lo.Uniq(orderNos) {
+		err := handle(orderNo)
+		if err == nil {
+			result.Succeeded = append(result.Succeeded, orderNo)
+			continue
+		}
+		appErr := new(errcode.AppError)
+		if !errors.As(err, &appErr) || appErr.Code() < 0 {
+			logger.New(oas.ctx).Error("BatchOrdersError", "err", err, "orderNo", orderNo)
+			appErr = errcode.ErrServer
+		}
+		result.Failed = append(result.Failed, &reply.OrderBatchFailure{
+			OrderNo: orderNo,
+			Code:    appErr.Code(),
+			Msg:     appErr.Msg(),
+		})
+	}
+	return result
+}
+
+// SearchOrders 管理后台按条件分页查询订单
+func (oas *OrderAppSvc) SearchOrders(queryRequest *request.AdminOrderQuery, pagination *app.Pagination) ([]*reply.AdminOrder, error) {
+	query, err := newOrderQuery(queryRequest)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := oas.orderDomainSvc.SearchOrders(query, pagination)
+	if err != nil {
+		return nil, err
+	}
+	replyOrders := make([]*reply.AdminOrder, 0, len(orders))
+	if err = util.CopyProperties(&replyOrders, &orders); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	for _, replyOrder := range replyOrders {
+		replyOrder.FrontStatus = enum.OrderFrontStatus[replyOrder.OrderStatus]
+		replyOrder.Address.UserPhone = util.MaskPhone(replyOrder.Address.UserPhone)
+	}
+	return replyOrders, nil
+}
+
+// orderExportCsvHeader 导出订单的CSV表头, 每个购物明细一行, 订单和收货信息在每行重复
+// 拆单的父订单和子订单都会导出, 统计金额时按订单类型区分, 避免重复计算
+var orderExportCsvHeader = []string{
+	"订单号", "订单类型", "用户ID", "下单时间", "订单状态", "支付状态", "支付方式", "支付交易号", "订单金额(分)", "支付金额(分)", "支付时间",
+	"收货人", "收货人手机号", "收货地址", "物流公司", "物流单号",
+	"商品ID", "商品名称", "商品单价(分)", "商品数量",
+}
+
+// ExportOrdersCsv 按条件导出订单到CSV, 边查询边写入 w, 收货人手机号脱敏, 单元格内容做了防公式注入处理
+// 查询条件有误或者第一批订单查询失败时返回错误, 不写入任何内容
+func (oas *OrderAppSvc) ExportOrdersCsv(queryRequest *request.AdminOrderQuery, w io.Writer) error {
+	query, err := newOrderQuery(queryRequest)
+	if err != nil {
+		return err
+	}
+	csvWriter := csv.NewWriter(w)
+	writeRow := func(row []string) error {
+		return csvWriter.Write(lo.Map(row, func(cell string, _ int) string {
+			return escapeCsvFormula(cell)
+		}))
+	}
+	headerWritten := false
+	// 查询出第一批订单后再写入表头, 查询失败时调用方还可以返回错误响应
+	writeHeader := func() error {
+		headerWritten = true
+		// 写入 UTF-8 BOM, 用 Excel 打开时中文不会乱码
+		if _, err := io.WriteString(w, "\xEF\xBB\xBF"); err != nil {
+			return err
+		}
+		return csvWriter.Write(orderExportCsvHeader)
+	}
+	err = oas.orderDomainSvc.ExportOrders(query, func(orders []*do.Order) error {
+		if !headerWritten {
+			if err := writeHeader(); err != nil {
+				return err
+			}
+		}
+		for _, order := range orders {
+			orderColumns := []string{
+				order.OrderNo, enum.OrderTypeNames[order.OrderType], strconv.FormatInt(order.UserId, 10), order.CreatedAt.Format(enum.TimeFormatHyphenedYMDHIS),
+				enum.OrderFrontStatus[order.OrderStatus], strconv.Itoa(order.PayState), strconv.Itoa(order.PayType),
+				order.PayTransId, strconv.Itoa(order.BillMoney), strconv.Itoa(order.PayMoney), formatOrderTime(order.PaidAt),
+				order.Address.UserName, util.MaskPhone(order.Address.UserPhone),
+				order.Address.ProvinceName + order.Address.CityName + order.Address.RegionName + order.Address.DetailAddress,
+				order.ShipCarrier, order.TrackingNo,
+			}
+			if len(order.Items) == 0 {
+				if err := writeRow(append(orderColumns, "", "", "", "")); err != nil {
+					return err
+				}
+				continue
+			}
+			for _, item := range order.Items {
+				itemColumns := []string{
+					strconv.FormatInt(item.CommodityId, 10), item.CommodityName,
+					strconv.Itoa(item.CommoditySellingPrice), strconv.Itoa(item.CommodityNum),
+				}
+				if err := writeRow(append(slices.Clone(orderColumns), itemColumns...)); err != nil {
+					return err
+				}
+			}
+		}
+		// 每批订单写完后把缓冲的内容发送出去
+		csvWriter.Flush()
+		return csvWriter.Error()
+	})
+	if err != nil || headerWritten {
+		return err
+	}
+	// 没有符合条件的订单, 只输出表头
+	if err = writeHeader(); err != nil {
+		return err
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// newOrderQuery 把管理后台的查询请求转换成订单查询条件
+func newOrderQuery(queryRequest *request.AdminOrderQuery) (*do.OrderQuery, error) {
+	query := &do.OrderQuery{
+		OrderNo:     queryRequest.OrderNo,
+		UserId:      queryRequest.UserId,
+		UserPhone:   queryRequest.UserPhone,
+		OrderStatus: queryRequest.OrderStatus,
+		PayState:    queryRequest.PayState,
+		PayType:     queryRequest.PayType,
+	}
+	if queryRequest.StartDate != "" {
+		startDate, err := time.ParseInLocation(enum.TimeFormatHyphenedYMD, queryRequest.StartDate, time.Local)
+		if err != nil {
+			return nil, errcode.ErrParams.WithCause(err)
+		}
+		query.CreatedStart = startDate
+	}
+	if queryRequest.EndDate != "" {
+		endDate, err := time.ParseInLocation(enum.TimeFormatHyphenedYMD, queryRequest.EndDate, time.Local)
+		if err != nil {
+			return nil, errcode.ErrParams.WithCause(err)
+		}
+		// 结束日期当天的订单也要包含
+		query.CreatedEnd = endDate.AddDate(0, 0, 1)
+	}
+	if !query.CreatedStart.IsZero() && !query.CreatedEnd.IsZero() && !query.CreatedStart.Before(query.CreatedEnd) {
+		return nil, errcode.ErrParams.WithCause(errors.New("下单日期的开始不能晚于结束"))
+	}
+	return query, nil
+}
+
+// escapeCsvFormula 以 = + - @ 或者制表符、回车开头的单元格会被 Excel 等表格软件当成公式执行,
+// 在前面加上单引号让它按文本显示. 商品名称、收货地址等内容来自用户输入, 防止导出的文件被注入恶意公式
+func escapeCsvFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// formatOrderTime 格式化订单上的时间, 没有发生的时间(1970-01-01)返回空字符串
+func formatOrderTime(t time.Time) string {
+	if t.Year() <= 1970 {
+		return ""
+	}
+	return t.Format(enum.TimeFormatHyphenedYMDHIS)
+}
@@ -12,6 +12,8 @@ type ShoppingCartItem struct {
 	CommodityImg          string // 商品图片
 	CommoditySellingPrice int    // 商品售价
 	CommodityNum          int    // 商品数量
+	MerchantId            int64  // 商品所属的商家ID
+	WarehouseId           int64  // 商品的发货仓库ID
 	CreatedAt             time.Time
 	UpdatedAt             time.Time
 }
@@ -30,6 +32,8 @@ type CartBillInfo struct {
 		DiscountMoney int
 		Threshold     int // 使用门槛, 比如满1000 可用
 	}
+	CouponMoney        int // 实际使用的优惠券减免金额, 不满足使用门槛时为0
+	DiscountMoney      int // 实际使用的满减金额, 不满足使用门槛时为0
 	VipDiscountMoney   int // VIP减免的金额
 	OriginalTotalPrice int // 减免、优惠前的总金额
 	TotalPrice         int // 实际要支付的总金额
@@ -39,6 +39,8 @@ type Commodity struct {
 	OriginalPrice int       `json:"original_price"`
 	SellingPrice  int       `json:"selling_price"`
 	StockNum      int       `json:"stock_num"`
+	ReservedNum   int       `json:"reserved_num"`
+	SoldNum       int       `json:"sold_num"`
 	Tag           string    `json:"tag"`
 	SellStatus    int       `json:"sell_status"`
 	CreatedAt     time.Time `json:"created_at"`
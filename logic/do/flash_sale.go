@@ -0,0 +1,36 @@
+package do
+
+import "time"
+
+// FlashSale 秒杀活动
+type FlashSale struct {
+	ID           int64
+	Name         string
+	CommodityId  int64
+	FlashPrice   int
+	TotalStock   int
+	SoldNum      int
+	PerUserLimit int
+	StartTime    time.Time
+	EndTime      time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// FlashSaleOrder 秒杀下单请求, 抢到库存后进入下单队列, 由后台任务异步创建订单
+type FlashSaleOrder struct {
+	FlashSaleId   int64     `json:"flash_sale_id"`
+	UserId        int64     `json:"user_id"`
+	OrderNo       string    `json:"order_no"` // 排队时预先生成的订单号, 用户用它查询下单结果
+	CommodityNum  int       `json:"commodity_num"`
+	UserAddressId int64     `json:"user_address_id"`
+	QueuedAt      time.Time `json:"queued_at"`
+}
+
+// FlashSaleOrderResult 秒杀下单的排队结果
+type FlashSaleOrderResult struct {
+	OrderNo    string `json:"order_no"`
+	UserId     int64  `json:"user_id"`
+	State      int    `json:"state"`       // 1-排队中 2-下单成功 3-下单失败
+	FailReason string `json:"fail_reason"` // 下单失败的原因
+}
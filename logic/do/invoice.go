@@ -0,0 +1,49 @@
+package do
+
+import "time"
+
+// InvoiceTitle 发票抬头
+type InvoiceTitle struct {
+	ID        int64
+	UserId    int64
+	TitleType int
+	Title     string
+	TaxNo     string
+	CreatedAt time.Time
+}
+
+// OrderInvoice 订单的开票记录
+type OrderInvoice struct {
+	ID               int64
+	InvoiceNo        string
+	OrderId          int64
+	OrderNo          string
+	UserId           int64
+	TitleType        int
+	Title            string
+	TaxNo            string
+	Amount           int
+	InvoiceState     int
+	InvoiceNumber    string
+	PdfPath          string
+	RedInvoiceNumber string
+	RedPdfPath       string
+	Items            []*InvoiceItem // 发票上的商品明细, 开票时按订单计算, 不单独存储
+	IssuedAt         time.Time
+	RedFlushedAt     time.Time
+	CreatedAt        time.Time
+}
+
+// InvoiceItem 发票上的一行商品明细
+type InvoiceItem struct {
+	CommodityName string
+	CommodityNum  int
+	Amount        int // 这行商品的开票金额（分）
+}
+
+// InvoiceIssueResult 开票平台开具发票或者红字发票的结果
+type InvoiceIssueResult struct {
+	InvoiceNumber string    // 发票号码
+	PdfPath       string    // 发票PDF文件
+	IssuedAt      time.Time // 开票时间
+}
@@ -5,20 +5,40 @@ import (
 )
 
 type Order struct {
-	ID          int64
-	OrderNo     string
-	PayTransId  string
-	PayType     int
-	UserId      int64
-	BillMoney   int
-	PayMoney    int
-	PayState    int
-	OrderStatus int
-	Address     *OrderAddress
-	Items       []*OrderItem
-	PaidAt      time.Time
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID         int64
+	OrderNo    string
+	PayTransId string
+	PayType    int
+	UserId     int64
+	BillMoney  int
+	PayMoney   int
+	// 订单的价格明细: BillMoney - CouponMoney - DiscountMoney - VipDiscountMoney = PayMoney
+	CouponId         int64
+	CouponMoney      int
+	DiscountId       int64
+	DiscountMoney    int
+	VipDiscountMoney int
+	PayState         int
+	OrderStatus      int
+	Address          *OrderAddress
+	Items            []*OrderItem
+	PayDeadline      time.Time
+	PaidAt           time.Time
+	ShipCarrier      string
+	TrackingNo       string
+	ShippedAt        time.Time
+	DeliveredAt      time.Time
+	ConfirmedAt      time.Time
+	OrderType        int
+	ParentId         int64
+	MerchantId       int64
+	WarehouseId      int64
+	FlashSaleId      int64    // 秒杀活动ID, 不是秒杀订单时为0
+	IsDel            int      // 用户是否删除了订单 0-未删除 1-已删除
+	ParentOrderNo    string   // 子订单的父订单号, 只在订单详情中查询
+	SubOrders        []*Order // 父订单拆分出的子订单, 只在订单详情中查询
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
 }
 
 type OrderAddress struct {
@@ -40,6 +60,19 @@ type OrderItem struct {
 	CommodityImg          string
 	CommoditySellingPrice int
 	CommodityNum          int
+	DiscountMoney         int // 分摊到购物项的优惠金额
+	PaidMoney             int // 购物项的实付金额
+}
+
+// OrderPayResult 支付平台返回的订单支付结果
+// 支付结果通知和主动查询到的支付结果都先转换成它, 再用同一个流程去结算订单
+type OrderPayResult struct {
+	OrderNo    string    // 业务订单号
+	PayType    int       // 支付方式
+	PayTransId string    // 支付平台的交易ID
+	PayMoney   int       // 用户实际支付的金额(分)
+	PayState   int       // 支付结果 enum.PayStatePaid | enum.PayStatePayFailed
+	PaidAt     time.Time // 支付完成时间
 }
 
 func OrderNew() *Order {
@@ -47,3 +80,66 @@ func OrderNew() *Order {
 	order.Address = new(OrderAddress) // 内嵌的Pointer字段不自己初始化会是 nil, 无法用 util.CopyProperties 来拷贝属性值
 	return order
 }
+
+// SandboxPayTransaction 沙箱支付创建的模拟交易
+type SandboxPayTransaction struct {
+	OrderNo    string    // 业务订单号
+	PayTransId string    // 模拟的支付平台交易ID
+	PayMoney   int       // 需要支付的金额(分)
+	CreatedAt  time.Time // 交易创建时间
+}
+
+// OrderStatusLog 订单状态变更记录
+type OrderStatusLog struct {
+	OrderId    int64
+	OrderNo    string
+	Event      string
+	FromStatus int
+	ToStatus   int
+	Actor      int
+	ActorId    int64
+	Remark     string
+	CreatedAt  time.Time
+}
+
+// OrderShipment 订单的发货信息
+type OrderShipment struct {
+	OrderNo     string
+	CarrierCode string // 物流公司编码
+	TrackingNo  string // 物流单号
+}
+
+// OrderQuery 管理后台查询订单的条件, 零值或 nil 的条件不参与查询
+type OrderQuery struct {
+	OrderNo      string
+	UserId       int64
+	UserPhone    string    // 收货人手机号
+	CreatedStart time.Time // 下单时间的开始, 包含
+	CreatedEnd   time.Time // 下单时间的结束, 不包含
+	OrderStatus  *int
+	PayState     *int
+	PayType      *int
+}
+
+// BuyAgainResult 再次购买的结果
+type BuyAgainResult struct {
+	AddedItems        []*OrderItem            // 加入购物车的商品
+	SkippedItems      []*BuyAgainSkippedItem  // 没有加入购物车的商品
+	PriceChangedItems []*BuyAgainPriceChanged // 价格与原订单不同的商品
+}
+
+// BuyAgainSkippedItem 再次购买时没有加入购物车的订单商品
+type BuyAgainSkippedItem struct {
+	CommodityId   int64
+	CommodityName string
+	CommodityNum  int
+	Reason        string // 跳过的原因 enum.BuyAgainSkipXxx
+}
+
+// BuyAgainPriceChanged 再次购买时价格发生变化的商品
+type BuyAgainPriceChanged struct {
+	CommodityId   int64
+	CommodityName string
+	OrderedPrice  int // 原订单中的价格
+	CurrentPrice  int // 现在的售价
+}
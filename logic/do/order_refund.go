@@ -0,0 +1,38 @@
+package do
+
+import "time"
+
+type OrderRefund struct {
+	ID            int64
+	RefundNo      string
+	OrderId       int64
+	OrderNo       string
+	UserId        int64
+	PayType       int
+	RefundMoney   int
+	IsFullRefund  bool
+	Reason        string
+	AuditRemark   string
+	RefundState   int
+	RefundTransId string
+	Items         []*OrderRefundItem
+	RefundedAt    time.Time
+	CreatedAt     time.Time
+}
+
+type OrderRefundItem struct {
+	RefundId     int64
+	OrderId      int64
+	CommodityId  int64
+	CommodityNum int
+	RefundMoney  int
+}
+
+// OrderRefundResult 支付平台返回的退款结果
+// 退款结果通知和发起退款时同步返回的结果都先转换成它, 再用同一个流程去结算退款
+type OrderRefundResult struct {
+	RefundNo      string    // 业务退款单号
+	RefundTransId string    // 支付平台的退款单号
+	RefundState   int       // 退款结果 enum.RefundStateSuccess | enum.RefundStateFailed | enum.RefundStateProcessing
+	RefundedAt    time.Time // 退款成功时间
+}
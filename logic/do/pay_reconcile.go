@@ -0,0 +1,17 @@
+package do
+
+import "time"
+
+// PayReconcileDiff 支付对账发现的差异
+type PayReconcileDiff struct {
+	BillDate        string
+	PayType         int
+	DiffType        int
+	OrderNo         string
+	LocalTransId    string
+	RemoteTransId   string
+	LocalPayMoney   int
+	RemotePayMoney  int
+	RemoteTradeTime time.Time
+	CreatedAt       time.Time
+}
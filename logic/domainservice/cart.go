@@ -44,6 +44,15 @@ func (cds *CartDomainSvc) CartAddItem(cartItem *do.ShoppingCartItem) error {
 	return err
 }
 
+// CartEnsureItems 把多个商品一起加入用户的购物车, 保证购物车中每个商品的数量不少于 CommodityNum
+// 跟 CartAddItem 不同, 已经在购物车中的商品数量不会累加; 任何一个商品加入失败时都不加入
+func (cds *CartDomainSvc) CartEnsureItems(userId int64, cartItems []*do.ShoppingCartItem) error {
+	if err := cds.cartDao.EnsureCartItems(userId, cartItems); err != nil {
+		return errcode.Wrap("CartEnsureItemsError", err)
+	}
+	return nil
+}
+
 // CartUpdateItem 更改购物项
 func (cds *CartDomainSvc) CartUpdateItem(request *request.CartItemUpdate, userId int64) error {
 	cartItemModel, err := cds.cartDao.GetCartItemById(request.ItemId)
@@ -108,6 +117,8 @@ func (cds *CartDomainSvc) fillInCommodityInfo(cartItems []*do.ShoppingCartItem)
 		cartItem.CommodityName = commodityMap[cartItem.CommodityId].Name
 		cartItem.CommodityImg = commodityMap[cartItem.CommodityId].CoverImg
 		cartItem.CommoditySellingPrice = commodityMap[cartItem.CommodityId].SellingPrice
+		cartItem.MerchantId = commodityMap[cartItem.CommodityId].MerchantId
+		cartItem.WarehouseId = commodityMap[cartItem.CommodityId].WarehouseId
 	}
 
 	return nil
@@ -48,25 +48,31 @@ func (cbc *CartBillChecker) GetBill() (*do.CartBillInfo, error) {
 	}
 	// 计算商品使用减免前的总价
 	originalTotalPrice := lo.Reduce(cbc.checkingItems, func(agg int, item *do.ShoppingCartItem, index int) int {
-		return agg + item.CommoditySellingPrice
+		return agg + item.CommoditySellingPrice*item.CommodityNum
 	}, 0)
 
 	// VIP 能减免的金额
 	vipDiscountMoney := int(math.Round(float64(originalTotalPrice) * float64(cbc.VipOffRate) / 100.0))
 
 	totalPrice := originalTotalPrice - vipDiscountMoney
+	couponMoney := 0
 	if cbc.Coupon.Threshold != 0 && originalTotalPrice > cbc.Coupon.Threshold {
 		// 满足优惠卷使用条件
-		totalPrice -= cbc.Coupon.DiscountMoney
+		couponMoney = cbc.Coupon.DiscountMoney
+		totalPrice -= couponMoney
 	}
 
+	discountMoney := 0
 	if cbc.Discount.Threshold != 0 && totalPrice > cbc.Discount.Threshold {
 		// 满足使用满减券
-		totalPrice -= cbc.Discount.DiscountMoney
+		discountMoney = cbc.Discount.DiscountMoney
+		totalPrice -= discountMoney
 	}
 	billInfo := new(do.CartBillInfo)
 	billInfo.Coupon = cbc.Coupon
 	billInfo.Discount = cbc.Discount
+	billInfo.CouponMoney = couponMoney
+	billInfo.DiscountMoney = discountMoney
 	billInfo.VipDiscountMoney = vipDiscountMoney
 	billInfo.TotalPrice = totalPrice
 	billInfo.OriginalTotalPrice = originalTotalPrice
@@ -0,0 +1,362 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// 秒杀
+// 秒杀活动在开始前把活动信息和库存预热到 Redis, 抢购时只在 Redis 里用 Lua 脚本检查限购数量并扣减活动库存,
+// 抢到库存的请求预先生成订单号后进入下单队列, 由后台任务按排队顺序创建订单, 用户用订单号轮询下单结果。
+// 后台任务串行地创建订单, 商品行记录上不会有大量请求同时等锁; 下单失败、订单取消或者超时关闭后, 抢到的库存还给活动。
+// 对账任务定期把 Redis 中的抢购数量同步回 MySQL。
+// 活动的库存在创建活动时就从商品的可售库存里占用出来, 活动结束后对账任务把没有卖出的库存释放回可售库存。
+
+const (
+	flashSalePreloadAhead     = 10 * time.Minute // 提前多久把秒杀活动预热到 Redis
+	flashSaleOrderResultTTL   = 24 * time.Hour   // 秒杀下单结果的保存时间
+	flashSaleReconcileOverdue = time.Hour        // 活动结束后继续对账多久, 让排队中的请求和未支付的订单都处理完
+)
+
+// flashSaleReturnOrderStatus 秒杀订单处于这些状态时, 订单抢到的库存还给秒杀活动
+var flashSaleReturnOrderStatus = []int{enum.OrderStatusUserQuit, enum.OrderStatusUnpaidClose, enum.OrderStatusMerchantClose}
+
+type FlashSaleDomainSvc struct {
+	ctx          context.Context
+	flashSaleDao *dao.FlashSaleDao
+}
+
+func NewFlashSaleDomainSvc(ctx context.Context) *FlashSaleDomainSvc {
+	return &FlashSaleDomainSvc{
+		ctx:          ctx,
+		flashSaleDao: dao.NewFlashSaleDao(ctx),
+	}
+}
+
+// CreateFlashSale 创建秒杀活动并占用活动的库存, 活动快开始时由预热任务把活动加载到 Redis
+func (fss *FlashSaleDomainSvc) CreateFlashSale(flashSale *do.FlashSale) (*do.FlashSale, error) {
+	if !flashSale.EndTime.After(flashSale.StartTime) || !flashSale.EndTime.After(time.Now()) {
+		return nil, errcode.ErrFlashSaleParams.WithCause(errors.New("活动的结束时间要晚于开始时间和当前时间"))
+	}
+	commodity, err := dao.NewCommodityDao(fss.ctx).FindCommodityById(flashSale.CommodityId)
+	if err != nil {
+		return nil, errcode.Wrap("CreateFlashSaleError", err)
+	}
+	if commodity.ID == 0 || commodity.SellStatus != enum.CommoditySellStatusOn {
+		return nil, errcode.ErrCommodityNotExists
+	}
+	if flashSale.FlashPrice >= commodity.SellingPrice {
+		return nil, errcode.ErrFlashSaleParams.WithCause(errors.New("秒杀价要低于商品的售价"))
+	}
+	flashSale.SoldNum = 0
+	// 创建活动时把活动库存从商品的可售库存里占用出来
+	flashSaleModel, err := fss.flashSaleDao.CreateFlashSale(flashSale)
+	if errors.Is(err, errcode.ErrCommodityStockOut) {
+		return nil, errcode.ErrFlashSaleParams.WithCause(errors.New("活动库存不能超过商品的可售库存"))
+	}
+	if err != nil {
+		return nil, errcode.Wrap("CreateFlashSaleError", err)
+	}
+	if err = util.CopyProperties(flashSale, flashSaleModel); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return flashSale, nil
+}
+
+// GetFlashSales 管理后台分页查询秒杀活动
+func (fss *FlashSaleDomainSvc) GetFlashSales(pagination *app.Pagination) ([]*do.FlashSale, error) {
+	flashSaleModels, totalRows, err := fss.flashSaleDao.GetFlashSales(pagination.Offset(), pagination.GetPageSize())
+	if err != nil {
+		return nil, errcode.Wrap("GetFlashSalesError", err)
+	}
+	pagination.SetTotalRows(int(totalRows))
+	flashSales := make([]*do.FlashSale, 0, len(flashSaleModels))
+	if err = util.CopyProperties(&flashSales, &flashSaleModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return flashSales, nil
+}
+
+// GetFlashSale 查询秒杀活动和活动剩余的库存, 活动还没有预热时剩余库存按 MySQL 中的抢购数量计算
+func (fss *FlashSaleDomainSvc) GetFlashSale(flashSaleId int64) (flashSale *do.FlashSale, stock int, err error) {
+	flashSale, err = cache.GetFlashSale(fss.ctx, flashSaleId)
+	if err != nil {
+		return nil, 0, errcode.Wrap("GetFlashSaleError", err)
+	}
+	if flashSale == nil {
+		flashSaleModel, err := fss.flashSaleDao.GetFlashSaleById(flashSaleId)
+		if err != nil {
+			return nil, 0, errcode.Wrap("GetFlashSaleError", err)
+		}
+		if flashSaleModel.ID == 0 {
+			return nil, 0, errcode.ErrFlashSaleNotExists
+		}
+		flashSale = new(do.FlashSale)
+		if err = util.CopyProperties(flashSale, flashSaleModel); err != nil {
+			return nil, 0, errcode.ErrCoverData.WithCause(err)
+		}
+	}
+	stock, loaded, err := cache.GetFlashSaleStock(fss.ctx, flashSaleId)
+	if err != nil {
+		return nil, 0, errcode.Wrap("GetFlashSaleError", err)
+	}
+	if !loaded {
+		stock = flashSale.TotalStock - flashSale.SoldNum
+	}
+	return flashSale, stock, nil
+}
+
+// PreloadFlashSales 把即将开始和正在进行的秒杀活动预热到 Redis, 已经预热过的活动库存不会被重置
+// Redis 中的库存丢失后重新预热时, 按对账同步到 MySQL 的抢购数量恢复库存
+func (fss *FlashSaleDomainSvc) PreloadFlashSales() (int, error) {
+	now := time.Now()
+	flashSaleModels, err := fss.flashSaleDao.GetFlashSalesStartBefore(now.Add(flashSalePreloadAhead), now)
+	if err != nil {
+		return 0, errcode.Wrap("PreloadFlashSalesError", err)
+	}
+	flashSales := make([]*do.FlashSale, 0, len(flashSaleModels))
+	if err = util.CopyProperties(&flashSales, &flashSaleModels); err != nil {
+		return 0, errcode.ErrCoverData.WithCause(err)
+	}
+	for _, flashSale := range flashSales {
+		if err = cache.LoadFlashSale(fss.ctx, flashSale, flashSale.TotalStock-flashSale.SoldNum); err != nil {
+			return 0, errcode.Wrap("PreloadFlashSalesError", err)
+		}
+	}
+	return len(flashSales), nil
+}
+
+// QueueFlashSaleOrder 抢购秒杀商品, 抢到库存后进入下单队列
+// 只读写 Redis, 不在这里创建订单; 返回的订单号用来轮询下单结果
+func (fss *FlashSaleDomainSvc) QueueFlashSaleOrder(flashSaleOrder *do.FlashSaleOrder) (string, error) {
+	flashSale, err := cache.GetFlashSale(fss.ctx, flashSaleOrder.FlashSaleId)
+	if err != nil {
+		return "", errcode.Wrap("QueueFlashSaleOrderError", err)
+	}
+	now := time.Now()
+	if flashSale == nil || now.Before(flashSale.StartTime) || !now.Before(flashSale.EndTime) {
+		// 活动还没有预热说明活动不存在或者离开始还早
+		return "", errcode.ErrFlashSaleNotInTime
+	}
+	// 订单号在扣减库存前生成, 归还库存时用订单号保证同一个请求只归还一次
+	flashSaleOrder.OrderNo, err = NewIdGenDomainSvc(fss.ctx).GenOrderNo()
+	if err != nil {
+		return "", err
+	}
+	err = cache.DeductFlashSaleStock(fss.ctx, flashSale.ID, flashSaleOrder.UserId, flashSaleOrder.CommodityNum, flashSale.PerUserLimit)
+	switch {
+	case errors.Is(err, cache.ErrFlashSaleSoldOut):
+		return "", errcode.ErrFlashSaleSoldOut
+	case errors.Is(err, cache.ErrFlashSaleOverLimit):
+		return "", errcode.ErrFlashSaleOverLimit
+	case errors.Is(err, cache.ErrFlashSaleNotLoaded):
+		return "", errcode.ErrFlashSaleNotInTime
+	case err != nil:
+		return "", errcode.Wrap("QueueFlashSaleOrderError", err)
+	}
+
+	flashSaleOrder.QueuedAt = now
+	// 先保存排队结果再入队, 避免后台任务先创建了订单, 下单结果又被改回排队中
+	err = fss.setOrderResult(flashSaleOrder, enum.FlashSaleOrderStateQueued, "")
+	if err == nil {
+		err = cache.PushFlashSaleOrder(fss.ctx, flashSaleOrder)
+	}
+	if err != nil {
+		// 没能进入下单队列, 把抢到的库存还回去
+		fss.returnStock(flashSaleOrder)
+		return "", errcode.Wrap("QueueFlashSaleOrderError", err)
+	}
+	return flashSaleOrder.OrderNo, nil
+}
+
+// GetFlashSaleOrderResult 查询秒杀下单的排队结果, 结果过期后按订单是否存在返回下单结果
+func (fss *FlashSaleDomainSvc) GetFlashSaleOrderResult(orderNo string, userId int64) (*do.FlashSaleOrderResult, error) {
+	result, err := cache.GetFlashSaleOrderResult(fss.ctx, orderNo)
+	if err != nil {
+		return nil, errcode.Wrap("GetFlashSaleOrderResultError", err)
+	}
+	if result != nil {
+		if result.UserId != userId {
+			return nil, errcode.ErrOrderNotExists
+		}
+		return result, nil
+	}
+	order, err := dao.NewOrderDao(fss.ctx).GetOrderByNo(orderNo)
+	if err != nil {
+		return nil, errcode.Wrap("GetFlashSaleOrderResultError", err)
+	}
+	if order.ID == 0 || order.UserId != userId || order.FlashSaleId == 0 {
+		return nil, errcode.ErrOrderNotExists
+	}
+	return &do.FlashSaleOrderResult{OrderNo: orderNo, UserId: userId, State: enum.FlashSaleOrderStateCreated}, nil
+}
+
+// CreateQueuedOrders 按排队顺序为下单队列中的请求创建订单, 每次最多处理 limit 个请求
+// 请求处理完后才从处理中列表里删除, 服务中途崩溃时没有处理完的请求由 RequeueStuckOrders 放回下单队列
+// @return created 创建成功的订单数
+func (fss *FlashSaleDomainSvc) CreateQueuedOrders(limit int) (created int, err error) {
+	for i := 0; i < limit; i++ {
+		flashSaleOrder, claimed, err := cache.ClaimFlashSaleOrder(fss.ctx)
+		if err != nil && claimed == "" {
+			return created, errcode.Wrap("CreateQueuedFlashSaleOrdersError", err)
+		}
+		if claimed == "" { // 队列已经空了
+			break
+		}
+		if err != nil {
+			// 解析不了的请求重试也没用, 记录日志后丢弃
+			logger.New(fss.ctx).Error("CreateQueuedFlashSaleOrdersError", "err", err, "flashSaleOrder", claimed)
+		} else if fss.createQueuedOrder(flashSaleOrder) {
+			created++
+		}
+		if err = cache.AckFlashSaleOrder(fss.ctx, claimed); err != nil {
+			return created, errcode.Wrap("CreateQueuedFlashSaleOrdersError", err)
+		}
+	}
+	return created, nil
+}
+
+// RequeueStuckOrders 把取出后超过 timeout 仍没有处理完的下单请求放回下单队列
+// @return requeued 放回下单队列的请求数
+func (fss *FlashSaleDomainSvc) RequeueStuckOrders(timeout time.Duration) (int, error) {
+	requeued, err := cache.RequeueStuckFlashSaleOrders(fss.ctx, timeout)
+	if err != nil {
+		return requeued, errcode.Wrap("RequeueStuckFlashSaleOrdersError", err)
+	}
+	return requeued, nil
+}
+
+// createQueuedOrder 为一个排队的请求创建订单, 下单失败时把抢到的库存还给活动
+// 订单号是排队时生成的, 请求被重复处理时不会重复创建订单
+func (fss *FlashSaleDomainSvc) createQueuedOrder(flashSaleOrder *do.FlashSaleOrder) bool {
+	log := logger.New(fss.ctx)
+	order, err := dao.NewOrderDao(fss.ctx).GetOrderByNo(flashSaleOrder.OrderNo)
+	if err == nil && order.ID != 0 {
+		// 订单已经创建过了, 补上可能没保存成功的下单结果
+		if err = fss.setOrderResult(flashSaleOrder, enum.FlashSaleOrderStateCreated, ""); err != nil {
+			log.Error("SetFlashSaleOrderResultError", "err", err, "orderNo", flashSaleOrder.OrderNo)
+		}
+		return false
+	}
+	if err == nil {
+		err = fss.createOrder(flashSaleOrder)
+	}
+	if dao.IsDuplicateKeyError(err) {
+		// 重复处理的请求并发创建了同一个订单, 订单已经由另一次处理创建好了, 不能归还库存
+		if err = fss.setOrderResult(flashSaleOrder, enum.FlashSaleOrderStateCreated, ""); err != nil {
+			log.Error("SetFlashSaleOrderResultError", "err", err, "orderNo", flashSaleOrder.OrderNo)
+		}
+		return false
+	}
+	if err != nil {
+		log.Error("CreateFlashSaleOrderError", "err", err, "flashSaleOrder", flashSaleOrder)
+		fss.returnStock(flashSaleOrder)
+		failReason := errcode.ErrServer.Msg()
+		var appErr *errcode.AppError
+		if errors.As(err, &appErr) && appErr.Code() > 0 {
+			failReason = appErr.Msg()
+		}
+		if err = fss.setOrderResult(flashSaleOrder, enum.FlashSaleOrderStateFailed, failReason); err != nil {
+			log.Error("SetFlashSaleOrderResultError", "err", err, "orderNo", flashSaleOrder.OrderNo)
+		}
+		return false
+	}
+	if err = fss.setOrderResult(flashSaleOrder, enum.FlashSaleOrderStateCreated, ""); err != nil {
+		log.Error("SetFlashSaleOrderResultError", "err", err, "orderNo", flashSaleOrder.OrderNo)
+	}
+	return true
+}
+
+func (fss *FlashSaleDomainSvc) createOrder(flashSaleOrder *do.FlashSaleOrder) error {
+	flashSaleModel, err := fss.flashSaleDao.GetFlashSaleById(flashSaleOrder.FlashSaleId)
+	if err != nil {
+		return err
+	}
+	if flashSaleModel.ID == 0 {
+		return errcode.ErrFlashSaleNotExists
+	}
+	flashSale := new(do.FlashSale)
+	if err = util.CopyProperties(flashSale, flashSaleModel); err != nil {
+		return errcode.ErrCoverData.WithCause(err)
+	}
+	address, err := NewUserDomainSvc(fss.ctx).GetUserSingleAddress(flashSaleOrder.UserId, flashSaleOrder.UserAddressId)
+	if err != nil {
+		return err
+	}
+	_, err = NewOrderDomainSvc(fss.ctx).CreateFlashSaleOrder(flashSale, flashSaleOrder.OrderNo, flashSaleOrder.CommodityNum, address)
+	return err
+}
+
+// ReconcileFlashSales 秒杀活动对账
+// 把已经取消或者关闭的秒杀订单抢到的库存还给活动, 再把 Redis 中的抢购数量同步回 MySQL;
+// 活动结束超过 flashSaleReconcileOverdue 后不再对账, 把活动没有卖出的库存释放回商品的可售库存
+// @return reconciled 对账的活动数
+func (fss *FlashSaleDomainSvc) ReconcileFlashSales() (reconciled int, err error) {
+	now := time.Now()
+	flashSales, err := fss.flashSaleDao.GetFlashSalesStartBefore(now, now.Add(-flashSaleReconcileOverdue))
+	if err != nil {
+		return 0, errcode.Wrap("ReconcileFlashSalesError", err)
+	}
+	for _, flashSale := range flashSales {
+		closedOrders, err := fss.flashSaleDao.GetFlashSaleOrdersInStatus(flashSale.ID, flashSaleReturnOrderStatus)
+		if err != nil {
+			return reconciled, errcode.Wrap("ReconcileFlashSalesError", err)
+		}
+		for _, closedOrder := range closedOrders {
+			if _, err = cache.ReturnFlashSaleStock(fss.ctx, closedOrder); err != nil {
+				return reconciled, errcode.Wrap("ReconcileFlashSalesError", err)
+			}
+		}
+		stock, loaded, err := cache.GetFlashSaleStock(fss.ctx, flashSale.ID)
+		if err != nil {
+			return reconciled, errcode.Wrap("ReconcileFlashSalesError", err)
+		}
+		if !loaded {
+			continue
+		}
+		if soldNum := flashSale.TotalStock - stock; soldNum != flashSale.SoldNum {
+			if err = fss.flashSaleDao.SetFlashSaleSoldNum(flashSale.ID, soldNum); err != nil {
+				return reconciled, errcode.Wrap("ReconcileFlashSalesError", err)
+			}
+		}
+		reconciled++
+	}
+
+	// 对账结束的活动, 把没有卖出的库存释放回商品的可售库存
+	endedFlashSales, err := fss.flashSaleDao.GetFlashSalesToReleaseStock(now.Add(-flashSaleReconcileOverdue))
+	if err != nil {
+		return reconciled, errcode.Wrap("ReconcileFlashSalesError", err)
+	}
+	for _, flashSale := range endedFlashSales {
+		if _, err = fss.flashSaleDao.ReleaseFlashSaleStock(flashSale.ID); err != nil {
+			return reconciled, errcode.Wrap("ReconcileFlashSalesError", err)
+		}
+	}
+	return reconciled, nil
+}
+
+func (fss *FlashSaleDomainSvc) setOrderResult(flashSaleOrder *do.FlashSaleOrder, state int, failReason string) error {
+	return cache.SetFlashSaleOrderResult(fss.ctx, &do.FlashSaleOrderResult{
+		OrderNo:    flashSaleOrder.OrderNo,
+		UserId:     flashSaleOrder.UserId,
+		State:      state,
+		FailReason: failReason,
+	}, flashSaleOrderResultTTL)
+}
+
+// returnStock 把没能下单的请求抢到的库存还给活动, 归还失败时只记录日志, 由人工核对
+func (fss *FlashSaleDomainSvc) returnStock(flashSaleOrder *do.FlashSaleOrder) {
+	if _, err := cache.ReturnFlashSaleStock(fss.ctx, flashSaleOrder); err != nil {
+		logger.New(fss.ctx).Error("ReturnFlashSaleStockError", "err", err, "flashSaleOrder", flashSaleOrder)
+	}
+}
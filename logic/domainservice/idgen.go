@@ -0,0 +1,130 @@
+package domainservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/library"
+)
+
+const (
+	// idWorkerLeaseTTL worker id 租约的有效期
+	idWorkerLeaseTTL = time.Minute
+	// idWorkerRenewBefore 租约剩余时间少于这个时长时续约
+	idWorkerRenewBefore = 40 * time.Second
+	// idWorkerSafetyMargin 租约到期前提前停止使用 worker id, 避免和下一个租用者因为时钟误差生成相同的ID
+	idWorkerSafetyMargin = time.Second
+)
+
+// idWorker 服务实例租用的 worker id 和对应的ID生成器, 一个服务实例只租用一个 worker id
+type idWorker struct {
+	mu            sync.Mutex
+	owner         string // 租约持有者, 区分不同的服务实例
+	generator     *library.IdGenerator
+	leaseExpireAt time.Time
+}
+
+var defaultIdWorker = &idWorker{}
+
+// getGenerator 返回租约有效的ID生成器, 没有租约或者租约失效时重新租用 worker id
+func (iw *idWorker) getGenerator(ctx context.Context) (*library.IdGenerator, error) {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+
+	now := time.Now()
+	if iw.generator != nil && now.Before(iw.leaseExpireAt.Add(-idWorkerRenewBefore)) {
+		return iw.generator, nil
+	}
+	if iw.owner == "" {
+		hostname, _ := os.Hostname()
+		iw.owner = fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), util.RandomString(8))
+	}
+	if iw.generator != nil {
+		renewed, err := cache.RenewIdWorker(ctx, iw.generator.WorkerId(), iw.owner, idWorkerLeaseTTL)
+		if err == nil && renewed {
+			iw.leaseExpireAt = now.Add(idWorkerLeaseTTL - idWorkerSafetyMargin)
+			return iw.generator, nil
+		}
+		if now.Before(iw.leaseExpireAt) { // 续约失败但租约还没到期, 继续使用, 下次再续约
+			logger.New(ctx).Warn("RenewIdWorkerError", "err", err, "workerId", iw.generator.WorkerId())
+			return iw.generator, nil
+		}
+		// 租约已经失效, worker id 可能已经被其他实例租用, 重新租用一个
+		iw.generator = nil
+	}
+
+	workerId, err := cache.LeaseIdWorker(ctx, iw.owner, library.IdMaxWorkerId, idWorkerLeaseTTL)
+	if err != nil {
+		return nil, err
+	}
+	generator, err := library.NewIdGenerator(workerId)
+	if err != nil {
+		return nil, err
+	}
+	iw.generator = generator
+	iw.leaseExpireAt = now.Add(idWorkerLeaseTTL - idWorkerSafetyMargin)
+	logger.New(ctx).Info("LeaseIdWorker", "workerId", workerId, "owner", iw.owner)
+	return generator, nil
+}
+
+// release 释放 worker id 的租约
+func (iw *idWorker) release(ctx context.Context) error {
+	iw.mu.Lock()
+	defer iw.mu.Unlock()
+	if iw.generator == nil {
+		return nil
+	}
+	err := cache.ReleaseIdWorker(ctx, iw.generator.WorkerId(), iw.owner)
+	iw.generator = nil
+	return err
+}
+
+// ReleaseIdWorker 服务停止时释放ID生成器租用的 worker id, 让其他实例可以马上使用
+func ReleaseIdWorker(ctx context.Context) error {
+	return defaultIdWorker.release(ctx)
+}
+
+// IdGenDomainSvc 生成订单号、退款单号、开票申请单号等业务单号
+// 单号由 Snowflake ID 生成, 在多个服务实例间唯一, 长度固定并且按生成时间递增
+type IdGenDomainSvc struct {
+	ctx context.Context
+}
+
+func NewIdGenDomainSvc(ctx context.Context) *IdGenDomainSvc {
+	return &IdGenDomainSvc{ctx: ctx}
+}
+
+// GenOrderNo 生成订单号
+func (igs *IdGenDomainSvc) GenOrderNo() (string, error) {
+	return igs.genBizNo(enum.BizNoPrefixOrder)
+}
+
+// GenRefundNo 生成退款单号
+func (igs *IdGenDomainSvc) GenRefundNo() (string, error) {
+	return igs.genBizNo(enum.BizNoPrefixRefund)
+}
+
+// GenInvoiceNo 生成开票申请单号
+func (igs *IdGenDomainSvc) GenInvoiceNo() (string, error) {
+	return igs.genBizNo(enum.BizNoPrefixInvoice)
+}
+
+func (igs *IdGenDomainSvc) genBizNo(prefix string) (string, error) {
+	generator, err := defaultIdWorker.getGenerator(igs.ctx)
+	if err != nil {
+		return "", errcode.Wrap("GenBizNoError", err)
+	}
+	no, err := generator.NextNo(prefix)
+	if err != nil {
+		return "", errcode.Wrap("GenBizNoError", err)
+	}
+	return no, nil
+}
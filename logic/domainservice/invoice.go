@@ -0,0 +1,396 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+)
+
+// 发票
+// 用户维护自己的发票抬头, 对已支付的订单申请开票, 由管理后台确认后通过开票服务开具发票。
+// 开票金额是订单的实付金额减去已经退款成功的金额, 开具后订单再申请退款时, 需要先红冲原发票, 退款后再重新申请。
+
+type InvoiceDomainSvc struct {
+	ctx        context.Context
+	invoiceDao *dao.InvoiceDao
+}
+
+func NewInvoiceDomainSvc(ctx context.Context) *InvoiceDomainSvc {
+	return &InvoiceDomainSvc{
+		ctx:        ctx,
+		invoiceDao: dao.NewInvoiceDao(ctx),
+	}
+}
+
+// AddInvoiceTitle 新增发票抬头
+func (ids *InvoiceDomainSvc) AddInvoiceTitle(title *do.InvoiceTitle) (*do.InvoiceTitle, error) {
+	if err := checkInvoiceTitle(title); err != nil {
+		return nil, err
+	}
+	titleModel, err := ids.invoiceDao.CreateInvoiceTitle(title)
+	if err != nil {
+		return nil, errcode.Wrap("AddInvoiceTitleError", err)
+	}
+	if err = util.CopyProperties(title, titleModel); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return title, nil
+}
+
+// GetUserInvoiceTitles 查询用户的发票抬头列表
+func (ids *InvoiceDomainSvc) GetUserInvoiceTitles(userId int64) ([]*do.InvoiceTitle, error) {
+	titleModels, err := ids.invoiceDao.FindUserInvoiceTitles(userId)
+	if err != nil {
+		return nil, errcode.Wrap("GetUserInvoiceTitlesError", err)
+	}
+	titles := make([]*do.InvoiceTitle, 0, len(titleModels))
+	if err = util.CopyProperties(&titles, &titleModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return titles, nil
+}
+
+// ModifyInvoiceTitle 修改用户的发票抬头
+func (ids *InvoiceDomainSvc) ModifyInvoiceTitle(title *do.InvoiceTitle) error {
+	if err := checkInvoiceTitle(title); err != nil {
+		return err
+	}
+	if _, err := ids.getUserInvoiceTitle(title.UserId, title.ID); err != nil {
+		return err
+	}
+	if err := ids.invoiceDao.UpdateInvoiceTitle(title); err != nil {
+		return errcode.Wrap("ModifyInvoiceTitleError", err)
+	}
+	return nil
+}
+
+// DeleteInvoiceTitle 删除用户的发票抬头, 已经申请的发票保存了抬头的副本, 不受影响
+func (ids *InvoiceDomainSvc) DeleteInvoiceTitle(userId, titleId int64) error {
+	titleModel, err := ids.getUserInvoiceTitle(userId, titleId)
+	if err != nil {
+		return err
+	}
+	if err = ids.invoiceDao.DeleteInvoiceTitle(titleModel); err != nil {
+		return errcode.Wrap("DeleteInvoiceTitleError", err)
+	}
+	return nil
+}
+
+func (ids *InvoiceDomainSvc) getUserInvoiceTitle(userId, titleId int64) (*model.InvoiceTitle, error) {
+	titleModel, err := ids.invoiceDao.GetInvoiceTitle(titleId)
+	if err != nil || titleModel.ID == 0 || titleModel.UserId != userId {
+		logger.New(ids.ctx).Error("InvoiceTitleNotMatchError", "err", err, "return data", titleModel, "titleId", titleId, "userId", userId)
+		return nil, errcode.ErrParams
+	}
+	return titleModel, nil
+}
+
+// checkInvoiceTitle 单位抬头必须有纳税人识别号, 个人抬头不保存纳税人识别号
+func checkInvoiceTitle(title *do.InvoiceTitle) error {
+	switch title.TitleType {
+	case enum.InvoiceTitleTypePersonal:
+		title.TaxNo = ""
+	case enum.InvoiceTitleTypeCompany:
+		if title.TaxNo == "" {
+			return errcode.ErrInvoiceParams.WithCause(errors.New("单位抬头需要填写纳税人识别号"))
+		}
+	default:
+		return errcode.ErrInvoiceParams
+	}
+	return nil
+}
+
+// RequestOrderInvoice 用户为已支付的订单申请开票
+// @param orderNo 订单号
+// @param userId 用户ID
+// @param titleId 发票抬头ID
+func (ids *InvoiceDomainSvc) RequestOrderInvoice(orderNo string, userId, titleId int64) (*do.OrderInvoice, error) {
+	order, err := NewOrderDomainSvc(ids.ctx).GetSpecifiedUserOrder(orderNo, userId)
+	if err != nil {
+		return nil, err
+	}
+	if order.PayState != enum.PayStatePaid || !lo.Contains(refundableOrderStatus, order.OrderStatus) {
+		return nil, errcode.ErrInvoiceNotAllowed
+	}
+	if order.OrderType == enum.OrderTypeParent {
+		return nil, errcode.ErrInvoiceNotAllowed.WithCause(errors.New("拆单的订单需要按子订单申请开票"))
+	}
+	title, err := ids.getUserInvoiceTitle(userId, titleId)
+	if err != nil {
+		return nil, err
+	}
+	refunds, err := ids.getInvoiceableOrderRefunds(order)
+	if err != nil {
+		return nil, err
+	}
+	amount, _, err := ids.orderInvoiceAmount(order, refunds, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, errcode.ErrInvoiceNotAllowed.WithCause(errors.New("订单已全部退款"))
+	}
+
+	invoiceNo, err := NewIdGenDomainSvc(ids.ctx).GenInvoiceNo()
+	if err != nil {
+		return nil, err
+	}
+	invoice := &do.OrderInvoice{
+		InvoiceNo:    invoiceNo,
+		OrderId:      order.ID,
+		OrderNo:      order.OrderNo,
+		UserId:       userId,
+		TitleType:    title.TitleType,
+		Title:        title.Title,
+		TaxNo:        title.TaxNo,
+		Amount:       amount,
+		InvoiceState: enum.InvoiceStateRequested,
+	}
+	// 一个订单同一时间只能有一张有效的发票, 已经红冲的发票不算, 创建时在事务里检查
+	created, err := ids.invoiceDao.CreateOrderInvoice(invoice)
+	if err != nil {
+		return nil, errcode.Wrap("RequestOrderInvoiceError", err)
+	}
+	if !created {
+		return nil, errcode.ErrInvoiceNotAllowed.WithCause(errors.New("订单已经申请过开票"))
+	}
+	return invoice, nil
+}
+
+// getInvoiceableOrderRefunds 查询订单的退款申请, 有正在处理的退款申请时开票金额还不确定, 不能开票
+func (ids *InvoiceDomainSvc) getInvoiceableOrderRefunds(order *do.Order) ([]*model.OrderRefund, error) {
+	refunds, err := dao.NewOrderRefundDao(ids.ctx).GetOrderRefunds(order.ID)
+	if err != nil {
+		return nil, errcode.Wrap("GetInvoiceableOrderRefundsError", err)
+	}
+	if lo.ContainsBy(refunds, func(refund *model.OrderRefund) bool {
+		return refund.RefundState == enum.RefundStatePending || refund.RefundState == enum.RefundStateProcessing
+	}) {
+		return nil, errcode.ErrInvoiceNotAllowed.WithCause(errors.New("订单有正在处理的退款申请"))
+	}
+	return refunds, nil
+}
+
+// orderInvoiceAmount 计算订单的开票金额和发票上的商品明细
+// 开票金额是订单实付金额减去 asOf 之前已经退款成功的金额, 再按每个购物项剩余的实付金额分摊到商品明细上
+func (ids *InvoiceDomainSvc) orderInvoiceAmount(order *do.Order, refunds []*model.OrderRefund, asOf time.Time) (int, []*do.InvoiceItem, error) {
+	refunds = lo.Filter(refunds, func(refund *model.OrderRefund, _ int) bool {
+		return !refund.RefundedAt.After(asOf)
+	})
+	refundedMoney, refundedItems, err := NewOrderDomainSvc(ids.ctx).getOrderRefunded(refunds)
+	if err != nil {
+		return 0, nil, err
+	}
+	amount := order.PayMoney - refundedMoney
+	if amount <= 0 {
+		return 0, nil, nil
+	}
+
+	items := make([]*do.InvoiceItem, 0, len(order.Items))
+	remainMoneys := make([]int, 0, len(order.Items))
+	for _, orderItem := range order.Items {
+		refunded := refundedItems[orderItem.CommodityId]
+		remainNum := orderItem.CommodityNum - refunded.num
+		if remainNum <= 0 {
+			continue
+		}
+		items = append(items, &do.InvoiceItem{CommodityName: orderItem.CommodityName, CommodityNum: remainNum})
+		// 退完剩余的全部商品要退的金额就是购物项剩余的实付金额
+		remainMoneys = append(remainMoneys, orderItemRefundMoney(orderItem, refunded, remainNum))
+	}
+	if len(items) == 0 {
+		return amount, items, nil
+	}
+	itemAmounts := apportionMoney(amount, remainMoneys)
+	// 购物项剩余的实付金额之和小于开票金额时分摊不完, 剩下的金额记到最后一行, 保证明细之和等于开票金额
+	itemAmounts[len(itemAmounts)-1] += amount - lo.Sum(itemAmounts)
+	for i, itemAmount := range itemAmounts {
+		items[i].Amount = itemAmount
+	}
+	return amount, items, nil
+}
+
+// GetUserOrderInvoices 查询用户订单的开票记录
+func (ids *InvoiceDomainSvc) GetUserOrderInvoices(orderNo string, userId int64) ([]*do.OrderInvoice, error) {
+	order, err := dao.NewOrderDao(ids.ctx).GetOrderByNo(orderNo)
+	if err != nil {
+		return nil, errcode.Wrap("GetUserOrderInvoicesError", err)
+	}
+	if order.ID == 0 || order.UserId != userId {
+		return nil, errcode.ErrOrderParams
+	}
+	invoiceModels, err := ids.invoiceDao.GetOrderInvoices(order.ID)
+	if err != nil {
+		return nil, errcode.Wrap("GetUserOrderInvoicesError", err)
+	}
+	invoices := make([]*do.OrderInvoice, 0, len(invoiceModels))
+	if err = util.CopyProperties(&invoices, &invoiceModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return invoices, nil
+}
+
+// GetUserInvoicePdf 获取用户发票的PDF文件, 红冲后的发票返回红字发票
+func (ids *InvoiceDomainSvc) GetUserInvoicePdf(invoiceNo string, userId int64) (string, error) {
+	invoice, err := ids.invoiceDao.GetOrderInvoiceByNo(invoiceNo)
+	if err != nil {
+		return "", errcode.Wrap("GetUserInvoicePdfError", err)
+	}
+	if invoice.ID == 0 || invoice.UserId != userId {
+		return "", errcode.ErrInvoiceNotExists
+	}
+	switch invoice.InvoiceState {
+	case enum.InvoiceStateIssued:
+		return invoice.PdfPath, nil
+	case enum.InvoiceStateRedFlushed:
+		return invoice.RedPdfPath, nil
+	default:
+		return "", errcode.ErrInvoiceStateInvalid.WithCause(errors.New("发票还没有开具"))
+	}
+}
+
+// GetInvoices 管理后台分页查询开票记录
+func (ids *InvoiceDomainSvc) GetInvoices(invoiceState int, pagination *app.Pagination) ([]*do.OrderInvoice, error) {
+	invoiceModels, totalRows, err := ids.invoiceDao.GetInvoices(invoiceState, pagination.Offset(), pagination.GetPageSize())
+	if err != nil {
+		return nil, errcode.Wrap("GetInvoicesError", err)
+	}
+	pagination.SetTotalRows(int(totalRows))
+	invoices := make([]*do.OrderInvoice, 0, len(invoiceModels))
+	if err = util.CopyProperties(&invoices, &invoiceModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return invoices, nil
+}
+
+// IssueInvoice 管理后台确认开票, 按订单当前的实付金额开具发票
+// 申请后订单又有退款成功时, 开票金额以开票时计算的为准
+// 调用开票服务前先把发票占用为开票中, 并发的开票请求只有一个能开出发票
+func (ids *InvoiceDomainSvc) IssueInvoice(invoiceNo string) (err error) {
+	invoice, order, err := ids.getInvoiceAndOrder(invoiceNo, enum.InvoiceStateRequested)
+	if err != nil {
+		return err
+	}
+	claimed, err := ids.invoiceDao.ClaimInvoiceIssuing(invoice.ID)
+	if err != nil {
+		return errcode.Wrap("IssueInvoiceError", err)
+	}
+	if !claimed {
+		return errcode.ErrInvoiceStateInvalid
+	}
+	var result *do.InvoiceIssueResult
+	defer func() {
+		// 没有开出发票时恢复为已申请, 之后可以重新开票
+		if err == nil || result != nil {
+			return
+		}
+		if releaseErr := ids.invoiceDao.ReleaseInvoiceIssuing(invoice.ID); releaseErr != nil {
+			logger.New(ids.ctx).Error("ReleaseInvoiceIssuingError", "err", releaseErr, "invoiceNo", invoiceNo)
+		}
+	}()
+
+	// 占用发票后再检查退款, 占用之前创建的退款申请都能检查到
+	refunds, err := ids.getInvoiceableOrderRefunds(order)
+	if err != nil {
+		return err
+	}
+	invoice.Amount, invoice.Items, err = ids.orderInvoiceAmount(order, refunds, time.Now())
+	if err != nil {
+		return err
+	}
+	if invoice.Amount <= 0 {
+		return errcode.ErrInvoiceNotAllowed.WithCause(errors.New("订单已全部退款"))
+	}
+	if result, err = newInvoiceIssuer().Issue(invoice); err != nil {
+		return errcode.Wrap("IssueInvoiceError", err)
+	}
+	issued, err := ids.invoiceDao.SetInvoiceIssued(invoice.ID, invoice.Amount, result)
+	if err != nil || !issued {
+		// 发票已经开出, 不能再恢复为已申请, 需要人工把开票结果补到记录上
+		logger.New(ids.ctx).Error("SetInvoiceIssuedError", "err", err, "invoiceNo", invoiceNo,
+			"invoiceNumber", result.InvoiceNumber, "pdfPath", result.PdfPath)
+		if err != nil {
+			return errcode.Wrap("IssueInvoiceError", err)
+		}
+		return errcode.ErrInvoiceStateInvalid
+	}
+	return nil
+}
+
+// RedFlushInvoice 管理后台红冲已开具的发票
+// 红字发票的商品明细和原发票一致, 按原发票开具之前的退款计算
+func (ids *InvoiceDomainSvc) RedFlushInvoice(invoiceNo string) error {
+	invoice, order, err := ids.getInvoiceAndOrder(invoiceNo, enum.InvoiceStateIssued)
+	if err != nil {
+		return err
+	}
+	refunds, err := dao.NewOrderRefundDao(ids.ctx).GetOrderRefunds(order.ID)
+	if err != nil {
+		return errcode.Wrap("RedFlushInvoiceError", err)
+	}
+	amount, items, err := ids.orderInvoiceAmount(order, refunds, invoice.IssuedAt)
+	if err != nil {
+		return err
+	}
+	invoice.Items = items
+	if amount != invoice.Amount {
+		// 算不出和原发票一致的明细时, 红字发票只开一行合计金额
+		invoice.Items = []*do.InvoiceItem{{CommodityName: "订单 " + invoice.OrderNo, CommodityNum: 1, Amount: invoice.Amount}}
+	}
+	result, err := newInvoiceIssuer().RedFlush(invoice)
+	if err != nil {
+		return errcode.Wrap("RedFlushInvoiceError", err)
+	}
+	redFlushed, err := ids.invoiceDao.SetInvoiceRedFlushed(invoice.ID, result)
+	if err != nil {
+		return errcode.Wrap("RedFlushInvoiceError", err)
+	}
+	if !redFlushed {
+		logger.New(ids.ctx).Error("RedFlushInvoiceConflict", "invoiceNo", invoiceNo, "invoiceNumber", result.InvoiceNumber)
+		return errcode.ErrInvoiceStateInvalid
+	}
+	return nil
+}
+
+// getInvoiceAndOrder 查询要处理的开票记录和它的订单, 开票记录必须处于 invoiceState 状态
+func (ids *InvoiceDomainSvc) getInvoiceAndOrder(invoiceNo string, invoiceState int) (*do.OrderInvoice, *do.Order, error) {
+	invoiceModel, err := ids.invoiceDao.GetOrderInvoiceByNo(invoiceNo)
+	if err != nil {
+		return nil, nil, errcode.Wrap("GetInvoiceError", err)
+	}
+	if invoiceModel.ID == 0 {
+		return nil, nil, errcode.ErrInvoiceNotExists
+	}
+	if invoiceModel.InvoiceState != invoiceState {
+		return nil, nil, errcode.ErrInvoiceStateInvalid
+	}
+	invoice := new(do.OrderInvoice)
+	if err = util.CopyProperties(invoice, invoiceModel); err != nil {
+		return nil, nil, errcode.ErrCoverData.WithCause(err)
+	}
+	order, err := NewOrderDomainSvc(ids.ctx).GetSpecifiedUserOrder(invoice.OrderNo, invoice.UserId)
+	if err != nil {
+		return nil, nil, err
+	}
+	return invoice, order, nil
+}
+
+func newInvoiceIssuer() library.InvoiceIssuer {
+	return library.NewInvoiceIssuer(config.App.Invoice.Issuer, library.InvoiceIssuerConfig{
+		PdfDir:      config.App.Invoice.PdfDir,
+		SellerName:  config.App.Invoice.SellerName,
+		SellerTaxNo: config.App.Invoice.SellerTaxNo,
+	})
+}
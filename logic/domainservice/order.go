@@ -3,15 +3,16 @@ package domainservice
 import (
 	"context"
 	"fmt"
-	"time"
+	"sort"
 
 	"github.com/WoWBytePaladin/go-mall/common/app"
 	"github.com/WoWBytePaladin/go-mall/common/enum"
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
 	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
 	"github.com/WoWBytePaladin/go-mall/dal/dao"
 	"github.com/WoWBytePaladin/go-mall/dal/model"
-	"github.com/WoWBytePaladin/go-mall/library"
 	"github.com/WoWBytePaladin/go-mall/logic/do"
 	"github.com/samber/lo"
 )
@@ -28,8 +29,69 @@ func NewOrderDomainSvc(ctx context.Context) *OrderDomainSvc {
 	}
 }
 
-// CreateOrder 创建订单
+// CreateOrder 用购物车中选中的购物项创建订单, 订单创建后删除这些购物项
 func (ods *OrderDomainSvc) CreateOrder(items []*do.ShoppingCartItem, userAddress *do.UserAddressInfo) (*do.Order, error) {
+	cartItemIds := lo.Map(items, func(item *do.ShoppingCartItem, index int) int64 {
+		return item.CartItemId
+	})
+	return ods.createOrder(do.OrderNew(), items, userAddress, cartItemIds)
+}
+
+// CreateDirectOrder 立即购买, 不经过购物车直接用商品创建订单
+// 商品按购物项走同样的账单计算, 不会读取和修改用户的购物车
+func (ods *OrderDomainSvc) CreateDirectOrder(commodityId int64, commodityNum int, userAddress *do.UserAddressInfo) (*do.Order, error) {
+	commodity, err := dao.NewCommodityDao(ods.ctx).FindCommodityById(commodityId)
+	if err != nil {
+		return nil, errcode.Wrap("CreateDirectOrderError", err)
+	}
+	if commodity.ID == 0 || commodity.SellStatus != enum.CommoditySellStatusOn { // 下架的商品不能购买
+		return nil, errcode.ErrCommodityNotExists
+	}
+	item := &do.ShoppingCartItem{
+		UserId:                userAddress.UserId,
+		CommodityId:           commodity.ID,
+		CommodityName:         commodity.Name,
+		CommodityImg:          commodity.CoverImg,
+		CommoditySellingPrice: commodity.SellingPrice,
+		CommodityNum:          commodityNum,
+		MerchantId:            commodity.MerchantId,
+		WarehouseId:           commodity.WarehouseId,
+	}
+	return ods.createOrder(do.OrderNew(), []*do.ShoppingCartItem{item}, userAddress, nil)
+}
+
+// CreateFlashSaleOrder 秒杀下单, 用排队时预先生成的订单号按秒杀价创建订单
+func (ods *OrderDomainSvc) CreateFlashSaleOrder(flashSale *do.FlashSale, orderNo string, commodityNum int, userAddress *do.UserAddressInfo) (*do.Order, error) {
+	commodity, err := dao.NewCommodityDao(ods.ctx).FindCommodityById(flashSale.CommodityId)
+	if err != nil {
+		return nil, errcode.Wrap("CreateFlashSaleOrderError", err)
+	}
+	if commodity.ID == 0 || commodity.SellStatus != enum.CommoditySellStatusOn {
+		return nil, errcode.ErrCommodityNotExists
+	}
+	item := &do.ShoppingCartItem{
+		UserId:                userAddress.UserId,
+		CommodityId:           commodity.ID,
+		CommodityName:         commodity.Name,
+		CommodityImg:          commodity.CoverImg,
+		CommoditySellingPrice: flashSale.FlashPrice,
+		CommodityNum:          commodityNum,
+		MerchantId:            commodity.MerchantId,
+		WarehouseId:           commodity.WarehouseId,
+	}
+	order := do.OrderNew()
+	order.OrderNo = orderNo
+	order.FlashSaleId = flashSale.ID
+	return ods.createOrder(order, []*do.ShoppingCartItem{item}, userAddress, nil)
+}
+
+// createOrderMaxRetry 创建订单时订单号重复, 重新生成订单号后最多重试的次数
+const createOrderMaxRetry = 3
+
+// createOrder 创建订单和拆分出的子订单、删除下单的购物项、预占商品库存, 订单号重复时重新生成订单号再创建
+// @param order 要创建的订单, 没有预先设置订单号时生成订单号
+// @param cartItemIds 下单后要删除的购物项, 立即购买时为空
+func (ods *OrderDomainSvc) createOrder(order *do.Order, items []*do.ShoppingCartItem, userAddress *do.UserAddressInfo, cartItemIds []int64) (*do.Order, error) {
 	billInfo, err := NewCartBillChecker(items, userAddress.UserId).GetBill()
 	if err != nil {
 		return nil, errcode.Wrap("CreateOrderError", err)
@@ -37,18 +99,69 @@ func (ods *OrderDomainSvc) CreateOrder(items []*do.ShoppingCartItem, userAddress
 	if billInfo.OriginalTotalPrice <= 0 {
 		return nil, errcode.ErrCartItemParam
 	}
-	order := do.OrderNew()
+	presetOrderNo := order.OrderNo != ""
+	if !presetOrderNo {
+		if order.OrderNo, err = NewIdGenDomainSvc(ods.ctx).GenOrderNo(); err != nil {
+			return nil, err
+		}
+	}
 	order.UserId = userAddress.UserId
-	order.OrderNo = util.GenOrderNo(order.UserId)
 	order.BillMoney = billInfo.OriginalTotalPrice
 	order.PayMoney = billInfo.TotalPrice
+	order.CouponId = billInfo.Coupon.CouponId
+	order.CouponMoney = billInfo.CouponMoney
+	order.DiscountId = billInfo.Discount.DiscountId
+	order.DiscountMoney = billInfo.DiscountMoney
+	order.VipDiscountMoney = billInfo.VipDiscountMoney
 	order.OrderStatus = enum.OrderStatusCreated
+	order.PayDeadline = newOrderPayDeadline()
 	if err = util.CopyProperties(&order.Items, &items); err != nil {
 		return nil, errcode.ErrCoverData.WithCause(err)
 	}
 	if err = util.CopyProperties(&order.Address, &userAddress); err != nil {
 		return nil, errcode.ErrCoverData.WithCause(err)
 	}
+	// 商品来自不同的商家或者仓库时拆单; 把订单的优惠分摊到每个购物项上, 退款、开票时按购物项的实付金额计算
+	order.SubOrders, err = ods.splitOrder(order, items)
+	if err != nil {
+		return nil, err
+	}
+	// 订单号在多个服务实例间唯一, 极少数情况下(比如 worker id 的租约失效后被其他实例租用)会重复,
+	// 写库时违反订单号的唯一索引就重新生成订单号再创建; 预先生成订单号的秒杀订单重复时说明订单已经创建过了, 不重试
+	for retry := 0; ; retry++ {
+		err = ods.saveOrder(order, billInfo, cartItemIds)
+		if presetOrderNo || retry >= createOrderMaxRetry || !dao.IsDuplicateKeyError(err) {
+			break
+		}
+		logger.New(ods.ctx).Warn("CreateOrderDuplicateOrderNo", "orderNo", order.OrderNo, "retry", retry)
+		if err = ods.regenerateOrderNo(order); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// regenerateOrderNo 为订单和拆分出的子订单重新生成订单号, 清掉上次写库失败时回填的订单ID
+func (ods *OrderDomainSvc) regenerateOrderNo(order *do.Order) (err error) {
+	idGenSvc := NewIdGenDomainSvc(ods.ctx)
+	order.ID = 0
+	if order.OrderNo, err = idGenSvc.GenOrderNo(); err != nil {
+		return err
+	}
+	for _, subOrder := range order.SubOrders {
+		subOrder.ID = 0
+		if subOrder.OrderNo, err = idGenSvc.GenOrderNo(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveOrder 在一个事务里保存订单和拆分出的子订单、删除下单的购物项、预占商品库存
+func (ods *OrderDomainSvc) saveOrder(order *do.Order, billInfo *do.CartBillInfo, cartItemIds []int64) (err error) {
 	// 手动开启事务
 	tx := dao.DBMaster().Begin()
 	panicked := true
@@ -64,16 +177,23 @@ func (ods *OrderDomainSvc) CreateOrder(items []*do.ShoppingCartItem, userAddress
 	// 创建订单
 	err = ods.orderDao.CreateOrder(tx, order)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// 创建拆分出的子订单
+	for _, subOrder := range order.SubOrders {
+		subOrder.ParentId = order.ID
+		err = ods.orderDao.CreateOrder(tx, subOrder)
+		if err != nil {
+			return err
+		}
 	}
 	// 删除购物车中的购买的购物项
-	cartDao := dao.NewCartDao(ods.ctx)
-	cartItemIds := lo.Map(items, func(item *do.ShoppingCartItem, index int) int64 {
-		return item.CartItemId
-	})
-	err = cartDao.DeleteMultiCartItemInTx(tx, cartItemIds)
-	if err != nil {
-		return nil, err
+	if len(cartItemIds) > 0 {
+		cartDao := dao.NewCartDao(ods.ctx)
+		err = cartDao.DeleteMultiCartItemInTx(tx, cartItemIds)
+		if err != nil {
+			return err
+		}
 	}
 	// 记录Coupon使用信息 并 锁定优惠卷
 	if billInfo.Coupon.CouponId > 0 {
@@ -83,24 +203,79 @@ func (ods *OrderDomainSvc) CreateOrder(items []*do.ShoppingCartItem, userAddress
 	if billInfo.Discount.DiscountId > 0 {
 		// discountDao.recordDiscount(tx, discount)
 	}
-	// 减少订单购买商品的库存-- 会锁行记录, 把这一步放到创建订单步骤的最后, 减少行记录加锁的时间
+	// 预占订单购买商品的库存-- 会锁行记录, 把这一步放到创建订单步骤的最后, 减少行记录加锁的时间
+	// 拆单的订单按父订单预占库存, 父订单的购物项包含了所有子订单的商品
+	// 秒杀订单从秒杀活动占用的库存里预占
 	commodityDao := dao.NewCommodityDao(ods.ctx)
-	err = commodityDao.ReduceStuckInOrderCreate(tx, order.Items)
+	if order.FlashSaleId > 0 {
+		err = commodityDao.ReserveFlashSaleOrderStock(tx, order.FlashSaleId, order.ID, order.OrderNo, order.Items)
+	} else {
+		err = commodityDao.ReserveOrderStock(tx, order.ID, order.OrderNo, order.Items)
+	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// 加入支付超时队列, 超时未支付的订单由后台任务关闭
+	if queueErr := cache.AddOrderPayDeadline(ods.ctx, order.OrderNo, order.PayDeadline); queueErr != nil {
+		logger.New(ods.ctx).Error("CreateOrderAddPayDeadlineError", "err", queueErr, "orderNo", order.OrderNo)
 	}
 
 	panicked = false // 这个设置别忘了, 让事务能正常提交
 
-	return order, err
+	return nil
+}
+
+// apportionOrderDiscount 按购物项金额占订单金额的比例分摊订单的优惠金额, 并计算购物项的实付金额
+func apportionOrderDiscount(items []*do.OrderItem, discountMoney int) {
+	itemsMoney := lo.Map(items, func(item *do.OrderItem, _ int) int {
+		return item.CommoditySellingPrice * item.CommodityNum
+	})
+	discounts := apportionMoney(discountMoney, itemsMoney)
+	for i, item := range items {
+		item.DiscountMoney = discounts[i]
+		item.PaidMoney = itemsMoney[i] - discounts[i]
+	}
+}
+
+// apportionMoney 按权重比例把金额分摊成多份, 每份不超过自己的权重
+// 按比例分摊后不足一分钱的零头, 依次分给按比例计算时舍去部分最多的那几份, 保证分摊的金额之和等于要分摊的金额
+func apportionMoney(money int, weights []int) []int {
+	totalWeight := lo.Sum(weights)
+	money = lo.Clamp(money, 0, totalWeight) // 分摊的金额不能超过总权重
+	shares := make([]int, len(weights))
+	remainders := make([]int, len(weights))
+	apportioned := 0
+	for i, weight := range weights {
+		if money > 0 {
+			shares[i] = int(int64(money) * int64(weight) / int64(totalWeight))
+			remainders[i] = int(int64(money) * int64(weight) % int64(totalWeight))
+		}
+		apportioned += shares[i]
+	}
+	indexes := lo.Range(len(weights))
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return remainders[indexes[i]] > remainders[indexes[j]]
+	})
+	for _, i := range indexes[:money-apportioned] {
+		shares[i]++
+	}
+	return shares
 }
 
 // GetUserOrders 查询用户订单
-func (ods *OrderDomainSvc) GetUserOrders(userId int64, pagination *app.Pagination) ([]*do.Order, error) {
+// @param frontStatus 订单的前台状态, 为空时查询所有订单; 还在履约的部分退款订单按履约进度出现在待发货、待收货、待评价里
+func (ods *OrderDomainSvc) GetUserOrders(userId int64, frontStatus string, pagination *app.Pagination) ([]*do.Order, error) {
+	var orderStatuses []int
+	if frontStatus != "" {
+		orderStatuses = orderStatusesOfFront(frontStatus)
+		if len(orderStatuses) == 0 {
+			return nil, errcode.ErrParams.WithCause(fmt.Errorf("未知的订单前台状态: %s", frontStatus))
+		}
+	}
 	offset := pagination.Offset()
 	size := pagination.GetPageSize()
 	// 查询用户订单
-	orderModels, totalRow, err := ods.orderDao.GetUserOrders(userId, offset, size)
+	orderModels, totalRow, err := ods.orderDao.GetUserOrders(userId, orderStatuses, offset, size)
 	if err != nil {
 		return nil, errcode.Wrap("GetUserOrdersError", err)
 	}
@@ -109,6 +284,15 @@ func (ods *OrderDomainSvc) GetUserOrders(userId int64, pagination *app.Paginatio
 	if err = util.CopyProperties(&orders, &orderModels); err != nil {
 		return nil, errcode.ErrCoverData.WithCause(err)
 	}
+	if err = ods.fillOrdersDetail(orders); err != nil {
+		return nil, errcode.Wrap("GetUserOrdersError", err)
+	}
+
+	return orders, nil
+}
+
+// fillOrdersDetail 批量查询并填充订单的收货地址和购物明细
+func (ods *OrderDomainSvc) fillOrdersDetail(orders []*do.Order) error {
 	// 提取所有订单ID
 	orderIds := lo.Map(orders, func(order *do.Order, index int) int64 {
 		return order.ID
@@ -116,27 +300,62 @@ func (ods *OrderDomainSvc) GetUserOrders(userId int64, pagination *app.Paginatio
 	// 查询订单的地址
 	ordersAddressMap, err := ods.orderDao.GetMultiOrdersAddress(orderIds)
 	if err != nil {
-		return nil, errcode.Wrap("GetUserOrdersError", err)
+		return err
 	}
 	// 查询订单明细
 	ordersItemMap, err := ods.orderDao.GetMultiOrdersItems(orderIds)
 	if err != nil {
-		return nil, errcode.Wrap("GetUserOrdersError", err)
+		return err
 	}
 
 	// 填充Order中的Address和Items
 	for _, order := range orders {
 		order.Address = new(do.OrderAddress) // 先初始化
 		if err = util.CopyProperties(order.Address, ordersAddressMap[order.ID]); err != nil {
-			return nil, errcode.ErrCoverData.WithCause(err)
+			return errcode.ErrCoverData.WithCause(err)
 		}
 		orderItems := ordersItemMap[order.ID]
 		if err = util.CopyProperties(&order.Items, &orderItems); err != nil {
-			return nil, errcode.ErrCoverData.WithCause(err)
+			return errcode.ErrCoverData.WithCause(err)
 		}
 	}
+	return nil
+}
 
-	return orders, nil
+// CountUserOrdersByFrontStatus 统计用户每种前台状态的订单数
+// 一次分组查询出每个订单状态的订单数, 再按前台状态合计;
+// 部分退款的订单还要继续履约, 除了计入部分退款, 还按履约进度计入待发货、待收货、待评价
+func (ods *OrderDomainSvc) CountUserOrdersByFrontStatus(userId int64) (map[string]int64, error) {
+	statusCounts, err := ods.orderDao.CountUserOrdersByStatus(userId)
+	if err != nil {
+		return nil, errcode.Wrap("CountUserOrdersByFrontStatusError", err)
+	}
+	frontCounts := make(map[string]int64)
+	for orderStatus, count := range statusCounts {
+		frontCounts[enum.OrderFrontStatus[orderStatus]] += count
+	}
+	if statusCounts[enum.OrderStatusPartialRefunded] > 0 {
+		partialRefundedOrders, err := ods.orderDao.GetUserPartialRefundedOrders(userId)
+		if err != nil {
+			return nil, errcode.Wrap("CountUserOrdersByFrontStatusError", err)
+		}
+		for _, order := range partialRefundedOrders {
+			frontCounts[enum.OrderFrontStatus[orderFulfilmentStatus(order)]]++
+		}
+	}
+	return frontCounts, nil
+}
+
+// orderStatusesOfFront 前台状态对应的所有订单状态
+func orderStatusesOfFront(frontStatus string) []int {
+	orderStatuses := make([]int, 0)
+	for orderStatus, front := range enum.OrderFrontStatus {
+		if front == frontStatus {
+			orderStatuses = append(orderStatuses, orderStatus)
+		}
+	}
+	sort.Ints(orderStatuses)
+	return orderStatuses
 }
 
 // GetSpecifiedUserOrder 获取 orderNo 对应的用户订单详情
@@ -182,61 +401,18 @@ func (ods *OrderDomainSvc) CancelUserOrder(orderNo string, userId int64) error {
 		// 已经支付, 用户不能取消订单 -- 需要申请退款
 		return errcode.ErrOrderCanNotBeChanged
 	}
-	// 更新订单状态为用户主动取消
-	err = ods.orderDao.UpdateOrderStatus(order.ID, enum.OrderStatusUserQuit)
-	if err != nil {
-		return errcode.Wrap("CancelOrderError", err)
-
-	}
-	//  恢复商品的库存
-	commodityDao := dao.NewCommodityDao(ods.ctx)
-	err = commodityDao.RecoverOrderCommodityStuck(order.Items)
-	return err
-}
-
-func (ods *OrderDomainSvc) CreteOrderWxPay(orderNo string, userId int64) (payInfo *library.WxPayInvokeInfo, err error) {
-	order, err := ods.GetSpecifiedUserOrder(orderNo, userId)
-	if err != nil {
-		return
-	}
-	if order.OrderStatus != enum.OrderStatusCreated { // 订单不是初始状态,不能发起支付
-		err = errcode.ErrOrderParams
-		return
-	}
-	order.PayType = enum.PayTypeWxPay          // 支付方式--微信支付
-	order.OrderStatus = enum.OrderStatusUnPaid // 订单状态--待支付
-	order.PayState = enum.PayStateUnPaid
 	orderModel := new(model.Order)
 	if err = util.CopyProperties(orderModel, order); err != nil {
-		err = errcode.Wrap("CreteOrderWxPayError", err)
-		return
-	}
-	if err = ods.orderDao.UpdateOrder(orderModel); err != nil { // 更新Order表的支付类型和状态
-		err = errcode.Wrap("CreteOrderWxPayError", err)
-		return
+		return errcode.ErrCoverData.WithCause(err)
 	}
-
-	// 用userId获取对应的Openid, 这里先Mock一个
-	//openId := "QsudfrhgrDYDEEA1344EF"
-	//wxPayLib := library.NewWxPayLib(ods.ctx, library.WxtPayConfig{
-	//	AppId:           config.App.WechatPay.AppId,
-	//	MchId:           config.App.WechatPay.MchId,
-	//	PrivateSerialNo: config.App.WechatPay.PrivateSerialNo,
-	//	AesKey:          config.App.WechatPay.AesKey,
-	//	NotifyUrl:       config.App.WechatPay.NotifyUrl,
-	//})
-
-	// payInfo, err = wxPayLib.CreateOrderPay(order, openId)
-	payInfo = &library.WxPayInvokeInfo{
-		AppId:     "123456",
-		TimeStamp: fmt.Sprintf("%v", time.Now().Unix()),
-		NonceStr:  "e61463f8efa94090b1f366cccfbbb444",
-		Package:   "prepay_id=wx21201855730335ac86f8c43d1889123400",
-		SignType:  "RSA",
-		PaySign:   "oR9d8PuhnIc+YZ8cBHFCwfgpaK9gd7vaRvkYD7rthRAZ/X+QBhcCYL21N7cHCTUxbQ+EAt6Uy+lwSN22f5YZvI45MLko8Pfso0jm46v5hqcVwrk6uddkGuT+Cdvu4WBqDzaDjnNa5UK3GfE1Wfl2gHxIIY5lLdUgWFts17D4WuolLLkiFZV+JSHMvH7eaLdT9N5GBovBwu5yYKUR7skR8Fu+LozcSqQixnlEZUfyE55feLOQTUYzLmR9pNtPbPsu6WVhbNHMS3Ss2+AehHvz+n64GDmXxbX++IOBvm2olHu3PsOUGRwhudhVf7UcGcunXt8cqNjKNqZLhLw4jq/xDg==",
-	}
-
-	return
+	// 更新订单状态为用户主动取消, 状态变更成功后恢复商品的库存
+	_, err = NewOrderStateMachine(ods.ctx).Fire(orderModel, &OrderStatusChange{
+		Event:   enum.OrderEventUserCancel,
+		Actor:   enum.OrderActorUser,
+		ActorId: userId,
+	})
+	// 状态机返回的 ErrOrderCanNotBeChanged 直接返回给调用方
+	return err
 }
 
 // StartOrderWxPay 把订单设置为开始支付的状态, 支付方式为微信支付
@@ -244,27 +420,112 @@ func (ods *OrderDomainSvc) StartOrderWxPay(orderNo string, userId int64) error {
 	return ods.setOrderStartPay(orderNo, userId, enum.PayTypeWxPay)
 }
 
+// StartOrderAliPay 把订单设置为开始支付的状态, 支付方式为支付宝
+func (ods *OrderDomainSvc) StartOrderAliPay(orderNo string, userId int64) error {
+	return ods.setOrderStartPay(orderNo, userId, enum.PayTypeAliPay)
+}
+
 // setOrderStartPay 把订单设置为开始支付的状态
+// 订单已经用同一种支付方式开始支付时不再变更, 向支付平台下单失败后可以重新发起支付
 func (ods *OrderDomainSvc) setOrderStartPay(orderNo string, userId int64, payType int) error {
 	order, err := ods.GetSpecifiedUserOrder(orderNo, userId)
 	if err != nil {
 		return err
 	}
+	if order.OrderStatus == enum.OrderStatusUnPaid && order.PayType == payType {
+		return nil
+	}
 	if order.OrderStatus != enum.OrderStatusCreated { // 订单不是初始状态,不能发起支付
 		err = errcode.ErrOrderParams
 		return err
 	}
-	order.PayType = payType
-	order.OrderStatus = enum.OrderStatusUnPaid // 订单状态--待支付
-	order.PayState = enum.PayStateUnPaid
 	orderModel := new(model.Order)
 	if err = util.CopyProperties(orderModel, order); err != nil {
-		err = errcode.Wrap("CreteOrderWxPayError", err)
+		return errcode.ErrCoverData.WithCause(err)
+	}
+	// 订单状态--待支付, 同时更新订单的支付类型和状态
+	transited, err := NewOrderStateMachine(ods.ctx).Fire(orderModel, &OrderStatusChange{
+		Event:   enum.OrderEventStartPay,
+		Actor:   enum.OrderActorUser,
+		ActorId: userId,
+		Updates: map[string]interface{}{
+			"pay_type":  payType,
+			"pay_state": enum.PayStateUnPaid,
+		},
+	})
+	if err != nil {
 		return err
 	}
-	if err = ods.orderDao.UpdateOrder(orderModel); err != nil { // 更新Order表的支付类型和状态
-		err = errcode.Wrap("CreteOrderWxPayError", err)
-		return err
+	if !transited { // 订单状态在读取后被并发修改了
+		return errcode.ErrOrderParams
+	}
+
+	return nil
+}
+
+// SettleOrderPay 用支付平台返回的支付结果结算订单
+// 支付结果通知、主动查询支付结果都走这个流程, 重复结算同一个支付结果不会重复更新订单
+func (ods *OrderDomainSvc) SettleOrderPay(payResult *do.OrderPayResult) error {
+	log := logger.New(ods.ctx)
+	orderModel, err := ods.orderDao.GetOrderByNo(payResult.OrderNo)
+	if err != nil {
+		return errcode.Wrap("SettleOrderPayError", err)
+	}
+	if orderModel.ID == 0 {
+		log.Error("SettleOrderPayError", "err", "订单不存在", "payResult", payResult)
+		return errcode.ErrOrderNotExists
+	}
+	if orderModel.PayState == enum.PayStatePaid {
+		// 订单已经结算过了, 重复的通知直接忽略
+		return nil
+	}
+	if payResult.PayState == enum.PayStateUnPaid {
+		// 用户还没完成支付, 不需要结算
+		return nil
+	}
+	if payResult.PayState == enum.PayStatePayFailed {
+		err = ods.orderDao.SetOrderPayFailed(orderModel.ID)
+		if err != nil {
+			return errcode.Wrap("SettleOrderPayError", err)
+		}
+		return nil
+	}
+	if payResult.PayMoney != orderModel.PayMoney {
+		log.Error("SettleOrderPayError", "err", "支付金额与订单金额不一致", "payResult", payResult,
+			"orderPayMoney", orderModel.PayMoney)
+		return errcode.ErrOrderPayMoneyMismatch
+	}
+	if !CanFire(orderModel.OrderStatus, enum.OrderEventPaySuccess, enum.OrderActorPayment) {
+		// 订单已经被关闭, 已关闭订单的支付需要人工介入退款
+		log.Error("SettleOrderPayError", "err", "订单关闭后收到付款, 未能结算支付结果",
+			"payResult", payResult, "orderStatus", orderModel.OrderStatus)
+		return errcode.ErrOrderPaidAfterClosed
+	}
+	settled, err := NewOrderStateMachine(ods.ctx).Fire(orderModel, &OrderStatusChange{
+		Event:  enum.OrderEventPaySuccess,
+		Actor:  enum.OrderActorPayment,
+		Remark: payResult.PayTransId,
+		Updates: map[string]interface{}{
+			"pay_trans_id": payResult.PayTransId,
+			"pay_state":    enum.PayStatePaid,
+			"paid_at":      payResult.PaidAt,
+		},
+	})
+	if err != nil {
+		return errcode.Wrap("SettleOrderPayError", err)
+	}
+	if !settled {
+		// 订单在读取后被并发结算或者被关闭了, 重新读取订单确认是哪一种
+		orderModel, err = ods.orderDao.GetOrderByNo(payResult.OrderNo)
+		if err != nil {
+			return errcode.Wrap("SettleOrderPayError", err)
+		}
+		if orderModel.PayState != enum.PayStatePaid {
+			// 已关闭订单的支付需要人工介入退款
+			log.Error("SettleOrderPayError", "err", "订单关闭后收到付款, 未能结算支付结果",
+				"payResult", payResult, "orderStatus", orderModel.OrderStatus)
+			return errcode.ErrOrderPaidAfterClosed
+		}
 	}
 
 	return nil
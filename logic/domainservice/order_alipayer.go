@@ -0,0 +1,122 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// AliOrderPayHandler 支付宝订单支付处理类
+type AliOrderPayHandler struct {
+	CommonOrderPayHandler
+}
+
+func (aliHandler *AliOrderPayHandler) LoadPayAndUserConfig() error {
+	aliPayConfig, err := newAliPayConfig()
+	if err != nil {
+		return errcode.Wrap("AliPayLoadConfigError", err)
+	}
+	aliHandler.PayConfig.AliPayConfig = aliPayConfig
+	aliHandler.PayConfig.PayUserId = aliHandler.UserId
+	return nil
+}
+
+func (aliHandler *AliOrderPayHandler) LoadOrderPayStrategy() error {
+	switch aliHandler.Scene {
+	case "web": // 电脑网站支付
+		aliHandler.PayStrategy = new(AliWebPayStrategy)
+	case "wap", "h5": // 手机网站支付
+		aliHandler.PayStrategy = new(AliWapPayStrategy)
+	case "app": // APP支付
+		aliHandler.PayStrategy = new(AliAppPayStrategy)
+	default:
+		return errcode.ErrOrderUnsupportedPayScene
+	}
+
+	return nil
+}
+
+// aliPayKeyProvider 应用私钥的提供者, 整个进程共用一个, 解析后的私钥缓存在其中
+var aliPayKeyProvider = sync.OnceValue(func() library.KeyProvider {
+	return library.NewKeyProvider(config.App.AliPay.KeyProvider, config.App.AliPay.KeySource)
+})
+
+// newAliPayConfig 用应用配置生成支付宝Lib需要的支付配置
+// 应用私钥由 aliPayKeyProvider 提供, 支付宝公钥从配置的文件读取, 都不打包进程序
+func newAliPayConfig() (*library.AliPayConfig, error) {
+	aliPayPublicKey, err := os.ReadFile(config.App.AliPay.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &library.AliPayConfig{
+		AppId:           config.App.AliPay.AppId,
+		KeyProvider:     aliPayKeyProvider(),
+		AliPayPublicKey: aliPayPublicKey,
+		GatewayUrl:      config.App.AliPay.GatewayUrl,
+		NotifyUrl:       config.App.AliPay.NotifyUrl,
+		ReturnUrl:       config.App.AliPay.ReturnUrl,
+	}, nil
+}
+
+// checkAliPayKeys 检查支付宝的应用私钥和支付宝公钥能否加载
+func checkAliPayKeys() error {
+	if _, err := aliPayKeyProvider().PrivateKey(config.App.AliPay.AppId); err != nil {
+		return err
+	}
+	aliPayPublicKey, err := os.ReadFile(config.App.AliPay.PublicKeyFile)
+	if err != nil {
+		return err
+	}
+	_, err = util.ParseRsaPublicKey(aliPayPublicKey)
+	return err
+}
+
+// AliWebPayStrategy 支付宝电脑网站支付接口实现
+type AliWebPayStrategy struct {
+}
+
+func (strategy *AliWebPayStrategy) CreatePay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig) (interface{}, error) {
+	return createAliPay(ctx, order, payConfig, (*library.AliPayLib).CreatePagePay)
+}
+
+// AliWapPayStrategy 支付宝手机网站支付接口实现
+type AliWapPayStrategy struct {
+}
+
+func (strategy *AliWapPayStrategy) CreatePay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig) (interface{}, error) {
+	return createAliPay(ctx, order, payConfig, (*library.AliPayLib).CreateWapPay)
+}
+
+// AliAppPayStrategy 支付宝APP支付接口实现
+type AliAppPayStrategy struct {
+}
+
+func (strategy *AliAppPayStrategy) CreatePay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig) (interface{}, error) {
+	return createAliPay(ctx, order, payConfig, (*library.AliPayLib).CreateAppPay)
+}
+
+// createAliPay 支付宝各个支付场景共用的发起支付流程, 不同场景只是调用的支付宝接口不同
+func createAliPay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig,
+	createPay func(*library.AliPayLib, *do.Order) (*library.AliPayInvokeInfo, error)) (interface{}, error) {
+	if payConfig.AliPayConfig == nil {
+		return nil, errcode.Wrap("AliPayStrategyCreatePayError", errors.New("alipay config not loaded"))
+	}
+	ods := NewOrderDomainSvc(ctx)
+	if err := ods.StartOrderAliPay(order.OrderNo, order.UserId); err != nil {
+		return nil, err
+	}
+	apl := library.NewAliPayLib(ctx, *payConfig.AliPayConfig)
+	reply, err := createPay(apl, order)
+	if err != nil {
+		err = errcode.Wrap("AliPayStrategyCreatePayError", err)
+	}
+	return reply, err
+}
@@ -0,0 +1,91 @@
+package domainservice
+
+import (
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+)
+
+// BuyAgain 再次购买, 把用户订单中还能购买的商品按原来的数量重新加入购物车
+// 已删除、已下架、库存不足的商品不加入购物车, 在结果中返回跳过的原因; 价格变化的商品照常加入并在结果中提示
+// 购物车中已有的商品数量补足到订单中的数量而不是累加, 重复再次购买不会让购物车中的数量越来越多;
+// 所有商品在一个事务里加入购物车, 要么全部加入要么都不加入
+func (ods *OrderDomainSvc) BuyAgain(orderNo string, userId int64) (*do.BuyAgainResult, error) {
+	order, err := ods.GetSpecifiedUserOrder(orderNo, userId)
+	if err != nil {
+		return nil, err
+	}
+	commodityIds := lo.Map(order.Items, func(item *do.OrderItem, _ int) int64 {
+		return item.CommodityId
+	})
+	// 删除了的商品查询不出来
+	commodities, err := dao.NewCommodityDao(ods.ctx).FindCommodities(commodityIds)
+	if err != nil {
+		return nil, errcode.Wrap("BuyAgainError", err)
+	}
+	commodityMap := lo.KeyBy(commodities, func(commodity *model.Commodity) int64 {
+		return commodity.ID
+	})
+	cartDao := dao.NewCartDao(ods.ctx)
+	userCartItems, err := cartDao.GetUserCartItems(userId)
+	if err != nil {
+		return nil, errcode.Wrap("BuyAgainError", err)
+	}
+	cartNums := lo.SliceToMap(userCartItems, func(cartItem *model.ShoppingCartItem) (int64, int) {
+		return cartItem.CommodityId, cartItem.CommodityNum
+	})
+
+	result := &do.BuyAgainResult{
+		AddedItems:        make([]*do.OrderItem, 0, len(order.Items)),
+		SkippedItems:      make([]*do.BuyAgainSkippedItem, 0),
+		PriceChangedItems: make([]*do.BuyAgainPriceChanged, 0),
+	}
+	cartItems := make([]*do.ShoppingCartItem, 0, len(order.Items))
+	for _, item := range order.Items {
+		commodity, exists := commodityMap[item.CommodityId]
+		skipReason := ""
+		switch {
+		case !exists:
+			skipReason = enum.BuyAgainSkipDeleted
+		case commodity.SellStatus != enum.CommoditySellStatusOn:
+			skipReason = enum.BuyAgainSkipOffShelf
+		case commodity.StockNum < max(cartNums[item.CommodityId], item.CommodityNum):
+			// 按加入后购物车中的数量初步判断库存是否充足, 下单时会重新判断
+			skipReason = enum.BuyAgainSkipOutOfStock
+		}
+		if skipReason != "" {
+			result.SkippedItems = append(result.SkippedItems, &do.BuyAgainSkippedItem{
+				CommodityId:   item.CommodityId,
+				CommodityName: item.CommodityName,
+				CommodityNum:  item.CommodityNum,
+				Reason:        skipReason,
+			})
+			continue
+		}
+
+		cartItems = append(cartItems, &do.ShoppingCartItem{
+			UserId:       userId,
+			CommodityId:  item.CommodityId,
+			CommodityNum: item.CommodityNum,
+		})
+		result.AddedItems = append(result.AddedItems, item)
+		if commodity.SellingPrice != item.CommoditySellingPrice {
+			result.PriceChangedItems = append(result.PriceChangedItems, &do.BuyAgainPriceChanged{
+				CommodityId:   item.CommodityId,
+				CommodityName: commodity.Name,
+				OrderedPrice:  item.CommoditySellingPrice,
+				CurrentPrice:  commodity.SellingPrice,
+			})
+		}
+	}
+
+	if len(cartItems) > 0 {
+		if err = NewCartDomainSvc(ods.ctx).CartEnsureItems(userId, cartItems); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package domainservice
+
+import (
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+)
+
+// defaultOrderPayTimeout 没有配置支付时限时使用的默认值
+const defaultOrderPayTimeout = 30 * time.Minute
+
+// newOrderPayDeadline 生成新订单的支付截止时间
+func newOrderPayDeadline() time.Time {
+	payTimeout := config.App.Order.PayTimeout
+	if payTimeout <= 0 {
+		payTimeout = defaultOrderPayTimeout
+	}
+	return time.Now().Add(payTimeout)
+}
+
+// CloseExpiredUnpaidOrders 关闭超过支付截止时间仍未支付的订单
+// @param limit 每次最多处理的订单数
+// @return closed 被关闭的订单数
+func (ods *OrderDomainSvc) CloseExpiredUnpaidOrders(limit int) (closed int, err error) {
+	log := logger.New(ods.ctx)
+	orderNos, err := cache.PopExpiredOrderPayDeadlines(ods.ctx, time.Now(), int64(limit))
+	if err != nil {
+		return 0, errcode.Wrap("CloseExpiredUnpaidOrdersError", err)
+	}
+	for _, orderNo := range orderNos {
+		isClosed, err := ods.CloseExpiredUnpaidOrder(orderNo)
+		if isClosed {
+			closed++
+		}
+		if err != nil {
+			log.Error("CloseExpiredUnpaidOrderError", "err", err, "orderNo", orderNo)
+			// 关闭失败的订单一分钟后重新放回队列再试, 队列里丢失的订单由 SweepExpiredUnpaidOrders 兜底关闭
+			if err = cache.AddOrderPayDeadline(ods.ctx, orderNo, time.Now().Add(time.Minute)); err != nil {
+				log.Error("CloseExpiredUnpaidOrderRequeueError", "err", err, "orderNo", orderNo)
+			}
+		}
+	}
+	return closed, nil
+}
+
+// CloseExpiredUnpaidOrder 关闭超时未支付的订单, 恢复订单商品的库存
+// 已经发起过支付的订单先向支付平台确认支付结果, 用户刚刚支付成功的订单会被结算而不是关闭
+// @return closed 订单是否被关闭
+func (ods *OrderDomainSvc) CloseExpiredUnpaidOrder(orderNo string) (closed bool, err error) {
+	log := logger.New(ods.ctx)
+	orderModel, err := ods.orderDao.GetOrderByNo(orderNo)
+	if err != nil {
+		return false, errcode.Wrap("CloseExpiredUnpaidOrderError", err)
+	}
+	if orderModel.ID == 0 || orderModel.PayState == enum.PayStatePaid ||
+		(orderModel.OrderStatus != enum.OrderStatusCreated && orderModel.OrderStatus != enum.OrderStatusUnPaid) {
+		// 订单不存在或者已经支付、已经关闭
+		return false, nil
+	}
+	if orderModel.PayDeadline.After(time.Now()) {
+		// 支付截止时间被延长过, 按新的截止时间重新入队
+		return false, cache.AddOrderPayDeadline(ods.ctx, orderNo, orderModel.PayDeadline)
+	}
+
+	if orderModel.OrderStatus == enum.OrderStatusUnPaid {
+		// 发起过支付的订单, 先确认用户是否已经付款
+		// 支付平台查询失败或者没能关闭支付平台的交易时不关单, 稍后重试, 避免关单后用户的付款需要人工退款
+		if err = ods.ReconcileOrderPay(orderModel); err != nil {
+			log.Error("CloseExpiredUnpaidOrderReconcileError", "err", err, "orderNo", orderNo)
+			return false, errcode.Wrap("CloseExpiredUnpaidOrderError", err)
+		}
+		// 关闭支付平台的交易, 避免用户在订单关闭后还能付款
+		if err = ods.closeOrderPayTrade(orderModel); err != nil {
+			log.Error("CloseExpiredUnpaidOrderClosePayTradeError", "err", err, "orderNo", orderNo,
+				"payType", orderModel.PayType)
+			return false, errcode.Wrap("CloseExpiredUnpaidOrderError", err)
+		}
+	}
+	// 只有仍未支付的订单会被关闭, 上面对账时结算了的订单在这里不会被更新; 关闭后恢复商品的库存
+	closed, err = NewOrderStateMachine(ods.ctx).Fire(orderModel, &OrderStatusChange{
+		Event: enum.OrderEventPayTimeout,
+		Actor: enum.OrderActorSystem,
+	})
+	if err != nil {
+		log.Error("CloseExpiredUnpaidOrderError", "err", err, "orderNo", orderNo)
+		return closed, errcode.Wrap("CloseExpiredUnpaidOrderError", err)
+	}
+	return closed, nil
+}
+
+// closeOrderPayTrade 关闭订单在支付平台上的交易
+// 沙箱支付没有真实的支付平台交易, 不需要关闭
+func (ods *OrderDomainSvc) closeOrderPayTrade(orderModel *model.Order) error {
+	switch orderModel.PayType {
+	case enum.PayTypeWxPay:
+		return library.NewWxPayLib(ods.ctx, *newWxPayConfig()).CloseOrder(orderModel.OrderNo)
+	case enum.PayTypeAliPay:
+		aliPayConfig, err := newAliPayConfig()
+		if err != nil {
+			return err
+		}
+		return library.NewAliPayLib(ods.ctx, *aliPayConfig).CloseTrade(orderModel.OrderNo)
+	}
+	return nil
+}
+
+// SweepExpiredUnpaidOrders 从数据库里找出超过支付截止时间仍未关闭的订单并关闭
+// 支付超时队列里的订单出队后才处理, 进程在两步之间退出或者入队失败的订单不会再出现在队列里, 由这里兜底关闭
+// @param grace 支付截止时间过去多久后才兜底关闭, 留给支付超时队列正常处理的时间
+// @return closed 被关闭的订单数
+func (ods *OrderDomainSvc) SweepExpiredUnpaidOrders(grace time.Duration, batchSize int) (closed int, err error) {
+	log := logger.New(ods.ctx)
+	deadlineBefore := time.Now().Add(-grace)
+	var lastId int64
+	for {
+		orders, err := ods.orderDao.GetExpiredUnpaidOrders(deadlineBefore, lastId, batchSize)
+		if err != nil {
+			return closed, errcode.Wrap("SweepExpiredUnpaidOrdersError", err)
+		}
+		for _, order := range orders {
+			// 单个订单关闭失败不影响其他订单, 下一轮会再次处理
+			isClosed, err := ods.CloseExpiredUnpaidOrder(order.OrderNo)
+			if err != nil {
+				log.Error("SweepExpiredUnpaidOrderError", "err", err, "orderNo", order.OrderNo)
+				continue
+			}
+			if isClosed {
+				closed++
+			}
+		}
+		if len(orders) < batchSize {
+			break
+		}
+		lastId = orders[len(orders)-1].ID
+	}
+
+	return closed, nil
+}
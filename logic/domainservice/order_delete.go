@@ -0,0 +1,89 @@
+package domainservice
+
+import (
+	"errors"
+
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+)
+
+// 用户删除订单
+// 删除只是把订单从用户的订单列表里移到已删除订单列表, 用户可以再恢复; 订单详情、退款、客服和管理后台的查询都不受影响。
+
+// deletableOrderStatus 用户可以删除订单的状态, 只有已经结束的订单可以删除
+var deletableOrderStatus = []int{
+	enum.OrderStatusCompleted, enum.OrderStatusUserQuit, enum.OrderStatusUnpaidClose,
+	enum.OrderStatusMerchantClose, enum.OrderStatusRefunded,
+}
+
+// DeleteUserOrder 用户删除订单, 删除拆单的父订单时子订单一起删除
+func (ods *OrderDomainSvc) DeleteUserOrder(orderNo string, userId int64) error {
+	order, err := ods.getUserVisibleOrder(orderNo, userId)
+	if err != nil {
+		return err
+	}
+	if order.IsDel == 1 {
+		return nil
+	}
+	if !lo.Contains(deletableOrderStatus, order.OrderStatus) {
+		return errcode.ErrOrderCanNotBeChanged.WithCause(errors.New("只能删除已完成、已取消或者已退款的订单"))
+	}
+	if err = ods.orderDao.SetUserOrderDeleted(order.ID, true); err != nil {
+		return errcode.Wrap("DeleteUserOrderError", err)
+	}
+	return nil
+}
+
+// RestoreUserOrder 用户恢复删除了的订单
+func (ods *OrderDomainSvc) RestoreUserOrder(orderNo string, userId int64) error {
+	order, err := ods.getUserVisibleOrder(orderNo, userId)
+	if err != nil {
+		return err
+	}
+	if order.IsDel == 0 {
+		return nil
+	}
+	if err = ods.orderDao.SetUserOrderDeleted(order.ID, false); err != nil {
+		return errcode.Wrap("RestoreUserOrderError", err)
+	}
+	return nil
+}
+
+// getUserVisibleOrder 查询用户在订单列表里能看到的订单, 拆单的订单支付前只能操作父订单, 支付后只能操作子订单
+func (ods *OrderDomainSvc) getUserVisibleOrder(orderNo string, userId int64) (*model.Order, error) {
+	order, err := ods.orderDao.GetOrderByNo(orderNo)
+	if err != nil {
+		return nil, errcode.Wrap("GetUserVisibleOrderError", err)
+	}
+	if order.ID == 0 || order.UserId != userId {
+		return nil, errcode.ErrOrderParams
+	}
+	if (order.OrderType == enum.OrderTypeParent && order.PayState == enum.PayStatePaid) ||
+		(order.OrderType == enum.OrderTypeSub && order.PayState != enum.PayStatePaid) {
+		return nil, errcode.ErrOrderCanNotBeChanged.WithCause(errors.New("拆单的订单支付前操作父订单, 支付后操作子订单"))
+	}
+	return order, nil
+}
+
+// GetUserDeletedOrders 分页查询用户删除了的订单
+func (ods *OrderDomainSvc) GetUserDeletedOrders(userId int64, pagination *app.Pagination) ([]*do.Order, error) {
+	orderModels, totalRow, err := ods.orderDao.GetUserDeletedOrders(userId, pagination.Offset(), pagination.GetPageSize())
+	if err != nil {
+		return nil, errcode.Wrap("GetUserDeletedOrdersError", err)
+	}
+	pagination.SetTotalRows(int(totalRow))
+	orders := make([]*do.Order, 0, len(orderModels))
+	if err = util.CopyProperties(&orders, &orderModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	if err = ods.fillOrdersDetail(orders); err != nil {
+		return nil, errcode.Wrap("GetUserDeletedOrdersError", err)
+	}
+
+	return orders, nil
+}
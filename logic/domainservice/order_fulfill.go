@@ -0,0 +1,67 @@
+package domainservice
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// 商家履约: 检货、发货、派送、送达
+// 订单状态都通过状态机变更, 触发变更的角色是商家
+
+// PickOrder 把已支付的订单标记为检货完成
+func (ods *OrderDomainSvc) PickOrder(orderNo string) error {
+	return ods.fireMerchantEvent(orderNo, enum.OrderEventCheck, "", nil)
+}
+
+// ShipOrder 订单发货, 在订单上记录物流公司和物流单号
+func (ods *OrderDomainSvc) ShipOrder(shipment *do.OrderShipment) error {
+	if _, exists := enum.ShipCarriers[shipment.CarrierCode]; !exists {
+		return errcode.ErrOrderShipCarrierInvalid.WithCause(fmt.Errorf("物流公司编码: %s", shipment.CarrierCode))
+	}
+	return ods.fireMerchantEvent(shipment.OrderNo, enum.OrderEventShip, shipment.CarrierCode+" "+shipment.TrackingNo,
+		map[string]interface{}{
+			"ship_carrier": shipment.CarrierCode,
+			"tracking_no":  shipment.TrackingNo,
+			"shipped_at":   time.Now(),
+		})
+}
+
+// StartOrderDelivery 把已发货的订单标记为派送中
+func (ods *OrderDomainSvc) StartOrderDelivery(orderNo string) error {
+	return ods.fireMerchantEvent(orderNo, enum.OrderEventStartDelivery, "", nil)
+}
+
+// DeliverOrder 把订单标记为已送达
+func (ods *OrderDomainSvc) DeliverOrder(orderNo string) error {
+	return ods.fireMerchantEvent(orderNo, enum.OrderEventDeliver, "", map[string]interface{}{
+		"delivered_at": time.Now(),
+	})
+}
+
+// fireMerchantEvent 由商家触发订单状态变更
+func (ods *OrderDomainSvc) fireMerchantEvent(orderNo, event, remark string, updates map[string]interface{}) error {
+	orderModel, err := ods.orderDao.GetOrderByNo(orderNo)
+	if err != nil {
+		return errcode.Wrap("FireMerchantOrderEventError", err)
+	}
+	if orderModel.ID == 0 {
+		return errcode.ErrOrderNotExists
+	}
+	transited, err := NewOrderStateMachine(ods.ctx).Fire(orderModel, &OrderStatusChange{
+		Event:   event,
+		Actor:   enum.OrderActorMerchant,
+		Remark:  remark,
+		Updates: updates,
+	})
+	if err != nil {
+		return err
+	}
+	if !transited { // 订单状态在读取后被并发修改了
+		return errcode.ErrOrderCanNotBeChanged
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package domainservice
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// HandleWxPayNotify 处理微信支付结果通知
+// 验证通知的签名、解密通知中的支付结果后用支付结果结算订单
+// @param serialNo HTTP头 Wechatpay-Serial, 签名所用的平台证书序列号
+// @param timestamp HTTP头 Wechatpay-Timestamp
+// @param nonce HTTP头 Wechatpay-Nonce
+// @param signature HTTP头 Wechatpay-Signature
+// @param rawBody 通知的原始请求体
+func (ods *OrderDomainSvc) HandleWxPayNotify(serialNo, timestamp, nonce, signature, rawBody string) error {
+	log := logger.New(ods.ctx)
+	if err := checkWxPayNotifyTimestamp(timestamp); err != nil {
+		log.Error("WxPayNotifyTimestampError", "err", err, "timestamp", timestamp)
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+	wxPayConfig := newWxPayConfig()
+	wpl := library.NewWxPayLib(ods.ctx, *wxPayConfig)
+	verified, err := wpl.ValidateNotifySignature(serialNo, timestamp, nonce, signature, rawBody)
+	if err != nil || !verified {
+		log.Error("WxPayNotifySignatureError", "err", err, "body", rawBody)
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+	resourceData, err := wpl.DecryptNotifyResourceData(rawBody)
+	if err != nil {
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+	log.Info("WxPayNotifyResource", "resource", resourceData)
+	// 只结算发给本商户的支付结果
+	if resourceData.Mchid != wxPayConfig.MchId ||
+		(resourceData.AppID != wxPayConfig.AppId && resourceData.AppID != wxPayConfig.AppAppId) {
+		log.Error("WxPayNotifyMerchantMismatch", "mchid", resourceData.Mchid, "appid", resourceData.AppID)
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(errors.New("支付通知的商户号或者AppID与配置不一致"))
+	}
+
+	return ods.settleNotifiedOrderPay(newWxOrderPayResult(resourceData))
+}
+
+// wxPayNotifyMaxDelay 微信支付通知的签名时间最多比当前时间早多久, 超过的通知可能是被截获后重放的, 不予处理
+const wxPayNotifyMaxDelay = 5 * time.Minute
+
+// checkWxPayNotifyTimestamp 检查通知的签名时间(HTTP头 Wechatpay-Timestamp, 秒级时间戳)是否在有效期内
+func checkWxPayNotifyTimestamp(timestamp string) error {
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return err
+	}
+	if delay := time.Since(time.Unix(signedAt, 0)); delay > wxPayNotifyMaxDelay || delay < -wxPayNotifyMaxDelay {
+		return fmt.Errorf("支付通知的签名时间已过期, 签名时间: %s", timestamp)
+	}
+	return nil
+}
+
+// settleNotifiedOrderPay 用支付通知中的支付结果结算订单
+// 订单不存在、支付金额与订单金额不一致或者订单关闭后才收到付款时, 支付平台重发多少次通知都结算不了,
+// 告警后按处理成功应答, 让支付平台停止重发, 由人工核实后处理(关闭后的付款需要退款), 每日的支付对账也会把这笔交易记录为差异
+func (ods *OrderDomainSvc) settleNotifiedOrderPay(payResult *do.OrderPayResult) error {
+	err := ods.SettleOrderPay(payResult)
+	if errors.Is(err, errcode.ErrOrderNotExists) || errors.Is(err, errcode.ErrOrderPayMoneyMismatch) ||
+		errors.Is(err, errcode.ErrOrderPaidAfterClosed) {
+		logger.New(ods.ctx).Error("PayNotifyUnsettleableAlert", "err", err, "payResult", payResult)
+		return nil
+	}
+	return err
+}
+
+// newWxOrderPayResult 把微信支付的交易信息转换成订单支付结果
+func newWxOrderPayResult(resourceData *library.WxPayNotifyResourceData) *do.OrderPayResult {
+	payResult := &do.OrderPayResult{
+		OrderNo:    resourceData.OutTradeNo,
+		PayType:    enum.PayTypeWxPay,
+		PayTransId: resourceData.TransactionID,
+		PayMoney:   resourceData.Amount.Total,
+		PaidAt:     resourceData.SuccessTime,
+	}
+	switch resourceData.TradeState {
+	case library.WxTradeStateSuccess:
+		payResult.PayState = enum.PayStatePaid
+	case library.WxTradeStatePayError, library.WxTradeStateClosed, library.WxTradeStateRevoked:
+		payResult.PayState = enum.PayStatePayFailed
+	default: // 其他状态说明用户还未完成支付
+		payResult.PayState = enum.PayStateUnPaid
+	}
+
+	return payResult
+}
+
+// HandleAliPayNotify 处理支付宝异步通知
+// 验证通知的签名后用通知中的交易信息结算订单
+// @param form 通知请求的表单参数
+func (ods *OrderDomainSvc) HandleAliPayNotify(form url.Values) error {
+	log := logger.New(ods.ctx)
+	aliPayConfig, err := newAliPayConfig()
+	if err != nil {
+		return errcode.Wrap("AliPayNotifyLoadConfigError", err)
+	}
+	notifyData, err := library.NewAliPayLib(ods.ctx, *aliPayConfig).VerifyNotify(form)
+	if err != nil {
+		log.Error("AliPayNotifySignatureError", "err", err, "form", form)
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+	log.Info("AliPayNotifyData", "data", notifyData)
+	payResult, err := newAliOrderPayResult(notifyData.OutTradeNo, notifyData.TradeNo, notifyData.TradeStatus,
+		notifyData.TotalAmount, notifyData.GmtPayment)
+	if err != nil {
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+
+	return ods.settleNotifiedOrderPay(payResult)
+}
+
+// newAliOrderPayResult 把支付宝的交易信息转换成订单支付结果
+func newAliOrderPayResult(outTradeNo, tradeNo, tradeStatus, totalAmount, paidAt string) (*do.OrderPayResult, error) {
+	payMoney, err := util.YuanToFen(totalAmount)
+	if err != nil {
+		return nil, err
+	}
+	payResult := &do.OrderPayResult{
+		OrderNo:    outTradeNo,
+		PayType:    enum.PayTypeAliPay,
+		PayTransId: tradeNo,
+		PayMoney:   payMoney,
+	}
+	switch tradeStatus {
+	case library.AliTradeStatusSuccess, library.AliTradeStatusFinished:
+		payResult.PayState = enum.PayStatePaid
+		// 支付宝返回的时间为北京时间
+		payResult.PaidAt, _ = time.ParseInLocation(enum.TimeFormatHyphenedYMDHIS, paidAt, time.Local)
+	case library.AliTradeStatusClosed:
+		payResult.PayState = enum.PayStatePayFailed
+	default: // 等待买家付款
+		payResult.PayState = enum.PayStateUnPaid
+	}
+
+	return payResult, nil
+}
@@ -0,0 +1,94 @@
+package domainservice
+
+import (
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// orderPayQueryMaxWait 主动查询订单支付结果时, 两次查询之间最长的退避等待时间
+const orderPayQueryMaxWait = 30 * time.Minute
+
+// ReconcileStuckOrderPays 对账发起支付后迟迟没有收到支付结果的订单
+// 支付结果通知丢失时, 用户已经付款的订单会一直停留在待支付, 这里主动向支付平台查询订单真实的支付结果
+// @param threshold 发起支付超过多久仍未收到支付结果的订单才去查询
+// @param batchSize 每批查询的订单数量
+// @return reconciled 完成对账的订单数
+func (ods *OrderDomainSvc) ReconcileStuckOrderPays(threshold time.Duration, batchSize int) (reconciled int, err error) {
+	log := logger.New(ods.ctx)
+	startedBefore := time.Now().Add(-threshold)
+	var lastId int64
+	for {
+		orders, err := ods.orderDao.GetStuckUnPaidOrders(startedBefore, lastId, batchSize)
+		if err != nil {
+			return reconciled, errcode.Wrap("ReconcileStuckOrderPaysError", err)
+		}
+		for _, order := range orders {
+			// 用户一直没有付款的订单每轮都会被查出来, 按查询次数退避, 避免每分钟都去查询支付平台
+			delayed, err := cache.IsOrderPayQueryDelayed(ods.ctx, order.OrderNo)
+			if err != nil {
+				log.Error("ReconcileOrderPayError", "err", err, "orderNo", order.OrderNo)
+				continue
+			}
+			if delayed {
+				continue
+			}
+			if err = cache.DelayOrderPayQuery(ods.ctx, order.OrderNo, orderPayQueryMaxWait); err != nil {
+				log.Error("ReconcileOrderPayError", "err", err, "orderNo", order.OrderNo)
+			}
+			// 单个订单对账失败不影响其他订单, 下一轮会再次对账
+			if err = ods.ReconcileOrderPay(order); err != nil {
+				log.Error("ReconcileOrderPayError", "err", err, "orderNo", order.OrderNo)
+				continue
+			}
+			reconciled++
+		}
+		if len(orders) < batchSize {
+			break
+		}
+		lastId = orders[len(orders)-1].ID
+	}
+
+	return reconciled, nil
+}
+
+// ReconcileOrderPay 向支付平台查询订单的支付结果, 用跟支付结果通知相同的流程结算订单
+func (ods *OrderDomainSvc) ReconcileOrderPay(order *model.Order) error {
+	var payResult *do.OrderPayResult
+	switch order.PayType {
+	case enum.PayTypeWxPay:
+		tradeData, err := library.NewWxPayLib(ods.ctx, *newWxPayConfig()).QueryOrderByOutTradeNo(order.OrderNo)
+		if err != nil {
+			return errcode.Wrap("ReconcileOrderPayError", err)
+		}
+		payResult = newWxOrderPayResult(tradeData)
+	case enum.PayTypeAliPay:
+		aliPayConfig, err := newAliPayConfig()
+		if err != nil {
+			return errcode.Wrap("ReconcileOrderPayError", err)
+		}
+		queryResult, err := library.NewAliPayLib(ods.ctx, *aliPayConfig).QueryTrade(order.OrderNo)
+		if queryResult != nil && queryResult.SubCode == library.AliPaySubCodeTradeNotExist {
+			// 用户还没有扫码或者登录付款, 支付宝还没有创建交易
+			return nil
+		}
+		if err != nil {
+			return errcode.Wrap("ReconcileOrderPayError", err)
+		}
+		payResult, err = newAliOrderPayResult(queryResult.OutTradeNo, queryResult.TradeNo, queryResult.TradeStatus,
+			queryResult.TotalAmount, queryResult.SendPayDate)
+		if err != nil {
+			return errcode.Wrap("ReconcileOrderPayError", err)
+		}
+	default: // 还没确定支付方式的订单不需要对账
+		return nil
+	}
+
+	return ods.SettleOrderPay(payResult)
+}
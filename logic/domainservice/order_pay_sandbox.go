@@ -0,0 +1,135 @@
+package domainservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// 沙箱支付模拟支付平台, 不调用任何真实的支付接口, 只在开发和测试环境可用。
+// 发起支付时创建一笔模拟交易, 再通过模拟接口指定支付结果, 支付结果跟真实的支付结果通知一样用 SettleOrderPay 结算订单。
+
+// 模拟的支付结果
+const (
+	SandboxPayResultSuccess = "success" // 支付成功
+	SandboxPayResultFail    = "fail"    // 支付失败
+	SandboxPayResultDelay   = "delay"   // 延迟一段时间后通知支付成功, 用来测试待支付状态下的流程
+)
+
+// sandboxPayDefaultDelay 没有指定延迟时间时, 延迟通知支付成功的默认时间
+const sandboxPayDefaultDelay = 10 * time.Second
+
+// SandboxPayEnabled 当前环境是否可以使用沙箱支付
+func SandboxPayEnabled() bool {
+	return config.App.Env == enum.ModeDev || config.App.Env == enum.ModeTest
+}
+
+// SandboxOrderPayHandler 沙箱订单支付处理类
+type SandboxOrderPayHandler struct {
+	CommonOrderPayHandler
+}
+
+func (sandboxHandler *SandboxOrderPayHandler) LoadPayAndUserConfig() error {
+	sandboxHandler.PayConfig.PayUserId = sandboxHandler.UserId
+	return nil
+}
+
+func (sandboxHandler *SandboxOrderPayHandler) LoadOrderPayStrategy() error {
+	// 沙箱支付不区分支付场景, 所有场景都用同一个策略
+	sandboxHandler.PayStrategy = new(SandboxPayStrategy)
+	return nil
+}
+
+// SandboxPayInvokeInfo 沙箱支付返回给客户端的支付信息
+type SandboxPayInvokeInfo struct {
+	OrderNo    string `json:"order_no"`
+	PayTransId string `json:"pay_trans_id"`
+	PayMoney   int    `json:"pay_money"`
+}
+
+// SandboxPayStrategy 沙箱支付策略, 创建一笔模拟交易
+type SandboxPayStrategy struct {
+}
+
+func (strategy *SandboxPayStrategy) CreatePay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig) (interface{}, error) {
+	if err := NewOrderDomainSvc(ctx).setOrderStartPay(order.OrderNo, order.UserId, enum.PayTypeSandbox); err != nil {
+		return nil, err
+	}
+	transaction := &do.SandboxPayTransaction{
+		OrderNo:    order.OrderNo,
+		PayTransId: fmt.Sprintf("SANDBOX%s", util.RandNumStr(20)),
+		PayMoney:   order.PayMoney,
+		CreatedAt:  time.Now(),
+	}
+	// 模拟交易在订单支付截止后就没用了, 多留一个小时方便排查问题
+	ttl := time.Until(order.PayDeadline) + time.Hour
+	if err := cache.SetSandboxPayTransaction(ctx, transaction, ttl); err != nil {
+		return nil, errcode.Wrap("SandboxPayStrategyCreatePayError", err)
+	}
+
+	return &SandboxPayInvokeInfo{
+		OrderNo:    transaction.OrderNo,
+		PayTransId: transaction.PayTransId,
+		PayMoney:   transaction.PayMoney,
+	}, nil
+}
+
+// SimulateSandboxPay 模拟沙箱支付的支付结果通知
+// @param result 模拟的支付结果 success | fail | delay
+// @param delay 延迟通知的时间, 只对 delay 生效, 为 0 时使用默认的延迟时间
+func (ods *OrderDomainSvc) SimulateSandboxPay(orderNo string, userId int64, result string, delay time.Duration) error {
+	if !SandboxPayEnabled() {
+		return errcode.ErrOrderSandboxPayDisabled
+	}
+	order, err := ods.GetSpecifiedUserOrder(orderNo, userId)
+	if err != nil {
+		return err
+	}
+	if order.PayType != enum.PayTypeSandbox {
+		return errcode.ErrOrderParams
+	}
+	transaction, err := cache.GetSandboxPayTransaction(ods.ctx, orderNo)
+	if err != nil {
+		return errcode.Wrap("SimulateSandboxPayError", err)
+	}
+	if transaction == nil { // 没有发起过沙箱支付或者模拟交易已过期
+		return errcode.ErrOrderParams
+	}
+
+	payResult := &do.OrderPayResult{
+		OrderNo:    transaction.OrderNo,
+		PayType:    enum.PayTypeSandbox,
+		PayTransId: transaction.PayTransId,
+		PayMoney:   transaction.PayMoney,
+		PayState:   enum.PayStatePaid,
+	}
+	switch result {
+	case SandboxPayResultFail:
+		payResult.PayState = enum.PayStatePayFailed
+	case SandboxPayResultDelay:
+		if delay <= 0 {
+			delay = sandboxPayDefaultDelay
+		}
+		// 请求的 ctx 在请求结束后就不能再用了, 延迟通知使用新的 ctx, 保留追踪ID方便在日志里关联
+		notifyCtx := context.WithValue(context.Background(), "traceid", ods.ctx.Value("traceid"))
+		notifyCtx = context.WithValue(notifyCtx, "spanid", ods.ctx.Value("spanid"))
+		time.AfterFunc(delay, func() {
+			payResult.PaidAt = time.Now()
+			if err := NewOrderDomainSvc(notifyCtx).SettleOrderPay(payResult); err != nil {
+				logger.New(notifyCtx).Error("SimulateSandboxPayDelayError", "err", err, "orderNo", orderNo)
+			}
+		})
+		return nil
+	}
+	payResult.PaidAt = time.Now()
+
+	return ods.SettleOrderPay(payResult)
+}
@@ -2,11 +2,17 @@ package domainservice
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/WoWBytePaladin/go-mall/common/enum"
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
 	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
 	"github.com/WoWBytePaladin/go-mall/library"
 	"github.com/WoWBytePaladin/go-mall/logic/do"
 )
@@ -21,6 +27,8 @@ type OrderPayTemplateContract interface {
 type OrderPayHandlerContract interface {
 	// CheckRepetition 防重校验
 	CheckRepetition() error
+	// ReleaseRepetition 支付流程结束后释放防重校验占用的资源
+	ReleaseRepetition()
 	// ValidateOrder 检验Order参数是否符合预期
 	ValidateOrder() error
 	// LoadPayAndUserConfig 加载支付配置和支付平台需要的一些用户信息--比如微信的openID
@@ -50,6 +58,7 @@ func (template OrderPayTemplate) CreateOrderPay() (interface{}, error) {
 	if err := template.CheckRepetition(); err != nil {
 		return nil, err
 	}
+	defer template.ReleaseRepetition()
 	// 校验参数是否符合预期
 	if err := template.ValidateOrder(); err != nil {
 		return nil, err
@@ -71,11 +80,15 @@ func (template OrderPayTemplate) CreateOrderPay() (interface{}, error) {
 	return response, nil
 }
 
+// orderPayReplyMaxTTL 支付信息缓存的最长有效期
+const orderPayReplyMaxTTL = 2 * time.Hour
+
 type OrderPayConfig struct {
-	PayUserId   int64
-	WxOpenId    string
-	WxPayConfig *library.WxtPayConfig
-	//AliPayConfig
+	PayUserId    int64
+	WxOpenId     string
+	ClientIp     string // 用户的客户端IP, 微信H5支付下单时需要
+	WxPayConfig  *library.WxtPayConfig
+	AliPayConfig *library.AliPayConfig
 }
 
 // CommonOrderPayHandler 支付处理的通用类, 只实现参数校验这样的每个支付方式都需要做的通用操作。
@@ -83,26 +96,67 @@ type OrderPayConfig struct {
 type CommonOrderPayHandler struct {
 	ctx       context.Context
 	Scene     string // 支付场景 H5 、app、小程序 jsapi(公众号、线下、PC网页) 等 -- 对应支付平台不同支付场景
+	PayType   int    // 支付方式
 	UserId    int64
 	OrderNo   string // 业务订单号
 	Order     *do.Order
 	PayConfig *OrderPayConfig
-	// 这里还可以继续定义AliPayConfig 字段
 
 	PayStrategy OrderPayStrategyContract // 支付策略
+
+	locked      bool            // 是否持有订单发起支付的锁
+	cachedReply json.RawMessage // 之前发起支付时缓存的支付信息
 }
 
+// CheckRepetition 用 Redis 做防重校验
+// 之前发起支付的支付信息还在有效期内时直接复用, 不再重复向支付平台下单;
+// 同一个订单有发起支付的请求正在处理时, 返回请求过多
 func (handler *CommonOrderPayHandler) CheckRepetition() error {
-	// 根据自己的业务量, 用 Redis 或者更高级的方式做防重校验
+	cachedReply, err := cache.GetOrderPayReply(handler.ctx, handler.OrderNo, handler.PayType, handler.Scene)
+	if err != nil {
+		return errcode.Wrap("CheckOrderPayRepetitionError", err)
+	}
+	if cachedReply != nil {
+		handler.cachedReply = cachedReply
+		return nil
+	}
+	locked, err := cache.LockOrderPay(handler.ctx, handler.OrderNo)
+	if err != nil {
+		return errcode.Wrap("CheckOrderPayRepetitionError", err)
+	}
+	if !locked {
+		return errcode.ErrTooManyRequests
+	}
+	handler.locked = true
 	return nil
 }
 
+func (handler *CommonOrderPayHandler) ReleaseRepetition() {
+	if !handler.locked {
+		return
+	}
+	if err := cache.UnlockOrderPay(handler.ctx, handler.OrderNo); err != nil {
+		logger.New(handler.ctx).Error("ReleaseOrderPayRepetitionError", "err", err, "orderNo", handler.OrderNo)
+	}
+	handler.locked = false
+}
+
 func (handler *CommonOrderPayHandler) ValidateOrder() error {
 	order, err := NewOrderDomainSvc(handler.ctx).GetSpecifiedUserOrder(handler.OrderNo, handler.UserId)
 	if err != nil {
 		return err
 	}
-	if order.OrderStatus > enum.OrderStatusCreated {
+	switch {
+	case handler.cachedReply != nil:
+		// 复用之前的支付信息时订单应该还在待支付, 订单支付成功或者关闭后不能再发起支付
+		if order.OrderStatus != enum.OrderStatusUnPaid {
+			return errcode.ErrOrderParams
+		}
+	case order.OrderStatus == enum.OrderStatusCreated:
+	case order.OrderStatus == enum.OrderStatusUnPaid && order.PayType == handler.PayType:
+		// 之前用同一种支付方式发起过支付, 上次向支付平台下单失败或者换了支付场景时可以重新下单,
+		// 支付平台按业务订单号识别是同一笔交易
+	default:
 		return errcode.ErrOrderParams // 订单状态不对, 不能发起支付
 	}
 
@@ -122,7 +176,33 @@ func (handler *CommonOrderPayHandler) LoadOrderPayStrategy() error {
 }
 
 func (handler *CommonOrderPayHandler) HandleOrderPay() (interface{}, error) {
-	return handler.PayStrategy.CreatePay(handler.ctx, handler.Order, handler.PayConfig)
+	if handler.cachedReply != nil {
+		return handler.cachedReply, nil
+	}
+	reply, err := handler.PayStrategy.CreatePay(handler.ctx, handler.Order, handler.PayConfig)
+	if err != nil {
+		return nil, err
+	}
+	handler.cachePayReply(reply)
+	return reply, nil
+}
+
+// cachePayReply 缓存支付信息, 有效期为微信预支付交易会话的有效期2小时, 且不超过订单的支付截止时间
+func (handler *CommonOrderPayHandler) cachePayReply(reply interface{}) {
+	ttl := time.Until(handler.Order.PayDeadline)
+	if ttl > orderPayReplyMaxTTL {
+		ttl = orderPayReplyMaxTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	replyBytes, err := json.Marshal(reply)
+	if err == nil {
+		err = cache.SetOrderPayReply(handler.ctx, handler.OrderNo, handler.PayType, handler.Scene, replyBytes, ttl)
+	}
+	if err != nil {
+		logger.New(handler.ctx).Error("CacheOrderPayReplyError", "err", err, "orderNo", handler.OrderNo)
+	}
 }
 
 // WxOrderPayHandler 微信订单支付处理类
@@ -131,13 +211,7 @@ type WxOrderPayHandler struct {
 }
 
 func (wxHandler *WxOrderPayHandler) LoadPayAndUserConfig() error {
-	wxHandler.PayConfig.WxPayConfig = &library.WxtPayConfig{
-		AppId:           config.App.WechatPay.AppId,
-		MchId:           config.App.WechatPay.MchId,
-		PrivateSerialNo: config.App.WechatPay.PrivateSerialNo,
-		AesKey:          config.App.WechatPay.AesKey,
-		NotifyUrl:       config.App.WechatPay.NotifyUrl,
-	}
+	wxHandler.PayConfig.WxPayConfig = newWxPayConfig()
 	wxHandler.PayConfig.PayUserId = wxHandler.UserId
 	// 用userId获取对应的Openid, 这里先Mock一个
 	// xxx.GetUserOpenId(wxHandler.userId)
@@ -149,18 +223,56 @@ func (wxHandler *WxOrderPayHandler) LoadPayAndUserConfig() error {
 func (wxHandler *WxOrderPayHandler) LoadOrderPayStrategy() error {
 	switch wxHandler.Scene {
 	case "app": // app 支付
-		return errcode.ErrOrderUnsupportedPayScene
-	// 加载app的支付策略类
+		wxHandler.PayStrategy = new(WxAppPayStrategy)
+	case "h5": // 手机浏览器中的H5支付
+		wxHandler.PayStrategy = new(WxH5PayStrategy)
+	case "native": // PC网页扫码支付
+		wxHandler.PayStrategy = new(WxNativePayStrategy)
 	case "jsapi": // 网页支付
 		// 加载封装了微信支付JSAPI的策略类
 		wxHandler.PayStrategy = new(WxJSPayStrategy)
 	default:
-		return errcode.ErrOrderParams.WithCause(errors.New("unsupported platform"))
+		return errcode.ErrOrderUnsupportedPayScene
 	}
 
 	return nil
 }
 
+// wxPayKeyProvider 商户私钥的提供者, 整个进程共用一个, 解析后的私钥缓存在其中
+var wxPayKeyProvider = sync.OnceValue(func() library.KeyProvider {
+	return library.NewKeyProvider(config.App.WechatPay.KeyProvider, config.App.WechatPay.KeySource)
+})
+
+// CheckPayKeys 检查已开通的支付渠道的密钥能否加载, 在服务启动时调用
+// 密钥缺失或格式不对时让服务启动失败, 而不是等到用户支付时才发现
+func CheckPayKeys() error {
+	if config.App.WechatPay.MchId != "" {
+		if _, err := wxPayKeyProvider().PrivateKey(config.App.WechatPay.MchId); err != nil {
+			return fmt.Errorf("微信支付商户私钥加载失败: %w", err)
+		}
+	}
+	if config.App.AliPay.AppId != "" {
+		if err := checkAliPayKeys(); err != nil {
+			return fmt.Errorf("支付宝密钥加载失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// newWxPayConfig 用应用配置生成微信支付Lib需要的支付配置
+func newWxPayConfig() *library.WxtPayConfig {
+	return &library.WxtPayConfig{
+		AppId:           config.App.WechatPay.AppId,
+		AppAppId:        config.App.WechatPay.AppAppId,
+		MchId:           config.App.WechatPay.MchId,
+		PrivateSerialNo: config.App.WechatPay.PrivateSerialNo,
+		AesKey:          config.App.WechatPay.AesKey,
+		NotifyUrl:       config.App.WechatPay.NotifyUrl,
+		RefundNotifyUrl: config.App.WechatPay.RefundNotifyUrl,
+		KeyProvider:     wxPayKeyProvider(),
+	}
+}
+
 // WxJSPayStrategy 微信JSAPI 支付接口实现
 type WxJSPayStrategy struct {
 }
@@ -178,28 +290,82 @@ func (strategy *WxJSPayStrategy) CreatePay(ctx context.Context, order *do.Order,
 	return reply, err
 }
 
-// type WxAppPayStrategy
-// ......
+// WxAppPayStrategy 微信APP支付接口实现
+type WxAppPayStrategy struct {
+}
+
+func (strategy *WxAppPayStrategy) CreatePay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig) (interface{}, error) {
+	if err := NewOrderDomainSvc(ctx).StartOrderWxPay(order.OrderNo, order.UserId); err != nil {
+		return nil, err
+	}
+	reply, err := library.NewWxPayLib(ctx, *payConfig.WxPayConfig).CreateAppOrderPay(order)
+	if err != nil {
+		err = errcode.Wrap("WxAppPayStrategyCreatePayError", err)
+	}
+	return reply, err
+}
+
+// WxH5PayStrategy 微信H5支付接口实现
+type WxH5PayStrategy struct {
+}
+
+func (strategy *WxH5PayStrategy) CreatePay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig) (interface{}, error) {
+	if err := NewOrderDomainSvc(ctx).StartOrderWxPay(order.OrderNo, order.UserId); err != nil {
+		return nil, err
+	}
+	reply, err := library.NewWxPayLib(ctx, *payConfig.WxPayConfig).CreateH5OrderPay(order, payConfig.ClientIp)
+	if err != nil {
+		err = errcode.Wrap("WxH5PayStrategyCreatePayError", err)
+	}
+	return reply, err
+}
+
+// WxNativePayStrategy 微信Native扫码支付接口实现
+type WxNativePayStrategy struct {
+}
+
+func (strategy *WxNativePayStrategy) CreatePay(ctx context.Context, order *do.Order, payConfig *OrderPayConfig) (interface{}, error) {
+	if err := NewOrderDomainSvc(ctx).StartOrderWxPay(order.OrderNo, order.UserId); err != nil {
+		return nil, err
+	}
+	reply, err := library.NewWxPayLib(ctx, *payConfig.WxPayConfig).CreateNativeOrderPay(order)
+	if err != nil {
+		err = errcode.Wrap("WxNativePayStrategyCreatePayError", err)
+	}
+	return reply, err
+}
 
 // NewOrderPayTemplate
 // 创建订单支付模版的工厂方法
 // @param ctx
 // @param userId
 // @param orderNo
-// @param payScene 支付场景 app h5 jsapi min-app...
-// @param payType 支付类型  微信支付｜支付宝 ｜ ...
-func NewOrderPayTemplate(ctx context.Context, userId int64, orderNo, payScene string, payType int) *OrderPayTemplate {
+// @param payScene 支付场景 app h5 jsapi native min-app...
+// @param clientIp 用户的客户端IP
+// @param payType 支付类型  微信支付｜支付宝 ｜ 沙箱支付 ｜ ...
+func NewOrderPayTemplate(ctx context.Context, userId int64, orderNo, payScene, clientIp string, payType int) (*OrderPayTemplate, error) {
+	commonHandler := CommonOrderPayHandler{
+		ctx:       ctx,
+		Scene:     payScene,
+		PayType:   payType,
+		UserId:    userId,
+		OrderNo:   orderNo,
+		PayConfig: &OrderPayConfig{ClientIp: clientIp},
+	}
 	payTemplate := new(OrderPayTemplate)
 	switch payType {
 	case enum.PayTypeWxPay: // 微信支付
-		payHandler := new(WxOrderPayHandler)
-		payHandler.ctx = ctx
-		payHandler.UserId = userId
-		payHandler.OrderNo = orderNo
-		payHandler.Scene = payScene
-		payTemplate.OrderPayHandlerContract = payHandler
-
+		payTemplate.OrderPayHandlerContract = &WxOrderPayHandler{CommonOrderPayHandler: commonHandler}
+	case enum.PayTypeAliPay: // 支付宝
+		payTemplate.OrderPayHandlerContract = &AliOrderPayHandler{CommonOrderPayHandler: commonHandler}
+	case enum.PayTypeSandbox: // 沙箱支付
+		if !SandboxPayEnabled() {
+			return nil, errcode.ErrOrderSandboxPayDisabled
+		}
+		payTemplate.OrderPayHandlerContract = &SandboxOrderPayHandler{CommonOrderPayHandler: commonHandler}
+	default:
+		return nil, errcode.ErrOrderParams.WithCause(errors.New("unsupported pay type"))
 	}
 
-	return payTemplate
+	return payTemplate, nil
 }
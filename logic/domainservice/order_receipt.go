@@ -0,0 +1,133 @@
+package domainservice
+
+import (
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+)
+
+const (
+	// defaultOrderAutoConfirmDays 没有配置时, 订单送达后自动确认收货的天数
+	defaultOrderAutoConfirmDays = 7
+	// defaultOrderReviewWindowDays 没有配置时, 确认收货后订单自动完成的天数
+	defaultOrderReviewWindowDays = 15
+)
+
+// orderAutoConfirmDuration 订单送达后多久自动确认收货
+func orderAutoConfirmDuration() time.Duration {
+	days := config.App.Order.AutoConfirmDays
+	if days <= 0 {
+		days = defaultOrderAutoConfirmDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// orderReviewWindowDuration 确认收货后多久订单自动完成
+func orderReviewWindowDuration() time.Duration {
+	days := config.App.Order.ReviewWindowDays
+	if days <= 0 {
+		days = defaultOrderReviewWindowDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ConfirmOrderReceipt 用户确认收货
+func (ods *OrderDomainSvc) ConfirmOrderReceipt(orderNo string, userId int64) error {
+	order, err := ods.GetSpecifiedUserOrder(orderNo, userId)
+	if err != nil {
+		return err
+	}
+	orderModel := new(model.Order)
+	if err = util.CopyProperties(orderModel, order); err != nil {
+		return errcode.ErrCoverData.WithCause(err)
+	}
+	transited, err := NewOrderStateMachine(ods.ctx).Fire(orderModel, &OrderStatusChange{
+		Event:   enum.OrderEventConfirmReceipt,
+		Actor:   enum.OrderActorUser,
+		ActorId: userId,
+		Updates: map[string]interface{}{"confirmed_at": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	if !transited { // 订单状态在读取后被并发修改了
+		return errcode.ErrOrderCanNotBeChanged
+	}
+	return nil
+}
+
+// AutoConfirmDeliveredOrders 为送达后超过期限仍未确认收货的订单自动确认收货
+// @return confirmed 自动确认收货的订单数
+func (ods *OrderDomainSvc) AutoConfirmDeliveredOrders(batchSize int) (confirmed int, err error) {
+	log := logger.New(ods.ctx)
+	deliveredBefore := time.Now().Add(-orderAutoConfirmDuration())
+	stateMachine := NewOrderStateMachine(ods.ctx)
+	var lastId int64
+	for {
+		orders, err := ods.orderDao.GetDeliveredOrdersBefore(deliveredBefore, lastId, batchSize)
+		if err != nil {
+			return confirmed, errcode.Wrap("AutoConfirmDeliveredOrdersError", err)
+		}
+		for _, order := range orders {
+			// 单个订单失败不影响其他订单, 下一轮会再次处理
+			transited, err := stateMachine.Fire(order, &OrderStatusChange{
+				Event:   enum.OrderEventConfirmReceipt,
+				Actor:   enum.OrderActorSystem,
+				Remark:  "超时自动确认收货",
+				Updates: map[string]interface{}{"confirmed_at": time.Now()},
+			})
+			if err != nil {
+				log.Error("AutoConfirmOrderReceiptError", "err", err, "orderNo", order.OrderNo)
+				continue
+			}
+			if transited {
+				confirmed++
+			}
+		}
+		if len(orders) < batchSize {
+			break
+		}
+		lastId = orders[len(orders)-1].ID
+	}
+
+	return confirmed, nil
+}
+
+// CompleteConfirmedOrders 完成确认收货后已过评价期的订单
+// @return completed 完成的订单数
+func (ods *OrderDomainSvc) CompleteConfirmedOrders(batchSize int) (completed int, err error) {
+	log := logger.New(ods.ctx)
+	confirmedBefore := time.Now().Add(-orderReviewWindowDuration())
+	stateMachine := NewOrderStateMachine(ods.ctx)
+	var lastId int64
+	for {
+		orders, err := ods.orderDao.GetConfirmedOrdersBefore(confirmedBefore, lastId, batchSize)
+		if err != nil {
+			return completed, errcode.Wrap("CompleteConfirmedOrdersError", err)
+		}
+		for _, order := range orders {
+			transited, err := stateMachine.Fire(order, &OrderStatusChange{
+				Event: enum.OrderEventComplete,
+				Actor: enum.OrderActorSystem,
+			})
+			if err != nil {
+				log.Error("CompleteOrderError", "err", err, "orderNo", order.OrderNo)
+				continue
+			}
+			if transited {
+				completed++
+			}
+		}
+		if len(orders) < batchSize {
+			break
+		}
+		lastId = orders[len(orders)-1].ID
+	}
+
+	return completed, nil
+}
@@ -0,0 +1,470 @@
+package domainservice
+
+import (
+	"errors"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// refundableOrderStatus 可以申请退款的订单状态
+var refundableOrderStatus = []int{
+	enum.OrderStatusPaid, enum.OrderStatusChecked, enum.OrderStatusShipped, enum.OrderStatusOnDelivery,
+	enum.OrderStatusDelivered, enum.OrderStatusConfirmReceipt, enum.OrderStatusCompleted, enum.OrderStatusPartialRefunded,
+}
+
+// refundablePayTypes 支持退款的支付方式, 其他支付方式的订单暂时只能线下处理退款
+var refundablePayTypes = []int{enum.PayTypeWxPay}
+
+// ApplyOrderRefund 用户申请退款
+// @param orderNo 订单号
+// @param userId 用户ID
+// @param reason 退款原因
+// @param refundItems 要退的商品和数量, 为空时整单退款
+func (ods *OrderDomainSvc) ApplyOrderRefund(orderNo string, userId int64, reason string, refundItems []*do.OrderRefundItem) (*do.OrderRefund, error) {
+	order, err := ods.GetSpecifiedUserOrder(orderNo, userId)
+	if err != nil {
+		return nil, err
+	}
+	if order.PayState != enum.PayStatePaid || !lo.Contains(refundableOrderStatus, order.OrderStatus) {
+		return nil, errcode.ErrOrderRefundNotAllowed
+	}
+	if order.OrderType == enum.OrderTypeParent {
+		return nil, errcode.ErrOrderRefundNotAllowed.WithCause(errors.New("拆单的订单需要按子订单申请退款"))
+	}
+	if !lo.Contains(refundablePayTypes, order.PayType) {
+		// 审核时也发起不了退款, 不创建注定无法通过的退款申请
+		return nil, errcode.ErrOrderRefundNotAllowed.WithCause(errors.New("暂不支持该支付方式的退款"))
+	}
+	invoices, err := dao.NewInvoiceDao(ods.ctx).GetOrderInvoices(order.ID)
+	if err != nil {
+		return nil, errcode.Wrap("ApplyOrderRefundError", err)
+	}
+	// 发票按退款前的金额开具, 已经开具或者正在开具发票的订单要先红冲发票才能退款
+	if lo.ContainsBy(invoices, func(invoice *model.OrderInvoice) bool {
+		return invoice.InvoiceState == enum.InvoiceStateIssued || invoice.InvoiceState == enum.InvoiceStateIssuing
+	}) {
+		return nil, errcode.ErrOrderRefundNotAllowed.WithCause(errors.New("订单已开具发票, 需要先红冲发票"))
+	}
+	refundDao := dao.NewOrderRefundDao(ods.ctx)
+	refunds, err := refundDao.GetOrderRefunds(order.ID)
+	if err != nil {
+		return nil, errcode.Wrap("ApplyOrderRefundError", err)
+	}
+	// 同一时间只能有一个在处理中的退款申请, 避免多个申请一起退款时退款金额超过支付金额
+	if lo.ContainsBy(refunds, func(refund *model.OrderRefund) bool {
+		return refund.RefundState == enum.RefundStatePending || refund.RefundState == enum.RefundStateProcessing
+	}) {
+		return nil, errcode.ErrOrderRefundNotAllowed.WithCause(errors.New("订单有正在处理的退款申请"))
+	}
+	refundedMoney, refundedItems, err := ods.getOrderRefunded(refunds)
+	if err != nil {
+		return nil, err
+	}
+	refundableMoney := order.PayMoney - refundedMoney
+	if refundableMoney <= 0 {
+		return nil, errcode.ErrOrderRefundNotAllowed.WithCause(errors.New("订单已全部退款"))
+	}
+
+	refundNo, err := NewIdGenDomainSvc(ods.ctx).GenRefundNo()
+	if err != nil {
+		return nil, err
+	}
+	refund := &do.OrderRefund{
+		RefundNo:     refundNo,
+		OrderId:      order.ID,
+		OrderNo:      order.OrderNo,
+		UserId:       userId,
+		PayType:      order.PayType,
+		IsFullRefund: len(refundItems) == 0,
+		Reason:       reason,
+		RefundState:  enum.RefundStatePending,
+	}
+	orderItems := lo.SliceToMap(order.Items, func(item *do.OrderItem) (int64, *do.OrderItem) {
+		return item.CommodityId, item
+	})
+	if refund.IsFullRefund {
+		// 整单退款 -- 退还订单剩余的全部商品和金额
+		for _, orderItem := range order.Items {
+			if remainNum := orderItem.CommodityNum - refundedItems[orderItem.CommodityId].num; remainNum > 0 {
+				refundItems = append(refundItems, &do.OrderRefundItem{CommodityId: orderItem.CommodityId, CommodityNum: remainNum})
+			}
+		}
+	}
+	for _, refundItem := range refundItems {
+		orderItem, exists := orderItems[refundItem.CommodityId]
+		if !exists || refundItem.CommodityNum <= 0 ||
+			refundItem.CommodityNum > orderItem.CommodityNum-refundedItems[refundItem.CommodityId].num {
+			return nil, errcode.ErrOrderRefundParams
+		}
+		refundItem.OrderId = order.ID
+		refundItem.RefundMoney = orderItemRefundMoney(orderItem, refundedItems[refundItem.CommodityId], refundItem.CommodityNum)
+		refund.RefundMoney += refundItem.RefundMoney
+	}
+	refund.Items = refundItems
+	// 订单有优惠时商品价格之和会大于实付金额, 退款金额不能超过订单剩余可退的金额
+	if refund.IsFullRefund || refund.RefundMoney > refundableMoney {
+		refund.RefundMoney = refundableMoney
+	}
+
+	if err = refundDao.CreateOrderRefund(refund); err != nil {
+		return nil, errcode.Wrap("ApplyOrderRefundError", err)
+	}
+	return refund, nil
+}
+
+// orderItemRefunded 购物项已经退款成功的商品数量和金额
+type orderItemRefunded struct {
+	num   int
+	money int
+}
+
+// orderItemRefundMoney 计算退还购物项中 refundNum 件商品要退的金额, 按购物项分摊优惠后的实付金额计算
+// 先按累计退的件数算出累计应退的金额, 再减去已经退了的金额, 每次按件数计算舍去的零头会留到后面退,
+// 购物项的商品全部退完时正好退完购物项的实付金额
+func orderItemRefundMoney(orderItem *do.OrderItem, refunded orderItemRefunded, refundNum int) int {
+	paidMoney := orderItem.PaidMoney
+	if orderItem.PaidMoney == 0 && orderItem.DiscountMoney == 0 {
+		// 记录价格明细之前创建的订单, 购物项上没有实付金额, 按商品售价计算
+		paidMoney = orderItem.CommoditySellingPrice * orderItem.CommodityNum
+	}
+	return max(paidMoney*(refunded.num+refundNum)/orderItem.CommodityNum-refunded.money, 0)
+}
+
+// getOrderRefunded 统计订单已经退款成功的金额和每个商品已退的数量和金额
+func (ods *OrderDomainSvc) getOrderRefunded(refunds []*model.OrderRefund) (refundedMoney int, refundedItems map[int64]orderItemRefunded, err error) {
+	refundedItems = make(map[int64]orderItemRefunded)
+	successRefunds := lo.Filter(refunds, func(refund *model.OrderRefund, _ int) bool {
+		return refund.RefundState == enum.RefundStateSuccess
+	})
+	if len(successRefunds) == 0 {
+		return
+	}
+	refundIds := lo.Map(successRefunds, func(refund *model.OrderRefund, _ int) int64 {
+		refundedMoney += refund.RefundMoney
+		return refund.ID
+	})
+	refundItemsMap, err := dao.NewOrderRefundDao(ods.ctx).GetMultiRefundsItems(refundIds)
+	if err != nil {
+		return 0, nil, errcode.Wrap("GetOrderRefundedError", err)
+	}
+	for _, refundItems := range refundItemsMap {
+		for _, item := range refundItems {
+			refunded := refundedItems[item.CommodityId]
+			refunded.num += item.CommodityNum
+			refunded.money += item.RefundMoney
+			refundedItems[item.CommodityId] = refunded
+		}
+	}
+	return
+}
+
+// GetUserOrderRefunds 查询用户订单的退款申请
+func (ods *OrderDomainSvc) GetUserOrderRefunds(orderNo string, userId int64) ([]*do.OrderRefund, error) {
+	order, err := ods.orderDao.GetOrderByNo(orderNo)
+	if err != nil {
+		return nil, errcode.Wrap("GetUserOrderRefundsError", err)
+	}
+	if order.ID == 0 || order.UserId != userId {
+		return nil, errcode.ErrOrderParams
+	}
+	refundDao := dao.NewOrderRefundDao(ods.ctx)
+	refundModels, err := refundDao.GetOrderRefunds(order.ID)
+	if err != nil {
+		return nil, errcode.Wrap("GetUserOrderRefundsError", err)
+	}
+	refunds := make([]*do.OrderRefund, 0, len(refundModels))
+	if len(refundModels) == 0 {
+		return refunds, nil
+	}
+	if err = util.CopyProperties(&refunds, &refundModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	refundItemsMap, err := refundDao.GetMultiRefundsItems(lo.Map(refundModels, func(refund *model.OrderRefund, _ int) int64 {
+		return refund.ID
+	}))
+	if err != nil {
+		return nil, errcode.Wrap("GetUserOrderRefundsError", err)
+	}
+	for _, refund := range refunds {
+		refundItems := refundItemsMap[refund.ID]
+		if err = util.CopyProperties(&refund.Items, &refundItems); err != nil {
+			return nil, errcode.ErrCoverData.WithCause(err)
+		}
+	}
+	return refunds, nil
+}
+
+// ApproveOrderRefund 审核通过退款申请, 向支付平台发起退款
+func (ods *OrderDomainSvc) ApproveOrderRefund(refundNo, auditRemark string) error {
+	refundDao := dao.NewOrderRefundDao(ods.ctx)
+	refund, err := refundDao.GetOrderRefundByNo(refundNo)
+	if err != nil {
+		return errcode.Wrap("ApproveOrderRefundError", err)
+	}
+	if refund.ID == 0 {
+		return errcode.ErrOrderRefundParams
+	}
+	if !lo.Contains(refundablePayTypes, refund.PayType) {
+		return errcode.ErrOrderRefundNotAllowed.WithCause(errors.New("暂不支持该支付方式的退款"))
+	}
+	audited, err := refundDao.AuditOrderRefund(refund.ID, true, auditRemark)
+	if err != nil {
+		return errcode.Wrap("ApproveOrderRefundError", err)
+	}
+	if !audited { // 退款申请已经被审核过了
+		return errcode.ErrOrderRefundNotAllowed
+	}
+
+	return ods.createWxRefund(refund)
+}
+
+// createWxRefund 向微信支付发起退款, 用退款申请的单号作为业务退款单号, 同一个退款申请多次发起只会退款一次
+// 微信支付明确拒绝退款时退款申请更新为退款失败; 请求超时、系统错误等结果不确定的情况,
+// 退款申请保持退款中, 由退款结果通知或者退款对账任务确定最终的退款结果
+func (ods *OrderDomainSvc) createWxRefund(refund *model.OrderRefund) error {
+	log := logger.New(ods.ctx)
+	order, err := ods.orderDao.GetOrderByNo(refund.OrderNo)
+	if err != nil {
+		return errcode.Wrap("CreateWxRefundError", err)
+	}
+	// 子订单没有单独支付, 退款时要用父订单的支付交易
+	payOrder := order
+	if order.OrderType == enum.OrderTypeSub {
+		if payOrder, err = ods.orderDao.GetOrderById(order.ParentId); err != nil {
+			return errcode.Wrap("CreateWxRefundError", err)
+		}
+	}
+
+	wpl := library.NewWxPayLib(ods.ctx, *newWxPayConfig())
+	refundResult, err := wpl.CreateRefund(payOrder.OrderNo, refund.RefundNo, refund.Reason, refund.RefundMoney, payOrder.PayMoney)
+	if err != nil {
+		apiErr, ok := library.AsWxPayApiError(err)
+		if !ok || !apiErr.Rejected() {
+			log.Warn("CreateWxRefundUncertain", "err", err, "refundNo", refund.RefundNo)
+			return nil
+		}
+		log.Error("CreateWxRefundError", "err", err, "refundNo", refund.RefundNo)
+		if _, updateErr := dao.NewOrderRefundDao(ods.ctx).SetOrderRefundResult(refund.ID, enum.RefundStateFailed, "", time.Time{}); updateErr != nil {
+			log.Error("CreateWxRefundError", "err", updateErr, "refundNo", refund.RefundNo)
+		}
+		return errcode.Wrap("CreateWxRefundError", err)
+	}
+
+	return ods.SettleOrderRefund(newWxOrderRefundResult(refundResult.OutRefundNo, refundResult.RefundId,
+		refundResult.Status, refundResult.SuccessTime))
+}
+
+// ReconcileStuckOrderRefunds 对账审核通过后迟迟没有拿到退款结果的退款申请
+// 发起退款时结果不确定或者退款结果通知丢失时, 退款申请会一直停留在退款中, 这里主动向支付平台查询退款结果
+// @param threshold 退款申请超过多久没有更新才去查询
+// @param batchSize 每批查询的退款申请数量
+// @return reconciled 完成对账的退款申请数
+func (ods *OrderDomainSvc) ReconcileStuckOrderRefunds(threshold time.Duration, batchSize int) (reconciled int, err error) {
+	log := logger.New(ods.ctx)
+	refundDao := dao.NewOrderRefundDao(ods.ctx)
+	updatedBefore := time.Now().Add(-threshold)
+	var lastId int64
+	for {
+		refunds, err := refundDao.GetStuckProcessingRefunds(updatedBefore, lastId, batchSize)
+		if err != nil {
+			return reconciled, errcode.Wrap("ReconcileStuckOrderRefundsError", err)
+		}
+		for _, refund := range refunds {
+			// 单个退款申请对账失败不影响其他申请, 下一轮会再次对账
+			if err = ods.ReconcileOrderRefund(refund); err != nil {
+				log.Error("ReconcileOrderRefundError", "err", err, "refundNo", refund.RefundNo)
+				continue
+			}
+			reconciled++
+		}
+		if len(refunds) < batchSize {
+			break
+		}
+		lastId = refunds[len(refunds)-1].ID
+	}
+
+	return reconciled, nil
+}
+
+// ReconcileOrderRefund 向微信支付查询退款申请的退款结果, 用跟退款结果通知相同的流程结算退款申请
+// 微信支付查询不到退款单时说明发起退款的请求没有被受理, 用同一个退款单号重新发起退款
+func (ods *OrderDomainSvc) ReconcileOrderRefund(refund *model.OrderRefund) error {
+	wpl := library.NewWxPayLib(ods.ctx, *newWxPayConfig())
+	refundResult, err := wpl.QueryRefund(refund.RefundNo)
+	if err != nil {
+		if apiErr, ok := library.AsWxPayApiError(err); ok && apiErr.NotExists() {
+			return ods.createWxRefund(refund)
+		}
+		return errcode.Wrap("ReconcileOrderRefundError", err)
+	}
+
+	return ods.SettleOrderRefund(newWxOrderRefundResult(refundResult.OutRefundNo, refundResult.RefundId,
+		refundResult.Status, refundResult.SuccessTime))
+}
+
+// RejectOrderRefund 驳回退款申请
+func (ods *OrderDomainSvc) RejectOrderRefund(refundNo, auditRemark string) error {
+	refundDao := dao.NewOrderRefundDao(ods.ctx)
+	refund, err := refundDao.GetOrderRefundByNo(refundNo)
+	if err != nil {
+		return errcode.Wrap("RejectOrderRefundError", err)
+	}
+	if refund.ID == 0 {
+		return errcode.ErrOrderRefundParams
+	}
+	audited, err := refundDao.AuditOrderRefund(refund.ID, false, auditRemark)
+	if err != nil {
+		return errcode.Wrap("RejectOrderRefundError", err)
+	}
+	if !audited {
+		return errcode.ErrOrderRefundNotAllowed
+	}
+	return nil
+}
+
+// SettleOrderRefund 用支付平台返回的退款结果结算退款申请
+// 退款成功后恢复退款商品的库存, 并按订单已退的金额把订单更新为已退款或部分退款, 三者在同一个事务里变更
+func (ods *OrderDomainSvc) SettleOrderRefund(refundResult *do.OrderRefundResult) error {
+	log := logger.New(ods.ctx)
+	refundDao := dao.NewOrderRefundDao(ods.ctx)
+	refund, err := refundDao.GetOrderRefundByNo(refundResult.RefundNo)
+	if err != nil {
+		return errcode.Wrap("SettleOrderRefundError", err)
+	}
+	if refund.ID == 0 {
+		log.Error("SettleOrderRefundError", "err", "退款申请不存在", "refundResult", refundResult)
+		return errcode.ErrOrderRefundParams
+	}
+	if refundResult.RefundState == enum.RefundStateProcessing {
+		// 支付平台还在处理退款, 先记下支付平台的退款单号, 等退款结果通知再结算
+		if refundResult.RefundTransId == "" {
+			return nil
+		}
+		if err = refundDao.SetRefundTransId(refund.ID, refundResult.RefundTransId); err != nil {
+			return errcode.Wrap("SettleOrderRefundError", err)
+		}
+		return nil
+	}
+	if refundResult.RefundState != enum.RefundStateSuccess {
+		// 退款失败只更新退款申请, 订单和库存不变
+		if _, err = refundDao.SetOrderRefundResult(refund.ID, refundResult.RefundState, refundResult.RefundTransId, refundResult.RefundedAt); err != nil {
+			return errcode.Wrap("SettleOrderRefundError", err)
+		}
+		return nil
+	}
+	if refund.RefundState != enum.RefundStateProcessing { // 重复的退款通知
+		return nil
+	}
+
+	order, err := ods.orderDao.GetOrderByNo(refund.OrderNo)
+	if err != nil {
+		return errcode.Wrap("SettleOrderRefundError", err)
+	}
+	refunds, err := refundDao.GetOrderRefunds(order.ID)
+	if err != nil {
+		return errcode.Wrap("SettleOrderRefundError", err)
+	}
+	// 订单已退的金额包含这次还没有结算的退款
+	refundedMoney := refund.RefundMoney + lo.SumBy(refunds, func(orderRefund *model.OrderRefund) int {
+		if orderRefund.RefundState == enum.RefundStateSuccess && orderRefund.ID != refund.ID {
+			return orderRefund.RefundMoney
+		}
+		return 0
+	})
+	event := enum.OrderEventPartialRefund
+	if refundedMoney >= order.PayMoney {
+		event = enum.OrderEventRefund
+	}
+	refundItemsMap, err := refundDao.GetMultiRefundsItems([]int64{refund.ID})
+	if err != nil {
+		return errcode.Wrap("SettleOrderRefundError", err)
+	}
+	stockItems := lo.Map(refundItemsMap[refund.ID], func(item *model.OrderRefundItem, _ int) *do.OrderItem {
+		return &do.OrderItem{OrderId: item.OrderId, CommodityId: item.CommodityId, CommodityNum: item.CommodityNum}
+	})
+
+	// 退款申请的结果、退款商品的库存和订单状态在同一个事务里变更, 任何一步失败都整体回滚, 重试时重新结算
+	duplicated := false
+	transited, err := NewOrderStateMachine(ods.ctx).Fire(order, &OrderStatusChange{
+		Event:  event,
+		Actor:  enum.OrderActorPayment,
+		Remark: refund.RefundNo,
+		InTransaction: func(tx *gorm.DB) error {
+			settled, err := refundDao.SetOrderRefundResultInTx(tx, refund.ID, refundResult.RefundState, refundResult.RefundTransId, refundResult.RefundedAt)
+			if err != nil {
+				return err
+			}
+			if !settled { // 并发的重复通知已经结算了这笔退款
+				duplicated = true
+				return errors.New("退款申请已经结算过了")
+			}
+			// 退款商品的已售库存退回可售库存
+			return dao.NewCommodityDao(ods.ctx).ReturnSoldStock(tx, stockItems)
+		},
+	})
+	if duplicated {
+		return nil
+	}
+	if err != nil {
+		log.Error("SettleOrderRefundError", "err", err, "refundNo", refund.RefundNo)
+		return errcode.Wrap("SettleOrderRefundError", err)
+	}
+	if !transited {
+		// 订单状态在读取后被并发修改, 退款申请保持退款中, 重试时按订单最新的状态重新结算
+		return errcode.Wrap("SettleOrderRefundError", errors.New("订单状态被并发修改"))
+	}
+	return nil
+}
+
+// HandleWxRefundNotify 处理微信退款结果通知
+// 验证通知的签名、解密通知中的退款结果后用退款结果结算退款申请
+func (ods *OrderDomainSvc) HandleWxRefundNotify(serialNo, timestamp, nonce, signature, rawBody string) error {
+	log := logger.New(ods.ctx)
+	if err := checkWxPayNotifyTimestamp(timestamp); err != nil {
+		log.Error("WxRefundNotifyTimestampError", "err", err, "timestamp", timestamp)
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+	wpl := library.NewWxPayLib(ods.ctx, *newWxPayConfig())
+	verified, err := wpl.ValidateNotifySignature(serialNo, timestamp, nonce, signature, rawBody)
+	if err != nil || !verified {
+		log.Error("WxRefundNotifySignatureError", "err", err, "body", rawBody)
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+	resourceData, err := wpl.DecryptRefundNotifyResourceData(rawBody)
+	if err != nil {
+		return errcode.ErrOrderPayNotifyInvalid.WithCause(err)
+	}
+	log.Info("WxRefundNotifyResource", "resource", resourceData)
+
+	return ods.SettleOrderRefund(newWxOrderRefundResult(resourceData.OutRefundNo, resourceData.RefundId,
+		resourceData.RefundStatus, resourceData.SuccessTime))
+}
+
+// newWxOrderRefundResult 把微信支付的退款信息转换成退款结果
+func newWxOrderRefundResult(outRefundNo, refundId, refundStatus string, successTime time.Time) *do.OrderRefundResult {
+	refundResult := &do.OrderRefundResult{
+		RefundNo:      outRefundNo,
+		RefundTransId: refundId,
+		RefundedAt:    successTime,
+	}
+	switch refundStatus {
+	case library.WxRefundStatusSuccess:
+		refundResult.RefundState = enum.RefundStateSuccess
+	case library.WxRefundStatusClosed, library.WxRefundStatusAbnormal:
+		refundResult.RefundState = enum.RefundStateFailed
+	default:
+		refundResult.RefundState = enum.RefundStateProcessing
+	}
+
+	return refundResult
+}
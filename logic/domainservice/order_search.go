@@ -0,0 +1,59 @@
+package domainservice
+
+import (
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+)
+
+// 管理后台的订单查询和导出
+
+// orderExportBatchSize 导出订单时每批查询的订单数
+const orderExportBatchSize = 200
+
+// SearchOrders 按条件分页查询订单, 包含订单的收货地址和购物明细
+func (ods *OrderDomainSvc) SearchOrders(query *do.OrderQuery, pagination *app.Pagination) ([]*do.Order, error) {
+	orderModels, totalRow, err := ods.orderDao.SearchOrders(query, pagination.Offset(), pagination.GetPageSize())
+	if err != nil {
+		return nil, errcode.Wrap("SearchOrdersError", err)
+	}
+	pagination.SetTotalRows(int(totalRow))
+	orders := make([]*do.Order, 0, len(orderModels))
+	if err = util.CopyProperties(&orders, &orderModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	if err = ods.fillOrdersDetail(orders); err != nil {
+		return nil, errcode.Wrap("SearchOrdersError", err)
+	}
+	return orders, nil
+}
+
+// ExportOrders 按条件分批查询所有订单, 每查出一批订单交给 handle 处理, 不会一次把所有订单加载到内存
+// handle 返回错误时停止导出
+func (ods *OrderDomainSvc) ExportOrders(query *do.OrderQuery, handle func(orders []*do.Order) error) error {
+	var lastId int64
+	for {
+		orderModels, err := ods.orderDao.ScanOrders(query, lastId, orderExportBatchSize)
+		if err != nil {
+			return errcode.Wrap("ExportOrdersError", err)
+		}
+		if len(orderModels) == 0 {
+			return nil
+		}
+		orders := make([]*do.Order, 0, len(orderModels))
+		if err = util.CopyProperties(&orders, &orderModels); err != nil {
+			return errcode.ErrCoverData.WithCause(err)
+		}
+		if err = ods.fillOrdersDetail(orders); err != nil {
+			return errcode.Wrap("ExportOrdersError", err)
+		}
+		if err = handle(orders); err != nil {
+			return err
+		}
+		if len(orderModels) < orderExportBatchSize {
+			return nil
+		}
+		lastId = orderModels[len(orderModels)-1].ID
+	}
+}
@@ -0,0 +1,117 @@
+package domainservice
+
+import (
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+)
+
+// 拆单
+// 一次下单的商品来自不同的商家或者仓库时, 订单拆分成一个父订单和多个子订单, 每个商家的每个仓库一个子订单。
+// 用户只需要支付父订单, 支付前父订单和子订单的状态一起变更; 支付后由子订单分别发货、确认收货和退款。
+
+// orderSplitKey 拆单的依据, 商家和发货仓库都相同的商品在同一个子订单里
+type orderSplitKey struct {
+	MerchantId  int64
+	WarehouseId int64
+}
+
+// splitOrder 按商品的商家和发货仓库拆分订单, 并把订单的优惠分摊到购物项上
+// 订单的优惠先按子订单的商品金额分摊到子订单上, 再分摊到子订单的购物项上, 父订单的购物项和子订单的购物项金额一致
+// 商品都来自同一个商家的同一个仓库时不拆单, 返回的子订单为空
+// @param order 要拆分的订单, order.Items 和 items 一一对应
+// @param items 下单的购物项
+func (ods *OrderDomainSvc) splitOrder(order *do.Order, items []*do.ShoppingCartItem) ([]*do.Order, error) {
+	splitKeys := make([]orderSplitKey, 0)
+	splitItems := make(map[orderSplitKey][]*do.OrderItem)
+	for i, item := range items {
+		key := orderSplitKey{MerchantId: item.MerchantId, WarehouseId: item.WarehouseId}
+		if _, exists := splitItems[key]; !exists {
+			splitKeys = append(splitKeys, key)
+		}
+		splitItems[key] = append(splitItems[key], order.Items[i])
+	}
+	if len(splitKeys) <= 1 {
+		order.OrderType = enum.OrderTypeNormal
+		if len(splitKeys) == 1 {
+			order.MerchantId = splitKeys[0].MerchantId
+			order.WarehouseId = splitKeys[0].WarehouseId
+		}
+		apportionOrderDiscount(order.Items, order.BillMoney-order.PayMoney)
+		return nil, nil
+	}
+
+	order.OrderType = enum.OrderTypeParent
+	subOrdersBillMoney := lo.Map(splitKeys, func(key orderSplitKey, _ int) int {
+		return lo.SumBy(splitItems[key], func(item *do.OrderItem) int {
+			return item.CommoditySellingPrice * item.CommodityNum
+		})
+	})
+	couponMoneys := apportionMoney(order.CouponMoney, subOrdersBillMoney)
+	discountMoneys := apportionMoney(order.DiscountMoney, subOrdersBillMoney)
+	vipDiscountMoneys := apportionMoney(order.VipDiscountMoney, subOrdersBillMoney)
+
+	idGenSvc := NewIdGenDomainSvc(ods.ctx)
+	subOrders := make([]*do.Order, 0, len(splitKeys))
+	for i, key := range splitKeys {
+		subOrder := do.OrderNew()
+		orderNo, err := idGenSvc.GenOrderNo()
+		if err != nil {
+			return nil, err
+		}
+		subOrder.OrderNo = orderNo
+		subOrder.UserId = order.UserId
+		subOrder.OrderType = enum.OrderTypeSub
+		subOrder.MerchantId = key.MerchantId
+		subOrder.WarehouseId = key.WarehouseId
+		subOrder.OrderStatus = order.OrderStatus
+		subOrder.PayDeadline = order.PayDeadline
+		subOrder.CouponId = order.CouponId
+		subOrder.DiscountId = order.DiscountId
+		subOrder.BillMoney = subOrdersBillMoney[i]
+		subOrder.CouponMoney = couponMoneys[i]
+		subOrder.DiscountMoney = discountMoneys[i]
+		subOrder.VipDiscountMoney = vipDiscountMoneys[i]
+		subOrder.PayMoney = subOrder.BillMoney - subOrder.CouponMoney - subOrder.DiscountMoney - subOrder.VipDiscountMoney
+		if err = util.CopyProperties(subOrder.Address, order.Address); err != nil {
+			return nil, errcode.ErrCoverData.WithCause(err)
+		}
+		// 分摊到父订单购物项上的金额和子订单购物项一致, 子订单的购物项用复制出的对象, 写库时各自回填订单ID
+		apportionOrderDiscount(splitItems[key], subOrder.BillMoney-subOrder.PayMoney)
+		subOrder.Items = lo.Map(splitItems[key], func(item *do.OrderItem, _ int) *do.OrderItem {
+			subOrderItem := *item
+			return &subOrderItem
+		})
+		subOrders = append(subOrders, subOrder)
+	}
+
+	return subOrders, nil
+}
+
+// FillOrderFamily 为订单详情填充拆单的关系, 父订单填充拆分出的子订单, 子订单填充父订单号
+func (ods *OrderDomainSvc) FillOrderFamily(order *do.Order) error {
+	switch order.OrderType {
+	case enum.OrderTypeParent:
+		subOrderModels, err := ods.orderDao.GetSubOrders(order.ID)
+		if err != nil {
+			return errcode.Wrap("FillOrderFamilyError", err)
+		}
+		subOrders := make([]*do.Order, 0, len(subOrderModels))
+		if err = util.CopyProperties(&subOrders, &subOrderModels); err != nil {
+			return errcode.ErrCoverData.WithCause(err)
+		}
+		if err = ods.fillOrdersDetail(subOrders); err != nil {
+			return errcode.Wrap("FillOrderFamilyError", err)
+		}
+		order.SubOrders = subOrders
+	case enum.OrderTypeSub:
+		parentOrder, err := ods.orderDao.GetOrderById(order.ParentId)
+		if err != nil {
+			return errcode.Wrap("FillOrderFamilyError", err)
+		}
+		order.ParentOrderNo = parentOrder.OrderNo
+	}
+	return nil
+}
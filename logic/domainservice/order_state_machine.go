@@ -0,0 +1,275 @@
+package domainservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+	"gorm.io/gorm"
+)
+
+// OrderTransition 订单状态机中的一种状态变更
+type OrderTransition struct {
+	Event  string                // 触发变更的事件
+	From   []int                 // 允许变更的订单状态
+	To     int                   // 变更后的订单状态
+	Actors []int                 // 允许触发变更的角色
+	Hooks  []OrderTransitionHook // 状态变更成功后在同一个事务里执行的操作
+	// Cascade 支付前的状态变更, 拆单的订单只能由父订单触发, 子订单跟随父订单一起变更;
+	// 其他状态变更只能由子订单触发
+	Cascade bool
+}
+
+// OrderTransitionHook 订单状态变更成功后执行的操作, order 是变更前读取的订单
+// Hook 和订单状态变更在同一个事务 tx 里执行, Hook 执行失败时订单状态变更一起回滚, 重试时会再次执行
+type OrderTransitionHook func(ctx context.Context, tx *gorm.DB, order *model.Order) error
+
+// 订单状态的变更都在这里声明, 不在这里的状态变更不允许发生
+var orderTransitions = lo.SliceToMap([]*OrderTransition{
+	{
+		Event:   enum.OrderEventStartPay,
+		From:    []int{enum.OrderStatusCreated},
+		To:      enum.OrderStatusUnPaid,
+		Actors:  []int{enum.OrderActorUser},
+		Cascade: true,
+	},
+	{
+		Event:   enum.OrderEventPaySuccess,
+		From:    []int{enum.OrderStatusCreated, enum.OrderStatusUnPaid},
+		To:      enum.OrderStatusPaid,
+		Actors:  []int{enum.OrderActorPayment},
+		Hooks:   []OrderTransitionHook{commitOrderStockHook},
+		Cascade: true,
+	},
+	{
+		Event:   enum.OrderEventUserCancel,
+		From:    []int{enum.OrderStatusCreated, enum.OrderStatusUnPaid},
+		To:      enum.OrderStatusUserQuit,
+		Actors:  []int{enum.OrderActorUser},
+		Hooks:   []OrderTransitionHook{releaseOrderStockHook},
+		Cascade: true,
+	},
+	{
+		Event:   enum.OrderEventPayTimeout,
+		From:    []int{enum.OrderStatusCreated, enum.OrderStatusUnPaid},
+		To:      enum.OrderStatusUnpaidClose,
+		Actors:  []int{enum.OrderActorSystem},
+		Hooks:   []OrderTransitionHook{releaseOrderStockHook},
+		Cascade: true,
+	},
+	{
+		Event:   enum.OrderEventMerchantClose,
+		From:    []int{enum.OrderStatusCreated, enum.OrderStatusUnPaid},
+		To:      enum.OrderStatusMerchantClose,
+		Actors:  []int{enum.OrderActorMerchant},
+		Hooks:   []OrderTransitionHook{releaseOrderStockHook},
+		Cascade: true,
+	},
+	{
+		Event:  enum.OrderEventCheck,
+		From:   []int{enum.OrderStatusPaid},
+		To:     enum.OrderStatusChecked,
+		Actors: []int{enum.OrderActorMerchant},
+	},
+	{
+		Event:  enum.OrderEventShip,
+		From:   []int{enum.OrderStatusPaid, enum.OrderStatusChecked},
+		To:     enum.OrderStatusShipped,
+		Actors: []int{enum.OrderActorMerchant},
+	},
+	{
+		Event:  enum.OrderEventStartDelivery,
+		From:   []int{enum.OrderStatusShipped},
+		To:     enum.OrderStatusOnDelivery,
+		Actors: []int{enum.OrderActorMerchant, enum.OrderActorSystem},
+	},
+	{
+		Event:  enum.OrderEventDeliver,
+		From:   []int{enum.OrderStatusShipped, enum.OrderStatusOnDelivery},
+		To:     enum.OrderStatusDelivered,
+		Actors: []int{enum.OrderActorMerchant, enum.OrderActorSystem},
+	},
+	{
+		Event:  enum.OrderEventConfirmReceipt,
+		From:   []int{enum.OrderStatusShipped, enum.OrderStatusOnDelivery, enum.OrderStatusDelivered},
+		To:     enum.OrderStatusConfirmReceipt,
+		Actors: []int{enum.OrderActorUser, enum.OrderActorSystem},
+	},
+	{
+		Event:  enum.OrderEventComplete,
+		From:   []int{enum.OrderStatusConfirmReceipt},
+		To:     enum.OrderStatusCompleted,
+		Actors: []int{enum.OrderActorSystem},
+	},
+	{
+		Event:  enum.OrderEventRefund,
+		From:   refundableOrderStatus,
+		To:     enum.OrderStatusRefunded,
+		Actors: []int{enum.OrderActorPayment},
+	},
+	{
+		Event:  enum.OrderEventPartialRefund,
+		From:   refundableOrderStatus,
+		To:     enum.OrderStatusPartialRefunded,
+		Actors: []int{enum.OrderActorPayment},
+	},
+}, func(transition *OrderTransition) (string, *OrderTransition) {
+	return transition.Event, transition
+})
+
+// OrderStatusChange 一次订单状态变更的请求
+type OrderStatusChange struct {
+	Event   string
+	Actor   int                    // 触发变更的角色
+	ActorId int64                  // 触发变更的用户ID等, 没有时为0
+	Remark  string                 // 备注
+	Updates map[string]interface{} // 随订单状态一起更新的其他字段, 比如支付成功时的支付平台交易ID
+	// InTransaction 跟订单状态变更在同一个事务里执行的其他变更, 比如结算退款申请; 返回错误时订单状态变更一起回滚
+	InTransaction func(tx *gorm.DB) error
+}
+
+// OrderStateMachine 订单状态机, 订单状态只能通过状态机变更
+type OrderStateMachine struct {
+	ctx      context.Context
+	orderDao *dao.OrderDao
+}
+
+func NewOrderStateMachine(ctx context.Context) *OrderStateMachine {
+	return &OrderStateMachine{
+		ctx:      ctx,
+		orderDao: dao.NewOrderDao(ctx),
+	}
+}
+
+// orderFulfilmentStatus 订单在履约流程中所处的状态
+// 部分退款的订单还要继续履约, 它的订单状态记录的是部分退款, 履约进度由发货、送达、确认收货的时间记录,
+// 按这些时间推算出订单退款前所处的履约状态; 其他订单返回订单状态
+func orderFulfilmentStatus(order *model.Order) int {
+	if order.OrderStatus != enum.OrderStatusPartialRefunded {
+		return order.OrderStatus
+	}
+	switch {
+	case orderTimeHappened(order.ConfirmedAt):
+		return enum.OrderStatusConfirmReceipt
+	case orderTimeHappened(order.DeliveredAt):
+		return enum.OrderStatusDelivered
+	case orderTimeHappened(order.ShippedAt):
+		return enum.OrderStatusShipped
+	default:
+		return enum.OrderStatusPaid
+	}
+}
+
+// orderTimeHappened 订单上记录的时间是否已经发生, 没有发生的时间是默认的1970-01-01
+func orderTimeHappened(t time.Time) bool {
+	return t.Year() > 1970
+}
+
+// CanFire 订单当前的状态是否允许 actor 触发 event
+func CanFire(orderStatus int, event string, actor int) bool {
+	transition, exists := orderTransitions[event]
+	return exists && lo.Contains(transition.From, orderStatus) && lo.Contains(transition.Actors, actor)
+}
+
+// Fire 触发订单状态变更, 变更、变更记录和变更的 Hooks 在同一个事务里执行
+// 订单的状态不允许这次变更时返回 ErrOrderCanNotBeChanged
+// @param order 读取到的订单, 以它的状态作为变更前的状态
+// @return transited 是否真正变更了订单状态, 订单状态在读取后被并发修改时返回 false;
+// 执行 Hooks 失败时订单状态不会变更, 返回 false 和错误, 调用方重试时会重新执行整个变更
+func (sm *OrderStateMachine) Fire(order *model.Order, change *OrderStatusChange) (transited bool, err error) {
+	transition, exists := orderTransitions[change.Event]
+	if !exists {
+		return false, errcode.Wrap("OrderStateMachineError", fmt.Errorf("未知的订单事件: %s", change.Event))
+	}
+	if !lo.Contains(transition.Actors, change.Actor) {
+		return false, errcode.ErrOrderCanNotBeChanged.WithCause(
+			fmt.Errorf("角色 %d 不能触发订单事件 %s", change.Actor, change.Event))
+	}
+	// 部分退款的订单按它所处的履约状态继续履约
+	if !lo.Contains(transition.From, order.OrderStatus) && !lo.Contains(transition.From, orderFulfilmentStatus(order)) {
+		return false, errcode.ErrOrderCanNotBeChanged.WithCause(
+			fmt.Errorf("订单状态 %d 不能触发订单事件 %s", order.OrderStatus, change.Event))
+	}
+	if order.OrderType == enum.OrderTypeSub && transition.Cascade {
+		return false, errcode.ErrOrderCanNotBeChanged.WithCause(
+			fmt.Errorf("子订单不能单独触发订单事件 %s, 需要由父订单触发", change.Event))
+	}
+	if order.OrderType == enum.OrderTypeParent && !transition.Cascade {
+		return false, errcode.ErrOrderCanNotBeChanged.WithCause(
+			fmt.Errorf("父订单不能触发订单事件 %s, 需要由子订单触发", change.Event))
+	}
+
+	statusLog := &model.OrderStatusLog{
+		OrderNo:    order.OrderNo,
+		Event:      change.Event,
+		FromStatus: order.OrderStatus,
+		ToStatus:   transition.To,
+		Actor:      change.Actor,
+		ActorId:    change.ActorId,
+		Remark:     change.Remark,
+	}
+	var afterTransit func(tx *gorm.DB) error
+	if change.InTransaction != nil || len(transition.Hooks) > 0 {
+		afterTransit = func(tx *gorm.DB) error {
+			if change.InTransaction != nil {
+				if err := change.InTransaction(tx); err != nil {
+					return err
+				}
+			}
+			for _, hook := range transition.Hooks {
+				if err := hook(sm.ctx, tx, order); err != nil {
+					return errcode.Wrap("OrderStateMachineHookError", err)
+				}
+			}
+			return nil
+		}
+	}
+	if order.OrderType == enum.OrderTypeParent {
+		transited, err = sm.orderDao.TransitParentOrderStatus(order.ID, change.Updates, statusLog, afterTransit)
+	} else {
+		transited, err = sm.orderDao.TransitOrderStatus(order.ID, change.Updates, statusLog, afterTransit)
+	}
+	if err != nil {
+		return false, errcode.Wrap("OrderStateMachineError", err)
+	}
+	if !transited {
+		logger.New(sm.ctx).Warn("OrderStatusChangedConcurrently", "orderNo", order.OrderNo,
+			"event", change.Event, "fromStatus", order.OrderStatus)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// GetOrderStatusLogs 查询订单的状态变更记录
+func (sm *OrderStateMachine) GetOrderStatusLogs(orderId int64) ([]*do.OrderStatusLog, error) {
+	logModels, err := sm.orderDao.GetOrderStatusLogs(orderId)
+	if err != nil {
+		return nil, errcode.Wrap("GetOrderStatusLogsError", err)
+	}
+	statusLogs := make([]*do.OrderStatusLog, 0, len(logModels))
+	if err = util.CopyProperties(&statusLogs, &logModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+	return statusLogs, nil
+}
+
+// commitOrderStockHook 订单支付成功后把订单预占的库存记为已售
+// 拆单的订单按父订单预占库存, 支付成功由父订单触发
+func commitOrderStockHook(ctx context.Context, tx *gorm.DB, order *model.Order) error {
+	return dao.NewCommodityDao(ctx).CommitOrderStock(tx, order.ID)
+}
+
+// releaseOrderStockHook 未支付的订单关闭后释放订单预占的库存
+// 拆单的订单由父订单触发关闭, 库存也是按父订单预占的, 只释放一次
+func releaseOrderStockHook(ctx context.Context, tx *gorm.DB, order *model.Order) error {
+	return dao.NewCommodityDao(ctx).ReleaseOrderStock(tx, order.ID)
+}
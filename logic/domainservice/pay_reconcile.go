@@ -0,0 +1,163 @@
+package domainservice
+
+import (
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/app"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/common/logger"
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/samber/lo"
+)
+
+// ReconcileWxTradeBill 用微信支付的交易账单核对指定日期的订单支付
+// 按订单号比对账单里支付成功的交易和本地支付成功的订单, 比对支付平台交易ID和支付金额, 发现的差异保存下来供人工处理
+// 同一天重复对账时, 以最新一次对账的结果为准
+func (ods *OrderDomainSvc) ReconcileWxTradeBill(billDate time.Time) ([]*do.PayReconcileDiff, error) {
+	records, err := library.NewWxPayLib(ods.ctx, *newWxPayConfig()).DownloadTradeBill(billDate)
+	if err != nil {
+		return nil, errcode.Wrap("ReconcileWxTradeBillError", err)
+	}
+	dayStart := time.Date(billDate.Year(), billDate.Month(), billDate.Day(), 0, 0, 0, 0, time.Local)
+	localOrders, err := ods.orderDao.GetPaidOrdersBetween(enum.PayTypeWxPay, dayStart, dayStart.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, errcode.Wrap("ReconcileWxTradeBillError", err)
+	}
+	// 账单里只需要核对支付成功的交易, 退款走退款单自己的流程
+	remoteTrades := lo.Filter(records, func(record *library.WxTradeBillRecord, _ int) bool {
+		return record.TradeState == library.WxTradeStateSuccess
+	})
+	// 支付结果通知延迟到了第二天的订单, 本地的支付时间不在账单日期内, 按订单号补查一次
+	localOrderMap := lo.SliceToMap(localOrders, func(order *model.Order) (string, *model.Order) {
+		return order.OrderNo, order
+	})
+	absentOrderNos := make([]string, 0)
+	for _, trade := range remoteTrades {
+		if _, exists := localOrderMap[trade.OutTradeNo]; !exists {
+			absentOrderNos = append(absentOrderNos, trade.OutTradeNo)
+		}
+	}
+	if len(absentOrderNos) > 0 {
+		absentOrders, err := ods.orderDao.GetOrdersByNos(absentOrderNos)
+		if err != nil {
+			return nil, errcode.Wrap("ReconcileWxTradeBillError", err)
+		}
+		for _, order := range absentOrders {
+			if order.PayState == enum.PayStatePaid && order.PayType == enum.PayTypeWxPay {
+				localOrderMap[order.OrderNo] = order
+			}
+		}
+	}
+
+	diffs := diffWxTradeBill(billDate.Format(enum.TimeFormatHyphenedYMD), localOrders, localOrderMap, remoteTrades)
+	err = dao.NewPayReconcileDao(ods.ctx).ReplaceReconcileDiffs(billDate.Format(enum.TimeFormatHyphenedYMD), enum.PayTypeWxPay, diffs)
+	if err != nil {
+		return nil, errcode.Wrap("ReconcileWxTradeBillError", err)
+	}
+	if len(diffs) > 0 {
+		logger.New(ods.ctx).Warn("ReconcileWxTradeBillFoundDiffs", "billDate", billDate.Format(enum.TimeFormatHyphenedYMD),
+			"diffCount", len(diffs))
+	}
+
+	return diffs, nil
+}
+
+// ReconcileDailyWxTradeBill 每天核对前一天的微信支付交易账单
+// 微信次日10点后才提供前一天的账单, 10点之前不执行; 已经完成对账的日期不再重复对账
+// @return done 这次调用是否执行了对账
+func (ods *OrderDomainSvc) ReconcileDailyWxTradeBill(now time.Time) (done bool, err error) {
+	if now.Hour() < 10 {
+		return false, nil
+	}
+	billDate := now.AddDate(0, 0, -1)
+	billDateStr := billDate.Format(enum.TimeFormatHyphenedYMD)
+	reconciled, err := cache.IsPayReconcileDone(ods.ctx, enum.PayTypeWxPay, billDateStr)
+	if err != nil {
+		return false, errcode.Wrap("ReconcileDailyWxTradeBillError", err)
+	}
+	if reconciled {
+		return false, nil
+	}
+	if _, err = ods.ReconcileWxTradeBill(billDate); err != nil {
+		return false, err
+	}
+	if err = cache.SetPayReconcileDone(ods.ctx, enum.PayTypeWxPay, billDateStr); err != nil {
+		// 标记失败只会导致下个周期重复对账, 对账结果会被覆盖, 不影响正确性
+		logger.New(ods.ctx).Error("SetPayReconcileDoneError", "err", err, "billDate", billDateStr)
+	}
+
+	return true, nil
+}
+
+// diffWxTradeBill 比对账单交易和本地订单
+// @param localOrders 账单日期内本地支付成功的订单
+// @param localOrderMap 订单号到本地支付成功订单的映射, 包含支付时间不在账单日期内但在账单里出现的订单
+// @param remoteTrades 账单里支付成功的交易
+func diffWxTradeBill(billDate string, localOrders []*model.Order, localOrderMap map[string]*model.Order,
+	remoteTrades []*library.WxTradeBillRecord) []*do.PayReconcileDiff {
+	diffs := make([]*do.PayReconcileDiff, 0)
+	remoteOrderNos := make(map[string]struct{}, len(remoteTrades))
+	for _, trade := range remoteTrades {
+		remoteOrderNos[trade.OutTradeNo] = struct{}{}
+		diff := &do.PayReconcileDiff{
+			BillDate:        billDate,
+			PayType:         enum.PayTypeWxPay,
+			OrderNo:         trade.OutTradeNo,
+			RemoteTransId:   trade.TransactionId,
+			RemotePayMoney:  trade.TotalAmount,
+			RemoteTradeTime: trade.TradeTime,
+		}
+		order, exists := localOrderMap[trade.OutTradeNo]
+		if !exists {
+			diff.DiffType = enum.ReconcileDiffMissingLocal
+			diffs = append(diffs, diff)
+			continue
+		}
+		diff.LocalTransId = order.PayTransId
+		diff.LocalPayMoney = order.PayMoney
+		if order.PayTransId != trade.TransactionId {
+			diff.DiffType = enum.ReconcileDiffTransIdMismatch
+			diffs = append(diffs, diff)
+		} else if order.PayMoney != trade.TotalAmount {
+			diff.DiffType = enum.ReconcileDiffMoneyMismatch
+			diffs = append(diffs, diff)
+		}
+	}
+	for _, order := range localOrders {
+		if _, exists := remoteOrderNos[order.OrderNo]; exists {
+			continue
+		}
+		diffs = append(diffs, &do.PayReconcileDiff{
+			BillDate:      billDate,
+			PayType:       enum.PayTypeWxPay,
+			DiffType:      enum.ReconcileDiffMissingRemote,
+			OrderNo:       order.OrderNo,
+			LocalTransId:  order.PayTransId,
+			LocalPayMoney: order.PayMoney,
+		})
+	}
+
+	return diffs
+}
+
+// GetReconcileDiffs 分页查询对账差异
+func (ods *OrderDomainSvc) GetReconcileDiffs(billDate string, diffType int, pagination *app.Pagination) ([]*do.PayReconcileDiff, error) {
+	diffModels, totalRows, err := dao.NewPayReconcileDao(ods.ctx).GetReconcileDiffs(billDate, diffType,
+		pagination.Offset(), pagination.GetPageSize())
+	if err != nil {
+		return nil, errcode.Wrap("GetReconcileDiffsError", err)
+	}
+	pagination.SetTotalRows(int(totalRows))
+	diffs := make([]*do.PayReconcileDiff, 0, len(diffModels))
+	if err = util.CopyProperties(&diffs, &diffModels); err != nil {
+		return nil, errcode.ErrCoverData.WithCause(err)
+	}
+
+	return diffs, nil
+}
@@ -11,14 +11,27 @@ import (
 	"github.com/WoWBytePaladin/go-mall/common/enum"
 	"github.com/WoWBytePaladin/go-mall/common/logger"
 	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/job"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// 连接不上数据库和redis时阻挡应用的继续启动
+	dao.InitDB()
+	cache.InitRedis()
+
 	if config.App.Env == enum.ModeProd {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// 支付密钥加载不了时阻挡应用的继续启动
+	if err := domainservice.CheckPayKeys(); err != nil {
+		panic(err)
+	}
+
 	g := gin.New()
 	router.RegisterRoutes(g)
 	server := http.Server{
@@ -28,14 +41,23 @@ func main() {
 
 	log := logger.New(context.Background())
 
+	// 启动后台任务
+	jobCtx, stopJobs := context.WithCancel(context.Background())
+	job.Start(jobCtx)
+
 	// 创建系统信号接收器
 	done := make(chan os.Signal)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-done
+		stopJobs()
 		if err := server.Shutdown(context.Background()); err != nil {
 			log.Error("ShutdownServerError", "err", err)
 		}
+		// 释放ID生成器租用的 worker id
+		if err := domainservice.ReleaseIdWorker(context.Background()); err != nil {
+			log.Error("ReleaseIdWorkerError", "err", err)
+		}
 	}()
 
 	log.Info("Starting GO MALL HTTP server...")
@@ -0,0 +1,68 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	dao2 "github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/stretchr/testify/assert"
+)
+
+const cartItemForUpdateSql = "SELECT * FROM `shopping_cart_items` WHERE (`shopping_cart_items`.`user_id` = ? AND `shopping_cart_items`.`commodity_id` = ?) AND `shopping_cart_items`.`is_del` = ? FOR UPDATE"
+
+func TestCartDao_EnsureCartItems(t *testing.T) {
+	var userId int64 = 1
+	mock.ExpectBegin()
+	// 购物车中已有的商品数量不够, 补足到订单中的数量
+	mock.ExpectQuery(regexp.QuoteMeta(cartItemForUpdateSql)).
+		WithArgs(userId, 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"cart_item_id", "user_id", "commodity_id", "commodity_num"}).AddRow(5, userId, 10, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `shopping_cart_items` SET `commodity_num`=?,`updated_at`=? WHERE `shopping_cart_items`.`is_del` = ? AND `cart_item_id` = ?")).
+		WithArgs(2, AnyTime{}, 0, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// 购物车中已有的商品数量够了, 不再累加
+	mock.ExpectQuery(regexp.QuoteMeta(cartItemForUpdateSql)).
+		WithArgs(userId, 11, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"cart_item_id", "user_id", "commodity_id", "commodity_num"}).AddRow(6, userId, 11, 3))
+	// 购物车中没有的商品新加入
+	mock.ExpectQuery(regexp.QuoteMeta(cartItemForUpdateSql)).
+		WithArgs(userId, 12, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"cart_item_id"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `shopping_cart_items`")).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectCommit()
+
+	err := dao2.NewCartDao(context.TODO()).EnsureCartItems(userId, []*do.ShoppingCartItem{
+		{UserId: userId, CommodityId: 10, CommodityNum: 2},
+		{UserId: userId, CommodityId: 11, CommodityNum: 2},
+		{UserId: userId, CommodityId: 12, CommodityNum: 1},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCartDao_EnsureCartItemsRollback(t *testing.T) {
+	var userId int64 = 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(cartItemForUpdateSql)).
+		WithArgs(userId, 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"cart_item_id"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `shopping_cart_items`")).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(cartItemForUpdateSql)).
+		WithArgs(userId, 11, 0).
+		WillReturnError(errors.New("lock wait timeout"))
+	// 后面的商品加入失败时, 前面加入的商品一起回滚
+	mock.ExpectRollback()
+
+	err := dao2.NewCartDao(context.TODO()).EnsureCartItems(userId, []*do.ShoppingCartItem{
+		{UserId: userId, CommodityId: 10, CommodityNum: 1},
+		{UserId: userId, CommodityId: 11, CommodityNum: 1},
+	})
+	assert.NotNil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,142 @@
+package dao
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	dao2 "github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"gorm.io/plugin/soft_delete"
+)
+
+const countOrderReservationsSql = "SELECT count(*) FROM `stock_reservations` WHERE order_id = ?"
+
+func TestCommodityDao_ReserveOrderStock(t *testing.T) {
+	var orderId int64 = 1
+	orderNo := "202410180000000000000000001"
+	commodityDel := soft_delete.DeletedAt(0)
+	// 商品10已经预占过了, 同一商品的购物项合并后预占
+	orderItems := []*do.OrderItem{
+		{CommodityId: 11, CommodityNum: 1},
+		{CommodityId: 10, CommodityNum: 2},
+		{CommodityId: 11, CommodityNum: 2},
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `commodity_id` FROM `stock_reservations` WHERE order_id = ?")).
+		WithArgs(orderId).
+		WillReturnRows(sqlmock.NewRows([]string{"commodity_id"}).AddRow(10))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `commodities` SET `reserved_num`=reserved_num + ?,`stock_num`=stock_num - ?")).
+		WithArgs(3, 3, AnyTime{}, 11, 3, commodityDel).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `stock_reservations`")).
+		WithArgs(orderId, orderNo, 11, 3, 0, enum.StockReservationStateReserved).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	tx := dao2.DBMaster().Begin()
+	err := dao2.NewCommodityDao(context.TODO()).ReserveOrderStock(tx, orderId, orderNo, orderItems)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Commit().Error)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCommodityDao_ReserveOrderStockOut(t *testing.T) {
+	var orderId int64 = 2
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `commodity_id` FROM `stock_reservations` WHERE order_id = ?")).
+		WithArgs(orderId).
+		WillReturnRows(sqlmock.NewRows([]string{"commodity_id"}))
+	// 可售库存不足时条件更新不会更新任何行
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `commodities` SET")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	tx := dao2.DBMaster().Begin()
+	err := dao2.NewCommodityDao(context.TODO()).ReserveOrderStock(tx, orderId, "202410180000000000000000002",
+		[]*do.OrderItem{{CommodityId: 10, CommodityNum: 100}})
+	assert.ErrorIs(t, err, errcode.ErrCommodityStockOut)
+	assert.Nil(t, tx.Rollback().Error)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCommodityDao_CommitOrderStock(t *testing.T) {
+	var orderId int64 = 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `stock_reservations` WHERE order_id = ? AND state = ? ORDER BY commodity_id")).
+		WithArgs(orderId, enum.StockReservationStateReserved).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "commodity_id", "commodity_num", "state"}).
+			AddRow(1, orderId, 10, 2, enum.StockReservationStateReserved).
+			AddRow(2, orderId, 11, 3, enum.StockReservationStateReserved))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_reservations` SET `state`=?,`updated_at`=? WHERE state = ? AND `id` = ?")).
+		WithArgs(enum.StockReservationStateCommitted, AnyTime{}, enum.StockReservationStateReserved, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `commodities` SET `reserved_num`=reserved_num - ?,`sold_num`=sold_num + ?,`updated_at`=? WHERE id = ?")).
+		WithArgs(2, 2, AnyTime{}, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// 已经被并发售出或者释放的预占记录不再变更库存
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_reservations` SET `state`=?,`updated_at`=? WHERE state = ? AND `id` = ?")).
+		WithArgs(enum.StockReservationStateCommitted, AnyTime{}, enum.StockReservationStateReserved, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	err := dao2.DBMaster().Transaction(func(tx *gorm.DB) error {
+		return dao2.NewCommodityDao(context.TODO()).CommitOrderStock(tx, orderId)
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCommodityDao_ReleaseFlashSaleOrderStock(t *testing.T) {
+	var orderId int64 = 3
+	var flashSaleId int64 = 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(countOrderReservationsSql)).
+		WithArgs(orderId).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `stock_reservations` WHERE order_id = ? AND state = ? ORDER BY commodity_id")).
+		WithArgs(orderId, enum.StockReservationStateReserved).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "commodity_id", "commodity_num", "flash_sale_id", "state"}).
+			AddRow(3, orderId, 10, 1, flashSaleId, enum.StockReservationStateReserved))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `stock_reservations` SET `state`=?,`updated_at`=? WHERE state = ? AND `id` = ?")).
+		WithArgs(enum.StockReservationStateReleased, AnyTime{}, enum.StockReservationStateReserved, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// 秒杀活动还没有释放库存, 秒杀订单释放的库存还给活动, 不变更商品库存
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`stock_released` FROM `flash_sales` WHERE id = ? FOR UPDATE")).
+		WithArgs(flashSaleId).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "stock_released"}).AddRow(flashSaleId, false))
+	mock.ExpectCommit()
+	err := dao2.DBMaster().Transaction(func(tx *gorm.DB) error {
+		return dao2.NewCommodityDao(context.TODO()).ReleaseOrderStock(tx, orderId)
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestCommodityDao_ReleaseLegacyOrderStock(t *testing.T) {
+	var orderId int64 = 4
+	mock.ExpectBegin()
+	// 库存预占上线前创建的订单没有预占记录, 按购物项恢复下单时扣减的可售库存
+	mock.ExpectQuery(regexp.QuoteMeta(countOrderReservationsSql)).
+		WithArgs(orderId).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `order_items` WHERE order_id = ?")).
+		WithArgs(orderId).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "commodity_id", "commodity_num"}).
+			AddRow(1, orderId, 11, 1).
+			AddRow(2, orderId, 10, 2))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `commodities` SET `stock_num`=stock_num + ?,`updated_at`=? WHERE id = ?")).
+		WithArgs(2, AnyTime{}, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `commodities` SET `stock_num`=stock_num + ?,`updated_at`=? WHERE id = ?")).
+		WithArgs(1, AnyTime{}, 11).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	err := dao2.DBMaster().Transaction(func(tx *gorm.DB) error {
+		return dao2.NewCommodityDao(context.TODO()).ReleaseOrderStock(tx, orderId)
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
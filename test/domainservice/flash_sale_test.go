@@ -0,0 +1,139 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFlashSaleDomainSvc_QueueFlashSaleOrder(t *testing.T) {
+	Convey("Given a preloaded flash sale", t, func() {
+		flashSale := &do.FlashSale{
+			ID: 1, CommodityId: 10, FlashPrice: 100, TotalStock: 10, PerUserLimit: 2,
+			StartTime: time.Now().Add(-time.Minute), EndTime: time.Now().Add(time.Hour),
+		}
+		patches := gomonkey.ApplyFunc(cache.GetFlashSale, func(_ context.Context, flashSaleId int64) (*do.FlashSale, error) {
+			return flashSale, nil
+		})
+		defer patches.Reset()
+		var idGenSvc *domainservice.IdGenDomainSvc
+		patches.ApplyMethod(idGenSvc, "GenOrderNo", func(_ *domainservice.IdGenDomainSvc) (string, error) {
+			return "202410180000000000000000001", nil
+		})
+		var deductErr error
+		patches.ApplyFunc(cache.DeductFlashSaleStock, func(_ context.Context, flashSaleId, userId int64, num, perUserLimit int) error {
+			return deductErr
+		})
+		var savedResult *do.FlashSaleOrderResult
+		patches.ApplyFunc(cache.SetFlashSaleOrderResult, func(_ context.Context, result *do.FlashSaleOrderResult, ttl time.Duration) error {
+			savedResult = result
+			return nil
+		})
+		var queuedOrder *do.FlashSaleOrder
+		patches.ApplyFunc(cache.PushFlashSaleOrder, func(_ context.Context, flashSaleOrder *do.FlashSaleOrder) error {
+			queuedOrder = flashSaleOrder
+			return nil
+		})
+		flashSaleSvc := domainservice.NewFlashSaleDomainSvc(context.TODO())
+		newFlashSaleOrder := func() *do.FlashSaleOrder {
+			return &do.FlashSaleOrder{FlashSaleId: flashSale.ID, UserId: 1, CommodityNum: 1, UserAddressId: 1}
+		}
+
+		Convey("When the user gets the stock", func() {
+			orderNo, err := flashSaleSvc.QueueFlashSaleOrder(newFlashSaleOrder())
+			Convey("Then the order should be queued with a pre-generated order number", func() {
+				So(err, ShouldBeNil)
+				So(orderNo, ShouldEqual, "202410180000000000000000001")
+				So(queuedOrder.OrderNo, ShouldEqual, orderNo)
+				So(savedResult.State, ShouldEqual, enum.FlashSaleOrderStateQueued)
+			})
+		})
+
+		Convey("When the stock is sold out", func() {
+			deductErr = cache.ErrFlashSaleSoldOut
+			_, err := flashSaleSvc.QueueFlashSaleOrder(newFlashSaleOrder())
+			Convey("Then the order should not be queued", func() {
+				So(errors.Is(err, errcode.ErrFlashSaleSoldOut), ShouldBeTrue)
+				So(queuedOrder, ShouldBeNil)
+			})
+		})
+
+		Convey("When the user has bought up to the limit", func() {
+			deductErr = cache.ErrFlashSaleOverLimit
+			_, err := flashSaleSvc.QueueFlashSaleOrder(newFlashSaleOrder())
+			Convey("Then the order should not be queued", func() {
+				So(errors.Is(err, errcode.ErrFlashSaleOverLimit), ShouldBeTrue)
+				So(queuedOrder, ShouldBeNil)
+			})
+		})
+
+		Convey("When the flash sale has ended", func() {
+			flashSale.EndTime = time.Now().Add(-time.Second)
+			_, err := flashSaleSvc.QueueFlashSaleOrder(newFlashSaleOrder())
+			Convey("Then the order should not be queued", func() {
+				So(errors.Is(err, errcode.ErrFlashSaleNotInTime), ShouldBeTrue)
+				So(queuedOrder, ShouldBeNil)
+			})
+		})
+
+		Convey("When the order can not be queued", func() {
+			patches.ApplyFunc(cache.PushFlashSaleOrder, func(_ context.Context, flashSaleOrder *do.FlashSaleOrder) error {
+				return errors.New("redis unavailable")
+			})
+			var returnedOrder *do.FlashSaleOrder
+			patches.ApplyFunc(cache.ReturnFlashSaleStock, func(_ context.Context, flashSaleOrder *do.FlashSaleOrder) (bool, error) {
+				returnedOrder = flashSaleOrder
+				return true, nil
+			})
+			_, err := flashSaleSvc.QueueFlashSaleOrder(newFlashSaleOrder())
+			Convey("Then the stock should be returned to the flash sale", func() {
+				So(err, ShouldNotBeNil)
+				So(returnedOrder.OrderNo, ShouldEqual, "202410180000000000000000001")
+			})
+		})
+	})
+}
+
+func TestFlashSaleDomainSvc_CreateQueuedOrders(t *testing.T) {
+	Convey("Given a queued flash sale order", t, func() {
+		queue := []string{`{"flash_sale_id":1,"user_id":1,"order_no":"202410180000000000000000001","commodity_num":1}`}
+		patches := gomonkey.ApplyFunc(cache.ClaimFlashSaleOrder, func(_ context.Context) (*do.FlashSaleOrder, string, error) {
+			if len(queue) == 0 {
+				return nil, "", nil
+			}
+			claimed := queue[0]
+			queue = queue[1:]
+			return &do.FlashSaleOrder{FlashSaleId: 1, UserId: 1, OrderNo: "202410180000000000000000001", CommodityNum: 1}, claimed, nil
+		})
+		defer patches.Reset()
+		acked := make([]string, 0)
+		patches.ApplyFunc(cache.AckFlashSaleOrder, func(_ context.Context, claimed string) error {
+			acked = append(acked, claimed)
+			return nil
+		})
+		var flashSaleSvc *domainservice.FlashSaleDomainSvc
+		patches.ApplyPrivateMethod(flashSaleSvc, "createQueuedOrder", func(_ *domainservice.FlashSaleDomainSvc, flashSaleOrder *do.FlashSaleOrder) bool {
+			// 处理完之前请求不能从处理中列表里删除
+			So(acked, ShouldBeEmpty)
+			return true
+		})
+
+		Convey("When the order is created", func() {
+			created, err := domainservice.NewFlashSaleDomainSvc(context.TODO()).CreateQueuedOrders(10)
+			Convey("Then the request should be acked after it is processed", func() {
+				So(err, ShouldBeNil)
+				So(created, ShouldEqual, 1)
+				So(acked, ShouldHaveLength, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,46 @@
+package domainservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIdGenDomainSvc_GenOrderNo(t *testing.T) {
+	Convey("Given a worker id leased from redis", t, func() {
+		leaseTimes := 0
+		patches := gomonkey.ApplyFunc(cache.LeaseIdWorker, func(ctx context.Context, owner string, maxWorkerId int64, ttl time.Duration) (int64, error) {
+			leaseTimes++
+			return 5, nil
+		})
+		defer patches.Reset()
+		patches.ApplyFunc(cache.ReleaseIdWorker, func(ctx context.Context, workerId int64, owner string) error {
+			return nil
+		})
+		defer domainservice.ReleaseIdWorker(context.TODO())
+
+		Convey("When generate order and refund numbers", func() {
+			idGenSvc := domainservice.NewIdGenDomainSvc(context.TODO())
+			orderNo, err := idGenSvc.GenOrderNo()
+			So(err, ShouldBeNil)
+			refundNo, err := idGenSvc.GenRefundNo()
+			So(err, ShouldBeNil)
+			Convey("Then the numbers should be generated with the leased worker id", func() {
+				So(leaseTimes, ShouldEqual, 1)
+				id, err := library.ParseIdNo(enum.BizNoPrefixOrder, orderNo)
+				So(err, ShouldBeNil)
+				_, workerId, _ := library.ParseId(id)
+				So(workerId, ShouldEqual, 5)
+				So(refundNo, ShouldStartWith, enum.BizNoPrefixRefund)
+				So(len(refundNo), ShouldEqual, len(orderNo)+len(enum.BizNoPrefixRefund))
+			})
+		})
+	})
+}
@@ -0,0 +1,164 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInvoiceDomainSvc_OrderInvoice(t *testing.T) {
+	Convey("Given a paid order with one piece refunded", t, func() {
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{
+				ID: 1, OrderNo: orderNo, UserId: userId, BillMoney: 7000, PayMoney: 6800,
+				PayState: enum.PayStatePaid, OrderStatus: enum.OrderStatusPartialRefunded,
+				Items: []*do.OrderItem{
+					{CommodityId: 1, CommodityName: "机械键盘", CommoditySellingPrice: 3000, CommodityNum: 2, DiscountMoney: 171, PaidMoney: 5829},
+					{CommodityId: 2, CommodityName: "键帽", CommoditySellingPrice: 1000, CommodityNum: 1, DiscountMoney: 29, PaidMoney: 971},
+				},
+			}, nil
+		})
+		defer patches.Reset()
+		refunds := []*model.OrderRefund{
+			{ID: 1, OrderId: 1, RefundMoney: 2914, RefundState: enum.RefundStateSuccess, RefundedAt: time.Now().Add(-time.Hour)},
+		}
+		var refundDao *dao.OrderRefundDao
+		patches.ApplyMethod(refundDao, "GetOrderRefunds", func(_ *dao.OrderRefundDao, orderId int64) ([]*model.OrderRefund, error) {
+			return refunds, nil
+		})
+		patches.ApplyMethod(refundDao, "GetMultiRefundsItems", func(_ *dao.OrderRefundDao, refundIds []int64) (map[int64][]*model.OrderRefundItem, error) {
+			return map[int64][]*model.OrderRefundItem{
+				1: {{RefundId: 1, OrderId: 1, CommodityId: 1, CommodityNum: 1, RefundMoney: 2914}},
+			}, nil
+		})
+		var invoiceDao *dao.InvoiceDao
+		patches.ApplyMethod(invoiceDao, "GetInvoiceTitle", func(_ *dao.InvoiceDao, titleId int64) (*model.InvoiceTitle, error) {
+			return &model.InvoiceTitle{ID: titleId, UserId: 1, TitleType: enum.InvoiceTitleTypeCompany, Title: "某某科技有限公司", TaxNo: "91310000000000000Y"}, nil
+		})
+		patches.ApplyMethod(invoiceDao, "GetOrderInvoices", func(_ *dao.InvoiceDao, orderId int64) ([]*model.OrderInvoice, error) {
+			return nil, nil
+		})
+		var idGenSvc *domainservice.IdGenDomainSvc
+		patches.ApplyMethod(idGenSvc, "GenInvoiceNo", func(_ *domainservice.IdGenDomainSvc) (string, error) {
+			return "I202410180000000000000000001", nil
+		})
+		invoiceCreated := true
+		patches.ApplyMethod(invoiceDao, "CreateOrderInvoice", func(_ *dao.InvoiceDao, invoice *do.OrderInvoice) (bool, error) {
+			return invoiceCreated, nil
+		})
+		invoiceSvc := domainservice.NewInvoiceDomainSvc(context.TODO())
+
+		Convey("When request an invoice for the order", func() {
+			invoice, err := invoiceSvc.RequestOrderInvoice("202410180000000000000000001", 1, 1)
+			Convey("Then the amount should be the paid money minus the refunded money", func() {
+				So(err, ShouldBeNil)
+				So(invoice.Amount, ShouldEqual, 3886)
+				So(invoice.InvoiceState, ShouldEqual, enum.InvoiceStateRequested)
+				So(invoice.TaxNo, ShouldEqual, "91310000000000000Y")
+			})
+		})
+
+		Convey("When a concurrent request has created an invoice for the order", func() {
+			invoiceCreated = false
+			_, err := invoiceSvc.RequestOrderInvoice("202410180000000000000000001", 1, 1)
+			Convey("Then it should be rejected", func() {
+				So(errors.Is(err, errcode.ErrInvoiceNotAllowed), ShouldBeTrue)
+			})
+		})
+
+		Convey("When request an invoice while a refund is processing", func() {
+			refunds = append(refunds, &model.OrderRefund{ID: 2, OrderId: 1, RefundMoney: 971, RefundState: enum.RefundStateProcessing})
+			_, err := invoiceSvc.RequestOrderInvoice("202410180000000000000000001", 1, 1)
+			Convey("Then it should be rejected", func() {
+				So(errors.Is(err, errcode.ErrInvoiceNotAllowed), ShouldBeTrue)
+			})
+		})
+
+		Convey("When issue the requested invoice", func() {
+			patches.ApplyMethod(invoiceDao, "GetOrderInvoiceByNo", func(_ *dao.InvoiceDao, invoiceNo string) (*model.OrderInvoice, error) {
+				return &model.OrderInvoice{ID: 1, InvoiceNo: invoiceNo, OrderId: 1, OrderNo: "202410180000000000000000001", UserId: 1,
+					Amount: 6800, InvoiceState: enum.InvoiceStateRequested}, nil
+			})
+			patches.ApplyMethod(invoiceDao, "ClaimInvoiceIssuing", func(_ *dao.InvoiceDao, invoiceId int64) (bool, error) {
+				return true, nil
+			})
+			var issuedInvoice *do.OrderInvoice
+			var localIssuer *library.LocalInvoiceIssuer
+			patches.ApplyMethod(localIssuer, "Issue", func(_ *library.LocalInvoiceIssuer, invoice *do.OrderInvoice) (*do.InvoiceIssueResult, error) {
+				issuedInvoice = invoice
+				return &do.InvoiceIssueResult{InvoiceNumber: "24101812000012345678", IssuedAt: time.Now()}, nil
+			})
+			issuedAmount := 0
+			patches.ApplyMethod(invoiceDao, "SetInvoiceIssued", func(_ *dao.InvoiceDao, invoiceId int64, amount int, result *do.InvoiceIssueResult) (bool, error) {
+				issuedAmount = amount
+				return true, nil
+			})
+			err := invoiceSvc.IssueInvoice("I202410180000000000000000001")
+			Convey("Then the invoice should be issued with the amount refunded since it was requested taken off", func() {
+				So(err, ShouldBeNil)
+				So(issuedAmount, ShouldEqual, 3886)
+				So(issuedInvoice.Items, ShouldHaveLength, 2)
+				So(issuedInvoice.Items[0].CommodityNum, ShouldEqual, 1)
+				So(issuedInvoice.Items[0].Amount, ShouldEqual, 2915)
+				So(issuedInvoice.Items[0].Amount+issuedInvoice.Items[1].Amount, ShouldEqual, 3886)
+			})
+		})
+
+		Convey("When issue an invoice claimed by a concurrent request", func() {
+			patches.ApplyMethod(invoiceDao, "GetOrderInvoiceByNo", func(_ *dao.InvoiceDao, invoiceNo string) (*model.OrderInvoice, error) {
+				return &model.OrderInvoice{ID: 1, InvoiceNo: invoiceNo, OrderId: 1, OrderNo: "202410180000000000000000001", UserId: 1,
+					Amount: 6800, InvoiceState: enum.InvoiceStateRequested}, nil
+			})
+			patches.ApplyMethod(invoiceDao, "ClaimInvoiceIssuing", func(_ *dao.InvoiceDao, invoiceId int64) (bool, error) {
+				return false, nil
+			})
+			issueTimes := 0
+			var localIssuer *library.LocalInvoiceIssuer
+			patches.ApplyMethod(localIssuer, "Issue", func(_ *library.LocalInvoiceIssuer, invoice *do.OrderInvoice) (*do.InvoiceIssueResult, error) {
+				issueTimes++
+				return &do.InvoiceIssueResult{InvoiceNumber: "24101812000012345678", IssuedAt: time.Now()}, nil
+			})
+			err := invoiceSvc.IssueInvoice("I202410180000000000000000001")
+			Convey("Then it should not call the issuer", func() {
+				So(errors.Is(err, errcode.ErrInvoiceStateInvalid), ShouldBeTrue)
+				So(issueTimes, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the issuer fails after the invoice is claimed", func() {
+			patches.ApplyMethod(invoiceDao, "GetOrderInvoiceByNo", func(_ *dao.InvoiceDao, invoiceNo string) (*model.OrderInvoice, error) {
+				return &model.OrderInvoice{ID: 1, InvoiceNo: invoiceNo, OrderId: 1, OrderNo: "202410180000000000000000001", UserId: 1,
+					Amount: 6800, InvoiceState: enum.InvoiceStateRequested}, nil
+			})
+			patches.ApplyMethod(invoiceDao, "ClaimInvoiceIssuing", func(_ *dao.InvoiceDao, invoiceId int64) (bool, error) {
+				return true, nil
+			})
+			var localIssuer *library.LocalInvoiceIssuer
+			patches.ApplyMethod(localIssuer, "Issue", func(_ *library.LocalInvoiceIssuer, invoice *do.OrderInvoice) (*do.InvoiceIssueResult, error) {
+				return nil, errors.New("write pdf error")
+			})
+			released := false
+			patches.ApplyMethod(invoiceDao, "ReleaseInvoiceIssuing", func(_ *dao.InvoiceDao, invoiceId int64) error {
+				released = true
+				return nil
+			})
+			err := invoiceSvc.IssueInvoice("I202410180000000000000000001")
+			Convey("Then the invoice should go back to requested", func() {
+				So(err, ShouldNotBeNil)
+				So(released, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,95 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderDomainSvc_BuyAgain(t *testing.T) {
+	Convey("Given a previous order with four commodities", t, func() {
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{OrderNo: orderNo, UserId: userId, Items: []*do.OrderItem{
+				{CommodityId: 1, CommodityName: "在售商品", CommoditySellingPrice: 100, CommodityNum: 1},
+				{CommodityId: 2, CommodityName: "已删除商品", CommoditySellingPrice: 200, CommodityNum: 1},
+				{CommodityId: 3, CommodityName: "已下架商品", CommoditySellingPrice: 300, CommodityNum: 1},
+				{CommodityId: 4, CommodityName: "库存不足商品", CommoditySellingPrice: 400, CommodityNum: 5},
+			}}, nil
+		})
+		defer patches.Reset()
+		var commodityDao *dao.CommodityDao
+		patches.ApplyMethod(commodityDao, "FindCommodities", func(_ *dao.CommodityDao, commodityIdList []int64) ([]*model.Commodity, error) {
+			return []*model.Commodity{
+				{ID: 1, Name: "在售商品", SellingPrice: 120, StockNum: 10, SellStatus: enum.CommoditySellStatusOn},
+				{ID: 3, Name: "已下架商品", SellingPrice: 300, StockNum: 10, SellStatus: enum.CommoditySellStatusOff},
+				{ID: 4, Name: "库存不足商品", SellingPrice: 400, StockNum: 2, SellStatus: enum.CommoditySellStatusOn},
+			}, nil
+		})
+		cartItems := make([]*model.ShoppingCartItem, 0)
+		var cartDao *dao.CartDao
+		patches.ApplyMethod(cartDao, "GetUserCartItems", func(_ *dao.CartDao, userId int64) ([]*model.ShoppingCartItem, error) {
+			return cartItems, nil
+		})
+		addedItems := make([]*do.ShoppingCartItem, 0)
+		var ensureErr error
+		var cds *domainservice.CartDomainSvc
+		patches.ApplyMethod(cds, "CartEnsureItems", func(_ *domainservice.CartDomainSvc, userId int64, items []*do.ShoppingCartItem) error {
+			if ensureErr != nil {
+				return ensureErr
+			}
+			addedItems = append(addedItems, items...)
+			return nil
+		})
+
+		Convey("When buy the order again", func() {
+			result, err := domainservice.NewOrderDomainSvc(context.TODO()).BuyAgain("20240903374062590406950001", 1)
+			Convey("Then only the available commodity should be added to the cart", func() {
+				So(err, ShouldBeNil)
+				So(addedItems, ShouldHaveLength, 1)
+				So(addedItems[0].CommodityId, ShouldEqual, 1)
+				So(addedItems[0].UserId, ShouldEqual, 1)
+				So(result.AddedItems, ShouldHaveLength, 1)
+			})
+			Convey("And the skipped commodities should be reported with reasons", func() {
+				So(result.SkippedItems, ShouldHaveLength, 3)
+				So(result.SkippedItems[0].Reason, ShouldEqual, enum.BuyAgainSkipDeleted)
+				So(result.SkippedItems[1].Reason, ShouldEqual, enum.BuyAgainSkipOffShelf)
+				So(result.SkippedItems[2].Reason, ShouldEqual, enum.BuyAgainSkipOutOfStock)
+			})
+			Convey("And the price change should be reported", func() {
+				So(result.PriceChangedItems, ShouldHaveLength, 1)
+				So(result.PriceChangedItems[0].OrderedPrice, ShouldEqual, 100)
+				So(result.PriceChangedItems[0].CurrentPrice, ShouldEqual, 120)
+			})
+		})
+
+		Convey("When the cart already holds more of a commodity than the stock left", func() {
+			cartItems = append(cartItems, &model.ShoppingCartItem{UserId: 1, CommodityId: 1, CommodityNum: 11})
+			result, err := domainservice.NewOrderDomainSvc(context.TODO()).BuyAgain("20240903374062590406950001", 1)
+			Convey("Then the commodity should be skipped as out of stock", func() {
+				So(err, ShouldBeNil)
+				So(addedItems, ShouldBeEmpty)
+				So(result.SkippedItems, ShouldHaveLength, 4)
+				So(result.SkippedItems[0].Reason, ShouldEqual, enum.BuyAgainSkipOutOfStock)
+			})
+		})
+
+		Convey("When adding to the cart fails", func() {
+			ensureErr = errors.New("lock wait timeout")
+			_, err := domainservice.NewOrderDomainSvc(context.TODO()).BuyAgain("20240903374062590406950001", 1)
+			Convey("Then the error should be returned and nothing added", func() {
+				So(err, ShouldNotBeNil)
+				So(addedItems, ShouldBeEmpty)
+			})
+		})
+	})
+}
@@ -0,0 +1,99 @@
+package domainservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestOrderDomainSvc_CreateDirectOrder(t *testing.T) {
+	Convey("Given a commodity on the product page", t, func() {
+		sellStatus := enum.CommoditySellStatusOn
+		var commodityDao *dao.CommodityDao
+		patches := gomonkey.ApplyMethod(commodityDao, "FindCommodityById", func(_ *dao.CommodityDao, commodityId int64) (*model.Commodity, error) {
+			return &model.Commodity{ID: commodityId, Name: "go-mall T恤", CoverImg: "tshirt.jpg", SellingPrice: 9900, SellStatus: sellStatus}, nil
+		})
+		defer patches.Reset()
+		var orderedItems []*do.ShoppingCartItem
+		var deletedCartItemIds []int64
+		var ods *domainservice.OrderDomainSvc
+		patches.ApplyPrivateMethod(ods, "createOrder", func(_ *domainservice.OrderDomainSvc, order *do.Order, items []*do.ShoppingCartItem, userAddress *do.UserAddressInfo, cartItemIds []int64) (*do.Order, error) {
+			orderedItems = items
+			deletedCartItemIds = cartItemIds
+			order.OrderNo = "20240903374062590406950001"
+			return order, nil
+		})
+		address := &do.UserAddressInfo{UserId: 1}
+
+		Convey("When buy it now", func() {
+			order, err := domainservice.NewOrderDomainSvc(context.TODO()).CreateDirectOrder(10, 2, address)
+			Convey("Then the order should be created from the commodity without touching the cart", func() {
+				So(err, ShouldBeNil)
+				So(order.OrderNo, ShouldEqual, "20240903374062590406950001")
+				So(orderedItems, ShouldHaveLength, 1)
+				So(orderedItems[0].CommodityId, ShouldEqual, 10)
+				So(orderedItems[0].CommodityNum, ShouldEqual, 2)
+				So(orderedItems[0].CommoditySellingPrice, ShouldEqual, 9900)
+				So(orderedItems[0].UserId, ShouldEqual, 1)
+				So(deletedCartItemIds, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When buy it now after it is taken off the shelf", func() {
+			sellStatus = enum.CommoditySellStatusOff
+			_, err := domainservice.NewOrderDomainSvc(context.TODO()).CreateDirectOrder(10, 2, address)
+			Convey("Then the order should not be created", func() {
+				So(err, ShouldEqual, errcode.ErrCommodityNotExists)
+				So(orderedItems, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_CreateOrderDuplicateOrderNo(t *testing.T) {
+	Convey("Given an order number that is already used", t, func() {
+		var commodityDao *dao.CommodityDao
+		patches := gomonkey.ApplyMethod(commodityDao, "FindCommodityById", func(_ *dao.CommodityDao, commodityId int64) (*model.Commodity, error) {
+			return &model.Commodity{ID: commodityId, Name: "go-mall T恤", SellingPrice: 9900, SellStatus: enum.CommoditySellStatusOn}, nil
+		})
+		defer patches.Reset()
+		var billChecker *domainservice.CartBillChecker
+		patches.ApplyMethod(billChecker, "GetBill", func(_ *domainservice.CartBillChecker) (*do.CartBillInfo, error) {
+			return &do.CartBillInfo{OriginalTotalPrice: 19800, TotalPrice: 19800}, nil
+		})
+		orderNos := []string{"20240903374062590406950001", "20240903374062590406950002"}
+		var idGenSvc *domainservice.IdGenDomainSvc
+		patches.ApplyMethod(idGenSvc, "GenOrderNo", func(_ *domainservice.IdGenDomainSvc) (string, error) {
+			orderNo := orderNos[0]
+			orderNos = orderNos[1:]
+			return orderNo, nil
+		})
+		savedOrderNos := make([]string, 0)
+		var ods *domainservice.OrderDomainSvc
+		patches.ApplyPrivateMethod(ods, "saveOrder", func(_ *domainservice.OrderDomainSvc, order *do.Order, billInfo *do.CartBillInfo, cartItemIds []int64) error {
+			savedOrderNos = append(savedOrderNos, order.OrderNo)
+			if len(savedOrderNos) == 1 {
+				return gorm.ErrDuplicatedKey
+			}
+			return nil
+		})
+
+		Convey("When buy it now", func() {
+			order, err := domainservice.NewOrderDomainSvc(context.TODO()).CreateDirectOrder(10, 2, &do.UserAddressInfo{UserId: 1})
+			Convey("Then the order should be created with a regenerated order number", func() {
+				So(err, ShouldBeNil)
+				So(savedOrderNos, ShouldResemble, []string{"20240903374062590406950001", "20240903374062590406950002"})
+				So(order.OrderNo, ShouldEqual, "20240903374062590406950002")
+			})
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestOrderDomainSvc_SweepExpiredUnpaidOrders(t *testing.T) {
+	Convey("Given an expired unpaid order that dropped out of the pay deadline queue", t, func() {
+		order := &model.Order{ID: 1, OrderNo: "20240903374062590406950001", PayType: enum.PayTypeAliPay,
+			PayState: enum.PayStateUnPaid, OrderStatus: enum.OrderStatusUnPaid, PayDeadline: time.Now().Add(-time.Hour)}
+		var orderDao *dao.OrderDao
+		patches := gomonkey.ApplyMethod(orderDao, "GetExpiredUnpaidOrders", func(_ *dao.OrderDao, deadlineBefore time.Time, lastId int64, limit int) ([]*model.Order, error) {
+			return []*model.Order{order}, nil
+		})
+		defer patches.Reset()
+		patches.ApplyMethod(orderDao, "GetOrderByNo", func(_ *dao.OrderDao, orderNo string) (*model.Order, error) {
+			return order, nil
+		})
+		var closedOrderIds []int64
+		patches.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			closedOrderIds = append(closedOrderIds, orderId)
+			return true, nil
+		})
+		var ods *domainservice.OrderDomainSvc
+		reconcileErr := errors.New("alipay query timeout")
+		patches.ApplyMethod(ods, "ReconcileOrderPay", func(_ *domainservice.OrderDomainSvc, order *model.Order) error {
+			return reconcileErr
+		})
+		// 关单前要关闭支付宝的交易, 支付宝公钥用一个临时文件代替
+		publicKeyFile := filepath.Join(t.TempDir(), "alipay_public.pem")
+		So(os.WriteFile(publicKeyFile, []byte("alipay public key"), 0600), ShouldBeNil)
+		publicKeyFileBefore := config.App.AliPay.PublicKeyFile
+		config.App.AliPay.PublicKeyFile = publicKeyFile
+		defer func() { config.App.AliPay.PublicKeyFile = publicKeyFileBefore }()
+		var closedTradeNos []string
+		var apl *library.AliPayLib
+		patches.ApplyMethod(apl, "CloseTrade", func(_ *library.AliPayLib, outTradeNo string) error {
+			closedTradeNos = append(closedTradeNos, outTradeNo)
+			return nil
+		})
+		odsSvc := domainservice.NewOrderDomainSvc(context.TODO())
+
+		Convey("When the pay platform can't be queried", func() {
+			closed, err := odsSvc.SweepExpiredUnpaidOrders(5*time.Minute, 100)
+			Convey("Then the order should be left open for the next round", func() {
+				So(err, ShouldBeNil)
+				So(closed, ShouldEqual, 0)
+				So(closedOrderIds, ShouldBeEmpty)
+				So(closedTradeNos, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the pay platform confirms it is still unpaid", func() {
+			reconcileErr = nil
+			closed, err := odsSvc.SweepExpiredUnpaidOrders(5*time.Minute, 100)
+			Convey("Then the order should be closed", func() {
+				So(err, ShouldBeNil)
+				So(closed, ShouldEqual, 1)
+				So(closedOrderIds, ShouldResemble, []int64{1})
+				So(closedTradeNos, ShouldResemble, []string{order.OrderNo})
+			})
+		})
+	})
+}
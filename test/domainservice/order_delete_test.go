@@ -0,0 +1,69 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderDomainSvc_DeleteUserOrder(t *testing.T) {
+	Convey("Given a user order", t, func() {
+		order := &model.Order{ID: 1, OrderNo: "202410180000000000000000001", UserId: 1, PayState: enum.PayStatePaid}
+		var orderDao *dao.OrderDao
+		patches := gomonkey.ApplyMethod(orderDao, "GetOrderByNo", func(_ *dao.OrderDao, orderNo string) (*model.Order, error) {
+			return order, nil
+		})
+		defer patches.Reset()
+		deletedOrders := make(map[int64]bool)
+		patches.ApplyMethod(orderDao, "SetUserOrderDeleted", func(_ *dao.OrderDao, orderId int64, deleted bool) error {
+			deletedOrders[orderId] = deleted
+			return nil
+		})
+		orderSvc := domainservice.NewOrderDomainSvc(context.TODO())
+
+		Convey("When delete a completed order", func() {
+			order.OrderStatus = enum.OrderStatusCompleted
+			err := orderSvc.DeleteUserOrder(order.OrderNo, 1)
+			Convey("Then the order should be deleted", func() {
+				So(err, ShouldBeNil)
+				So(deletedOrders, ShouldResemble, map[int64]bool{1: true})
+			})
+		})
+
+		Convey("When delete an order still on delivery", func() {
+			order.OrderStatus = enum.OrderStatusOnDelivery
+			err := orderSvc.DeleteUserOrder(order.OrderNo, 1)
+			Convey("Then it should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderCanNotBeChanged), ShouldBeTrue)
+				So(deletedOrders, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When delete an order of another user", func() {
+			order.OrderStatus = enum.OrderStatusCompleted
+			err := orderSvc.DeleteUserOrder(order.OrderNo, 2)
+			Convey("Then it should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderParams), ShouldBeTrue)
+				So(deletedOrders, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When restore a deleted order", func() {
+			order.OrderStatus = enum.OrderStatusUserQuit
+			order.IsDel = 1
+			err := orderSvc.RestoreUserOrder(order.OrderNo, 1)
+			Convey("Then the order should be back in the order list", func() {
+				So(err, ShouldBeNil)
+				So(deletedOrders, ShouldResemble, map[int64]bool{1: false})
+			})
+		})
+	})
+}
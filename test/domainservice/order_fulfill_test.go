@@ -0,0 +1,63 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestOrderDomainSvc_ShipOrder(t *testing.T) {
+	Convey("Given a picked order", t, func() {
+		orderNo := "20240903374062590406950001"
+		var orderDao *dao.OrderDao
+		patches := gomonkey.ApplyMethod(orderDao, "GetOrderByNo", func(_ *dao.OrderDao, orderNo string) (*model.Order, error) {
+			return &model.Order{ID: 1, OrderNo: orderNo, PayState: enum.PayStatePaid, OrderStatus: enum.OrderStatusChecked}, nil
+		})
+		defer patches.Reset()
+		var savedUpdates map[string]interface{}
+		var savedLog *model.OrderStatusLog
+		patches.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			savedUpdates = updates
+			savedLog = statusLog
+			return true, nil
+		})
+		orderDomainSvc := domainservice.NewOrderDomainSvc(context.TODO())
+
+		Convey("When ship it with a supported carrier", func() {
+			err := orderDomainSvc.ShipOrder(&do.OrderShipment{OrderNo: orderNo, CarrierCode: "SF", TrackingNo: "SF1234567890"})
+			Convey("Then the carrier and tracking number should be stored on the order", func() {
+				So(err, ShouldBeNil)
+				So(savedUpdates["ship_carrier"], ShouldEqual, "SF")
+				So(savedUpdates["tracking_no"], ShouldEqual, "SF1234567890")
+				So(savedLog.ToStatus, ShouldEqual, enum.OrderStatusShipped)
+				So(savedLog.Actor, ShouldEqual, enum.OrderActorMerchant)
+			})
+		})
+
+		Convey("When ship it with an unknown carrier", func() {
+			err := orderDomainSvc.ShipOrder(&do.OrderShipment{OrderNo: orderNo, CarrierCode: "UNKNOWN", TrackingNo: "1234"})
+			Convey("Then the shipment should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderShipCarrierInvalid), ShouldBeTrue)
+				So(savedLog, ShouldBeNil)
+			})
+		})
+
+		Convey("When mark it as delivered before it is shipped", func() {
+			err := orderDomainSvc.DeliverOrder(orderNo)
+			Convey("Then the order should not be changed", func() {
+				So(errors.Is(err, errcode.ErrOrderCanNotBeChanged), ShouldBeTrue)
+				So(savedLog, ShouldBeNil)
+			})
+		})
+	})
+}
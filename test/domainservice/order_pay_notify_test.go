@@ -0,0 +1,91 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderDomainSvc_HandleWxPayNotify(t *testing.T) {
+	Convey("Given a verified wxpay success notify", t, func() {
+		resourceData := &library.WxPayNotifyResourceData{TransactionID: "4200002362202409031234567890",
+			OutTradeNo: "20240903374062590406950001", TradeState: library.WxTradeStateSuccess, SuccessTime: time.Now()}
+		resourceData.Amount.Total = 549700
+		resourceData.Mchid, resourceData.AppID = config.App.WechatPay.MchId, config.App.WechatPay.AppId
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		var wpl *library.WxPayLib
+		patches := gomonkey.ApplyMethod(wpl, "ValidateNotifySignature", func(_ *library.WxPayLib, serialNo, timeStamp, nonce, signature, rawPost string) (bool, error) {
+			return true, nil
+		})
+		defer patches.Reset()
+		patches.ApplyMethod(wpl, "DecryptNotifyResourceData", func(_ *library.WxPayLib, rawPost string) (*library.WxPayNotifyResourceData, error) {
+			return resourceData, nil
+		})
+		orderModel := &model.Order{ID: 1, OrderNo: resourceData.OutTradeNo, PayMoney: 549700,
+			PayState: enum.PayStateUnPaid, OrderStatus: enum.OrderStatusCreated}
+		var orderDao *dao.OrderDao
+		patches.ApplyMethod(orderDao, "GetOrderByNo", func(_ *dao.OrderDao, orderNo string) (*model.Order, error) {
+			return orderModel, nil
+		})
+		odsSvc := domainservice.NewOrderDomainSvc(context.TODO())
+
+		Convey("When the notified order does not exist", func() {
+			orderModel = &model.Order{}
+			err := odsSvc.HandleWxPayNotify("serial", timestamp, "nonce", "signature", "{}")
+			Convey("Then it should be acknowledged so wxpay stops resending", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("When the notify was signed more than five minutes ago", func() {
+			timestamp = strconv.FormatInt(time.Now().Add(-6*time.Minute).Unix(), 10)
+			err := odsSvc.HandleWxPayNotify("serial", timestamp, "nonce", "signature", "{}")
+			Convey("Then it should be rejected as a possible replay", func() {
+				So(errors.Is(err, errcode.ErrOrderPayNotifyInvalid), ShouldBeTrue)
+				So(orderModel.PayState, ShouldEqual, enum.PayStateUnPaid)
+			})
+		})
+
+		Convey("When the notify is for another merchant", func() {
+			resourceData.Mchid = "1900000109"
+			err := odsSvc.HandleWxPayNotify("serial", timestamp, "nonce", "signature", "{}")
+			Convey("Then it should not be settled", func() {
+				So(errors.Is(err, errcode.ErrOrderPayNotifyInvalid), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the order has been closed before the payment", func() {
+			orderModel.OrderStatus = enum.OrderStatusUnpaidClose
+			err := odsSvc.HandleWxPayNotify("serial", timestamp, "nonce", "signature", "{}")
+			Convey("Then it should be acknowledged and left for a manual refund", func() {
+				So(err, ShouldBeNil)
+			})
+			Convey("Then settling it directly should report the payment after close", func() {
+				err = odsSvc.SettleOrderPay(&do.OrderPayResult{OrderNo: orderModel.OrderNo, PayType: enum.PayTypeWxPay,
+					PayMoney: 549700, PayState: enum.PayStatePaid})
+				So(errors.Is(err, errcode.ErrOrderPaidAfterClosed), ShouldBeTrue)
+			})
+		})
+
+		Convey("When the notified amount differs from the order", func() {
+			resourceData.Amount.Total = 100
+			err := odsSvc.HandleWxPayNotify("serial", timestamp, "nonce", "signature", "{}")
+			Convey("Then it should be acknowledged so wxpay stops resending", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
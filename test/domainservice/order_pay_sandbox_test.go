@@ -0,0 +1,69 @@
+package domainservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderDomainSvc_SimulateSandboxPay(t *testing.T) {
+	Convey("Given an order paying with the sandbox pay", t, func() {
+		orderNo := "20240903374062590406950001"
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{OrderNo: orderNo, UserId: userId, PayType: enum.PayTypeSandbox, PayMoney: 549700,
+				OrderStatus: enum.OrderStatusUnPaid}, nil
+		})
+		defer patches.Reset()
+		patches.ApplyFunc(cache.GetSandboxPayTransaction, func(_ context.Context, orderNo string) (*do.SandboxPayTransaction, error) {
+			return &do.SandboxPayTransaction{OrderNo: orderNo, PayTransId: "SANDBOX12345678901234567890", PayMoney: 549700}, nil
+		})
+		var settledResult *do.OrderPayResult
+		patches.ApplyMethod(ods, "SettleOrderPay", func(_ *domainservice.OrderDomainSvc, payResult *do.OrderPayResult) error {
+			settledResult = payResult
+			return nil
+		})
+		env := config.App.Env
+		defer func() { config.App.Env = env }()
+
+		Convey("When simulate a successful payment in dev environment", func() {
+			config.App.Env = enum.ModeDev
+			err := domainservice.NewOrderDomainSvc(context.TODO()).SimulateSandboxPay(orderNo, 1, domainservice.SandboxPayResultSuccess, 0)
+			Convey("Then the order should be settled as paid with the sandbox transaction", func() {
+				So(err, ShouldBeNil)
+				So(settledResult.OrderNo, ShouldEqual, orderNo)
+				So(settledResult.PayType, ShouldEqual, enum.PayTypeSandbox)
+				So(settledResult.PayTransId, ShouldEqual, "SANDBOX12345678901234567890")
+				So(settledResult.PayMoney, ShouldEqual, 549700)
+				So(settledResult.PayState, ShouldEqual, enum.PayStatePaid)
+			})
+		})
+
+		Convey("When simulate a failed payment in test environment", func() {
+			config.App.Env = enum.ModeTest
+			err := domainservice.NewOrderDomainSvc(context.TODO()).SimulateSandboxPay(orderNo, 1, domainservice.SandboxPayResultFail, 0)
+			Convey("Then the order should be settled as pay failed", func() {
+				So(err, ShouldBeNil)
+				So(settledResult.PayState, ShouldEqual, enum.PayStatePayFailed)
+			})
+		})
+
+		Convey("When simulate a payment in prod environment", func() {
+			config.App.Env = enum.ModeProd
+			settledResult = nil
+			err := domainservice.NewOrderDomainSvc(context.TODO()).SimulateSandboxPay(orderNo, 1, domainservice.SandboxPayResultSuccess, 0)
+			Convey("Then the sandbox pay should be rejected", func() {
+				So(err, ShouldEqual, errcode.ErrOrderSandboxPayDisabled)
+				So(settledResult, ShouldBeNil)
+			})
+		})
+	})
+}
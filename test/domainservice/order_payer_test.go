@@ -0,0 +1,86 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderPayTemplate_CreateOrderPayRetry(t *testing.T) {
+	Convey("Given a created order paying with wxpay", t, func() {
+		orderNo := "20240903374062590406950001"
+		orderStatus, payType := enum.OrderStatusCreated, enum.PayTypeNotConfirmed
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{ID: 1, OrderNo: orderNo, UserId: userId, PayMoney: 549700, PayType: payType,
+				OrderStatus: orderStatus, PayDeadline: time.Now().Add(30 * time.Minute)}, nil
+		})
+		defer patches.Reset()
+		patches.ApplyFunc(cache.GetOrderPayReply, func(_ context.Context, orderNo string, payType int, payScene string) ([]byte, error) {
+			return nil, nil
+		})
+		patches.ApplyFunc(cache.LockOrderPay, func(_ context.Context, orderNo string) (bool, error) {
+			return true, nil
+		})
+		patches.ApplyFunc(cache.UnlockOrderPay, func(_ context.Context, orderNo string) error {
+			return nil
+		})
+		cachedReplies := 0
+		patches.ApplyFunc(cache.SetOrderPayReply, func(_ context.Context, orderNo string, payType int, payScene string, payReply []byte, ttl time.Duration) error {
+			cachedReplies++
+			return nil
+		})
+		startPayTimes := 0
+		var stateMachine *domainservice.OrderStateMachine
+		patches.ApplyMethod(stateMachine, "Fire", func(_ *domainservice.OrderStateMachine, order *model.Order, change *domainservice.OrderStatusChange) (bool, error) {
+			startPayTimes++
+			orderStatus, payType = enum.OrderStatusUnPaid, change.Updates["pay_type"].(int)
+			return true, nil
+		})
+		prepayErrs := []error{errors.New("context deadline exceeded"), nil}
+		var wpl *library.WxPayLib
+		patches.ApplyMethod(wpl, "CreateOrderPay", func(_ *library.WxPayLib, order *do.Order, userOpenId string) (*library.WxPayInvokeInfo, error) {
+			err := prepayErrs[0]
+			prepayErrs = prepayErrs[1:]
+			if err != nil {
+				return nil, err
+			}
+			return &library.WxPayInvokeInfo{Package: "prepay_id=wx201410272009395522657a690389285100"}, nil
+		})
+
+		Convey("When the first prepay fails and the user pays again", func() {
+			payTemplate, err := domainservice.NewOrderPayTemplate(context.TODO(), 1, orderNo, "jsapi", "", enum.PayTypeWxPay)
+			So(err, ShouldBeNil)
+			_, firstErr := payTemplate.CreateOrderPay()
+			payTemplate, _ = domainservice.NewOrderPayTemplate(context.TODO(), 1, orderNo, "jsapi", "", enum.PayTypeWxPay)
+			reply, retryErr := payTemplate.CreateOrderPay()
+			Convey("Then the retry should prepay again and get the pay info", func() {
+				So(firstErr, ShouldNotBeNil)
+				So(retryErr, ShouldBeNil)
+				So(reply, ShouldNotBeNil)
+				So(startPayTimes, ShouldEqual, 1)
+				So(cachedReplies, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the unpaid order is paid with another pay type", func() {
+			orderStatus, payType = enum.OrderStatusUnPaid, enum.PayTypeWxPay
+			payTemplate, _ := domainservice.NewOrderPayTemplate(context.TODO(), 1, orderNo, "web", "", enum.PayTypeAliPay)
+			_, err := payTemplate.CreateOrderPay()
+			Convey("Then it should be rejected", func() {
+				So(err, ShouldNotBeNil)
+				So(startPayTimes, ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,142 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCartBillChecker_GetBill(t *testing.T) {
+	Convey("Given cart items bought in multiple pieces", t, func() {
+		items := []*do.ShoppingCartItem{
+			{CommodityId: 1, CommoditySellingPrice: 3000, CommodityNum: 2},
+			{CommodityId: 2, CommoditySellingPrice: 1000, CommodityNum: 1},
+		}
+
+		Convey("When check the bill", func() {
+			billInfo, err := domainservice.NewCartBillChecker(items, 1).GetBill()
+			Convey("Then the price breakdown should count the number of each item", func() {
+				So(err, ShouldBeNil)
+				So(billInfo.OriginalTotalPrice, ShouldEqual, 7000)
+				So(billInfo.CouponMoney, ShouldEqual, 100)
+				So(billInfo.DiscountMoney, ShouldEqual, 100)
+				So(billInfo.TotalPrice, ShouldEqual, billInfo.OriginalTotalPrice-billInfo.CouponMoney-billInfo.DiscountMoney-billInfo.VipDiscountMoney)
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_ApplyOrderRefundByPaidMoney(t *testing.T) {
+	Convey("Given a paid order with discounts apportioned to its items", t, func() {
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{
+				ID: 1, OrderNo: orderNo, UserId: userId, BillMoney: 7000, PayMoney: 6800,
+				PayType: enum.PayTypeWxPay, PayState: enum.PayStatePaid, OrderStatus: enum.OrderStatusPaid,
+				Items: []*do.OrderItem{
+					{CommodityId: 1, CommoditySellingPrice: 3000, CommodityNum: 2, DiscountMoney: 171, PaidMoney: 5829},
+					{CommodityId: 2, CommoditySellingPrice: 1000, CommodityNum: 1, DiscountMoney: 29, PaidMoney: 971},
+				},
+			}, nil
+		})
+		defer patches.Reset()
+		var refundDao *dao.OrderRefundDao
+		patches.ApplyMethod(refundDao, "GetOrderRefunds", func(_ *dao.OrderRefundDao, orderId int64) ([]*model.OrderRefund, error) {
+			return nil, nil
+		})
+		patches.ApplyMethod(refundDao, "GetMultiRefundsItems", func(_ *dao.OrderRefundDao, refundIds []int64) (map[int64][]*model.OrderRefundItem, error) {
+			return map[int64][]*model.OrderRefundItem{}, nil
+		})
+		var invoiceDao *dao.InvoiceDao
+		patches.ApplyMethod(invoiceDao, "GetOrderInvoices", func(_ *dao.InvoiceDao, orderId int64) ([]*model.OrderInvoice, error) {
+			return nil, nil
+		})
+		var idGenSvc *domainservice.IdGenDomainSvc
+		patches.ApplyMethod(idGenSvc, "GenRefundNo", func(_ *domainservice.IdGenDomainSvc) (string, error) {
+			return "R202410180000000000000000001", nil
+		})
+		patches.ApplyMethod(refundDao, "CreateOrderRefund", func(_ *dao.OrderRefundDao, refund *do.OrderRefund) error {
+			return nil
+		})
+
+		Convey("When refund one piece of the first item", func() {
+			refund, err := domainservice.NewOrderDomainSvc(context.TODO()).ApplyOrderRefund("20240903374062590406950001", 1, "不想要了",
+				[]*do.OrderRefundItem{{CommodityId: 1, CommodityNum: 1}})
+			Convey("Then the refund money should be what the user actually paid for it", func() {
+				So(err, ShouldBeNil)
+				So(refund.RefundMoney, ShouldEqual, 2914)
+				So(refund.Items[0].RefundMoney, ShouldEqual, 2914)
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_ApplyOrderRefundPieceByPiece(t *testing.T) {
+	Convey("Given a paid order whose item paid money can't be divided evenly by its pieces", t, func() {
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{
+				ID: 1, OrderNo: orderNo, UserId: userId, BillMoney: 7000, PayMoney: 6800,
+				PayType: enum.PayTypeWxPay, PayState: enum.PayStatePaid, OrderStatus: enum.OrderStatusPaid,
+				Items: []*do.OrderItem{
+					{CommodityId: 1, CommoditySellingPrice: 3000, CommodityNum: 2, DiscountMoney: 171, PaidMoney: 5829},
+					{CommodityId: 2, CommoditySellingPrice: 1000, CommodityNum: 1, DiscountMoney: 29, PaidMoney: 971},
+				},
+			}, nil
+		})
+		defer patches.Reset()
+		// 每次申请的退款都当作已经退款成功, 下次申请时计入已退的数量和金额
+		refunds := make([]*model.OrderRefund, 0)
+		refundItems := make(map[int64][]*model.OrderRefundItem)
+		var refundDao *dao.OrderRefundDao
+		patches.ApplyMethod(refundDao, "GetOrderRefunds", func(_ *dao.OrderRefundDao, orderId int64) ([]*model.OrderRefund, error) {
+			return refunds, nil
+		})
+		patches.ApplyMethod(refundDao, "GetMultiRefundsItems", func(_ *dao.OrderRefundDao, refundIds []int64) (map[int64][]*model.OrderRefundItem, error) {
+			return refundItems, nil
+		})
+		var invoiceDao *dao.InvoiceDao
+		patches.ApplyMethod(invoiceDao, "GetOrderInvoices", func(_ *dao.InvoiceDao, orderId int64) ([]*model.OrderInvoice, error) {
+			return nil, nil
+		})
+		var idGenSvc *domainservice.IdGenDomainSvc
+		patches.ApplyMethod(idGenSvc, "GenRefundNo", func(_ *domainservice.IdGenDomainSvc) (string, error) {
+			return "R202410180000000000000000001", nil
+		})
+		patches.ApplyMethod(refundDao, "CreateOrderRefund", func(_ *dao.OrderRefundDao, refund *do.OrderRefund) error {
+			refundId := int64(len(refunds) + 1)
+			refunds = append(refunds, &model.OrderRefund{ID: refundId, OrderId: refund.OrderId, RefundMoney: refund.RefundMoney, RefundState: enum.RefundStateSuccess})
+			for _, item := range refund.Items {
+				refundItems[refundId] = append(refundItems[refundId], &model.OrderRefundItem{
+					RefundId: refundId, OrderId: item.OrderId, CommodityId: item.CommodityId, CommodityNum: item.CommodityNum, RefundMoney: item.RefundMoney,
+				})
+			}
+			return nil
+		})
+
+		Convey("When refund the order one piece at a time", func() {
+			odsSvc := domainservice.NewOrderDomainSvc(context.TODO())
+			refundMoneys := make([]int, 0)
+			for _, commodityId := range []int64{1, 1, 2} {
+				refund, err := odsSvc.ApplyOrderRefund("20240903374062590406950001", 1, "不想要了",
+					[]*do.OrderRefundItem{{CommodityId: commodityId, CommodityNum: 1}})
+				So(err, ShouldBeNil)
+				refundMoneys = append(refundMoneys, refund.RefundMoney)
+			}
+			Convey("Then the last piece of an item should take the cent left over and the order should be fully refunded", func() {
+				So(refundMoneys, ShouldResemble, []int{2914, 2915, 971})
+				_, err := odsSvc.ApplyOrderRefund("20240903374062590406950001", 1, "不想要了", nil)
+				So(errors.Is(err, errcode.ErrOrderRefundNotAllowed), ShouldBeTrue)
+			})
+		})
+	})
+}
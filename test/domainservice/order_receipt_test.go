@@ -0,0 +1,78 @@
+package domainservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestOrderDomainSvc_AutoConfirmDeliveredOrders(t *testing.T) {
+	Convey("Given orders delivered more than the auto confirm days ago", t, func() {
+		autoConfirmDays := config.App.Order.AutoConfirmDays
+		config.App.Order.AutoConfirmDays = 7
+		defer func() { config.App.Order.AutoConfirmDays = autoConfirmDays }()
+
+		var orderDao *dao.OrderDao
+		var queriedBefore time.Time
+		patches := gomonkey.ApplyMethod(orderDao, "GetDeliveredOrdersBefore", func(_ *dao.OrderDao, deliveredBefore time.Time, lastId int64, limit int) ([]*model.Order, error) {
+			queriedBefore = deliveredBefore
+			return []*model.Order{
+				{ID: 1, OrderNo: "20240903374062590406950001", OrderStatus: enum.OrderStatusDelivered},
+				{ID: 2, OrderNo: "20240903374062590406950002", OrderStatus: enum.OrderStatusDelivered},
+			}, nil
+		})
+		defer patches.Reset()
+		statusLogs := make([]*model.OrderStatusLog, 0)
+		patches.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			statusLogs = append(statusLogs, statusLog)
+			// 第二个订单在查询后被用户确认收货了
+			return orderId == 1, nil
+		})
+
+		Convey("When the auto confirm job runs", func() {
+			confirmed, err := domainservice.NewOrderDomainSvc(context.TODO()).AutoConfirmDeliveredOrders(100)
+			Convey("Then only the orders still delivered should be confirmed by the system", func() {
+				So(err, ShouldBeNil)
+				So(confirmed, ShouldEqual, 1)
+				So(queriedBefore, ShouldHappenWithin, time.Minute, time.Now().Add(-7*24*time.Hour))
+				So(statusLogs, ShouldHaveLength, 2)
+				So(statusLogs[0].ToStatus, ShouldEqual, enum.OrderStatusConfirmReceipt)
+				So(statusLogs[0].Actor, ShouldEqual, enum.OrderActorSystem)
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_CompleteConfirmedOrders(t *testing.T) {
+	Convey("Given an order confirmed before the review window", t, func() {
+		var orderDao *dao.OrderDao
+		patches := gomonkey.ApplyMethod(orderDao, "GetConfirmedOrdersBefore", func(_ *dao.OrderDao, confirmedBefore time.Time, lastId int64, limit int) ([]*model.Order, error) {
+			return []*model.Order{{ID: 1, OrderNo: "20240903374062590406950001", OrderStatus: enum.OrderStatusConfirmReceipt}}, nil
+		})
+		defer patches.Reset()
+		var savedLog *model.OrderStatusLog
+		patches.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			savedLog = statusLog
+			return true, nil
+		})
+
+		Convey("When the auto complete job runs", func() {
+			completed, err := domainservice.NewOrderDomainSvc(context.TODO()).CompleteConfirmedOrders(100)
+			Convey("Then the order should be completed", func() {
+				So(err, ShouldBeNil)
+				So(completed, ShouldEqual, 1)
+				So(savedLog.ToStatus, ShouldEqual, enum.OrderStatusCompleted)
+				So(enum.OrderFrontStatus[savedLog.ToStatus], ShouldEqual, "已完成")
+			})
+		})
+	})
+}
@@ -0,0 +1,215 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestOrderDomainSvc_ApproveOrderRefund(t *testing.T) {
+	Convey("Given a pending refund of a wxpay order", t, func() {
+		refund := &model.OrderRefund{ID: 1, RefundNo: "R20240903374062590406950001", OrderNo: "20240903374062590406950001",
+			PayType: enum.PayTypeWxPay, RefundMoney: 100, RefundState: enum.RefundStatePending}
+		var refundDao *dao.OrderRefundDao
+		patches := gomonkey.ApplyMethod(refundDao, "GetOrderRefundByNo", func(_ *dao.OrderRefundDao, refundNo string) (*model.OrderRefund, error) {
+			return refund, nil
+		})
+		defer patches.Reset()
+		patches.ApplyMethod(refundDao, "AuditOrderRefund", func(_ *dao.OrderRefundDao, refundId int64, approved bool, auditRemark string) (bool, error) {
+			return true, nil
+		})
+		settledStates := make([]int, 0)
+		patches.ApplyMethod(refundDao, "SetOrderRefundResult", func(_ *dao.OrderRefundDao, refundId int64, refundState int, refundTransId string, refundedAt time.Time) (bool, error) {
+			settledStates = append(settledStates, refundState)
+			return true, nil
+		})
+		var orderDao *dao.OrderDao
+		patches.ApplyMethod(orderDao, "GetOrderByNo", func(_ *dao.OrderDao, orderNo string) (*model.Order, error) {
+			return &model.Order{ID: 1, OrderNo: orderNo, PayType: enum.PayTypeWxPay, PayMoney: 549700,
+				OrderStatus: enum.OrderStatusPaid}, nil
+		})
+		var wpl *library.WxPayLib
+		var createRefundErr error
+		patches.ApplyMethod(wpl, "CreateRefund", func(_ *library.WxPayLib, outTradeNo, outRefundNo, reason string, refundMoney, totalMoney int) (*library.WxRefundResult, error) {
+			return nil, createRefundErr
+		})
+		odsSvc := domainservice.NewOrderDomainSvc(context.TODO())
+
+		Convey("When the refund request times out", func() {
+			createRefundErr = errors.New("context deadline exceeded")
+			err := odsSvc.ApproveOrderRefund(refund.RefundNo, "")
+			Convey("Then the refund should stay processing", func() {
+				So(err, ShouldBeNil)
+				So(settledStates, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When wxpay returns a system error", func() {
+			createRefundErr = &library.WxPayApiError{HttpStatus: http.StatusInternalServerError, Code: "SYSTEM_ERROR"}
+			err := odsSvc.ApproveOrderRefund(refund.RefundNo, "")
+			Convey("Then the refund should stay processing", func() {
+				So(err, ShouldBeNil)
+				So(settledStates, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When wxpay rejects the refund", func() {
+			createRefundErr = &library.WxPayApiError{HttpStatus: http.StatusForbidden, Code: "NOT_ENOUGH"}
+			err := odsSvc.ApproveOrderRefund(refund.RefundNo, "")
+			Convey("Then the refund should fail", func() {
+				So(err, ShouldNotBeNil)
+				So(settledStates, ShouldResemble, []int{enum.RefundStateFailed})
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_SettleOrderRefund(t *testing.T) {
+	Convey("Given a processing refund of a paid order", t, func() {
+		refund := &model.OrderRefund{ID: 1, RefundNo: "R20240903374062590406950001", OrderNo: "20240903374062590406950001",
+			PayType: enum.PayTypeWxPay, RefundMoney: 100, RefundState: enum.RefundStateProcessing}
+		var refundDao *dao.OrderRefundDao
+		patches := gomonkey.ApplyMethod(refundDao, "GetOrderRefundByNo", func(_ *dao.OrderRefundDao, refundNo string) (*model.OrderRefund, error) {
+			return refund, nil
+		})
+		defer patches.Reset()
+		patches.ApplyMethod(refundDao, "GetOrderRefunds", func(_ *dao.OrderRefundDao, orderId int64) ([]*model.OrderRefund, error) {
+			return []*model.OrderRefund{refund}, nil
+		})
+		patches.ApplyMethod(refundDao, "GetMultiRefundsItems", func(_ *dao.OrderRefundDao, refundIds []int64) (map[int64][]*model.OrderRefundItem, error) {
+			return map[int64][]*model.OrderRefundItem{1: {{RefundId: 1, OrderId: 1, CommodityId: 1, CommodityNum: 1}}}, nil
+		})
+		settled := true
+		patches.ApplyMethod(refundDao, "SetOrderRefundResultInTx", func(_ *dao.OrderRefundDao, tx *gorm.DB, refundId int64, refundState int, refundTransId string, refundedAt time.Time) (bool, error) {
+			return settled, nil
+		})
+		var commodityDao *dao.CommodityDao
+		returnedStock := 0
+		stockErr := error(nil)
+		patches.ApplyMethod(commodityDao, "ReturnSoldStock", func(_ *dao.CommodityDao, tx *gorm.DB, orderItems []*do.OrderItem) error {
+			if stockErr != nil {
+				return stockErr
+			}
+			returnedStock += orderItems[0].CommodityNum
+			return nil
+		})
+		var orderDao *dao.OrderDao
+		patches.ApplyMethod(orderDao, "GetOrderByNo", func(_ *dao.OrderDao, orderNo string) (*model.Order, error) {
+			return &model.Order{ID: 1, OrderNo: orderNo, PayType: enum.PayTypeWxPay, PayMoney: 549700,
+				OrderStatus: enum.OrderStatusPaid}, nil
+		})
+		var toStatus int
+		patches.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			if err := afterTransit(nil); err != nil {
+				return false, err
+			}
+			toStatus = statusLog.ToStatus
+			return true, nil
+		})
+		refundResult := &do.OrderRefundResult{RefundNo: refund.RefundNo, RefundTransId: "50000000382019052709732678859",
+			RefundState: enum.RefundStateSuccess, RefundedAt: time.Now()}
+		odsSvc := domainservice.NewOrderDomainSvc(context.TODO())
+
+		Convey("When the refund succeeds", func() {
+			err := odsSvc.SettleOrderRefund(refundResult)
+			Convey("Then the stock and the order status should change with the refund", func() {
+				So(err, ShouldBeNil)
+				So(returnedStock, ShouldEqual, 1)
+				So(toStatus, ShouldEqual, enum.OrderStatusPartialRefunded)
+			})
+		})
+
+		Convey("When returning the stock fails", func() {
+			stockErr = errors.New("lock wait timeout")
+			err := odsSvc.SettleOrderRefund(refundResult)
+			Convey("Then the order status should not change", func() {
+				So(err, ShouldNotBeNil)
+				So(toStatus, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a concurrent notify has settled the refund", func() {
+			settled = false
+			err := odsSvc.SettleOrderRefund(refundResult)
+			Convey("Then it should be ignored", func() {
+				So(err, ShouldBeNil)
+				So(returnedStock, ShouldEqual, 0)
+				So(toStatus, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_ApplyInvoicedOrderRefund(t *testing.T) {
+	Convey("Given a paid order with an issued invoice", t, func() {
+		var ods *domainservice.OrderDomainSvc
+		payType := enum.PayTypeWxPay
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{ID: 1, OrderNo: orderNo, UserId: userId, PayMoney: 6800, PayType: payType,
+				PayState: enum.PayStatePaid, OrderStatus: enum.OrderStatusPaid,
+				Items: []*do.OrderItem{{CommodityId: 1, CommoditySellingPrice: 3400, CommodityNum: 2, PaidMoney: 6800}}}, nil
+		})
+		defer patches.Reset()
+		invoiceState := enum.InvoiceStateIssued
+		var invoiceDao *dao.InvoiceDao
+		patches.ApplyMethod(invoiceDao, "GetOrderInvoices", func(_ *dao.InvoiceDao, orderId int64) ([]*model.OrderInvoice, error) {
+			return []*model.OrderInvoice{{ID: 1, OrderId: orderId, Amount: 6800, InvoiceState: invoiceState}}, nil
+		})
+		var refundDao *dao.OrderRefundDao
+		patches.ApplyMethod(refundDao, "GetOrderRefunds", func(_ *dao.OrderRefundDao, orderId int64) ([]*model.OrderRefund, error) {
+			return nil, nil
+		})
+		patches.ApplyMethod(refundDao, "GetMultiRefundsItems", func(_ *dao.OrderRefundDao, refundIds []int64) (map[int64][]*model.OrderRefundItem, error) {
+			return map[int64][]*model.OrderRefundItem{}, nil
+		})
+		var idGenSvc *domainservice.IdGenDomainSvc
+		patches.ApplyMethod(idGenSvc, "GenRefundNo", func(_ *domainservice.IdGenDomainSvc) (string, error) {
+			return "R202410180000000000000000001", nil
+		})
+		created := false
+		patches.ApplyMethod(refundDao, "CreateOrderRefund", func(_ *dao.OrderRefundDao, refund *do.OrderRefund) error {
+			created = true
+			return nil
+		})
+		odsSvc := domainservice.NewOrderDomainSvc(context.TODO())
+
+		Convey("When apply refund before the invoice is red flushed", func() {
+			_, err := odsSvc.ApplyOrderRefund("20240903374062590406950001", 1, "不想要了", nil)
+			Convey("Then it should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderRefundNotAllowed), ShouldBeTrue)
+				So(created, ShouldBeFalse)
+			})
+		})
+
+		Convey("When apply refund after the invoice is red flushed", func() {
+			invoiceState = enum.InvoiceStateRedFlushed
+			_, err := odsSvc.ApplyOrderRefund("20240903374062590406950001", 1, "不想要了", nil)
+			Convey("Then the refund should be created", func() {
+				So(err, ShouldBeNil)
+				So(created, ShouldBeTrue)
+			})
+		})
+
+		Convey("When apply refund for an order paid by alipay", func() {
+			invoiceState, payType = enum.InvoiceStateRedFlushed, enum.PayTypeAliPay
+			_, err := odsSvc.ApplyOrderRefund("20240903374062590406950001", 1, "不想要了", nil)
+			Convey("Then it should be rejected because the refund could not be approved", func() {
+				So(errors.Is(err, errcode.ErrOrderRefundNotAllowed), ShouldBeTrue)
+				So(created, ShouldBeFalse)
+			})
+		})
+	})
+}
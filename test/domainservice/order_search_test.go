@@ -0,0 +1,84 @@
+package domainservice
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/api/request"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/appservice"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderDomainSvc_ExportOrders(t *testing.T) {
+	Convey("Given 250 orders matching the query", t, func() {
+		var orderDao *dao.OrderDao
+		lastIds := make([]int64, 0)
+		patches := gomonkey.ApplyMethod(orderDao, "ScanOrders", func(_ *dao.OrderDao, query *do.OrderQuery, lastId int64, limit int) ([]*model.Order, error) {
+			lastIds = append(lastIds, lastId)
+			orders := make([]*model.Order, 0, limit)
+			for id := lastId + 1; id <= 250 && len(orders) < limit; id++ {
+				orders = append(orders, &model.Order{ID: id, UserId: query.UserId})
+			}
+			return orders, nil
+		})
+		defer patches.Reset()
+		patches.ApplyMethod(orderDao, "GetMultiOrdersAddress", func(_ *dao.OrderDao, orderIds []int64) (map[int64]*model.OrderAddress, error) {
+			addresses := make(map[int64]*model.OrderAddress, len(orderIds))
+			for _, orderId := range orderIds {
+				addresses[orderId] = &model.OrderAddress{OrderId: orderId, UserPhone: "13800138000"}
+			}
+			return addresses, nil
+		})
+		patches.ApplyMethod(orderDao, "GetMultiOrdersItems", func(_ *dao.OrderDao, orderIds []int64) (map[int64][]*model.OrderItem, error) {
+			return map[int64][]*model.OrderItem{}, nil
+		})
+
+		Convey("When export the orders", func() {
+			batchSizes := make([]int, 0)
+			exported := make([]*do.Order, 0)
+			err := domainservice.NewOrderDomainSvc(context.TODO()).ExportOrders(&do.OrderQuery{UserId: 1}, func(orders []*do.Order) error {
+				batchSizes = append(batchSizes, len(orders))
+				exported = append(exported, orders...)
+				return nil
+			})
+			Convey("Then the orders should be handled batch by batch with their addresses", func() {
+				So(err, ShouldBeNil)
+				So(batchSizes, ShouldResemble, []int{200, 50})
+				So(lastIds, ShouldResemble, []int64{0, 200})
+				So(exported[249].ID, ShouldEqual, 250)
+				So(exported[249].Address.UserPhone, ShouldEqual, "13800138000")
+			})
+		})
+	})
+}
+
+func TestOrderAppSvc_ExportOrdersCsv(t *testing.T) {
+	Convey("Given an order whose commodity name and address look like formulas", t, func() {
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "ExportOrders", func(_ *domainservice.OrderDomainSvc, query *do.OrderQuery, handle func(orders []*do.Order) error) error {
+			order := &do.Order{ID: 1, OrderNo: "20240903374062590406950001", UserId: 1,
+				Address: &do.OrderAddress{UserName: "@SUM(1+1)", ProvinceName: "=HYPERLINK(\"http://evil.example\")", DetailAddress: "1号楼"},
+				Items:   []*do.OrderItem{{CommodityId: 1, CommodityName: "+1-2", CommodityNum: 1}},
+			}
+			return handle([]*do.Order{order})
+		})
+		defer patches.Reset()
+
+		Convey("When export the orders to csv", func() {
+			buf := new(bytes.Buffer)
+			err := appservice.NewOrderAppSvc(context.TODO()).ExportOrdersCsv(&request.AdminOrderQuery{}, buf)
+			Convey("Then the formula-like cells should be exported as text", func() {
+				So(err, ShouldBeNil)
+				So(buf.String(), ShouldContainSubstring, "'@SUM(1+1)")
+				So(buf.String(), ShouldContainSubstring, `"'=HYPERLINK(""http://evil.example"")1号楼"`)
+				So(buf.String(), ShouldContainSubstring, "'+1-2")
+			})
+		})
+	})
+}
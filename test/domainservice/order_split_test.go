@@ -0,0 +1,105 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestOrderStateMachine_FireSplitOrder(t *testing.T) {
+	Convey("Given a split order", t, func() {
+		var orderDao *dao.OrderDao
+		transitedOrderIds := make([]int64, 0)
+		patches := gomonkey.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			transitedOrderIds = append(transitedOrderIds, orderId)
+			return true, nil
+		})
+		defer patches.Reset()
+		transitedParentIds := make([]int64, 0)
+		patches.ApplyMethod(orderDao, "TransitParentOrderStatus", func(_ *dao.OrderDao, parentId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			if afterTransit != nil {
+				if err := afterTransit(nil); err != nil {
+					return false, err
+				}
+			}
+			transitedParentIds = append(transitedParentIds, parentId)
+			return true, nil
+		})
+		// 拆单的订单按父订单预占和扣减库存
+		var commodityDao *dao.CommodityDao
+		stockOrderIds := make([]int64, 0)
+		patches.ApplyMethod(commodityDao, "CommitOrderStock", func(_ *dao.CommodityDao, tx *gorm.DB, orderId int64) error {
+			stockOrderIds = append(stockOrderIds, orderId)
+			return nil
+		})
+		stateMachine := domainservice.NewOrderStateMachine(context.TODO())
+		parentOrder := &model.Order{ID: 1, OrderNo: "202410180000000000000000001", OrderType: enum.OrderTypeParent, OrderStatus: enum.OrderStatusUnPaid}
+		subOrder := &model.Order{ID: 2, OrderNo: "202410180000000000000000002", OrderType: enum.OrderTypeSub, ParentId: 1, OrderStatus: enum.OrderStatusUnPaid}
+
+		Convey("When the parent order is paid", func() {
+			transited, err := stateMachine.Fire(parentOrder, &domainservice.OrderStatusChange{Event: enum.OrderEventPaySuccess, Actor: enum.OrderActorPayment})
+			Convey("Then the parent order and its sub orders should be changed together", func() {
+				So(err, ShouldBeNil)
+				So(transited, ShouldBeTrue)
+				So(transitedParentIds, ShouldResemble, []int64{1})
+				So(transitedOrderIds, ShouldBeEmpty)
+				So(stockOrderIds, ShouldResemble, []int64{1})
+			})
+		})
+
+		Convey("When a sub order is cancelled alone", func() {
+			_, err := stateMachine.Fire(subOrder, &domainservice.OrderStatusChange{Event: enum.OrderEventUserCancel, Actor: enum.OrderActorUser})
+			Convey("Then it should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderCanNotBeChanged), ShouldBeTrue)
+				So(transitedOrderIds, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the paid parent order is shipped", func() {
+			parentOrder.OrderStatus = enum.OrderStatusPaid
+			_, err := stateMachine.Fire(parentOrder, &domainservice.OrderStatusChange{Event: enum.OrderEventShip, Actor: enum.OrderActorMerchant})
+			Convey("Then it should be rejected, sub orders are shipped separately", func() {
+				So(errors.Is(err, errcode.ErrOrderCanNotBeChanged), ShouldBeTrue)
+				So(transitedParentIds, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the paid sub order is shipped", func() {
+			subOrder.OrderStatus = enum.OrderStatusPaid
+			transited, err := stateMachine.Fire(subOrder, &domainservice.OrderStatusChange{Event: enum.OrderEventShip, Actor: enum.OrderActorMerchant})
+			Convey("Then only the sub order should be changed", func() {
+				So(err, ShouldBeNil)
+				So(transited, ShouldBeTrue)
+				So(transitedOrderIds, ShouldResemble, []int64{2})
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_ApplyParentOrderRefund(t *testing.T) {
+	Convey("Given a paid parent order", t, func() {
+		var ods *domainservice.OrderDomainSvc
+		patches := gomonkey.ApplyMethod(ods, "GetSpecifiedUserOrder", func(_ *domainservice.OrderDomainSvc, orderNo string, userId int64) (*do.Order, error) {
+			return &do.Order{ID: 1, OrderNo: orderNo, UserId: userId, OrderType: enum.OrderTypeParent,
+				PayState: enum.PayStatePaid, OrderStatus: enum.OrderStatusPaid, PayMoney: 6800}, nil
+		})
+		defer patches.Reset()
+
+		Convey("When apply refund for the parent order", func() {
+			_, err := domainservice.NewOrderDomainSvc(context.TODO()).ApplyOrderRefund("202410180000000000000000001", 1, "不想要了", nil)
+			Convey("Then it should be rejected, refunds are applied for sub orders", func() {
+				So(errors.Is(err, errcode.ErrOrderRefundNotAllowed), ShouldBeTrue)
+			})
+		})
+	})
+}
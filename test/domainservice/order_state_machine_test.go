@@ -0,0 +1,151 @@
+package domainservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/common/errcode"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+	"gorm.io/gorm"
+)
+
+func TestOrderStateMachine_Fire(t *testing.T) {
+	Convey("Given an unpaid order", t, func() {
+		order := &model.Order{ID: 1, OrderNo: "20240903374062590406950001", OrderStatus: enum.OrderStatusUnPaid}
+		var orderDao *dao.OrderDao
+		var savedLog *model.OrderStatusLog
+		patches := gomonkey.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			// 变更的 Hooks 在变更订单状态的事务里执行, 执行失败时状态变更回滚
+			if afterTransit != nil {
+				if err := afterTransit(nil); err != nil {
+					return false, err
+				}
+			}
+			savedLog = statusLog
+			return true, nil
+		})
+		defer patches.Reset()
+		var releasedOrderId int64
+		var commodityDao *dao.CommodityDao
+		patches.ApplyMethod(commodityDao, "ReleaseOrderStock", func(_ *dao.CommodityDao, tx *gorm.DB, orderId int64) error {
+			releasedOrderId = orderId
+			return nil
+		})
+		var committedOrderId int64
+		patches.ApplyMethod(commodityDao, "CommitOrderStock", func(_ *dao.CommodityDao, tx *gorm.DB, orderId int64) error {
+			committedOrderId = orderId
+			return nil
+		})
+		sm := domainservice.NewOrderStateMachine(context.TODO())
+
+		Convey("When the user cancels the order", func() {
+			transited, err := sm.Fire(order, &domainservice.OrderStatusChange{
+				Event: enum.OrderEventUserCancel, Actor: enum.OrderActorUser, ActorId: 1,
+			})
+			Convey("Then the transition should be logged and the reserved stock released", func() {
+				So(err, ShouldBeNil)
+				So(transited, ShouldBeTrue)
+				So(savedLog.FromStatus, ShouldEqual, enum.OrderStatusUnPaid)
+				So(savedLog.ToStatus, ShouldEqual, enum.OrderStatusUserQuit)
+				So(savedLog.Actor, ShouldEqual, enum.OrderActorUser)
+				So(savedLog.ActorId, ShouldEqual, 1)
+				So(releasedOrderId, ShouldEqual, order.ID)
+			})
+		})
+
+		Convey("When the payment succeeds", func() {
+			transited, err := sm.Fire(order, &domainservice.OrderStatusChange{
+				Event: enum.OrderEventPaySuccess, Actor: enum.OrderActorPayment,
+			})
+			Convey("Then the reserved stock should be committed as sold", func() {
+				So(err, ShouldBeNil)
+				So(transited, ShouldBeTrue)
+				So(savedLog.ToStatus, ShouldEqual, enum.OrderStatusPaid)
+				So(committedOrderId, ShouldEqual, order.ID)
+				So(releasedOrderId, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When releasing the reserved stock fails after the order is closed", func() {
+			patches.ApplyMethod(commodityDao, "ReleaseOrderStock", func(_ *dao.CommodityDao, tx *gorm.DB, orderId int64) error {
+				return errors.New("lock wait timeout")
+			})
+			transited, err := sm.Fire(order, &domainservice.OrderStatusChange{
+				Event: enum.OrderEventPayTimeout, Actor: enum.OrderActorSystem,
+			})
+			Convey("Then the order should stay unpaid so that a retry closes it again", func() {
+				So(err, ShouldNotBeNil)
+				So(transited, ShouldBeFalse)
+				So(savedLog, ShouldBeNil)
+			})
+		})
+
+		Convey("When the merchant tries to ship the unpaid order", func() {
+			transited, err := sm.Fire(order, &domainservice.OrderStatusChange{
+				Event: enum.OrderEventShip, Actor: enum.OrderActorMerchant,
+			})
+			Convey("Then the transition should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderCanNotBeChanged), ShouldBeTrue)
+				So(transited, ShouldBeFalse)
+				So(savedLog, ShouldBeNil)
+			})
+		})
+
+		Convey("When the user tries to close the order as the system", func() {
+			transited, err := sm.Fire(order, &domainservice.OrderStatusChange{
+				Event: enum.OrderEventPayTimeout, Actor: enum.OrderActorUser,
+			})
+			Convey("Then the transition should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderCanNotBeChanged), ShouldBeTrue)
+				So(transited, ShouldBeFalse)
+				So(savedLog, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestOrderStateMachine_FirePartialRefundedOrder(t *testing.T) {
+	Convey("Given a partially refunded order that has been shipped", t, func() {
+		emptyTime := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.Local)
+		order := &model.Order{ID: 1, OrderNo: "20240903374062590406950001", OrderStatus: enum.OrderStatusPartialRefunded,
+			ShippedAt: time.Now().Add(-time.Hour), DeliveredAt: emptyTime, ConfirmedAt: emptyTime}
+		var orderDao *dao.OrderDao
+		var savedLog *model.OrderStatusLog
+		patches := gomonkey.ApplyMethod(orderDao, "TransitOrderStatus", func(_ *dao.OrderDao, orderId int64, updates map[string]interface{}, statusLog *model.OrderStatusLog, afterTransit func(tx *gorm.DB) error) (bool, error) {
+			savedLog = statusLog
+			return true, nil
+		})
+		defer patches.Reset()
+		sm := domainservice.NewOrderStateMachine(context.TODO())
+
+		Convey("When the order is delivered", func() {
+			transited, err := sm.Fire(order, &domainservice.OrderStatusChange{
+				Event: enum.OrderEventDeliver, Actor: enum.OrderActorMerchant,
+			})
+			Convey("Then the fulfilment should go on from where the order was", func() {
+				So(err, ShouldBeNil)
+				So(transited, ShouldBeTrue)
+				So(savedLog.FromStatus, ShouldEqual, enum.OrderStatusPartialRefunded)
+				So(savedLog.ToStatus, ShouldEqual, enum.OrderStatusDelivered)
+			})
+		})
+
+		Convey("When the merchant tries to ship the order again", func() {
+			transited, err := sm.Fire(order, &domainservice.OrderStatusChange{
+				Event: enum.OrderEventShip, Actor: enum.OrderActorMerchant,
+			})
+			Convey("Then the transition should be rejected", func() {
+				So(errors.Is(err, errcode.ErrOrderCanNotBeChanged), ShouldBeTrue)
+				So(transited, ShouldBeFalse)
+				So(savedLog, ShouldBeNil)
+			})
+		})
+	})
+}
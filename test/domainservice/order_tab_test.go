@@ -0,0 +1,76 @@
+package domainservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderDomainSvc_CountUserOrdersByFrontStatus(t *testing.T) {
+	Convey("Given the order counts of a user grouped by order status", t, func() {
+		var orderDao *dao.OrderDao
+		patches := gomonkey.ApplyMethod(orderDao, "CountUserOrdersByStatus", func(_ *dao.OrderDao, userId int64) (map[int]int64, error) {
+			return map[int]int64{
+				enum.OrderStatusCreated:    1,
+				enum.OrderStatusUnPaid:     2,
+				enum.OrderStatusShipped:    1,
+				enum.OrderStatusOnDelivery: 3,
+				enum.OrderStatusCompleted:  5,
+			}, nil
+		})
+		defer patches.Reset()
+
+		Convey("When count the orders by front status", func() {
+			counts, err := domainservice.NewOrderDomainSvc(context.TODO()).CountUserOrdersByFrontStatus(1)
+			Convey("Then order statuses sharing a front status should be summed up", func() {
+				So(err, ShouldBeNil)
+				So(counts["待付款"], ShouldEqual, 3)
+				So(counts["待发货"], ShouldEqual, 0)
+				So(counts["待收货"], ShouldEqual, 4)
+				So(counts["已完成"], ShouldEqual, 5)
+			})
+		})
+	})
+}
+
+func TestOrderDomainSvc_CountPartialRefundedOrdersByFrontStatus(t *testing.T) {
+	Convey("Given a user with partially refunded orders still being fulfilled", t, func() {
+		var orderDao *dao.OrderDao
+		patches := gomonkey.ApplyMethod(orderDao, "CountUserOrdersByStatus", func(_ *dao.OrderDao, userId int64) (map[int]int64, error) {
+			return map[int]int64{
+				enum.OrderStatusPaid:            1,
+				enum.OrderStatusPartialRefunded: 3,
+			}, nil
+		})
+		defer patches.Reset()
+		emptyTime := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.Local)
+		patches.ApplyMethod(orderDao, "GetUserPartialRefundedOrders", func(_ *dao.OrderDao, userId int64) ([]*model.Order, error) {
+			return []*model.Order{
+				// 还没发货
+				{ID: 1, OrderStatus: enum.OrderStatusPartialRefunded, ShippedAt: emptyTime, DeliveredAt: emptyTime, ConfirmedAt: emptyTime},
+				// 已经发货
+				{ID: 2, OrderStatus: enum.OrderStatusPartialRefunded, ShippedAt: time.Now(), DeliveredAt: emptyTime, ConfirmedAt: emptyTime},
+				// 已经确认收货
+				{ID: 3, OrderStatus: enum.OrderStatusPartialRefunded, ShippedAt: time.Now(), DeliveredAt: time.Now(), ConfirmedAt: time.Now()},
+			}, nil
+		})
+
+		Convey("When count the orders by front status", func() {
+			counts, err := domainservice.NewOrderDomainSvc(context.TODO()).CountUserOrdersByFrontStatus(1)
+			Convey("Then the partially refunded orders should also be counted in the tabs of their fulfilment progress", func() {
+				So(err, ShouldBeNil)
+				So(counts["部分退款"], ShouldEqual, 3)
+				So(counts["待发货"], ShouldEqual, 2)
+				So(counts["待收货"], ShouldEqual, 1)
+				So(counts["待评价"], ShouldEqual, 1)
+			})
+		})
+	})
+}
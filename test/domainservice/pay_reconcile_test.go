@@ -0,0 +1,67 @@
+package domainservice
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/dal/model"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOrderDomainSvc_ReconcileWxTradeBill(t *testing.T) {
+	Convey("Given a recorded WeChat trade bill and local paid orders", t, func() {
+		// 账单里有两笔支付成功的交易: ...0001 金额 5497.00, ...0002 金额 99.90
+		billData, err := os.ReadFile("../library/testdata/wxpay_tradebill_20240903.csv")
+		So(err, ShouldBeNil)
+		var wxPayLib *library.WxPayLib
+		patches := gomonkey.ApplyMethod(wxPayLib, "DownloadTradeBill", func(_ *library.WxPayLib, billDate time.Time) ([]*library.WxTradeBillRecord, error) {
+			return library.ParseTradeBill(billData)
+		})
+		defer patches.Reset()
+
+		var orderDao *dao.OrderDao
+		// 账单日期内本地支付成功的订单: ...0001 金额不一致, ...0003 账单中没有
+		patches.ApplyMethod(orderDao, "GetPaidOrdersBetween", func(_ *dao.OrderDao, payType int, start, end time.Time) ([]*model.Order, error) {
+			return []*model.Order{
+				{OrderNo: "20240903374062590406950001", PayTransId: "4200000000202409031234567890", PayType: enum.PayTypeWxPay, PayState: enum.PayStatePaid, PayMoney: 549900},
+				{OrderNo: "20240903100000000000000003", PayTransId: "4200000000202409031234567892", PayType: enum.PayTypeWxPay, PayState: enum.PayStatePaid, PayMoney: 1000},
+			}, nil
+		})
+		// ...0002 的支付结果第二天才收到, 支付时间不在账单日期内
+		patches.ApplyMethod(orderDao, "GetOrdersByNos", func(_ *dao.OrderDao, orderNos []string) ([]*model.Order, error) {
+			return []*model.Order{
+				{OrderNo: "20240903100000000000000002", PayTransId: "4200000000202409031234567891", PayType: enum.PayTypeWxPay, PayState: enum.PayStatePaid, PayMoney: 9990},
+			}, nil
+		})
+		var savedDiffs []*do.PayReconcileDiff
+		var reconcileDao *dao.PayReconcileDao
+		patches.ApplyMethod(reconcileDao, "ReplaceReconcileDiffs", func(_ *dao.PayReconcileDao, billDate string, payType int, diffs []*do.PayReconcileDiff) error {
+			savedDiffs = diffs
+			return nil
+		})
+
+		Convey("When reconcile the bill of 2024-09-03", func() {
+			orderDomainSvc := domainservice.NewOrderDomainSvc(context.TODO())
+			diffs, err := orderDomainSvc.ReconcileWxTradeBill(time.Date(2024, 9, 3, 0, 0, 0, 0, time.Local))
+			Convey("Then the amount mismatch and the order missing remotely should be saved", func() {
+				So(err, ShouldBeNil)
+				So(savedDiffs, ShouldResemble, diffs)
+				So(diffs, ShouldHaveLength, 2)
+				So(diffs[0].OrderNo, ShouldEqual, "20240903374062590406950001")
+				So(diffs[0].DiffType, ShouldEqual, enum.ReconcileDiffMoneyMismatch)
+				So(diffs[0].LocalPayMoney, ShouldEqual, 549900)
+				So(diffs[0].RemotePayMoney, ShouldEqual, 549700)
+				So(diffs[1].OrderNo, ShouldEqual, "20240903100000000000000003")
+				So(diffs[1].DiffType, ShouldEqual, enum.ReconcileDiffMissingRemote)
+			})
+		})
+	})
+}
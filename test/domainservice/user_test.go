@@ -6,11 +6,14 @@ import (
 
 	"github.com/WoWBytePaladin/go-mall/common/errcode"
 	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/config"
+	"github.com/WoWBytePaladin/go-mall/dal/cache"
 	"github.com/WoWBytePaladin/go-mall/dal/dao"
 	"github.com/WoWBytePaladin/go-mall/dal/model"
 	"github.com/WoWBytePaladin/go-mall/logic/do"
 	"github.com/WoWBytePaladin/go-mall/logic/domainservice"
 	"github.com/agiledragon/gomonkey/v2"
+	"github.com/redis/go-redis/v9"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"testing"
@@ -18,6 +21,8 @@ import (
 )
 
 func TestMain(m *testing.M) {
+	// 单测不连接redis, 用测试配置创建客户端; 用到redis的单测给 cache 包的函数打桩
+	cache.SetRedisClient(redis.NewClient(&redis.Options{Addr: config.Redis.Addr, DB: config.Redis.DB}))
 	// convey在TestMain下的入口
 	SuppressConsoleStatistics()
 	result := m.Run()
@@ -0,0 +1,59 @@
+package dao
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	dao2 "github.com/WoWBytePaladin/go-mall/dal/dao"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	lockInvoiceOrderSql   = "SELECT `id` FROM `orders` WHERE id = ? AND `orders`.`is_del` = ? FOR UPDATE"
+	countActiveInvoiceSql = "SELECT count(*) FROM `order_invoices` WHERE order_id = ? AND invoice_state <> ?"
+)
+
+func TestInvoiceDao_CreateOrderInvoice(t *testing.T) {
+	var orderId int64 = 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(lockInvoiceOrderSql)).
+		WithArgs(orderId, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderId))
+	mock.ExpectQuery(regexp.QuoteMeta(countActiveInvoiceSql)).
+		WithArgs(orderId, enum.InvoiceStateRedFlushed).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `order_invoices`")).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+
+	invoice := &do.OrderInvoice{InvoiceNo: "I202410180000000000000000001", OrderId: orderId, Amount: 6800,
+		InvoiceState: enum.InvoiceStateRequested}
+	created, err := dao2.NewInvoiceDao(context.TODO()).CreateOrderInvoice(invoice)
+	assert.Nil(t, err)
+	assert.True(t, created)
+	assert.Equal(t, int64(5), invoice.ID)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestInvoiceDao_CreateOrderInvoiceWithActiveInvoice(t *testing.T) {
+	var orderId int64 = 1
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(lockInvoiceOrderSql)).
+		WithArgs(orderId, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(orderId))
+	// 锁住订单后发现并发的申请已经创建了发票, 不再创建
+	mock.ExpectQuery(regexp.QuoteMeta(countActiveInvoiceSql)).
+		WithArgs(orderId, enum.InvoiceStateRedFlushed).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectCommit()
+
+	created, err := dao2.NewInvoiceDao(context.TODO()).CreateOrderInvoice(&do.OrderInvoice{
+		InvoiceNo: "I202410180000000000000000002", OrderId: orderId, Amount: 6800, InvoiceState: enum.InvoiceStateRequested})
+	assert.Nil(t, err)
+	assert.False(t, created)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
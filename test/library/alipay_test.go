@@ -0,0 +1,246 @@
+package library
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+)
+
+// aliPayTestKeys 测试用的应用密钥和"支付宝"密钥, 每次测试时临时生成
+type aliPayTestKeys struct {
+	appPrivateKey    *rsa.PrivateKey
+	alipayPrivateKey *rsa.PrivateKey
+	alipayPublicPem  []byte
+}
+
+// PrivateKey 测试密钥直接作为应用私钥的提供者
+func (keys *aliPayTestKeys) PrivateKey(appId string) (*rsa.PrivateKey, error) {
+	return keys.appPrivateKey, nil
+}
+
+func newAliPayTestKeys(t *testing.T) *aliPayTestKeys {
+	appKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	alipayKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	alipayPubBytes, _ := x509.MarshalPKIXPublicKey(&alipayKey.PublicKey)
+	return &aliPayTestKeys{
+		appPrivateKey:    appKey,
+		alipayPrivateKey: alipayKey,
+		alipayPublicPem:  pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: alipayPubBytes}),
+	}
+}
+
+// 按支付宝的规则生成待签名字符串
+func aliPayTestSignContent(params url.Values, excludeKeys ...string) string {
+	keys := make([]string, 0)
+	for key := range params {
+		excluded := false
+		for _, excludeKey := range excludeKeys {
+			excluded = excluded || key == excludeKey
+		}
+		if !excluded && params.Get(key) != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+params.Get(key))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func aliPayTestSign(key *rsa.PrivateKey, content string) string {
+	signBytes, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, util.SHA256HashBytes(content))
+	return base64.StdEncoding.EncodeToString(signBytes)
+}
+
+func aliPayTestVerify(key *rsa.PublicKey, content, sign string) error {
+	signBytes, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return err
+	}
+	return rsa.VerifyPKCS1v15(key, crypto.SHA256, util.SHA256HashBytes(content), signBytes)
+}
+
+// newAliPayGatewayStandIn 本地的支付宝网关替身, 验证请求签名并对交易查询返回签过名的应答
+func newAliPayGatewayStandIn(t *testing.T, keys *aliPayTestKeys, tradeStatus string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := aliPayTestVerify(&keys.appPrivateKey.PublicKey, aliPayTestSignContent(r.Form, "sign"), r.Form.Get("sign")); err != nil {
+			t.Errorf("gateway verify request sign error: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Form.Get("method") {
+		case "alipay.trade.query":
+			bizContent := make(map[string]string)
+			json.Unmarshal([]byte(r.Form.Get("biz_content")), &bizContent)
+			content := fmt.Sprintf(`{"code":"10000","msg":"Success","trade_no":"2024090322001400000000000001","out_trade_no":"%s","trade_status":"%s","total_amount":"5497.00","send_pay_date":"2024-09-03 10:33:40"}`,
+				bizContent["out_trade_no"], tradeStatus)
+			fmt.Fprintf(w, `{"alipay_trade_query_response":%s,"sign":"%s"}`, content, aliPayTestSign(keys.alipayPrivateKey, content))
+		case "alipay.trade.close":
+			bizContent := make(map[string]string)
+			json.Unmarshal([]byte(r.Form.Get("biz_content")), &bizContent)
+			content := fmt.Sprintf(`{"code":"10000","msg":"Success","trade_no":"2024090322001400000000000001","out_trade_no":"%s"}`,
+				bizContent["out_trade_no"])
+			if tradeStatus == "" {
+				// 用户还没有付款时支付宝那边没有交易
+				content = `{"code":"40004","msg":"Business Failed","sub_code":"ACQ.TRADE_NOT_EXIST","sub_msg":"交易不存在"}`
+			}
+			fmt.Fprintf(w, `{"alipay_trade_close_response":%s,"sign":"%s"}`, content, aliPayTestSign(keys.alipayPrivateKey, content))
+		default:
+			// 网页支付的跳转请求, 验签通过就返回收银台页面
+			w.Write([]byte("<html>cashier</html>"))
+		}
+	}))
+}
+
+func newAliPayTestOrder() *do.Order {
+	return &do.Order{
+		OrderNo:  "20240903374062590406950001",
+		UserId:   1,
+		PayMoney: 549700,
+		Items: []*do.OrderItem{
+			{CommodityId: 2, CommodityName: "Apple iPhone 11 (A2223)", CommodityNum: 1},
+		},
+	}
+}
+
+func TestAliPayLib_CreatePagePay(t *testing.T) {
+	keys := newAliPayTestKeys(t)
+	gateway := newAliPayGatewayStandIn(t, keys, "")
+	defer gateway.Close()
+	aliPayLib := library.NewAliPayLib(context.TODO(), library.AliPayConfig{
+		AppId:           "2021000000000001",
+		KeyProvider:     keys,
+		AliPayPublicKey: keys.alipayPublicPem,
+		GatewayUrl:      gateway.URL,
+		NotifyUrl:       "https://go-mall.com/order/alipay-notify",
+		ReturnUrl:       "https://go-mall.com/order/paid",
+	})
+
+	order := newAliPayTestOrder()
+	order.PayDeadline = time.Date(2024, 9, 3, 11, 3, 40, 0, time.Local)
+	payInfo, err := aliPayLib.CreatePagePay(order)
+	assert.Nil(t, err)
+	payUrl, err := url.Parse(payInfo.PayUrl)
+	assert.Nil(t, err)
+	assert.Equal(t, "alipay.trade.page.pay", payUrl.Query().Get("method"))
+	assert.Contains(t, payUrl.Query().Get("biz_content"), `"total_amount":"5497.00"`)
+	assert.Contains(t, payUrl.Query().Get("biz_content"), `"product_code":"FAST_INSTANT_TRADE_PAY"`)
+	// 交易和订单的支付截止时间同时到期
+	assert.Contains(t, payUrl.Query().Get("biz_content"), `"time_expire":"2024-09-03 11:03:40"`)
+	// 用跳转地址访问网关替身, 签名正确才能打开收银台
+	resp, err := http.Get(payInfo.PayUrl)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	appPayInfo, err := aliPayLib.CreateAppPay(newAliPayTestOrder())
+	assert.Nil(t, err)
+	orderParams, _ := url.ParseQuery(appPayInfo.OrderString)
+	assert.Equal(t, "alipay.trade.app.pay", orderParams.Get("method"))
+	assert.Empty(t, orderParams.Get("return_url"))
+	assert.Nil(t, aliPayTestVerify(&keys.appPrivateKey.PublicKey, aliPayTestSignContent(orderParams, "sign"), orderParams.Get("sign")))
+}
+
+func TestAliPayLib_QueryTrade(t *testing.T) {
+	gock.EnableNetworking() // 让 httptool 的请求能发到本地的网关替身
+	defer gock.DisableNetworking()
+	keys := newAliPayTestKeys(t)
+	gateway := newAliPayGatewayStandIn(t, keys, library.AliTradeStatusSuccess)
+	defer gateway.Close()
+	config := library.AliPayConfig{
+		AppId:           "2021000000000001",
+		KeyProvider:     keys,
+		AliPayPublicKey: keys.alipayPublicPem,
+		GatewayUrl:      gateway.URL,
+	}
+
+	queryResult, err := library.NewAliPayLib(context.TODO(), config).QueryTrade("20240903374062590406950001")
+	assert.Nil(t, err)
+	assert.Equal(t, library.AliTradeStatusSuccess, queryResult.TradeStatus)
+	assert.Equal(t, "20240903374062590406950001", queryResult.OutTradeNo)
+	payMoney, _ := util.YuanToFen(queryResult.TotalAmount)
+	assert.Equal(t, 549700, payMoney)
+
+	// 应答的签名对不上时不能信任应答
+	otherKeys := newAliPayTestKeys(t)
+	config.AliPayPublicKey = otherKeys.alipayPublicPem
+	_, err = library.NewAliPayLib(context.TODO(), config).QueryTrade("20240903374062590406950001")
+	assert.NotNil(t, err)
+}
+
+func TestAliPayLib_CloseTrade(t *testing.T) {
+	gock.EnableNetworking()
+	defer gock.DisableNetworking()
+	keys := newAliPayTestKeys(t)
+	config := library.AliPayConfig{
+		AppId:           "2021000000000001",
+		KeyProvider:     keys,
+		AliPayPublicKey: keys.alipayPublicPem,
+	}
+
+	gateway := newAliPayGatewayStandIn(t, keys, library.AliTradeStatusWaitBuyerPay)
+	defer gateway.Close()
+	config.GatewayUrl = gateway.URL
+	assert.Nil(t, library.NewAliPayLib(context.TODO(), config).CloseTrade("20240903374062590406950001"))
+
+	// 用户还没有付款, 支付宝那边没有交易时也算关闭成功
+	notExistGateway := newAliPayGatewayStandIn(t, keys, "")
+	defer notExistGateway.Close()
+	config.GatewayUrl = notExistGateway.URL
+	assert.Nil(t, library.NewAliPayLib(context.TODO(), config).CloseTrade("20240903374062590406950001"))
+}
+
+func TestAliPayLib_VerifyNotify(t *testing.T) {
+	keys := newAliPayTestKeys(t)
+	aliPayLib := library.NewAliPayLib(context.TODO(), library.AliPayConfig{
+		AppId:           "2021000000000001",
+		KeyProvider:     keys,
+		AliPayPublicKey: keys.alipayPublicPem,
+	})
+	form := url.Values{}
+	form.Set("app_id", "2021000000000001")
+	form.Set("notify_type", "trade_status_sync")
+	form.Set("trade_no", "2024090322001400000000000001")
+	form.Set("out_trade_no", "20240903374062590406950001")
+	form.Set("trade_status", library.AliTradeStatusSuccess)
+	form.Set("total_amount", "5497.00")
+	form.Set("gmt_payment", "2024-09-03 10:33:40")
+	form.Set("sign_type", "RSA2")
+	form.Set("sign", aliPayTestSign(keys.alipayPrivateKey, aliPayTestSignContent(form, "sign", "sign_type")))
+
+	notifyData, err := aliPayLib.VerifyNotify(form)
+	assert.Nil(t, err)
+	assert.Equal(t, "20240903374062590406950001", notifyData.OutTradeNo)
+	assert.Equal(t, "5497.00", notifyData.TotalAmount)
+
+	// 通知内容被篡改后验签失败
+	form.Set("total_amount", "0.01")
+	_, err = aliPayLib.VerifyNotify(form)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,120 @@
+package library
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewIdGenerator(t *testing.T) {
+	_, err := library.NewIdGenerator(-1)
+	assert.NotNil(t, err)
+	_, err = library.NewIdGenerator(library.IdMaxWorkerId + 1)
+	assert.NotNil(t, err)
+	generator, err := library.NewIdGenerator(library.IdMaxWorkerId)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(library.IdMaxWorkerId), generator.WorkerId())
+}
+
+func TestIdGenerator_NextId(t *testing.T) {
+	generator, err := library.NewIdGenerator(7)
+	assert.Nil(t, err)
+	var lastId int64
+	// 超过一毫秒能生成的序列号数, 覆盖序列号用完等下一毫秒的情况
+	for i := 0; i < 20000; i++ {
+		id, err := generator.NextId()
+		assert.Nil(t, err)
+		if id <= lastId {
+			t.Fatalf("id should be increasing, got %d after %d", id, lastId)
+		}
+		lastId = id
+	}
+	createdAt, workerId, _ := library.ParseId(lastId)
+	assert.Equal(t, int64(7), workerId)
+	assert.WithinDuration(t, time.Now(), createdAt, time.Second)
+}
+
+// TestIdGenerator_NoCollision 多个 worker 在多个 goroutine 中并发生成ID, 不能有重复
+func TestIdGenerator_NoCollision(t *testing.T) {
+	const workers = 4
+	const goroutinesPerWorker = 8
+	const idsPerGoroutine = 5000
+
+	var mu sync.Mutex
+	seen := make(map[int64]struct{}, workers*goroutinesPerWorker*idsPerGoroutine)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		generator, err := library.NewIdGenerator(int64(w))
+		assert.Nil(t, err)
+		for g := 0; g < goroutinesPerWorker; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ids := make([]int64, 0, idsPerGoroutine)
+				for i := 0; i < idsPerGoroutine; i++ {
+					id, err := generator.NextId()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					ids = append(ids, id)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, id := range ids {
+					if _, exists := seen[id]; exists {
+						t.Errorf("duplicated id %d", id)
+					}
+					seen[id] = struct{}{}
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	assert.Len(t, seen, workers*goroutinesPerWorker*idsPerGoroutine)
+}
+
+func TestIdGenerator_NextNo(t *testing.T) {
+	generator, err := library.NewIdGenerator(1)
+	assert.Nil(t, err)
+	refundNos := make([]string, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		refundNo, err := generator.NextNo("R")
+		assert.Nil(t, err)
+		refundNos = append(refundNos, refundNo)
+	}
+	// 单号长度固定, 按字符串排序和生成顺序一致
+	for _, refundNo := range refundNos {
+		assert.Len(t, refundNo, len(refundNos[0]))
+	}
+	assert.True(t, sort.StringsAreSorted(refundNos))
+	assert.Equal(t, time.Now().Format("20060102"), refundNos[0][1:9])
+
+	id, err := library.ParseIdNo("R", refundNos[0])
+	assert.Nil(t, err)
+	assert.Equal(t, refundNos[0], library.FormatIdNo("R", id))
+	_, err = library.ParseIdNo("R", "20240903374062590406950001")
+	assert.NotNil(t, err)
+}
+
+func BenchmarkIdGenerator_NextId(b *testing.B) {
+	generator, _ := library.NewIdGenerator(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = generator.NextId()
+	}
+}
+
+func BenchmarkIdGenerator_NextNoParallel(b *testing.B) {
+	generator, _ := library.NewIdGenerator(1)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, _ = generator.NextNo("")
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package library
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/WoWBytePaladin/go-mall/logic/do"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderTextPdf(t *testing.T) {
+	pdf := library.RenderTextPdf([]string{"电子发票", "订单号: 123"})
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+	// 中文按 UCS-2 大端编码, "电子" -> 7535 5b50
+	assert.Contains(t, string(pdf), "<"+hex.EncodeToString([]byte{0x75, 0x35, 0x5b, 0x50}))
+	// xref 中记录的对象偏移量要指向对应的对象
+	xrefStart := bytes.Index(pdf, []byte("xref\n"))
+	assert.Greater(t, xrefStart, 0)
+	assert.Contains(t, string(pdf[xrefStart:]), "startxref\n")
+	firstObjectOffset := bytes.Index(pdf, []byte("1 0 obj"))
+	assert.Contains(t, string(pdf[xrefStart:]), "0000000009 00000 n")
+	assert.Equal(t, 9, firstObjectOffset)
+}
+
+func TestLocalInvoiceIssuer(t *testing.T) {
+	pdfDir := t.TempDir()
+	issuer := library.NewInvoiceIssuer(enum.InvoiceIssuerLocal, library.InvoiceIssuerConfig{
+		PdfDir: pdfDir, SellerName: "go-mall", SellerTaxNo: "91110000000000000X",
+	})
+	invoice := &do.OrderInvoice{
+		InvoiceNo: "I202410180000000000000000001", OrderNo: "202410180000000000000000001",
+		TitleType: enum.InvoiceTitleTypeCompany, Title: "某某科技有限公司", TaxNo: "91310000000000000Y",
+		Amount: 6800, InvoiceState: enum.InvoiceStateRequested,
+		Items: []*do.InvoiceItem{{CommodityName: "机械键盘", CommodityNum: 1, Amount: 6800}},
+	}
+
+	_, err := issuer.RedFlush(invoice)
+	assert.NotNil(t, err, "还没有开票的发票不能红冲")
+
+	result, err := issuer.Issue(invoice)
+	assert.Nil(t, err)
+	assert.Len(t, result.InvoiceNumber, 20)
+	assert.Equal(t, pdfDir, filepath.Dir(result.PdfPath))
+	pdf, err := os.ReadFile(result.PdfPath)
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-")))
+
+	invoice.InvoiceState = enum.InvoiceStateIssued
+	invoice.InvoiceNumber = result.InvoiceNumber
+	_, err = issuer.Issue(invoice)
+	assert.NotNil(t, err, "已经开票的发票不能重复开票")
+	redResult, err := issuer.RedFlush(invoice)
+	assert.Nil(t, err)
+	assert.NotEqual(t, result.PdfPath, redResult.PdfPath)
+	assert.FileExists(t, redResult.PdfPath)
+}
@@ -0,0 +1,96 @@
+package library
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPrivateKeyPem 生成一个 PKCS8 格式的 PEM 私钥
+func newPrivateKeyPem(t *testing.T) (*rsa.PrivateKey, []byte) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(t, err)
+	return privateKey, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	keyA, pemA := newPrivateKeyPem(t)
+	keyB, pemB := newPrivateKeyPem(t)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "mchA.pem"), pemA, 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "mchB.pem"), pemB, 0600))
+
+	provider := library.NewKeyProvider(library.KeyProviderFile, filepath.Join(dir, "{mchid}.pem"))
+	privateKeyA, err := provider.PrivateKey("mchA")
+	assert.Nil(t, err)
+	assert.True(t, keyA.Equal(privateKeyA))
+	privateKey, err := provider.PrivateKey("mchB")
+	assert.Nil(t, err)
+	assert.True(t, keyB.Equal(privateKey))
+	// 文件没有变化时返回缓存中已经解析好的私钥
+	cachedKey, err := provider.PrivateKey("mchA")
+	assert.Nil(t, err)
+	assert.Same(t, privateKeyA, cachedKey)
+
+	// 替换密钥文件后读取到新的私钥
+	keyRotated, pemRotated := newPrivateKeyPem(t)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "mchA.pem"), pemRotated, 0600))
+	assert.Nil(t, os.Chtimes(filepath.Join(dir, "mchA.pem"), time.Now(), time.Now().Add(time.Minute)))
+	privateKey, err = provider.PrivateKey("mchA")
+	assert.Nil(t, err)
+	assert.True(t, keyRotated.Equal(privateKey))
+
+	// 密钥文件不存在
+	_, err = provider.PrivateKey("mchC")
+	assert.NotNil(t, err)
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	keyA, pemA := newPrivateKeyPem(t)
+	keyB, pemB := newPrivateKeyPem(t)
+	t.Setenv("GOMALL_TEST_WXPAY_KEY_mchA", string(pemA))
+	// 也支持 base64 编码后的私钥
+	t.Setenv("GOMALL_TEST_WXPAY_KEY_mchB", base64.StdEncoding.EncodeToString(pemB))
+	t.Setenv("GOMALL_TEST_WXPAY_KEY_mchC", "invalid key")
+
+	provider := library.NewKeyProvider(library.KeyProviderEnv, "GOMALL_TEST_WXPAY_KEY")
+	privateKey, err := provider.PrivateKey("mchA")
+	assert.Nil(t, err)
+	assert.True(t, keyA.Equal(privateKey))
+	privateKey, err = provider.PrivateKey("mchB")
+	assert.Nil(t, err)
+	assert.True(t, keyB.Equal(privateKey))
+	// 私钥解析失败时返回错误
+	_, err = provider.PrivateKey("mchC")
+	assert.NotNil(t, err)
+	// 没有配置环境变量
+	_, err = provider.PrivateKey("mchD")
+	assert.NotNil(t, err)
+}
+
+func TestDirKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	keyA, pemA := newPrivateKeyPem(t)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "1900000001.pem"), pemA, 0600))
+
+	provider := library.NewKeyProvider(library.KeyProviderDir, dir)
+	privateKey, err := provider.PrivateKey("1900000001")
+	assert.Nil(t, err)
+	assert.True(t, keyA.Equal(privateKey))
+	// 商户号不能用来访问目录外的文件
+	_, err = provider.PrivateKey("../1900000001")
+	assert.NotNil(t, err)
+	_, err = provider.PrivateKey("")
+	assert.NotNil(t, err)
+}
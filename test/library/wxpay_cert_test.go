@@ -0,0 +1,145 @@
+package library
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/WoWBytePaladin/go-mall/library"
+	"github.com/agiledragon/gomonkey/v2"
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/assert"
+)
+
+// newPlatformCert 生成一个自签名证书模拟微信支付平台证书, 返回证书的PEM和私钥
+func newPlatformCert(t *testing.T, serialNo string) ([]byte, *rsa.PrivateKey) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	serial, _ := new(big.Int).SetString(serialNo, 16)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	assert.Nil(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), privateKey
+}
+
+// signNotify 用平台证书的私钥按微信支付的方式给通知签名
+func signNotify(privateKey *rsa.PrivateKey, timestamp, nonce, body string) string {
+	hashed := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s\n", timestamp, nonce, body)))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+func TestWxPayLib_ValidateNotifySignature(t *testing.T) {
+	defer gock.Off()
+	aesKey := "0123456789abcdef0123456789abcdef"
+	serialNo := "5157F09EFDC096DE15EBE81A47057A7232F1B8E1"
+	certPem, privateKey := newPlatformCert(t, serialNo)
+	// 按微信支付的方式用 APIv3 密钥加密证书
+	nonce := "4de73afd28b6"
+	block, _ := aes.NewCipher([]byte(aesKey))
+	aesGCM, _ := cipher.NewGCM(block)
+	ciphertext := aesGCM.Seal(nil, []byte(nonce), certPem, []byte("certificate"))
+	// 证书只下载一次, 之后的验签都使用缓存的证书
+	gock.New("https://api.mch.weixin.qq.com/v3/certificates").
+		Get("").
+		Times(1).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{{
+				"serial_no":      serialNo,
+				"effective_time": time.Now().Add(-time.Hour).Format(time.RFC3339),
+				"expire_time":    time.Now().Add(24 * time.Hour).Format(time.RFC3339),
+				"encrypt_certificate": map[string]string{
+					"algorithm":       "AEAD_AES_256_GCM",
+					"nonce":           nonce,
+					"associated_data": "certificate",
+					"ciphertext":      base64.StdEncoding.EncodeToString(ciphertext),
+				},
+			}},
+		})
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch-cert-test\"", nil
+	})
+	defer patches.Reset()
+	// 平台证书按商户号缓存, 用单独的商户号避免受其他测试影响
+	wxPayLib := library.NewWxPayLib(context.TODO(), library.WxtPayConfig{MchId: "mch-cert-test", AesKey: aesKey})
+	timestamp := "1725331021"
+	notifyNonce := "fdasflkja484"
+	body := `{"id":"EV-2018022511223320873","event_type":"TRANSACTION.SUCCESS"}`
+	signature := signNotify(privateKey, timestamp, notifyNonce, body)
+
+	verified, err := wxPayLib.ValidateNotifySignature(serialNo, timestamp, notifyNonce, signature, body)
+	assert.Nil(t, err)
+	assert.True(t, verified)
+	assert.True(t, gock.IsDone())
+
+	// 通知内容被篡改
+	verified, err = wxPayLib.ValidateNotifySignature(serialNo, timestamp, notifyNonce, signature, body+" ")
+	assert.NotNil(t, err)
+	assert.False(t, verified)
+
+	// 未知的证书序列号, 刚下载过证书不会再次下载
+	verified, err = wxPayLib.ValidateNotifySignature("0000000000000000000000000000000000000000", timestamp, notifyNonce, signature, body)
+	assert.NotNil(t, err)
+	assert.False(t, verified)
+
+	// 签名不是有效的 base64
+	verified, err = wxPayLib.ValidateNotifySignature(serialNo, timestamp, notifyNonce, "not base64!", body)
+	assert.NotNil(t, err)
+	assert.False(t, verified)
+}
+
+func TestWxPayLib_DownloadPlatformCerts_InvalidCert(t *testing.T) {
+	defer gock.Off()
+	aesKey := "0123456789abcdef0123456789abcdef"
+	nonce := "4de73afd28b6"
+	block, _ := aes.NewCipher([]byte(aesKey))
+	aesGCM, _ := cipher.NewGCM(block)
+	ciphertext := aesGCM.Seal(nil, []byte(nonce), []byte("not a certificate"), []byte("certificate"))
+	gock.New("https://api.mch.weixin.qq.com/v3/certificates").
+		Get("").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"data": []map[string]interface{}{{
+				"serial_no":      "5157F09EFDC096DE15EBE81A47057A7232F1B8E1",
+				"effective_time": "2024-09-01T00:00:00+08:00",
+				"expire_time":    "2029-09-01T00:00:00+08:00",
+				"encrypt_certificate": map[string]string{
+					"algorithm":       "AEAD_AES_256_GCM",
+					"nonce":           nonce,
+					"associated_data": "certificate",
+					"ciphertext":      base64.StdEncoding.EncodeToString(ciphertext),
+				},
+			}},
+		})
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch12345\"", nil
+	})
+	defer patches.Reset()
+	wxPayLib := library.NewWxPayLib(context.TODO(), library.WxtPayConfig{MchId: "mch12345", AesKey: aesKey})
+	// 证书解析失败时返回错误而不是 panic
+	certs, err := wxPayLib.DownloadPlatformCerts()
+	assert.NotNil(t, err)
+	assert.Nil(t, certs)
+}
@@ -1,8 +1,16 @@
 package library
 
 import (
+	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"os"
 	"testing"
 	"time"
 
@@ -73,7 +81,7 @@ func TestWxPayLib_CreateOrderPay(t *testing.T) {
 			Currency: "CNY",
 		},
 		Payer: struct {
-			OpenId string `json:"open_id"`
+			OpenId string `json:"openid"`
 		}{OpenId: openId},
 	}
 
@@ -115,3 +123,262 @@ func TestWxPayLib_CreateOrderPay(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestWxPayLib_DecryptNotifyResourceData(t *testing.T) {
+	aesKey := "0123456789abcdef0123456789abcdef" // APIv3密钥为32字节
+	nonce := "fdasflkja484"
+	associatedData := "transaction"
+	resourceData := `{"transaction_id":"4200000000202409031234567890","amount":{"total":549700,"payer_total":549700,"currency":"CNY","payer_currency":"CNY"},"mchid":"mch12345","trade_state":"SUCCESS","success_time":"2024-09-03T10:33:40+08:00","out_trade_no":"20240903374062590406950001"}`
+	// 按微信支付的方式用 AEAD_AES_256_GCM 加密通知数据
+	block, _ := aes.NewCipher([]byte(aesKey))
+	aesGCM, _ := cipher.NewGCM(block)
+	ciphertext := aesGCM.Seal(nil, []byte(nonce), []byte(resourceData), []byte(associatedData))
+	notifyBody, _ := json.Marshal(map[string]interface{}{
+		"id":            "EV-2018022511223320873",
+		"create_time":   "2024-09-03T10:33:41+08:00",
+		"event_type":    "TRANSACTION.SUCCESS",
+		"resource_type": "encrypt-resource",
+		"resource": map[string]string{
+			"algorithm":       "AEAD_AES_256_GCM",
+			"ciphertext":      base64.StdEncoding.EncodeToString(ciphertext),
+			"associated_data": associatedData,
+			"nonce":           nonce,
+		},
+	})
+
+	wxPayLib := library.NewWxPayLib(context.TODO(), library.WxtPayConfig{AesKey: aesKey})
+	notifyData, err := wxPayLib.DecryptNotifyResourceData(string(notifyBody))
+	assert.Nil(t, err)
+	assert.Equal(t, "20240903374062590406950001", notifyData.OutTradeNo)
+	assert.Equal(t, "4200000000202409031234567890", notifyData.TransactionID)
+	assert.Equal(t, library.WxTradeStateSuccess, notifyData.TradeState)
+	assert.Equal(t, 549700, notifyData.Amount.Total)
+
+	// 密钥不对时解密失败
+	wxPayLib = library.NewWxPayLib(context.TODO(), library.WxtPayConfig{AesKey: "abcdef0123456789abcdef0123456789"})
+	_, err = wxPayLib.DecryptNotifyResourceData(string(notifyBody))
+	assert.NotNil(t, err)
+}
+
+func TestWxPayLib_CreateNativeAndH5OrderPay(t *testing.T) {
+	defer gock.Off()
+	order := &do.Order{
+		OrderNo:  "20240903374062590406950001",
+		UserId:   1,
+		PayMoney: 549700,
+		Items: []*do.OrderItem{
+			{CommodityId: 2, CommodityName: "Apple iPhone 11 (A2223)", CommodityNum: 1},
+		},
+	}
+	payConfig := library.WxtPayConfig{
+		AppId:           "appId12345",
+		MchId:           "mch12345",
+		PrivateSerialNo: "567",
+	}
+	gock.New("https://api.mch.weixin.qq.com/v3/pay/transactions/native").
+		Post("").MatchType("json").
+		Reply(200).
+		JSON(map[string]string{"code_url": "weixin://wxpay/bizpayurl/up?pr=NwY5Mz9&groupid=00"})
+	gock.New("https://api.mch.weixin.qq.com/v3/pay/transactions/h5").
+		Post("").MatchType("json").
+		BodyString(`"scene_info":\{"payer_client_ip":"14.23.150.211","h5_info":\{"type":"Wap"\}\}`).
+		Reply(200).
+		JSON(map[string]string{"h5_url": "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=wx2916263004719461949c84457c735b0000"})
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch12345\"", nil
+	})
+	defer patches.Reset()
+
+	wxPayLib := library.NewWxPayLib(context.TODO(), payConfig)
+	nativePayInfo, err := wxPayLib.CreateNativeOrderPay(order)
+	assert.Nil(t, err)
+	assert.Equal(t, "weixin://wxpay/bizpayurl/up?pr=NwY5Mz9&groupid=00", nativePayInfo.CodeUrl)
+
+	h5PayInfo, err := wxPayLib.CreateH5OrderPay(order, "14.23.150.211")
+	assert.Nil(t, err)
+	assert.Contains(t, h5PayInfo.MWebUrl, "prepay_id=wx2916263004719461949c84457c735b0000")
+	assert.True(t, gock.IsDone())
+}
+
+func TestWxPayLib_CreateRefund(t *testing.T) {
+	defer gock.Off()
+	payConfig := library.WxtPayConfig{
+		AppId:           "appId12345",
+		MchId:           "mch12345",
+		PrivateSerialNo: "567",
+		RefundNotifyUrl: "https://go-mall.com/order/wxpay-refund-notify",
+	}
+	gock.New("https://api.mch.weixin.qq.com/v3/refund/domestic/refunds").
+		Post("").MatchType("json").
+		BodyString(`"out_refund_no":"R20240903374062590406950001".*"amount":\{"refund":100,"total":549700,"currency":"CNY"\}`).
+		Reply(200).
+		JSON(map[string]interface{}{
+			"refund_id":     "50000000382019052709732678859",
+			"out_refund_no": "R20240903374062590406950001",
+			"out_trade_no":  "20240903374062590406950001",
+			"status":        library.WxRefundStatusProcessing,
+			"amount":        map[string]int{"total": 549700, "refund": 100},
+		})
+	gock.New("https://api.mch.weixin.qq.com/v3/refund/domestic/refunds").
+		Post("").
+		Reply(400).
+		JSON(map[string]string{"code": "NOT_ENOUGH", "message": "基本账户余额不足"})
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch12345\"", nil
+	})
+	defer patches.Reset()
+
+	wxPayLib := library.NewWxPayLib(context.TODO(), payConfig)
+	refundResult, err := wxPayLib.CreateRefund("20240903374062590406950001", "R20240903374062590406950001", "不想要了", 100, 549700)
+	assert.Nil(t, err)
+	assert.Equal(t, library.WxRefundStatusProcessing, refundResult.Status)
+	assert.Equal(t, "50000000382019052709732678859", refundResult.RefundId)
+
+	// 微信支付返回错误码时申请退款失败, 错误里保留微信支付返回的错误码和错误描述
+	_, err = wxPayLib.CreateRefund("20240903374062590406950001", "R20240903374062590406950002", "不想要了", 100, 549700)
+	assert.NotNil(t, err)
+	apiErr, ok := library.AsWxPayApiError(err)
+	assert.True(t, ok)
+	assert.Equal(t, "NOT_ENOUGH", apiErr.Code)
+	assert.Equal(t, "基本账户余额不足", apiErr.Message)
+	assert.True(t, apiErr.Rejected())
+}
+
+func TestWxPayLib_QueryRefund(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://api.mch.weixin.qq.com/v3/refund/domestic/refunds/R20240903374062590406950001").
+		Get("").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"refund_id":     "50000000382019052709732678859",
+			"out_refund_no": "R20240903374062590406950001",
+			"out_trade_no":  "20240903374062590406950001",
+			"status":        library.WxRefundStatusSuccess,
+			"success_time":  "2024-09-03T10:33:40+08:00",
+			"amount":        map[string]int{"total": 549700, "refund": 100},
+		})
+	gock.New("https://api.mch.weixin.qq.com/v3/refund/domestic/refunds/R20240903374062590406950002").
+		Get("").
+		Reply(404).
+		JSON(map[string]string{"code": "RESOURCE_NOT_EXISTS", "message": "退款单不存在"})
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch12345\"", nil
+	})
+	defer patches.Reset()
+
+	wxPayLib := library.NewWxPayLib(context.TODO(), library.WxtPayConfig{MchId: "mch12345"})
+	refundResult, err := wxPayLib.QueryRefund("R20240903374062590406950001")
+	assert.Nil(t, err)
+	assert.Equal(t, library.WxRefundStatusSuccess, refundResult.Status)
+	assert.Equal(t, "50000000382019052709732678859", refundResult.RefundId)
+
+	// 退款申请没有到达微信支付时查询不到退款单
+	_, err = wxPayLib.QueryRefund("R20240903374062590406950002")
+	apiErr, ok := library.AsWxPayApiError(err)
+	assert.True(t, ok)
+	assert.True(t, apiErr.NotExists())
+}
+
+func TestWxPayLib_QueryOrderByOutTradeNo(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/20240903374062590406950001").
+		Get("").MatchParam("mchid", "mch12345").
+		Reply(200).
+		JSON(map[string]interface{}{
+			"transaction_id": "4200000000202409031234567890",
+			"out_trade_no":   "20240903374062590406950001",
+			"mchid":          "mch12345",
+			"trade_state":    library.WxTradeStateSuccess,
+			"success_time":   "2024-09-03T10:33:40+08:00",
+			"amount":         map[string]interface{}{"total": 549700, "payer_total": 549700, "currency": "CNY"},
+		})
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch12345\"", nil
+	})
+	defer patches.Reset()
+
+	wxPayLib := library.NewWxPayLib(context.TODO(), library.WxtPayConfig{MchId: "mch12345"})
+	tradeData, err := wxPayLib.QueryOrderByOutTradeNo("20240903374062590406950001")
+	assert.Nil(t, err)
+	assert.Equal(t, library.WxTradeStateSuccess, tradeData.TradeState)
+	assert.Equal(t, "4200000000202409031234567890", tradeData.TransactionID)
+	assert.Equal(t, 549700, tradeData.Amount.Total)
+}
+
+func TestWxPayLib_CloseOrder(t *testing.T) {
+	defer gock.Off()
+	gock.New("https://api.mch.weixin.qq.com/v3/pay/transactions/out-trade-no/20240903374062590406950001/close").
+		Post("").MatchType("json").
+		JSON(map[string]string{"mchid": "mch12345"}).
+		Reply(204)
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch12345\"", nil
+	})
+	defer patches.Reset()
+
+	wxPayLib := library.NewWxPayLib(context.TODO(), library.WxtPayConfig{MchId: "mch12345"})
+	assert.Nil(t, wxPayLib.CloseOrder("20240903374062590406950001"))
+	assert.True(t, gock.IsDone())
+}
+
+func TestWxPayLib_DownloadTradeBill(t *testing.T) {
+	// 录制的交易账单: 两笔支付成功的交易和第一笔交易的一次退款
+	billData, err := os.ReadFile("testdata/wxpay_tradebill_20240903.csv")
+	assert.Nil(t, err)
+	billHash := sha1.Sum(billData)
+	downloadUrl := "https://api.mch.weixin.qq.com/v3/billdownload/file?token=6XIv5TUPto7pByrTQKhd6kwvyKLG2uY2wMMR8cNXqaA_Cv_isgaUtBzp4QtiozLO"
+
+	var s *library.WxPayLib
+	patches := gomonkey.ApplyPrivateMethod(s, "getToken", func(_ *library.WxPayLib, httpMethod string, requestBody string, wxApiUrl string) (string, error) {
+		return "mchid=\"mch12345\"", nil
+	})
+	defer patches.Reset()
+	wxPayLib := library.NewWxPayLib(context.TODO(), library.WxtPayConfig{MchId: "mch12345"})
+	billDate := time.Date(2024, 9, 3, 0, 0, 0, 0, time.Local)
+
+	t.Run("bill downloaded", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.mch.weixin.qq.com/v3/bill/tradebill").
+			MatchParam("bill_date", "2024-09-03").
+			Reply(200).
+			JSON(map[string]string{"hash_type": "SHA1", "hash_value": hex.EncodeToString(billHash[:]), "download_url": downloadUrl})
+		gock.New(downloadUrl).Reply(200).Body(bytes.NewReader(billData))
+
+		records, err := wxPayLib.DownloadTradeBill(billDate)
+		assert.Nil(t, err)
+		assert.True(t, gock.IsDone())
+		assert.Len(t, records, 3)
+		assert.Equal(t, "20240903374062590406950001", records[0].OutTradeNo)
+		assert.Equal(t, "4200000000202409031234567890", records[0].TransactionId)
+		assert.Equal(t, library.WxTradeStateSuccess, records[0].TradeState)
+		assert.Equal(t, 549700, records[0].TotalAmount)
+		assert.Equal(t, time.Date(2024, 9, 3, 10, 33, 40, 0, time.Local), records[0].TradeTime)
+		assert.Equal(t, 9990, records[1].TotalAmount)
+		assert.Equal(t, "NATIVE", records[1].TradeType)
+		assert.Equal(t, library.WxTradeStateRefund, records[2].TradeState)
+		assert.Equal(t, "R20240903374062590406950001", records[2].OutRefundNo)
+		assert.Equal(t, 100, records[2].RefundAmount)
+	})
+
+	t.Run("bill hash mismatch", func(t *testing.T) {
+		defer gock.Off()
+		gock.New("https://api.mch.weixin.qq.com/v3/bill/tradebill").
+			MatchParam("bill_date", "2024-09-03").
+			Reply(200).
+			JSON(map[string]string{"hash_type": "SHA1", "hash_value": "79bb0f45fc4c42234a5e2ec9d4a0a6ca8b1c7b26", "download_url": downloadUrl})
+		gock.New(downloadUrl).Reply(200).Body(bytes.NewReader(billData))
+
+		_, err := wxPayLib.DownloadTradeBill(billDate)
+		assert.NotNil(t, err)
+	})
+}
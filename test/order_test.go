@@ -3,9 +3,13 @@ package dao
 import (
 	"context"
 	"database/sql/driver"
+	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/WoWBytePaladin/go-mall/common/enum"
+	dao2 "github.com/WoWBytePaladin/go-mall/dal/dao"
 	"github.com/WoWBytePaladin/go-mall/dal/model"
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 	"gorm.io/plugin/soft_delete"
 	"regexp"
 	"testing"
@@ -18,14 +22,19 @@ func TestOrderDao_GetUserOrders(t *testing.T) {
 	emptyPayTime := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
 
 	orders := []*model.Order{
-		{1, "12345675555", "", 1, 1, 100, 100, 0, 0, emptyPayTime, orderDel, now, now},
-		{2, "12345675556", "", 1, 1, 100, 100, 0, 0, emptyPayTime, orderDel, now, now},
+		{ID: 1, OrderNo: "12345675555", PayType: 1, UserId: 1, BillMoney: 100, PayMoney: 100,
+			PaidAt: emptyPayTime, IsDel: orderDel, CreatedAt: now, UpdatedAt: now},
+		{ID: 2, OrderNo: "12345675556", PayType: 1, UserId: 1, BillMoney: 100, PayMoney: 100,
+			PaidAt: emptyPayTime, IsDel: orderDel, CreatedAt: now, UpdatedAt: now},
 	}
 	od := dao2.NewOrderDao(context.TODO())
 	var userId int64 = 1
 	offset := 10
 	limit := 50
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `orders`")).WithArgs(userId, orderDel, limit, offset).
+	// 用户订单列表中拆单的订单支付前显示父订单, 支付后显示子订单
+	visibleArgs := []driver.Value{enum.OrderTypeParent, enum.PayStatePaid, enum.OrderTypeSub, enum.PayStatePaid}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `orders`")).
+		WithArgs(append(append([]driver.Value{userId}, visibleArgs...), orderDel, limit, offset)...).
 		WillReturnRows(
 			sqlmock.NewRows([]string{"id", "order_no", "pay_trans_id", "pay_type", "user_id", "bill_money", "pay_money",
 				"pay_state", "order_status", "paid_at", "is_del", "created_at", "updated_at"}).
@@ -37,25 +46,169 @@ func TestOrderDao_GetUserOrders(t *testing.T) {
 				orders[1].PayState, orders[1].OrderStatus, orders[1].PaidAt, orders[1].IsDel, orders[1].CreatedAt, orders[1].UpdatedAt,
 			),
 		)
-	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `orders`")).WithArgs(userId, orderDel).
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `orders`")).
+		WithArgs(append(append([]driver.Value{userId}, visibleArgs...), orderDel)...).
 		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2))
-	gotOrders, totalRow, err := od.GetUserOrders(userId, offset, limit)
+	gotOrders, totalRow, err := od.GetUserOrders(userId, nil, offset, limit)
 	assert.Nil(t, err)
 	assert.Equal(t, orders, gotOrders)
 	assert.Equal(t, totalRow, int64(2))
 }
 
-func TestOrderDao_UpdateOrderStatus(t *testing.T) {
-	orderNewStatus := 1
+func TestOrderDao_GetUserOrdersWithPartialRefunded(t *testing.T) {
+	var userId int64 = 1
+	orderDel := soft_delete.DeletedAt(0)
+	happenedSince := time.Date(1971, time.January, 1, 0, 0, 0, 0, time.Local)
+	// 待收货的订单状态, 还在履约的部分退款订单按发货、送达时间归到待收货
+	orderStatuses := []int{enum.OrderStatusShipped, enum.OrderStatusOnDelivery, enum.OrderStatusDelivered}
+	statusCond := "(order_status IN (?,?,?) OR (order_status = ? AND (confirmed_at < ? AND delivered_at < ? AND shipped_at >= ?)) " +
+		"OR (order_status = ? AND (confirmed_at < ? AND delivered_at >= ?)))"
+	args := []driver.Value{userId, enum.OrderStatusShipped, enum.OrderStatusOnDelivery, enum.OrderStatusDelivered,
+		enum.OrderStatusPartialRefunded, happenedSince, happenedSince, happenedSince,
+		enum.OrderStatusPartialRefunded, happenedSince, happenedSince,
+		enum.OrderTypeParent, enum.PayStatePaid, enum.OrderTypeSub, enum.PayStatePaid, orderDel}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT * FROM `orders` WHERE user_id = ? AND " + statusCond +
+		" AND ((order_type <> ? OR pay_state <> ?) AND (order_type <> ? OR pay_state = ?)) AND `orders`.`is_del` = ? ORDER BY id DESC LIMIT ?")).
+		WithArgs(append(args, 10)...).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_status"}).AddRow(1, enum.OrderStatusShipped).AddRow(2, enum.OrderStatusPartialRefunded))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT count(*) FROM `orders`")).
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(2))
+	gotOrders, totalRow, err := dao2.NewOrderDao(context.TODO()).GetUserOrders(userId, orderStatuses, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, gotOrders, 2)
+	assert.Equal(t, int64(2), totalRow)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestOrderDao_CountUserOrdersByStatus(t *testing.T) {
+	var userId int64 = 1
+	orderDel := soft_delete.DeletedAt(0)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT order_status, COUNT(*) AS total FROM `orders`")).
+		WithArgs(userId, enum.OrderTypeParent, enum.PayStatePaid, enum.OrderTypeSub, enum.PayStatePaid, orderDel).
+		WillReturnRows(sqlmock.NewRows([]string{"order_status", "total"}).
+			AddRow(enum.OrderStatusUnPaid, 2).
+			AddRow(enum.OrderStatusPaid, 1))
+	od := dao2.NewOrderDao(context.TODO())
+	counts, err := od.CountUserOrdersByStatus(userId)
+	assert.Nil(t, err)
+	assert.Equal(t, map[int]int64{enum.OrderStatusUnPaid: 2, enum.OrderStatusPaid: 1}, counts)
+}
+
+func TestOrderDao_TransitOrderStatus(t *testing.T) {
 	var orderId int64 = 1
-	orderDel := 0
+	// 用户删除了的订单照常变更状态, 更新时不带 is_del 条件
+	statusLog := &model.OrderStatusLog{
+		OrderNo:    "20240903374062590406950001",
+		Event:      enum.OrderEventUserCancel,
+		FromStatus: enum.OrderStatusUnPaid,
+		ToStatus:   enum.OrderStatusUserQuit,
+		Actor:      enum.OrderActorUser,
+		ActorId:    1,
+	}
 	mock.ExpectBegin()
 	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders` SET")).
-		WithArgs(orderNewStatus, AnyTime{}, orderId, orderDel).
+		WithArgs(statusLog.ToStatus, AnyTime{}, orderId, statusLog.FromStatus).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `order_status_logs`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	od := dao2.NewOrderDao(context.TODO())
+	transited, err := od.TransitOrderStatus(orderId, nil, statusLog, nil)
+	assert.Nil(t, err)
+	assert.True(t, transited)
+	assert.Equal(t, orderId, statusLog.OrderId)
+}
+
+func TestOrderDao_TransitOrderStatusChanged(t *testing.T) {
+	var orderId int64 = 1
+	// 订单状态已经被并发修改时不更新也不记录状态变更
+	statusLog := &model.OrderStatusLog{
+		OrderNo:    "20240903374062590406950001",
+		Event:      enum.OrderEventPayTimeout,
+		FromStatus: enum.OrderStatusUnPaid,
+		ToStatus:   enum.OrderStatusUnpaidClose,
+		Actor:      enum.OrderActorSystem,
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders` SET")).
+		WithArgs(statusLog.ToStatus, AnyTime{}, orderId, statusLog.FromStatus).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	od := dao2.NewOrderDao(context.TODO())
+	transited, err := od.TransitOrderStatus(orderId, nil, statusLog, nil)
+	assert.Nil(t, err)
+	assert.False(t, transited)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestOrderDao_TransitOrderStatusRollback(t *testing.T) {
+	var orderId int64 = 1
+	// 状态变更后在同一个事务里执行的操作失败时, 状态变更和变更记录一起回滚
+	statusLog := &model.OrderStatusLog{
+		OrderNo:    "20240903374062590406950001",
+		Event:      enum.OrderEventPayTimeout,
+		FromStatus: enum.OrderStatusUnPaid,
+		ToStatus:   enum.OrderStatusUnpaidClose,
+		Actor:      enum.OrderActorSystem,
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders` SET")).
+		WithArgs(statusLog.ToStatus, AnyTime{}, orderId, statusLog.FromStatus).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `order_status_logs`")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectRollback()
+	od := dao2.NewOrderDao(context.TODO())
+	transited, err := od.TransitOrderStatus(orderId, nil, statusLog, func(tx *gorm.DB) error {
+		return errors.New("release stock failed")
+	})
+	assert.NotNil(t, err)
+	assert.False(t, transited)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestOrderDao_TransitParentOrderStatus(t *testing.T) {
+	var parentId int64 = 1
+	statusLog := &model.OrderStatusLog{
+		OrderNo:    "202410180000000000000000001",
+		Event:      enum.OrderEventPaySuccess,
+		FromStatus: enum.OrderStatusUnPaid,
+		ToStatus:   enum.OrderStatusPaid,
+		Actor:      enum.OrderActorPayment,
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders` SET")).
+		WithArgs(statusLog.ToStatus, AnyTime{}, parentId, statusLog.FromStatus).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT `id`,`order_no` FROM `orders`")).
+		WithArgs(parentId, statusLog.FromStatus).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_no"}).
+			AddRow(2, "202410180000000000000000002").
+			AddRow(3, "202410180000000000000000003"))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders` SET")).
+		WithArgs(statusLog.ToStatus, AnyTime{}, 2, 3, statusLog.FromStatus).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO `order_status_logs`")).
+		WillReturnResult(sqlmock.NewResult(1, 3))
+	mock.ExpectCommit()
+	od := dao2.NewOrderDao(context.TODO())
+	transited, err := od.TransitParentOrderStatus(parentId, nil, statusLog, nil)
+	assert.Nil(t, err)
+	assert.True(t, transited)
+	assert.Equal(t, parentId, statusLog.OrderId)
+}
+
+func TestOrderDao_SetUserOrderDeleted(t *testing.T) {
+	var orderId int64 = 1
+	// 删除父订单时子订单一起删除
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE `orders` SET `is_del`=?,`updated_at`=? WHERE id = ? OR parent_id = ?")).
+		WithArgs(1, AnyTime{}, orderId, orderId).
+		WillReturnResult(sqlmock.NewResult(0, 3))
 	mock.ExpectCommit()
 	od := dao2.NewOrderDao(context.TODO())
-	err := od.UpdateOrderStatus(orderId, orderNewStatus)
+	err := od.SetUserOrderDeleted(orderId, true)
 	assert.Nil(t, err)
 }
 
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/WoWBytePaladin/go-mall/common/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestYuanToFen(t *testing.T) {
+	tests := []struct {
+		yuan    string
+		fen     int
+		wantErr bool
+	}{
+		{yuan: "5497.00", fen: 549700},
+		{yuan: "5497", fen: 549700},
+		{yuan: "0.5", fen: 50},
+		{yuan: "0.05", fen: 5},
+		{yuan: " 12.34 ", fen: 1234},
+		{yuan: "-12.34", fen: -1234},
+		// 格式不对的金额
+		{yuan: "", wantErr: true},
+		{yuan: ".5", wantErr: true},
+		{yuan: "1.", wantErr: true},
+		{yuan: "1.234", wantErr: true},
+		{yuan: "1.2.3", wantErr: true},
+		{yuan: "1.-5", wantErr: true},
+		{yuan: "+1.+2", wantErr: true},
+		{yuan: "+1", wantErr: true},
+		{yuan: "--1", wantErr: true},
+		{yuan: "1.+5", wantErr: true},
+		{yuan: "1 .5", wantErr: true},
+		{yuan: "1,000.00", wantErr: true},
+		{yuan: "1e3", wantErr: true},
+		{yuan: "１.00", wantErr: true},
+	}
+	for _, tt := range tests {
+		fen, err := util.YuanToFen(tt.yuan)
+		if tt.wantErr {
+			assert.NotNil(t, err, "yuan: %q", tt.yuan)
+			continue
+		}
+		assert.Nil(t, err, "yuan: %q", tt.yuan)
+		assert.Equal(t, tt.fen, fen, "yuan: %q", tt.yuan)
+	}
+}